@@ -13,8 +13,8 @@
 # WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
 # License for the specific language governing permissions and limitations
 # under the License.
-"""Will replay a json file in Eventlistenner, it automatically detects the
-EventListenner, the webhook secret from github-app-secret secret name
+"""Will replay a json file to the controller, it automatically detects the
+controller route, the webhook secret from github-app-secret secret name
 require requests library"""
 import argparse
 import base64
@@ -28,12 +28,12 @@ import requests
 
 NAMESPACE = "pipelines-as-code"
 SECRET_NAME = "github-app-secret"
-ELNAME = "pipelines-as-code"
+CONTROLLER_NAME = "pipelines-as-code-controller"
 
 
 def get_el_route():
     elroute = subprocess.run(
-        f"oc get route -n {NAMESPACE} -l eventlistener={ELNAME}-interceptor -o json",
+        f"oc get route -n {NAMESPACE} -l app={CONTROLLER_NAME} -o json",
         shell=True,
         check=True,
         capture_output=True)
@@ -87,7 +87,7 @@ def main(args):
         "X-Hub-Signature-256": "sha256=" + esha256,
     }
     r = requests.request("POST",
-                         el,
+                         el.rstrip("/") + "/webhook",
                          data=text.encode("utf-8"),
                          headers=headers)
     print(r.content.decode())
@@ -55,6 +55,47 @@ type RepositorySpec struct {
 	URL       string `json:"url"`
 	EventType string `json:"event_type"`
 	Branch    string `json:"branch"`
+
+	// WebhookSecret reference a Secret in the Repository namespace with the
+	// shared secret the webhook payloads are signed with, it overrides the
+	// install wide webhook secret
+	// +optional
+	WebhookSecret *Secret `json:"webhook_secret,omitempty"`
+
+	// CollaboratorPermission is the minimum permission level (write, maintain
+	// or admin) a collaborator needs on the repository to run the CI, default
+	// to write
+	// +optional
+	CollaboratorPermission string `json:"collaborator_permission,omitempty"`
+
+	// SkipDraftPullRequests do not run the CI on the draft pull requests
+	// until they are marked as ready for review
+	// +optional
+	SkipDraftPullRequests bool `json:"skip_draft_pull_requests,omitempty"`
+
+	// PullRequestComment report the status of each PipelineRun in a comment
+	// of the pull request as well, the comment is edited in place on each
+	// new status
+	// +optional
+	PullRequestComment bool `json:"pull_request_comment,omitempty"`
+
+	// UntrustedTektonDir is what to do with the .tekton directory of a pull
+	// request when the sender is only allowed by the /ok-to-test of an owner,
+	// base-branch takes it from the base branch and approval waits for an
+	// owner to approve the changes to it, default to take it from the pull
+	// request
+	// +optional
+	UntrustedTektonDir string `json:"untrusted_tekton_dir,omitempty"`
+}
+
+// Secret reference a key of a Secret
+type Secret struct {
+	// Name of the Secret
+	Name string `json:"name"`
+
+	// Key in the Secret, default to webhook.secret
+	// +optional
+	Key string `json:"key,omitempty"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -0,0 +1,324 @@
+// Package cache is a content addressed cache for what we fetch or compute from
+// immutable content, i.e: the git blobs and trees, the versioned tasks from the
+// hub or the PipelineRuns resolved for a commit. Since the keys address
+// content which never change, the entries never have to be invalidated and are
+// only evicted when the cache is full. The only exception are the lookups of
+// the latest version of a task which briefly stay in memory.
+package cache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// BlobKind is the content of a git blob keyed by its SHA
+	BlobKind = "blob"
+	// TreeKind is the concatenated yaml files of a git tree keyed by the tree
+	// SHA or a <commit>:<path> tree-ish
+	TreeKind = "tree"
+	// TaskKind is a remote task keyed by its versioned name or URL
+	TaskKind = "task"
+	// PipelineRunKind is the resolved PipelineRuns of a commit keyed by the
+	// digest of its templates
+	PipelineRunKind = "pipelinerun"
+	// LatestKind is the latest version of a remote task keyed by its name, it
+	// is not content addressed so it is set with a TTL and never persisted
+	LatestKind = "latest"
+
+	// DefaultMaxSize is the default size of the in memory cache in bytes
+	DefaultMaxSize = 64 << 20
+)
+
+// Stats are the hits and misses of a kind of entries
+type Stats struct {
+	Hits   int64
+	Misses int64
+}
+
+type entry struct {
+	id      string
+	data    []byte
+	expires time.Time
+	// persisted is true when the entry has a file in the cache directory
+	persisted bool
+}
+
+// Cache is a LRU cache of the content addressed entries bounded in size, it is
+// optionally backed by a directory (i.e: a PersistentVolume) so the entries
+// survive a restart. The directory mirrors the entries in memory, the files
+// are removed with the entries they back when those are evicted. A nil Cache
+// is valid and never caches anything.
+type Cache struct {
+	maxSize int
+	dir     string
+	now     func() time.Time
+
+	mu      sync.Mutex
+	size    int
+	lru     *list.List
+	entries map[string]*list.Element
+	stats   map[string]*Stats
+}
+
+// New create a cache of maxSize bytes, dir is the directory where the entries
+// are persisted or empty to only keep them in memory. The entries already in
+// dir are loaded from the most recent one, the ones not fitting in maxSize are
+// removed.
+func New(maxSize int, dir string) *Cache {
+	c := &Cache{
+		maxSize: maxSize,
+		dir:     dir,
+		now:     time.Now,
+		lru:     list.New(),
+		entries: map[string]*list.Element{},
+		stats:   map[string]*Stats{},
+	}
+	if dir != "" {
+		c.load()
+	}
+	return c
+}
+
+// Key build a key from parts, the parts which are not content addressed
+// themselves (i.e: the templates) are to be hashed with Digest
+func Key(parts ...string) string {
+	return strings.Join(parts, "/")
+}
+
+// Digest is the hex encoded sha256 digest of data
+func Digest(data ...[]byte) string {
+	h := sha256.New()
+	for _, d := range data {
+		_, _ = h.Write(d)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Get the data of key, a miss is recorded when it is not cached
+func (c *Cache) Get(kind, key string) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	id := c.id(kind, key)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.kindStats(kind)
+	if elt, ok := c.entries[id]; ok {
+		if !c.expired(elt.Value.(*entry)) {
+			c.lru.MoveToFront(elt)
+			stats.Hits++
+			return elt.Value.(*entry).data, true
+		}
+		c.remove(elt)
+	}
+
+	// another replica sharing the directory may have persisted it
+	if c.dir != "" && kind != LatestKind {
+		if data, err := ioutil.ReadFile(c.path(id)); err == nil {
+			c.add(&entry{id: id, data: data, persisted: true})
+			stats.Hits++
+			return data, true
+		}
+	}
+
+	stats.Misses++
+	return nil, false
+}
+
+// Set the data of key, the data is not to be modified afterwards
+func (c *Cache) Set(kind, key string, data []byte) {
+	c.set(kind, key, data, 0)
+}
+
+// SetWithTTL set the data of key for ttl, the entry is only kept in memory
+func (c *Cache) SetWithTTL(kind, key string, data []byte, ttl time.Duration) {
+	c.set(kind, key, data, ttl)
+}
+
+func (c *Cache) set(kind, key string, data []byte, ttl time.Duration) {
+	if c == nil {
+		return
+	}
+	id := c.id(kind, key)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elt, ok := c.entries[id]; ok {
+		if !c.expired(elt.Value.(*entry)) {
+			return
+		}
+		c.remove(elt)
+	}
+	e := &entry{id: id, data: data}
+	if ttl > 0 {
+		e.expires = c.now().Add(ttl)
+	} else if c.dir != "" && len(data) <= c.maxSize {
+		// The cache on disk is best effort, we just get a miss the next time
+		// if we cannot write it
+		e.persisted = c.persist(c.path(id), data) == nil
+	}
+	c.add(e)
+}
+
+// Stats get the hits and misses of each kind of entries
+func (c *Cache) Stats() map[string]Stats {
+	ret := map[string]Stats{}
+	if c == nil {
+		return ret
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for kind, stats := range c.stats {
+		ret[kind] = *stats
+	}
+	return ret
+}
+
+// WriteMetrics write the hits and misses counters in the prometheus text format
+func (c *Cache) WriteMetrics(w io.Writer) error {
+	stats := c.Stats()
+	kinds := make([]string, 0, len(stats))
+	for kind := range stats {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+
+	for _, counter := range []struct {
+		name, help string
+		value      func(Stats) int64
+	}{
+		{"pac_cache_hits_total", "The number of lookups found in the cache.", func(s Stats) int64 { return s.Hits }},
+		{"pac_cache_misses_total", "The number of lookups not found in the cache.", func(s Stats) int64 { return s.Misses }},
+	} {
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", counter.name, counter.help, counter.name); err != nil {
+			return err
+		}
+		for _, kind := range kinds {
+			if _, err := fmt.Fprintf(w, "%s{kind=%q} %d\n", counter.name, kind, counter.value(stats[kind])); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (c *Cache) kindStats(kind string) *Stats {
+	stats, ok := c.stats[kind]
+	if !ok {
+		stats = &Stats{}
+		c.stats[kind] = stats
+	}
+	return stats
+}
+
+// id of a key in memory and on disk, the keys are hashed since they contain
+// slashes and may be longer than a file name
+func (c *Cache) id(kind, key string) string {
+	return kind + "/" + Digest([]byte(kind+"/"+key))
+}
+
+func (c *Cache) path(id string) string {
+	return filepath.Join(c.dir, filepath.FromSlash(id))
+}
+
+func (c *Cache) expired(e *entry) bool {
+	return !e.expires.IsZero() && !c.now().Before(e.expires)
+}
+
+// add an entry in memory and evict the least recently used ones when it goes
+// over the max size, an entry larger than the cache itself is not kept
+func (c *Cache) add(e *entry) {
+	if len(e.data) > c.maxSize {
+		return
+	}
+	c.entries[e.id] = c.lru.PushFront(e)
+	c.size += len(e.data)
+	for c.size > c.maxSize {
+		c.remove(c.lru.Back())
+	}
+}
+
+// remove an entry from memory and its file from the disk
+func (c *Cache) remove(elt *list.Element) {
+	e := elt.Value.(*entry)
+	c.lru.Remove(elt)
+	delete(c.entries, e.id)
+	c.size -= len(e.data)
+	if e.persisted {
+		_ = os.Remove(c.path(e.id))
+	}
+}
+
+// load the entries persisted in the directory from the most recently written
+// one, the ones which do not fit in memory anymore and the leftovers of an
+// interrupted write are removed.
+func (c *Cache) load() {
+	type file struct {
+		id      string
+		modTime time.Time
+	}
+	files := []file{}
+	_ = filepath.Walk(c.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		if strings.HasPrefix(info.Name(), ".tmp-") {
+			_ = os.Remove(path)
+			return nil
+		}
+		rel, err := filepath.Rel(c.dir, path)
+		if err != nil {
+			return nil
+		}
+		files = append(files, file{id: filepath.ToSlash(rel), modTime: info.ModTime()})
+		return nil
+	})
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.After(files[j].modTime) })
+
+	for _, f := range files {
+		data, err := ioutil.ReadFile(c.path(f.id))
+		if err != nil || c.size+len(data) > c.maxSize {
+			_ = os.Remove(c.path(f.id))
+			continue
+		}
+		// the most recent first, each one goes behind the previous ones
+		c.entries[f.id] = c.lru.PushBack(&entry{id: f.id, data: data, persisted: true})
+		c.size += len(data)
+	}
+}
+
+// persist write the data atomically so a concurrent reader never sees a
+// partially written entry
+func (c *Cache) persist(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,142 @@
+package cache
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/fs"
+)
+
+func TestCache(t *testing.T) {
+	c := New(10, "")
+
+	_, ok := c.Get(BlobKind, "a")
+	assert.Assert(t, !ok)
+
+	c.Set(BlobKind, "a", []byte("12345"))
+	c.Set(TreeKind, "a", []byte("abc"))
+	data, ok := c.Get(BlobKind, "a")
+	assert.Assert(t, ok)
+	assert.Equal(t, string(data), "12345")
+	data, ok = c.Get(TreeKind, "a")
+	assert.Assert(t, ok)
+	assert.Equal(t, string(data), "abc")
+
+	// b evicts the least recently used blob/a to stay under 10 bytes
+	_, _ = c.Get(TreeKind, "a")
+	c.Set(BlobKind, "b", []byte("12345"))
+	_, ok = c.Get(BlobKind, "a")
+	assert.Assert(t, !ok)
+	_, ok = c.Get(TreeKind, "a")
+	assert.Assert(t, ok)
+
+	// too large to be cached at all
+	c.Set(BlobKind, "c", []byte("12345678901"))
+	_, ok = c.Get(BlobKind, "c")
+	assert.Assert(t, !ok)
+
+	assert.DeepEqual(t, c.Stats(), map[string]Stats{
+		BlobKind: {Hits: 1, Misses: 3},
+		TreeKind: {Hits: 3, Misses: 0},
+	})
+}
+
+func TestCacheNil(t *testing.T) {
+	var c *Cache
+	c.Set(BlobKind, "a", []byte("a"))
+	_, ok := c.Get(BlobKind, "a")
+	assert.Assert(t, !ok)
+	assert.DeepEqual(t, c.Stats(), map[string]Stats{})
+}
+
+func TestCacheDir(t *testing.T) {
+	dir := fs.NewDir(t, "cache")
+	defer dir.Remove()
+
+	c := New(DefaultMaxSize, dir.Path())
+	c.Set(BlobKind, "owner/repo/sha", []byte("hello"))
+
+	// a new cache, as after a restart, gets it from the disk
+	c = New(DefaultMaxSize, dir.Path())
+	data, ok := c.Get(BlobKind, "owner/repo/sha")
+	assert.Assert(t, ok)
+	assert.Equal(t, string(data), "hello")
+	_, ok = c.Get(TreeKind, "owner/repo/sha")
+	assert.Assert(t, !ok)
+}
+
+func TestCacheDirEviction(t *testing.T) {
+	dir := fs.NewDir(t, "cache")
+	defer dir.Remove()
+
+	c := New(10, dir.Path())
+	c.Set(BlobKind, "a", []byte("12345"))
+	c.Set(BlobKind, "b", []byte("12345"))
+	// c evicts a, from the memory and from the disk
+	c.Set(BlobKind, "c", []byte("12345"))
+	files, err := ioutil.ReadDir(filepath.Join(dir.Path(), BlobKind))
+	assert.NilError(t, err)
+	assert.Equal(t, len(files), 2)
+	_, ok := c.Get(BlobKind, "a")
+	assert.Assert(t, !ok)
+
+	// a smaller cache after a restart only keeps the most recent entry
+	now := time.Now()
+	assert.NilError(t, os.Chtimes(c.path(c.id(BlobKind, "b")), now.Add(-time.Hour), now.Add(-time.Hour)))
+	c = New(5, dir.Path())
+	files, err = ioutil.ReadDir(filepath.Join(dir.Path(), BlobKind))
+	assert.NilError(t, err)
+	assert.Equal(t, len(files), 1)
+	_, ok = c.Get(BlobKind, "c")
+	assert.Assert(t, ok)
+	_, ok = c.Get(BlobKind, "b")
+	assert.Assert(t, !ok)
+}
+
+func TestCacheTTL(t *testing.T) {
+	dir := fs.NewDir(t, "cache")
+	defer dir.Remove()
+
+	now := time.Now()
+	c := New(DefaultMaxSize, dir.Path())
+	c.now = func() time.Time { return now }
+	c.SetWithTTL(LatestKind, "task", []byte("0.1"), time.Minute)
+	data, ok := c.Get(LatestKind, "task")
+	assert.Assert(t, ok)
+	assert.Equal(t, string(data), "0.1")
+	// never persisted
+	_, err := os.Stat(filepath.Join(dir.Path(), LatestKind))
+	assert.Assert(t, os.IsNotExist(err))
+
+	now = now.Add(time.Minute)
+	_, ok = c.Get(LatestKind, "task")
+	assert.Assert(t, !ok)
+	c.SetWithTTL(LatestKind, "task", []byte("0.2"), time.Minute)
+	data, ok = c.Get(LatestKind, "task")
+	assert.Assert(t, ok)
+	assert.Equal(t, string(data), "0.2")
+}
+
+func TestWriteMetrics(t *testing.T) {
+	c := New(DefaultMaxSize, "")
+	c.Set(TaskKind, "a", []byte("a"))
+	_, _ = c.Get(TaskKind, "a")
+	_, _ = c.Get(BlobKind, "a")
+
+	b := &bytes.Buffer{}
+	assert.NilError(t, c.WriteMetrics(b))
+	assert.Equal(t, b.String(), `# HELP pac_cache_hits_total The number of lookups found in the cache.
+# TYPE pac_cache_hits_total counter
+pac_cache_hits_total{kind="blob"} 0
+pac_cache_hits_total{kind="task"} 1
+# HELP pac_cache_misses_total The number of lookups not found in the cache.
+# TYPE pac_cache_misses_total counter
+pac_cache_misses_total{kind="blob"} 1
+pac_cache_misses_total{kind="task"} 0
+`)
+}
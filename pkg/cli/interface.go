@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/openshift-pipelines/pipelines-as-code/pkg/cache"
 	pacversioned "github.com/openshift-pipelines/pipelines-as-code/pkg/generated/clientset/versioned"
 	"github.com/openshift-pipelines/pipelines-as-code/pkg/webvcs"
 	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
@@ -21,8 +22,10 @@ type Clients struct {
 	Kube           k8s.Interface
 	HTTPClient     http.Client
 	Log            *zap.SugaredLogger
-	GithubClient   webvcs.GithubVCS
+	VCSClient      webvcs.Interface
 	Dynamic        dynamic.Interface
+	// Cache the remote tasks and resolved PipelineRuns, nil to not cache them
+	Cache *cache.Cache
 }
 
 type Params interface {
@@ -36,6 +39,9 @@ type Params interface {
 	// SetGitAPIURL Set github token TODO: rename to a generic vcs
 	SetGitHubAPIURL(string)
 
+	// SetWebVCSType Set the Web VCS provider type (ie: github or gitlab)
+	SetWebVCSType(string)
+
 	// GetNamespace Get namesace
 	GetNamespace() string
 
@@ -48,5 +54,6 @@ type KubeInteractionIntf interface {
 	GetNamespace(context.Context, string) error
 	// TODO: we don't need tektonv1beta1client stuff here
 	WaitForPipelineRunSucceed(context.Context, tektonv1beta1client.TektonV1beta1Interface, *v1beta1.PipelineRun, time.Duration) error
-	CleanupPipelines(context.Context, string, string, int) error
+	CleanupPipelines(context.Context, string, string, string, int) error
+	GetPodLogs(context.Context, string, string, string) (string, error)
 }
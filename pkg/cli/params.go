@@ -20,6 +20,7 @@ type PacParams struct {
 	namespace      string
 	githubToken    string
 	githubAPIURL   string
+	webvcsType     string
 }
 
 var _ Params = (*PacParams)(nil)
@@ -40,6 +41,10 @@ func (p *PacParams) SetGitHubAPIURL(url string) {
 	p.githubAPIURL = url
 }
 
+func (p *PacParams) SetWebVCSType(vcsType string) {
+	p.webvcsType = vcsType
+}
+
 // Set kube client based on config
 func (p *PacParams) kubeClient(config *rest.Config) (k8s.Interface, error) {
 	k8scs, err := k8s.NewForConfig(config)
@@ -100,8 +105,8 @@ func (p *PacParams) pacClient(config *rest.Config) (pacversioned.Interface, erro
 	return cs, nil
 }
 
-func (p *PacParams) githubClient() webvcs.GithubVCS {
-	return webvcs.NewGithubVCS(p.githubToken, p.githubAPIURL)
+func (p *PacParams) vcsClient() (webvcs.Interface, error) {
+	return webvcs.New(p.webvcsType, p.githubToken, p.githubAPIURL)
 }
 
 // KubeClient returns only the kube client, not the tekton client
@@ -149,7 +154,10 @@ func (p *PacParams) Clients() (*Clients, error) {
 		return nil, err
 	}
 
-	ghClient := p.githubClient()
+	vcsClient, err := p.vcsClient()
+	if err != nil {
+		return nil, err
+	}
 
 	dynamic, err := p.dynamicClient(config)
 	if err != nil {
@@ -161,7 +169,7 @@ func (p *PacParams) Clients() (*Clients, error) {
 		Kube:           kube,
 		PipelineAsCode: pacc,
 		Log:            logger,
-		GithubClient:   ghClient,
+		VCSClient:      vcsClient,
 		Dynamic:        dynamic,
 	}
 
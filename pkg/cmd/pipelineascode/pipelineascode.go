@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
-	"strings"
 
 	"github.com/openshift-pipelines/pipelines-as-code/pkg/cli"
 	"github.com/openshift-pipelines/pipelines-as-code/pkg/flags"
@@ -18,6 +17,7 @@ import (
 const (
 	defaultURL             = "https://giphy.com/explore/cat"
 	defaultApplicationName = "Pipelines as Code CI"
+	defaultInstallNS       = "pipelines-as-code"
 )
 
 func Command(p cli.Params) *cobra.Command {
@@ -50,10 +50,22 @@ func Command(p cli.Params) *cobra.Command {
 
 	flags.AddPacOptions(cmd)
 	flags.AddWebCVSOptions(cmd)
+	cmd.AddCommand(serveCommand(p))
 
 	cmd.Flags().StringVarP(&opts.RunInfo.EventType, "webhook-type", "", os.Getenv("PAC_EVENT_TYPE"), "Payload event type as set from Github (ie: X-GitHub-Event header)")
 	cmd.Flags().StringVarP(&opts.RunInfo.TriggerTarget, "trigger-target", "", os.Getenv("PAC_TRIGGER_TARGET"), "The trigger target from where this event comes from")
 	cmd.Flags().StringVarP(&opts.PayloadFile, "payload-file", "", os.Getenv("PAC_PAYLOAD_FILE"), "A file containing the webhook payload")
+	cmd.Flags().StringVarP(&opts.PayloadSignature, "payload-signature", "", os.Getenv("PAC_PAYLOAD_SIGNATURE"),
+		"The webhook payload signature (ie: X-Hub-Signature-256 header) or token (ie: X-Gitlab-Token header)")
+	cmd.Flags().StringVarP(&opts.WebhookSecretName, "webhook-secret", "", os.Getenv("PAC_WEBHOOK_SECRET"),
+		"The Secret with the webhook.secret key to verify the payload signature with")
+	webhookSecretNS := os.Getenv("PAC_WEBHOOK_SECRET_NAMESPACE")
+	if webhookSecretNS == "" {
+		webhookSecretNS = defaultInstallNS
+	}
+	cmd.Flags().StringVarP(&opts.WebhookSecretNamespace, "webhook-secret-namespace", "", webhookSecretNS,
+		"The namespace of the webhook Secret")
+
 	applicationName := os.Getenv("PAC_APPLICATION_NAME")
 	if applicationName == "" {
 		applicationName = defaultApplicationName
@@ -65,7 +77,7 @@ func Command(p cli.Params) *cobra.Command {
 	return cmd
 }
 
-func parsePayload(ctx context.Context, cs *cli.Clients, opts *pacpkg.Options) (*webvcs.RunInfo, error) {
+func readPayload(opts *pacpkg.Options) ([]byte, error) {
 	if opts.PayloadFile == "" {
 		return nil, fmt.Errorf("no payload file has been passed")
 	}
@@ -73,13 +85,11 @@ func parsePayload(ctx context.Context, cs *cli.Clients, opts *pacpkg.Options) (*
 	if err != nil {
 		return nil, err
 	}
+	return ioutil.ReadFile(opts.PayloadFile)
+}
 
-	payloadB, err := ioutil.ReadFile(opts.PayloadFile)
-	if err != nil {
-		return nil, err
-	}
-
-	payloadinfo, err := cs.GithubClient.ParsePayload(ctx, cs.Log, opts.RunInfo.EventType,
+func parsePayloadBytes(ctx context.Context, cs *cli.Clients, opts *pacpkg.Options, payloadB []byte) (*webvcs.RunInfo, error) {
+	payloadinfo, err := cs.VCSClient.ParsePayload(ctx, cs.Log, opts.RunInfo.EventType,
 		opts.RunInfo.TriggerTarget, string(payloadB))
 	if err != nil {
 		return &webvcs.RunInfo{}, err
@@ -95,10 +105,31 @@ func parsePayload(ctx context.Context, cs *cli.Clients, opts *pacpkg.Options) (*
 
 // Wrap around a Run, create a CheckStatusID if there is a failure.
 func runWrap(ctx context.Context, opts *pacpkg.Options, cs *cli.Clients, kinteract cli.KubeInteractionIntf) error {
-	runinfo, err := parsePayload(ctx, cs, opts)
+	payload, err := readPayload(opts)
+	if err != nil {
+		return err
+	}
+	// Make sure the payload comes from the Web VCS before doing anything with it
+	verification, err := pacpkg.VerifyWebhookSignature(ctx, cs, opts, payload)
 	if err != nil {
 		return err
 	}
+	runinfo, err := parsePayloadBytes(ctx, cs, opts, payload)
+	if err != nil {
+		return err
+	}
+	return runPayload(ctx, cs, kinteract, verification, runinfo)
+}
+
+// runPayload run pipelines as code on a verified payload, once it is checked
+// to be signed for its repository
+func runPayload(ctx context.Context, cs *cli.Clients, kinteract cli.KubeInteractionIntf,
+	verification *pacpkg.WebhookVerification, runinfo *webvcs.RunInfo) error {
+	if err := verification.CheckRepository(ctx, cs, runinfo); err != nil {
+		cs.Log.Errorf("Rejecting the %s event payload for %s/%s from %s: %s", runinfo.EventType,
+			runinfo.Owner, runinfo.Repository, runinfo.Sender, err.Error())
+		return err
+	}
 
 	// Get webconsole url as soon as possible to have a link to click there
 	url, err := kinteract.GetConsoleUI(ctx, "", "")
@@ -110,8 +141,14 @@ func runWrap(ctx context.Context, opts *pacpkg.Options, cs *cli.Clients, kintera
 
 	err = pacpkg.Run(ctx, cs, kinteract, runinfo)
 	if err != nil {
-		if runinfo.CheckRunID != nil && !strings.Contains(err.Error(), "403 Resource not accessible by integration") {
-			_, _ = cs.GithubClient.CreateStatus(ctx, runinfo, "completed", "failure",
+		// The check run named after the application is only created when
+		// there is something to report on it, the Web VCS client falls back
+		// to a commit status when the token cannot create it
+		if runinfo.CheckRunID == nil {
+			_ = cs.VCSClient.CreateCheckRun(ctx, "in_progress", runinfo)
+		}
+		if runinfo.CheckRunID != nil {
+			_ = cs.VCSClient.CreateStatus(ctx, runinfo, "completed", "failure",
 				fmt.Sprintf("There was an issue validating the commit: %q", err),
 				runinfo.LogURL)
 		} else {
@@ -55,7 +55,7 @@ func TestRunWrapPR(t *testing.T) {
 		fmt.Fprintf(w, `{"id": %d}`, checkid)
 	})
 	cs := &cli.Clients{
-		GithubClient: webvcs.GithubVCS{
+		VCSClient: webvcs.GithubVCS{
 			Client: fakeghclient,
 		},
 		Log:            fakelogger,
@@ -88,7 +88,7 @@ func TestGetInfo(t *testing.T) {
 	})
 	ctx, _ := rtesting.SetupFakeContext(t)
 	cs := &cli.Clients{
-		GithubClient: webvcs.GithubVCS{
+		VCSClient: webvcs.GithubVCS{
 			Client: fakeclient,
 		},
 	}
@@ -165,7 +165,9 @@ func TestGetInfo(t *testing.T) {
 				PayloadFile: file.Path(),
 				RunInfo:     tC.runinfo,
 			}
-			runinfo, err := parsePayload(ctx, cs, opts)
+			payload, err := readPayload(opts)
+			assert.NilError(t, err)
+			runinfo, err := parsePayloadBytes(ctx, cs, opts, payload)
 			if tC.errmsg != "" {
 				assert.ErrorContains(t, err, tC.errmsg)
 			} else {
@@ -202,7 +204,7 @@ func TestRunWrap(t *testing.T) {
 
 	defer teardown()
 	cs := &cli.Clients{
-		GithubClient: webvcs.GithubVCS{
+		VCSClient: webvcs.GithubVCS{
 			Client: fakeghclient,
 		},
 		Log:            fakelogger,
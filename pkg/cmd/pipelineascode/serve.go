@@ -0,0 +1,369 @@
+package pipelineascode
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/openshift-pipelines/pipelines-as-code/pkg/cache"
+	"github.com/openshift-pipelines/pipelines-as-code/pkg/cli"
+	pacinformers "github.com/openshift-pipelines/pipelines-as-code/pkg/generated/informers/externalversions"
+	"github.com/openshift-pipelines/pipelines-as-code/pkg/kubeinteraction"
+	pacpkg "github.com/openshift-pipelines/pipelines-as-code/pkg/pipelineascode"
+	"github.com/openshift-pipelines/pipelines-as-code/pkg/webvcs"
+	"github.com/spf13/cobra"
+)
+
+const (
+	defaultListenAddress    = ":8080"
+	defaultMetricsAddress   = ":9090"
+	webhookPath             = "/webhook"
+	healthPath              = "/live"
+	metricsPath             = "/metrics"
+	maxPayloadSize          = 25 << 20 // GitHub caps the webhook payloads to 25MB
+	shutdownTimeout         = 30 * time.Second
+	serverReadHeaderTimeout = 10 * time.Second
+	defaultWorkers          = 8
+	defaultQueueSize        = 100
+)
+
+// webhookEvent is a verified webhook event queued for the workers
+type webhookEvent struct {
+	opts         pacpkg.Options
+	verification *pacpkg.WebhookVerification
+	payload      []byte
+}
+
+// webhookServer receive the raw webhook events from the Web VCS and run
+// pipelines as code on them in process with a fixed number of workers
+type webhookServer struct {
+	ctx       context.Context
+	opts      *pacpkg.Options
+	cs        *cli.Clients
+	kinteract cli.KubeInteractionIntf
+	wg        sync.WaitGroup
+	events    chan webhookEvent
+
+	// githubApp generate the token of the GitHub App installation an event
+	// comes from, it is nil when running with a static token. The tokens are
+	// only asked to the configured apiURL, never to a host from the request.
+	githubApp *webvcs.GithubApp
+	apiURL    string
+}
+
+func serveCommand(p cli.Params) *cobra.Command {
+	opts := &pacpkg.Options{}
+	var listenAddress, metricsAddress, githubAppSecret, cacheDir string
+	var cacheSize, workers, queueSize int
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Serve the webhook events from the Web VCS",
+		Long: `Listen for the webhook events sent by the Web VCS on the /webhook endpoint
+and run pipelines as code on them as soon as they come in.`,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			token, err := cmd.Flags().GetString("token")
+			if err != nil {
+				return err
+			}
+			vcsType, err := cmd.Flags().GetString("webvcs-type")
+			if err != nil {
+				return err
+			}
+			if token == "" && vcsType != "" && vcsType != webvcs.GithubType {
+				return fmt.Errorf("token option is not set properly")
+			}
+			cs, err := p.Clients()
+			if err != nil {
+				return err
+			}
+			kinteract, err := kubeinteraction.NewKubernetesInteraction(cs)
+			if err != nil {
+				return err
+			}
+
+			// The same commits come back over and over (i.e: /retest), what
+			// we got for them is cached for the lifetime of the server
+			cs.Cache = cache.New(cacheSize<<20, cacheDir)
+			if vcs, ok := cs.VCSClient.(webvcs.GithubVCS); ok {
+				vcs.Cache = cs.Cache
+				cs.VCSClient = vcs
+			}
+
+			webvcs.GithubRateLimits.SetLogger(cs.Log)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			// The payloads are verified before anything else is done with
+			// them, without listing the Repository CRs for each of them
+			informers := pacinformers.NewSharedInformerFactory(cs.PipelineAsCode, 0)
+			repositories := informers.Pipelinesascode().V1alpha1().Repositories().Lister()
+			informers.Start(ctx.Done())
+			for informer, synced := range informers.WaitForCacheSync(ctx.Done()) {
+				if !synced {
+					return fmt.Errorf("cannot sync the %v informer", informer)
+				}
+			}
+			opts.WebhookSecrets = pacpkg.NewWebhookSecretStore(repositories)
+
+			ws := &webhookServer{ctx: ctx, opts: opts, cs: cs, kinteract: kinteract}
+			// Without a token we are a GitHub App, generating a token for each event
+			if token == "" {
+				ws.githubApp, err = webvcs.NewGithubAppFromSecret(ctx, cs.Kube, opts.WebhookSecretNamespace, githubAppSecret)
+				if err != nil {
+					return err
+				}
+				if ws.apiURL, err = cmd.Flags().GetString("api-url"); err != nil {
+					return err
+				}
+			}
+			ws.startWorkers(workers, queueSize)
+			return serve(ctx, listenAddress, metricsAddress, ws)
+		},
+	}
+
+	cmd.Flags().StringVarP(&listenAddress, "listen-address", "", defaultListenAddress,
+		"The address the webhook server listens on")
+	cmd.Flags().StringVarP(&metricsAddress, "metrics-address", "", defaultMetricsAddress,
+		"The address the /metrics endpoint listens on, apart from the webhook events, empty to disable it")
+
+	cmd.Flags().IntVarP(&workers, "workers", "", defaultWorkers,
+		"The number of webhook events processed at the same time")
+	cmd.Flags().IntVarP(&queueSize, "queue-size", "", defaultQueueSize,
+		"The number of webhook events waiting for a worker before new ones are refused")
+
+	cmd.Flags().IntVarP(&cacheSize, "cache-size", "", cache.DefaultMaxSize>>20,
+		"The size in MB of the in memory cache of the files, tasks and PipelineRuns")
+	cmd.Flags().StringVarP(&cacheDir, "cache-dir", "", os.Getenv("PAC_CACHE_DIR"),
+		"A directory (i.e: a PersistentVolume) where to persist the cache across restarts")
+
+	webhookSecret := os.Getenv("PAC_WEBHOOK_SECRET")
+	if webhookSecret == "" {
+		webhookSecret = webvcs.GithubAppSecretName
+	}
+	cmd.Flags().StringVarP(&opts.WebhookSecretName, "webhook-secret", "", webhookSecret,
+		"The Secret with the webhook.secret key to verify the payload signature with")
+	webhookSecretNS := os.Getenv("PAC_WEBHOOK_SECRET_NAMESPACE")
+	if webhookSecretNS == "" {
+		webhookSecretNS = defaultInstallNS
+	}
+	cmd.Flags().StringVarP(&opts.WebhookSecretNamespace, "webhook-secret-namespace", "", webhookSecretNS,
+		"The namespace of the webhook and the GitHub App Secrets")
+
+	githubAppSecretName := os.Getenv("PAC_GITHUB_APP_SECRET")
+	if githubAppSecretName == "" {
+		githubAppSecretName = webvcs.GithubAppSecretName
+	}
+	cmd.Flags().StringVarP(&githubAppSecret, "github-app-secret", "", githubAppSecretName,
+		"The Secret with the GitHub App private.key and application_id used when no token is set")
+
+	applicationName := os.Getenv("PAC_APPLICATION_NAME")
+	if applicationName == "" {
+		applicationName = defaultApplicationName
+	}
+	cmd.Flags().StringVar(&opts.RunInfo.ApplicationName,
+		"application-name", applicationName,
+		"The name of the application.")
+	return cmd
+}
+
+// startWorkers start the workers processing the events queued by ServeHTTP
+func (ws *webhookServer) startWorkers(workers, queueSize int) {
+	ws.events = make(chan webhookEvent, queueSize)
+	for i := 0; i < workers; i++ {
+		ws.wg.Add(1)
+		go func() {
+			defer ws.wg.Done()
+			for event := range ws.events {
+				event := event
+				if err := ws.processEvent(&event.opts, event.verification, event.payload); err != nil {
+					ws.cs.Log.Errorf("Error while processing the %s event: %s", event.opts.RunInfo.EventType, err.Error())
+				}
+			}
+		}()
+	}
+}
+
+// stopWorkers wait for the workers to process the queued events, no event
+// can be queued anymore once called
+func (ws *webhookServer) stopWorkers() {
+	close(ws.events)
+	ws.wg.Wait()
+}
+
+// handler serve the webhook events and the health endpoint, it is the one
+// exposed to the Web VCS
+func (ws *webhookServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle(webhookPath, ws)
+	mux.HandleFunc(healthPath, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	})
+	return mux
+}
+
+// metricsHandler serve the metrics, on their own address so they are not
+// exposed with the webhook endpoint
+func (ws *webhookServer) metricsHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(metricsPath, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		_ = ws.cs.Cache.WriteMetrics(w)
+		_ = webvcs.GithubRateLimits.WriteMetrics(w)
+	})
+	return mux
+}
+
+// serve listen on the address, and the metrics on metricsAddress unless it is
+// empty, until a SIGINT or SIGTERM. The events which are queued or still
+// processing are waited for before returning
+func serve(ctx context.Context, address, metricsAddress string, ws *webhookServer) error {
+	srv := &http.Server{
+		Addr:              address,
+		Handler:           ws.handler(),
+		ReadHeaderTimeout: serverReadHeaderTimeout,
+	}
+
+	errc := make(chan error, 2)
+	go func() {
+		ws.cs.Log.Infof("Listening for webhook events on %s%s", address, webhookPath)
+		errc <- srv.ListenAndServe()
+	}()
+
+	var metricsSrv *http.Server
+	if metricsAddress != "" {
+		metricsSrv = &http.Server{
+			Addr:              metricsAddress,
+			Handler:           ws.metricsHandler(),
+			ReadHeaderTimeout: serverReadHeaderTimeout,
+		}
+		go func() {
+			ws.cs.Log.Infof("Serving the metrics on %s%s", metricsAddress, metricsPath)
+			errc <- metricsSrv.ListenAndServe()
+		}()
+	}
+
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigc)
+
+	select {
+	case err := <-errc:
+		return err
+	case sig := <-sigc:
+		ws.cs.Log.Infof("Received %s, shutting down", sig)
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	err := srv.Shutdown(shutdownCtx)
+	if metricsSrv != nil {
+		_ = metricsSrv.Shutdown(shutdownCtx)
+	}
+	ws.cs.Log.Infof("Waiting for the %d queued events and the ones still processing", len(ws.events))
+	ws.stopWorkers()
+	return err
+}
+
+func (ws *webhookServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "only POST is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	payload, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxPayloadSize))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("cannot read the payload: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	eventType, triggerTarget := ws.cs.VCSClient.ParseEventType(r.Header, payload)
+	if eventType == "" {
+		http.Error(w, "unknown webhook event", http.StatusBadRequest)
+		return
+	}
+	if triggerTarget == "" {
+		ws.cs.Log.Debugf("Skipping the %s event, there is nothing to run for it", eventType)
+		fmt.Fprintf(w, "skipping %s event\n", eventType)
+		return
+	}
+
+	opts := *ws.opts
+	opts.RunInfo.EventType = eventType
+	opts.RunInfo.TriggerTarget = triggerTarget
+	opts.PayloadSignature = ws.cs.VCSClient.WebhookSignature(r.Header)
+
+	// Make sure the payload comes from the Web VCS before doing anything with it
+	verification, err := pacpkg.VerifyWebhookSignature(r.Context(), ws.cs, &opts, payload)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	// A GitHub App always has a webhook secret, never ask an installation
+	// token for a payload which has not been verified
+	if ws.githubApp != nil && verification.Skipped {
+		ws.cs.Log.Errorf("Rejecting the %s event payload: no webhook secret configured for the GitHub App", eventType)
+		http.Error(w, "no webhook secret configured to verify the payload with", http.StatusUnauthorized)
+		return
+	}
+
+	select {
+	case ws.events <- webhookEvent{opts: opts, verification: verification, payload: payload}:
+	default:
+		ws.cs.Log.Errorf("Refusing the %s event, %d events are already waiting to be processed", eventType, cap(ws.events))
+		http.Error(w, "too many events waiting to be processed", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprintf(w, "accepted %s event\n", eventType)
+}
+
+// processEvent run pipelines as code on a webhook event, the event outlive the
+// request so it does not use the request context
+func (ws *webhookServer) processEvent(opts *pacpkg.Options, verification *pacpkg.WebhookVerification, payload []byte) error {
+	cs, err := ws.eventClients(payload)
+	if err != nil {
+		return err
+	}
+	runinfo, err := parsePayloadBytes(ws.ctx, cs, opts, payload)
+	if err != nil {
+		return err
+	}
+	return runPayload(ws.ctx, cs, ws.kinteract, verification, runinfo)
+}
+
+// eventClients get the clients to process an event with, when running as a
+// GitHub App the Web VCS client uses the token of the installation the event
+// comes from
+func (ws *webhookServer) eventClients(payload []byte) (*cli.Clients, error) {
+	if ws.githubApp == nil {
+		return ws.cs, nil
+	}
+	installationID, err := webvcs.GithubInstallationID(payload)
+	if err != nil {
+		return nil, err
+	}
+	token, err := ws.githubApp.Token(ws.ctx, ws.apiURL, installationID)
+	if err != nil {
+		return nil, err
+	}
+	login, err := ws.githubApp.Login(ws.ctx, ws.apiURL)
+	if err != nil {
+		return nil, err
+	}
+	vcs := webvcs.NewGithubVCS(token, ws.apiURL)
+	vcs.Cache = ws.cs.Cache
+	vcs.Login = login
+	cs := *ws.cs
+	cs.VCSClient = vcs
+	return &cs, nil
+}
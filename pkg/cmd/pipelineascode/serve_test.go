@@ -0,0 +1,289 @@
+package pipelineascode
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/openshift-pipelines/pipelines-as-code/pkg/cache"
+	"github.com/openshift-pipelines/pipelines-as-code/pkg/cli"
+	pacpkg "github.com/openshift-pipelines/pipelines-as-code/pkg/pipelineascode"
+	testclient "github.com/openshift-pipelines/pipelines-as-code/pkg/test/clients"
+	ghtesthelper "github.com/openshift-pipelines/pipelines-as-code/pkg/test/github"
+	kitesthelper "github.com/openshift-pipelines/pipelines-as-code/pkg/test/kubernetestint"
+	"github.com/openshift-pipelines/pipelines-as-code/pkg/webvcs"
+	"go.uber.org/zap"
+	zapobserver "go.uber.org/zap/zaptest/observer"
+	"gotest.tools/v3/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	rtesting "knative.dev/pkg/reconciler/testing"
+)
+
+func TestWebhookServer(t *testing.T) {
+	pullRequest, err := ioutil.ReadFile("testdata/pull_request.json")
+	assert.NilError(t, err)
+	mac := hmac.New(sha256.New, []byte("secret"))
+	_, _ = mac.Write(pullRequest)
+	goodSignature := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	tests := []struct {
+		name          string
+		method        string
+		event         string
+		signature     string
+		payload       []byte
+		wantCode      int
+		wantCheckRuns int
+		wantLog       string
+	}{
+		{
+			name:     "only post",
+			method:   http.MethodGet,
+			wantCode: http.StatusMethodNotAllowed,
+		},
+		{
+			name:     "unknown event",
+			method:   http.MethodPost,
+			payload:  []byte(`{}`),
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "skipped event",
+			method:   http.MethodPost,
+			event:    "push",
+			payload:  []byte(`{"deleted": true}`),
+			wantCode: http.StatusOK,
+		},
+		{
+			name:          "accepted event",
+			method:        http.MethodPost,
+			event:         "pull_request",
+			signature:     goodSignature,
+			payload:       pullRequest,
+			wantCode:      http.StatusAccepted,
+			wantCheckRuns: 1,
+		},
+		{
+			name:      "rejected event with a bad signature",
+			method:    http.MethodPost,
+			event:     "pull_request",
+			signature: "sha256=" + hex.EncodeToString([]byte("bad")),
+			payload:   pullRequest,
+			wantCode:  http.StatusUnauthorized,
+			wantLog:   "Rejecting the pull_request event payload",
+		},
+		{
+			name:     "rejected event without a signature",
+			method:   http.MethodPost,
+			event:    "pull_request",
+			payload:  pullRequest,
+			wantCode: http.StatusUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, _ := rtesting.SetupFakeContext(t)
+			fakeghclient, mux, _, teardown := ghtesthelper.SetupGH()
+			defer teardown()
+			checkRuns := 0
+			mux.HandleFunc("/repos/chmouel/scratchmyback/check-runs", func(w http.ResponseWriter, r *http.Request) {
+				checkRuns++
+				fmt.Fprint(w, `{"id": 1234}`)
+			})
+			mux.HandleFunc("/repos/chmouel/scratchmyback/check-runs/1234", func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, `{"id": 1234}`)
+			})
+			mux.HandleFunc("/repos/chmouel/scratchmyback/git/commits/ref", func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, `{"commit": {"message": "HELLO"}}`)
+			})
+
+			stdata, _ := testclient.SeedTestData(t, ctx, testclient.Data{
+				Secrets: []*corev1.Secret{
+					{
+						ObjectMeta: metav1.ObjectMeta{Name: "github-app-secret", Namespace: "pipelines-as-code"},
+						Data:       map[string][]byte{"webhook.secret": []byte("secret")},
+					},
+				},
+			})
+			observer, log := zapobserver.New(zap.InfoLevel)
+			ws := &webhookServer{
+				ctx: context.Background(),
+				opts: &pacpkg.Options{
+					WebhookSecretName:      "github-app-secret",
+					WebhookSecretNamespace: "pipelines-as-code",
+				},
+				cs: &cli.Clients{
+					VCSClient:      webvcs.GithubVCS{Client: fakeghclient},
+					Log:            zap.New(observer).Sugar(),
+					PipelineAsCode: stdata.PipelineAsCode,
+					Kube:           stdata.Kube,
+				},
+				kinteract: &kitesthelper.KinterfaceTest{ConsoleURL: "https://console.url"},
+			}
+
+			req := httptest.NewRequest(tt.method, webhookPath, bytes.NewReader(tt.payload))
+			if tt.event != "" {
+				req.Header.Set("X-GitHub-Event", tt.event)
+			}
+			if tt.signature != "" {
+				req.Header.Set(webvcs.SignatureHeader, tt.signature)
+			}
+			rec := httptest.NewRecorder()
+			ws.startWorkers(1, 1)
+			ws.ServeHTTP(rec, req)
+			ws.stopWorkers()
+
+			assert.Equal(t, rec.Code, tt.wantCode, rec.Body.String())
+			assert.Equal(t, checkRuns, tt.wantCheckRuns)
+			if tt.wantLog != "" {
+				assert.Assert(t, log.FilterMessageSnippet(tt.wantLog).Len() == 1, log.All())
+			}
+		})
+	}
+}
+
+func TestWebhookServerGithubApp(t *testing.T) {
+	pullRequest, err := ioutil.ReadFile("testdata/pull_request.json")
+	assert.NilError(t, err)
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NilError(t, err)
+	githubApp, err := webvcs.NewGithubApp(1234,
+		pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}))
+	assert.NilError(t, err)
+
+	mac := hmac.New(sha256.New, []byte("secret"))
+	_, _ = mac.Write(pullRequest)
+	signature := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	checkRunsAuth := ""
+	exchanges := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v3/app/installations/11956959/access_tokens", func(w http.ResponseWriter, r *http.Request) {
+		exchanges++
+		fmt.Fprint(w, `{"token": "installationtoken", "expires_at": "2100-01-01T00:00:00Z"}`)
+	})
+	mux.HandleFunc("/api/v3/app", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"slug": "pipelines-as-code"}`)
+	})
+	mux.HandleFunc("/api/v3/repos/chmouel/scratchmyback/check-runs", func(w http.ResponseWriter, r *http.Request) {
+		checkRunsAuth = r.Header.Get("Authorization")
+		fmt.Fprint(w, `{"id": 1234}`)
+	})
+	mux.HandleFunc("/api/v3/repos/chmouel/scratchmyback/check-runs/1234", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"id": 1234}`)
+	})
+	mux.HandleFunc("/api/v3/repos/chmouel/scratchmyback/git/commits/ref", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"commit": {"message": "HELLO"}}`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	ctx, _ := rtesting.SetupFakeContext(t)
+	stdata, _ := testclient.SeedTestData(t, ctx, testclient.Data{
+		Secrets: []*corev1.Secret{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "github-app-secret", Namespace: "pipelines-as-code"},
+				Data:       map[string][]byte{"webhook.secret": []byte("secret")},
+			},
+		},
+	})
+	observer, log := zapobserver.New(zap.InfoLevel)
+	ws := &webhookServer{
+		ctx:  context.Background(),
+		opts: &pacpkg.Options{},
+		cs: &cli.Clients{
+			VCSClient:      webvcs.GithubVCS{},
+			Log:            zap.New(observer).Sugar(),
+			PipelineAsCode: stdata.PipelineAsCode,
+			Kube:           stdata.Kube,
+		},
+		kinteract: &kitesthelper.KinterfaceTest{ConsoleURL: "https://console.url"},
+		githubApp: githubApp,
+		apiURL:    server.URL,
+	}
+	ws.startWorkers(1, 1)
+
+	// without a webhook secret to verify it with, no token is minted for the payload
+	req := httptest.NewRequest(http.MethodPost, webhookPath, bytes.NewReader(pullRequest))
+	req.Header.Set("X-GitHub-Event", "pull_request")
+	rec := httptest.NewRecorder()
+	ws.ServeHTTP(rec, req)
+	assert.Equal(t, rec.Code, http.StatusUnauthorized, rec.Body.String())
+
+	ws.opts.WebhookSecretName = "github-app-secret"
+	ws.opts.WebhookSecretNamespace = "pipelines-as-code"
+	req = httptest.NewRequest(http.MethodPost, webhookPath, bytes.NewReader(pullRequest))
+	req.Header.Set("X-GitHub-Event", "pull_request")
+	req.Header.Set(webvcs.SignatureHeader, signature)
+	rec = httptest.NewRecorder()
+	ws.ServeHTTP(rec, req)
+	ws.stopWorkers()
+
+	assert.Equal(t, rec.Code, http.StatusAccepted, rec.Body.String())
+	assert.Equal(t, exchanges, 1)
+	assert.Equal(t, log.FilterMessageSnippet("Error while processing").Len(), 0, log.All())
+	assert.Equal(t, checkRunsAuth, "Bearer installationtoken")
+}
+
+func TestWebhookServerQueueFull(t *testing.T) {
+	pullRequest, err := ioutil.ReadFile("testdata/pull_request.json")
+	assert.NilError(t, err)
+	ctx, _ := rtesting.SetupFakeContext(t)
+	stdata, _ := testclient.SeedTestData(t, ctx, testclient.Data{})
+	observer, log := zapobserver.New(zap.InfoLevel)
+	ws := &webhookServer{
+		ctx:  context.Background(),
+		opts: &pacpkg.Options{},
+		cs: &cli.Clients{
+			VCSClient:      webvcs.GithubVCS{},
+			Log:            zap.New(observer).Sugar(),
+			PipelineAsCode: stdata.PipelineAsCode,
+			Kube:           stdata.Kube,
+		},
+	}
+	// no worker to process the one event the queue can hold
+	ws.startWorkers(0, 1)
+
+	wantCodes := []int{http.StatusAccepted, http.StatusServiceUnavailable}
+	for _, wantCode := range wantCodes {
+		req := httptest.NewRequest(http.MethodPost, webhookPath, bytes.NewReader(pullRequest))
+		req.Header.Set("X-GitHub-Event", "pull_request")
+		rec := httptest.NewRecorder()
+		ws.ServeHTTP(rec, req)
+		assert.Equal(t, rec.Code, wantCode, rec.Body.String())
+	}
+	assert.Equal(t, log.FilterMessageSnippet("Refusing the pull_request event").Len(), 1, log.All())
+}
+
+func TestWebhookServerMetrics(t *testing.T) {
+	ws := &webhookServer{
+		cs: &cli.Clients{Cache: cache.New(cache.DefaultMaxSize, "")},
+	}
+
+	// the metrics are not served with the webhook events
+	rec := httptest.NewRecorder()
+	ws.handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, metricsPath, nil))
+	assert.Equal(t, rec.Code, http.StatusNotFound)
+
+	rec = httptest.NewRecorder()
+	ws.handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, healthPath, nil))
+	assert.Equal(t, rec.Code, http.StatusOK)
+
+	rec = httptest.NewRecorder()
+	ws.metricsHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, metricsPath, nil))
+	assert.Equal(t, rec.Code, http.StatusOK)
+	assert.Assert(t, strings.Contains(rec.Body.String(), "pac_cache"), rec.Body.String())
+}
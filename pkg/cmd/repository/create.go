@@ -266,7 +266,7 @@ func create(ctx context.Context, gitdir string, opts CreateOptions) error {
 			Name: "EventType",
 			Prompt: &survey.Select{
 				Message: "Enter the Git event type for triggering the pipeline: ",
-				Options: []string{"pull_request", "push"},
+				Options: []string{"pull_request", "push", "tag_push", "release"},
 				Default: "pull_request",
 			},
 		})
@@ -7,6 +7,7 @@ import (
 	"regexp"
 	"strings"
 
+	"github.com/gobwas/glob"
 	"github.com/openshift-pipelines/pipelines-as-code/pkg/apis/pipelinesascode"
 	apipac "github.com/openshift-pipelines/pipelines-as-code/pkg/apis/pipelinesascode/v1alpha1"
 	"github.com/openshift-pipelines/pipelines-as-code/pkg/cli"
@@ -18,10 +19,42 @@ const (
 	onEventAnnotation        = "on-event"
 	onTargetBranchAnnotation = "on-target-branch"
 	onTargetNamespace        = "target-namespace"
+	onPullRequestAction      = "on-pull-request-action"
+	onLabel                  = "on-label"
+	onPathChange             = "on-path-change"
+	onPathChangeIgnore       = "on-path-change-ignore"
 	reValidateTag            = `^\[(.*)\]$`
 	maxKeepRuns              = "max-keep-runs"
 )
 
+// defaultPullRequestActions are the pull request actions matched by the
+// PipelineRuns without the on-pull-request-action annotation
+var defaultPullRequestActions = []string{
+	webvcs.PullRequestActionOpened,
+	webvcs.PullRequestActionSynchronize,
+	webvcs.PullRequestActionReopened,
+}
+
+// IsDefaultPullRequestAction tells if the runinfo pull request action is
+// matched by the PipelineRuns without opting in to it, the events without an
+// action (i.e: a push or a comment) always are. A pull request marked as ready
+// for review is as well when the draft pull requests are skipped since it has
+// not been run yet.
+func IsDefaultPullRequestAction(runinfo *webvcs.RunInfo) bool {
+	if runinfo.EventType != webvcs.EventTypePullRequest || runinfo.PullRequestAction == "" {
+		return true
+	}
+	if runinfo.SkipDraftPullRequests && runinfo.PullRequestAction == webvcs.PullRequestActionReadyForReview {
+		return true
+	}
+	for _, action := range defaultPullRequestActions {
+		if runinfo.PullRequestAction == action {
+			return true
+		}
+	}
+	return false
+}
+
 // TODO: move to another file since it's common to all annotations_* files
 func getAnnotationValues(annotation string) ([]string, error) {
 	re := regexp.MustCompile(reValidateTag)
@@ -43,12 +76,38 @@ func getAnnotationValues(annotation string) ([]string, error) {
 	return splitted, nil
 }
 
+// Match is a PipelineRun matching an event, with the Repository of its
+// target-namespace annotation if it has one and its configuration
+type Match struct {
+	PipelineRun *v1beta1.PipelineRun
+	Repo        *apipac.Repository
+	Config      map[string]string
+}
+
+// MatchPipelinerunByAnnotation get all the PipelineRuns matching the runinfo
+// event by their annotations, in the order of pruns
 func MatchPipelinerunByAnnotation(ctx context.Context, pruns []*v1beta1.PipelineRun, cs *cli.Clients,
-	runinfo *webvcs.RunInfo) (*v1beta1.PipelineRun, *apipac.Repository, map[string]string, error) {
+	runinfo *webvcs.RunInfo) ([]Match, error) {
 	configurations := map[string]map[string]string{}
-	repo := &apipac.Repository{}
+	matches := []Match{}
+
+	// The files changed are only fetched once, when a PipelineRun needs them
+	var filesChanged []string
+	getFilesChanged := func() ([]string, error) {
+		if filesChanged != nil {
+			return filesChanged, nil
+		}
+		files, err := cs.VCSClient.GetFilesChanged(ctx, runinfo)
+		if err != nil {
+			return nil, fmt.Errorf("cannot get the files changed: %w", err)
+		}
+		filesChanged = files
+		return filesChanged, nil
+	}
+
 	for _, prun := range pruns {
 		configurations[prun.GetGenerateName()] = map[string]string{}
+		repo := &apipac.Repository{}
 		if prun.GetObjectMeta().GetAnnotations() == nil {
 			cs.Log.Warnf("PipelineRun %s does not have any annotations", prun.GetName())
 			continue
@@ -69,46 +128,238 @@ func MatchPipelinerunByAnnotation(ctx context.Context, pruns []*v1beta1.Pipeline
 			}
 		}
 
-		if targetEvent, ok := prun.GetObjectMeta().GetAnnotations()[pipelinesascode.
-			GroupName+"/"+onEventAnnotation]; ok {
-			matched, err := matchOnAnnotation(targetEvent, runinfo.EventType, false)
-			configurations[prun.GetGenerateName()]["target-event"] = targetEvent
+		matched, err := matchEventAndBranch(prun.GetObjectMeta().GetAnnotations(), runinfo,
+			configurations[prun.GetGenerateName()])
+		if err != nil {
+			return nil, err
+		}
+		if !matched {
+			continue
+		}
+
+		if targetLabel, ok := prun.GetObjectMeta().GetAnnotations()[pipelinesascode.
+			GroupName+"/"+onLabel]; ok {
+			matched, err := matchOnLabels(targetLabel, runinfo.PullRequestLabels)
+			configurations[prun.GetGenerateName()]["target-label"] = targetLabel
+			if err != nil {
+				return nil, err
+			}
+			if !matched {
+				continue
+			}
+		}
+
+		targetPaths, hasPaths := prun.GetObjectMeta().GetAnnotations()[pipelinesascode.GroupName+"/"+onPathChange]
+		ignorePaths, hasIgnores := prun.GetObjectMeta().GetAnnotations()[pipelinesascode.GroupName+"/"+onPathChangeIgnore]
+		if hasPaths || hasIgnores {
+			configurations[prun.GetGenerateName()]["target-path"] = targetPaths
+			files, err := getFilesChanged()
+			if err != nil {
+				return nil, err
+			}
+			matched, err := matchOnPathChange(targetPaths, hasPaths, ignorePaths, hasIgnores, files)
 			if err != nil {
-				return nil, nil, map[string]string{}, err
+				return nil, err
 			}
 			if !matched {
 				continue
 			}
 		}
 
-		if targetBranch, ok := prun.GetObjectMeta().GetAnnotations()[pipelinesascode.
-			GroupName+"/"+onTargetBranchAnnotation]; ok {
-			matched, err := matchOnAnnotation(targetBranch, runinfo.BaseBranch, true)
-			configurations[prun.GetGenerateName()]["target-branch"] = targetBranch
+		// The other pull request actions have to be opted in
+		if !IsDefaultPullRequestAction(runinfo) {
+			matched, err := matchPullRequestAction(prun.GetObjectMeta().GetAnnotations(), runinfo)
+			configurations[prun.GetGenerateName()]["target-action"] = prun.GetObjectMeta().GetAnnotations()[pipelinesascode.
+				GroupName+"/"+onPullRequestAction]
 			if err != nil {
-				return nil, nil, map[string]string{}, err
+				return nil, err
 			}
 			if !matched {
 				continue
 			}
 		}
 
-		return prun, repo, configurations[prun.GetGenerateName()], nil
+		matches = append(matches, Match{PipelineRun: prun, Repo: repo, Config: configurations[prun.GetGenerateName()]})
+	}
+	if len(matches) > 0 {
+		return matches, nil
 	}
 
 	cs.Log.Infof("cannot match between event and pipelineRuns: URL=%s baseBranch=%s, "+
-		"eventType=%s", runinfo.URL,
+		"eventType=%s action=%s", runinfo.URL,
 		runinfo.BaseBranch,
-		runinfo.EventType)
+		runinfo.EventType, runinfo.PullRequestAction)
 
 	cs.Log.Info("available configuration in pipelineRuns annotations")
 	for prunname, maps := range configurations {
-		cs.Log.Infof("pipelineRun: %s, baseBranch=%s, targetEvent=%s, targetNs=%s",
-			prunname, maps["target-branch"], maps["target-event"], maps["target-namespace"])
+		cs.Log.Infof("pipelineRun: %s, baseBranch=%s, targetEvent=%s, targetNs=%s, targetAction=%s, targetLabel=%s, targetPath=%s",
+			prunname, maps["target-branch"], maps["target-event"], maps["target-namespace"], maps["target-action"],
+			maps["target-label"], maps["target-path"])
 	}
 
 	// TODO: more descriptive error message
-	return nil, nil, map[string]string{}, fmt.Errorf("cannot match pipeline from webhook to pipelineruns")
+	return nil, fmt.Errorf("cannot match pipeline from webhook to pipelineruns")
+}
+
+// OriginalPipelineRunName get the name a PipelineRun has in the .tekton
+// directory, they are created with a generateName based on it
+func OriginalPipelineRunName(pr *v1beta1.PipelineRun) string {
+	if pr.GetGenerateName() != "" {
+		return strings.TrimSuffix(pr.GetGenerateName(), "-")
+	}
+	return pr.GetName()
+}
+
+// matchEventAndBranch tells if a PipelineRun matches the runinfo event type
+// and target branch with its on-event and on-target-branch annotations, the
+// annotations it has are recorded in configuration
+func matchEventAndBranch(annotations map[string]string, runinfo *webvcs.RunInfo, configuration map[string]string) (bool, error) {
+	if targetEvent, ok := annotations[pipelinesascode.GroupName+"/"+onEventAnnotation]; ok {
+		matched, err := matchOnAnnotation(targetEvent, runinfo.EventType, false)
+		// a tag push is a push as well
+		if err == nil && !matched && runinfo.EventType == webvcs.EventTypeTagPush {
+			matched, err = matchOnAnnotation(targetEvent, webvcs.EventTypePush, false)
+		}
+		configuration["target-event"] = targetEvent
+		if err != nil || !matched {
+			return false, err
+		}
+	}
+
+	if targetBranch, ok := annotations[pipelinesascode.GroupName+"/"+onTargetBranchAnnotation]; ok {
+		matched, err := matchOnAnnotation(targetBranch, runinfo.BaseBranch, true)
+		configuration["target-branch"] = targetBranch
+		if err != nil || !matched {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// MatchPipelinerunByName get the PipelineRun named name in the .tekton
+// directory for a comment asking to run it, it still has to match the event
+// and the target branch but not its other annotations (i.e: the labels or the
+// paths changed)
+func MatchPipelinerunByName(ctx context.Context, pruns []*v1beta1.PipelineRun, cs *cli.Clients,
+	runinfo *webvcs.RunInfo, name string) ([]Match, error) {
+	for _, prun := range pruns {
+		if OriginalPipelineRunName(prun) != name {
+			continue
+		}
+		match := Match{PipelineRun: prun, Repo: &apipac.Repository{}, Config: map[string]string{}}
+		annotations := prun.GetObjectMeta().GetAnnotations()
+		matched, err := matchEventAndBranch(annotations, runinfo, map[string]string{})
+		if err != nil {
+			return nil, err
+		}
+		if !matched {
+			return nil, fmt.Errorf("the PipelineRun %s does not run on the %s event to the %s branch",
+				name, runinfo.EventType, runinfo.BaseBranch)
+		}
+		if maxPrNumber, ok := annotations[pipelinesascode.GroupName+"/"+maxKeepRuns]; ok {
+			match.Config["max-keep-runs"] = maxPrNumber
+		}
+		if targetNS, ok := annotations[pipelinesascode.GroupName+"/"+onTargetNamespace]; ok {
+			match.Config["target-namespace"] = targetNS
+			match.Repo, _ = GetRepoByCR(ctx, cs, targetNS, runinfo)
+			if match.Repo == nil {
+				return nil, fmt.Errorf("could not find Repository CRD in %s while pipelineRun %s targets it", targetNS, name)
+			}
+		}
+		return []Match{match}, nil
+	}
+	return nil, fmt.Errorf("cannot find a PipelineRun named %s", name)
+}
+
+// matchPullRequestAction tells if a PipelineRun opted in to the runinfo pull
+// request action with the on-pull-request-action annotation, a PipelineRun
+// matching on labels is opted in to one of its labels getting added
+func matchPullRequestAction(annotations map[string]string, runinfo *webvcs.RunInfo) (bool, error) {
+	if targetLabel, ok := annotations[pipelinesascode.GroupName+"/"+onLabel]; ok &&
+		runinfo.PullRequestAction == webvcs.PullRequestActionLabeled {
+		matched, err := matchOnAnnotation(targetLabel, runinfo.PullRequestLabel, false)
+		if err != nil || matched {
+			return matched, err
+		}
+	}
+
+	targetAction, ok := annotations[pipelinesascode.GroupName+"/"+onPullRequestAction]
+	if !ok {
+		return false, nil
+	}
+	return matchOnAnnotation(targetAction, runinfo.PullRequestAction, false)
+}
+
+// matchOnLabels tells if one of the labels of the pull request is in the
+// on-label annotation
+func matchOnLabels(annotation string, labels []string) (bool, error) {
+	targets, err := getAnnotationValues(annotation)
+	if err != nil {
+		return false, err
+	}
+	for _, target := range targets {
+		for _, label := range labels {
+			if target == label {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// compilePathGlobs compile the globs of a path annotation, a * doesn't match
+// the / of a path while a ** does
+func compilePathGlobs(annotation string) ([]glob.Glob, error) {
+	patterns, err := getAnnotationValues(annotation)
+	if err != nil {
+		return nil, err
+	}
+	globs := make([]glob.Glob, 0, len(patterns))
+	for _, pattern := range patterns {
+		g, err := glob.Compile(pattern, '/')
+		if err != nil {
+			return nil, fmt.Errorf("invalid path pattern %s: %w", pattern, err)
+		}
+		globs = append(globs, g)
+	}
+	return globs, nil
+}
+
+func matchAnyGlob(globs []glob.Glob, file string) bool {
+	for _, g := range globs {
+		if g.Match(file) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchOnPathChange tells if one of the files changed, not matching the
+// on-path-change-ignore globs, matches the on-path-change globs, any file not
+// ignored matches when there is no on-path-change annotation
+func matchOnPathChange(targetPaths string, hasPaths bool, ignorePaths string, hasIgnores bool, files []string) (bool, error) {
+	var targets, ignores []glob.Glob
+	var err error
+	if hasPaths {
+		if targets, err = compilePathGlobs(targetPaths); err != nil {
+			return false, err
+		}
+	}
+	if hasIgnores {
+		if ignores, err = compilePathGlobs(ignorePaths); err != nil {
+			return false, err
+		}
+	}
+
+	for _, file := range files {
+		if matchAnyGlob(ignores, file) {
+			continue
+		}
+		if !hasPaths || matchAnyGlob(targets, file) {
+			return true, nil
+		}
+	}
+	return false, nil
 }
 
 func matchOnAnnotation(annotations string, runinfoValue string, branchMatching bool) (bool, error) {
@@ -2,6 +2,7 @@ package config
 
 import (
 	"fmt"
+	"net/http"
 	"reflect"
 	"strings"
 	"testing"
@@ -10,6 +11,7 @@ import (
 	"github.com/openshift-pipelines/pipelines-as-code/pkg/apis/pipelinesascode/v1alpha1"
 	"github.com/openshift-pipelines/pipelines-as-code/pkg/cli"
 	testclient "github.com/openshift-pipelines/pipelines-as-code/pkg/test/clients"
+	ghtesthelper "github.com/openshift-pipelines/pipelines-as-code/pkg/test/github"
 	testnewrepo "github.com/openshift-pipelines/pipelines-as-code/pkg/test/repository"
 	"github.com/openshift-pipelines/pipelines-as-code/pkg/webvcs"
 	tektonv1beta1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
@@ -95,7 +97,7 @@ func TestMatchPipelinerunAnnotationAndRepositories(t *testing.T) {
 			observer, log := zapobserver.New(zap.InfoLevel)
 			logger := zap.New(observer).Sugar()
 			client := &cli.Clients{PipelineAsCode: cs.PipelineAsCode, Log: logger}
-			got, repo, _, err := MatchPipelinerunByAnnotation(ctx,
+			matches, err := MatchPipelinerunByAnnotation(ctx,
 				tt.args.pruns,
 				client, tt.args.runinfo)
 
@@ -108,10 +110,10 @@ func TestMatchPipelinerunAnnotationAndRepositories(t *testing.T) {
 			}
 
 			if tt.wantRepoName != "" {
-				assert.Assert(t, tt.wantRepoName == repo.GetName())
+				assert.Assert(t, tt.wantRepoName == matches[0].Repo.GetName())
 			}
 			if tt.wantPRName != "" {
-				assert.Assert(t, tt.wantPRName == got.GetName())
+				assert.Assert(t, tt.wantPRName == matches[0].PipelineRun.GetName())
 			}
 			if tt.wantLog != "" {
 				logmsg := log.TakeAll()
@@ -123,6 +125,17 @@ func TestMatchPipelinerunAnnotationAndRepositories(t *testing.T) {
 }
 
 func TestMatchPipelinerunByAnnotation(t *testing.T) {
+	pipelineE2E := &tektonv1beta1.PipelineRun{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "pipeline-e2e",
+			Annotations: map[string]string{
+				pipelinesascode.GroupName + "/" + onEventAnnotation:        "[pull_request]",
+				pipelinesascode.GroupName + "/" + onTargetBranchAnnotation: "[main]",
+				pipelinesascode.GroupName + "/" + onLabel:                  "[run-e2e, e2e]",
+			},
+		},
+	}
+
 	pipelineGood := &tektonv1beta1.PipelineRun{
 		ObjectMeta: metav1.ObjectMeta{
 			Name: "pipeline-good",
@@ -143,10 +156,42 @@ func TestMatchPipelinerunByAnnotation(t *testing.T) {
 		},
 	}
 
+	pipelineCode := &tektonv1beta1.PipelineRun{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "pipeline-code",
+			Annotations: map[string]string{
+				pipelinesascode.GroupName + "/" + onEventAnnotation:        "[pull_request]",
+				pipelinesascode.GroupName + "/" + onTargetBranchAnnotation: "[main]",
+				pipelinesascode.GroupName + "/" + onPathChangeIgnore:       "[docs/**, *.md]",
+			},
+		},
+	}
+
+	pipelineDocs := &tektonv1beta1.PipelineRun{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "pipeline-docs",
+			Annotations: map[string]string{
+				pipelinesascode.GroupName + "/" + onEventAnnotation:        "[pull_request]",
+				pipelinesascode.GroupName + "/" + onTargetBranchAnnotation: "[main]",
+				pipelinesascode.GroupName + "/" + onPathChange:             "[docs/**]",
+			},
+		},
+	}
+
+	fakeclient, mux, _, teardown := ghtesthelper.SetupGH()
+	defer teardown()
+	mux.HandleFunc("/repos/owner/repo/pulls/1/files", func(rw http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(rw, `[{"filename": "docs/install/README.md"}, {"filename": "README.md"}]`)
+	})
+	mux.HandleFunc("/repos/owner/repo/pulls/2/files", func(rw http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(rw, `[{"filename": "docs/install/README.md"}, {"filename": "pkg/main.go"}]`)
+	})
+
 	observer, log := zapobserver.New(zap.InfoLevel)
 	logger := zap.New(observer).Sugar()
 	cs := &cli.Clients{
-		Log: logger,
+		Log:       logger,
+		VCSClient: webvcs.GithubVCS{Client: fakeclient},
 	}
 
 	type args struct {
@@ -154,11 +199,11 @@ func TestMatchPipelinerunByAnnotation(t *testing.T) {
 		runinfo *webvcs.RunInfo
 	}
 	tests := []struct {
-		name       string
-		args       args
-		wantErr    bool
-		wantPrName string
-		wantLog    string
+		name        string
+		args        args
+		wantErr     bool
+		wantPrNames []string
+		wantLog     string
 	}{
 		{
 			name: "good-match-with-only-one",
@@ -166,17 +211,63 @@ func TestMatchPipelinerunByAnnotation(t *testing.T) {
 				pruns:   []*tektonv1beta1.PipelineRun{pipelineGood},
 				runinfo: &webvcs.RunInfo{EventType: "pull_request", BaseBranch: "main"},
 			},
-			wantErr:    false,
-			wantPrName: "pipeline-good",
+			wantErr:     false,
+			wantPrNames: []string{"pipeline-good"},
 		},
 		{
-			name: "first-one-match-with-two-good-ones",
+			name: "match-all-the-good-ones",
 			args: args{
 				pruns:   []*tektonv1beta1.PipelineRun{pipelineGood, pipelineOther},
 				runinfo: &webvcs.RunInfo{EventType: "pull_request", BaseBranch: "main"},
 			},
-			wantErr:    false,
-			wantPrName: "pipeline-good",
+			wantErr:     false,
+			wantPrNames: []string{"pipeline-good", "pipeline-other"},
+		},
+		{
+			name: "match-on-path-change",
+			args: args{
+				pruns: []*tektonv1beta1.PipelineRun{pipelineCode, pipelineDocs},
+				runinfo: &webvcs.RunInfo{
+					EventType: "pull_request", BaseBranch: "main", Owner: "owner", Repository: "repo",
+					PullRequestNumber: 1,
+				},
+			},
+			wantErr:     false,
+			wantPrNames: []string{"pipeline-docs"},
+		},
+		{
+			name: "match-on-path-change-not-ignored",
+			args: args{
+				pruns: []*tektonv1beta1.PipelineRun{pipelineCode, pipelineDocs},
+				runinfo: &webvcs.RunInfo{
+					EventType: "pull_request", BaseBranch: "main", Owner: "owner", Repository: "repo",
+					PullRequestNumber: 2,
+				},
+			},
+			wantErr:     false,
+			wantPrNames: []string{"pipeline-code", "pipeline-docs"},
+		},
+		{
+			name: "no-match-on-path-change-all-ignored",
+			args: args{
+				pruns: []*tektonv1beta1.PipelineRun{pipelineCode},
+				runinfo: &webvcs.RunInfo{
+					EventType: "pull_request", BaseBranch: "main", Owner: "owner", Repository: "repo",
+					PullRequestNumber: 1,
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "error-getting-the-files-changed",
+			args: args{
+				pruns: []*tektonv1beta1.PipelineRun{pipelineDocs},
+				runinfo: &webvcs.RunInfo{
+					EventType: "pull_request", BaseBranch: "main", Owner: "owner", Repository: "repo",
+					PullRequestNumber: 3,
+				},
+			},
+			wantErr: true,
 		},
 		{
 			name: "no-match-on-event",
@@ -186,6 +277,153 @@ func TestMatchPipelinerunByAnnotation(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "tag-push-match-on-push",
+			args: args{
+				pruns: []*tektonv1beta1.PipelineRun{pipelineGood, {
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "pipeline-push",
+						Annotations: map[string]string{
+							pipelinesascode.GroupName + "/" + onEventAnnotation:        "[push]",
+							pipelinesascode.GroupName + "/" + onTargetBranchAnnotation: "[refs/tags/*]",
+						},
+					},
+				}},
+				runinfo: &webvcs.RunInfo{EventType: "tag_push", BaseBranch: "refs/tags/v1.0.0"},
+			},
+			wantErr:     false,
+			wantPrNames: []string{"pipeline-push"},
+		},
+		{
+			name: "match-on-release",
+			args: args{
+				pruns: []*tektonv1beta1.PipelineRun{pipelineGood, {
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "pipeline-release",
+						Annotations: map[string]string{
+							pipelinesascode.GroupName + "/" + onEventAnnotation:        "[tag_push, release]",
+							pipelinesascode.GroupName + "/" + onTargetBranchAnnotation: "[refs/tags/v*]",
+						},
+					},
+				}},
+				runinfo: &webvcs.RunInfo{EventType: "release", BaseBranch: "refs/tags/v1.0.0"},
+			},
+			wantErr:     false,
+			wantPrNames: []string{"pipeline-release"},
+		},
+		{
+			name: "match-on-default-pull-request-action",
+			args: args{
+				pruns:   []*tektonv1beta1.PipelineRun{pipelineGood},
+				runinfo: &webvcs.RunInfo{EventType: "pull_request", BaseBranch: "main", PullRequestAction: "reopened"},
+			},
+			wantErr:     false,
+			wantPrNames: []string{"pipeline-good"},
+		},
+		{
+			name: "no-match-on-pull-request-action-not-opted-in",
+			args: args{
+				pruns:   []*tektonv1beta1.PipelineRun{pipelineGood, pipelineOther},
+				runinfo: &webvcs.RunInfo{EventType: "pull_request", BaseBranch: "main", PullRequestAction: "labeled"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "match-on-pull-request-action-opted-in",
+			args: args{
+				pruns: []*tektonv1beta1.PipelineRun{pipelineGood, {
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "pipeline-labeled",
+						Annotations: map[string]string{
+							pipelinesascode.GroupName + "/" + onEventAnnotation:        "[pull_request]",
+							pipelinesascode.GroupName + "/" + onTargetBranchAnnotation: "[main]",
+							pipelinesascode.GroupName + "/" + onPullRequestAction:      "[labeled, edited]",
+						},
+					},
+				}},
+				runinfo: &webvcs.RunInfo{EventType: "pull_request", BaseBranch: "main", PullRequestAction: "labeled"},
+			},
+			wantErr:     false,
+			wantPrNames: []string{"pipeline-labeled"},
+		},
+		{
+			name: "match-on-label",
+			args: args{
+				pruns: []*tektonv1beta1.PipelineRun{pipelineE2E, pipelineGood},
+				runinfo: &webvcs.RunInfo{
+					EventType: "pull_request", BaseBranch: "main",
+					PullRequestAction: "synchronize", PullRequestLabels: []string{"bug", "run-e2e"},
+				},
+			},
+			wantErr:     false,
+			wantPrNames: []string{"pipeline-e2e", "pipeline-good"},
+		},
+		{
+			name: "no-match-without-label",
+			args: args{
+				pruns: []*tektonv1beta1.PipelineRun{pipelineE2E, pipelineGood},
+				runinfo: &webvcs.RunInfo{
+					EventType: "pull_request", BaseBranch: "main",
+					PullRequestAction: "synchronize", PullRequestLabels: []string{"bug"},
+				},
+			},
+			wantErr:     false,
+			wantPrNames: []string{"pipeline-good"},
+		},
+		{
+			name: "match-on-label-added",
+			args: args{
+				pruns: []*tektonv1beta1.PipelineRun{pipelineGood, pipelineE2E},
+				runinfo: &webvcs.RunInfo{
+					EventType: "pull_request", BaseBranch: "main", PullRequestAction: "labeled",
+					PullRequestLabels: []string{"bug", "run-e2e"}, PullRequestLabel: "run-e2e",
+				},
+			},
+			wantErr:     false,
+			wantPrNames: []string{"pipeline-e2e"},
+		},
+		{
+			name: "no-match-on-other-label-added",
+			args: args{
+				pruns: []*tektonv1beta1.PipelineRun{pipelineGood, pipelineE2E},
+				runinfo: &webvcs.RunInfo{
+					EventType: "pull_request", BaseBranch: "main", PullRequestAction: "labeled",
+					PullRequestLabels: []string{"bug", "run-e2e"}, PullRequestLabel: "bug",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "bad-label-annotation",
+			args: args{
+				runinfo: &webvcs.RunInfo{EventType: "pull_request", BaseBranch: "main"},
+				pruns: []*tektonv1beta1.PipelineRun{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name: "bad-label-annotation",
+							Annotations: map[string]string{
+								pipelinesascode.GroupName + "/" + onEventAnnotation:        "[pull_request]",
+								pipelinesascode.GroupName + "/" + onTargetBranchAnnotation: "[main]",
+								pipelinesascode.GroupName + "/" + onLabel:                  "run-e2e",
+							},
+						},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "match-on-ready-for-review-when-skipping-drafts",
+			args: args{
+				pruns: []*tektonv1beta1.PipelineRun{pipelineGood},
+				runinfo: &webvcs.RunInfo{
+					EventType: "pull_request", BaseBranch: "main",
+					PullRequestAction: "ready_for_review", SkipDraftPullRequests: true,
+				},
+			},
+			wantErr:     false,
+			wantPrNames: []string{"pipeline-good"},
+		},
 		{
 			name: "no-match-on-target-branch",
 			args: args{
@@ -322,14 +560,18 @@ func TestMatchPipelinerunByAnnotation(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			ctx, _ := rtesting.SetupFakeContext(t)
-			got, _, _, err := MatchPipelinerunByAnnotation(ctx, tt.args.pruns, cs, tt.args.runinfo)
+			matches, err := MatchPipelinerunByAnnotation(ctx, tt.args.pruns, cs, tt.args.runinfo)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("MatchPipelinerunByAnnotation() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
 
-			if tt.wantPrName != "" {
-				assert.Assert(t, got.GetName() == tt.wantPrName, "Pipelinerun hasn't been matched: "+got.GetName()+"!="+tt.wantPrName)
+			if tt.wantPrNames != nil {
+				got := []string{}
+				for _, match := range matches {
+					got = append(got, match.PipelineRun.GetName())
+				}
+				assert.DeepEqual(t, got, tt.wantPrNames)
 			}
 			if tt.wantLog != "" {
 				logmsg := log.TakeAll()
@@ -340,6 +582,107 @@ func TestMatchPipelinerunByAnnotation(t *testing.T) {
 	}
 }
 
+func TestMatchPipelinerunByName(t *testing.T) {
+	pipelineLint := &tektonv1beta1.PipelineRun{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "lint-",
+			Annotations: map[string]string{
+				pipelinesascode.GroupName + "/" + onEventAnnotation:        "[pull_request]",
+				pipelinesascode.GroupName + "/" + onTargetBranchAnnotation: "[" + mainBranch + "]",
+				pipelinesascode.GroupName + "/" + onPathChange:             "[docs/**]",
+				pipelinesascode.GroupName + "/" + maxKeepRuns:              "2",
+			},
+		},
+	}
+	pipelinePush := &tektonv1beta1.PipelineRun{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "push-",
+			Annotations: map[string]string{
+				pipelinesascode.GroupName + "/" + onEventAnnotation: "[push]",
+			},
+		},
+	}
+	pipelineRelease := &tektonv1beta1.PipelineRun{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "release-",
+			Annotations: map[string]string{
+				pipelinesascode.GroupName + "/" + onEventAnnotation:        "[pull_request]",
+				pipelinesascode.GroupName + "/" + onTargetBranchAnnotation: "[release-*]",
+			},
+		},
+	}
+	pipelineTargetNS := &tektonv1beta1.PipelineRun{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "target-ns-",
+			Annotations: map[string]string{
+				pipelinesascode.GroupName + "/" + onTargetNamespace: targetNamespace,
+			},
+		},
+	}
+	runinfo := &webvcs.RunInfo{URL: targetURL, EventType: "pull_request", BaseBranch: mainBranch}
+
+	tests := []struct {
+		name, prName, wantRepoName, wantErr string
+		wantConfig                          map[string]string
+		repositories                        []*v1alpha1.Repository
+	}{
+		{
+			name:       "match whatever the paths changed",
+			prName:     "lint",
+			wantConfig: map[string]string{"max-keep-runs": "2"},
+		},
+		{
+			name:    "not on the event",
+			prName:  "push",
+			wantErr: "the PipelineRun push does not run on the pull_request event to the " + mainBranch + " branch",
+		},
+		{
+			name:    "not on the target branch",
+			prName:  "release",
+			wantErr: "the PipelineRun release does not run on the pull_request event to the " + mainBranch + " branch",
+		},
+		{
+			name:         "match with a target NS",
+			prName:       "target-ns",
+			wantRepoName: "test-good",
+			wantConfig:   map[string]string{"target-namespace": targetNamespace},
+			repositories: []*v1alpha1.Repository{
+				testnewrepo.NewRepo("test-good", targetURL, mainBranch, targetNamespace, targetNamespace, "pull_request"),
+			},
+		},
+		{
+			name:    "no repository in the target NS",
+			prName:  "target-ns",
+			wantErr: "could not find Repository CRD in " + targetNamespace,
+		},
+		{
+			name:    "unknown pipelinerun",
+			prName:  "unknown",
+			wantErr: "cannot find a PipelineRun named unknown",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, _ := rtesting.SetupFakeContext(t)
+			cs, _ := testclient.SeedTestData(t, ctx, testclient.Data{Repositories: tt.repositories})
+			observer, _ := zapobserver.New(zap.InfoLevel)
+			client := &cli.Clients{PipelineAsCode: cs.PipelineAsCode, Log: zap.New(observer).Sugar()}
+
+			matches, err := MatchPipelinerunByName(ctx, []*tektonv1beta1.PipelineRun{pipelineLint, pipelinePush, pipelineRelease, pipelineTargetNS},
+				client, runinfo, tt.prName)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, len(matches), 1)
+			assert.Equal(t, OriginalPipelineRunName(matches[0].PipelineRun), tt.prName)
+			assert.Equal(t, matches[0].Repo.GetName(), tt.wantRepoName)
+			assert.DeepEqual(t, matches[0].Config, tt.wantConfig)
+		})
+	}
+}
+
 func Test_getAnnotationValues(t *testing.T) {
 	type args struct {
 		annotation string
@@ -394,3 +737,71 @@ func Test_getAnnotationValues(t *testing.T) {
 		})
 	}
 }
+
+func Test_matchOnPathChange(t *testing.T) {
+	files := []string{"README.md", "docs/install/README.md", "pkg/webvcs/github.go"}
+	tests := []struct {
+		name        string
+		targetPaths string
+		ignorePaths string
+		want        bool
+		wantErr     bool
+	}{
+		{
+			name:        "match-a-path",
+			targetPaths: "[pkg/webvcs/github.go]",
+			want:        true,
+		},
+		{
+			name:        "star-does-not-cross-directories",
+			targetPaths: "[pkg/*.go]",
+			want:        false,
+		},
+		{
+			name:        "double-star-crosses-directories",
+			targetPaths: "[pkg/**.go]",
+			want:        true,
+		},
+		{
+			name:        "match-one-of-the-paths",
+			targetPaths: "[config/**, docs/**]",
+			want:        true,
+		},
+		{
+			name:        "ignore-all-the-files",
+			ignorePaths: "[**.md, pkg/**]",
+			want:        false,
+		},
+		{
+			name:        "ignore-some-files",
+			ignorePaths: "[**.md]",
+			want:        true,
+		},
+		{
+			name:        "target-files-all-ignored",
+			targetPaths: "[docs/**]",
+			ignorePaths: "[**.md]",
+			want:        false,
+		},
+		{
+			name:        "bad-glob",
+			targetPaths: "[pkg/[a-]]",
+			wantErr:     true,
+		},
+		{
+			name:        "bad-annotation",
+			ignorePaths: "docs/**",
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := matchOnPathChange(tt.targetPaths, tt.targetPaths != "", tt.ignorePaths, tt.ignorePaths != "", files)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("matchOnPathChange() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			assert.Equal(t, got, tt.want)
+		})
+	}
+}
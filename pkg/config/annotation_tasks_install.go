@@ -50,7 +50,7 @@ func (rt RemoteTasks) getTask(ctx context.Context, task string) (*tektonv1beta1.
 		defer res.Body.Close()
 		return rt.convertTotask(string(data))
 	case strings.Contains(task, "/"):
-		data, err := rt.Clients.GithubClient.GetFileInsideRepo(ctx, task, false, rt.Runinfo)
+		data, err := rt.Clients.VCSClient.GetFileInsideRepo(ctx, task, false, rt.Runinfo)
 		if err != nil {
 			return ret, err
 		}
@@ -64,6 +64,40 @@ func (rt RemoteTasks) getTask(ctx context.Context, task string) (*tektonv1beta1.
 	}
 }
 
+// isMutableTask tells if a remote task can change without a new commit, the
+// tasks fetched from an URL or the latest version of a task from the hub
+func isMutableTask(task string) bool {
+	switch {
+	case strings.HasPrefix(task, "https://"), strings.HasPrefix(task, "http://"):
+		return true
+	case strings.Contains(task, "/"):
+		return false
+	default:
+		return !strings.Contains(task, ":") || strings.HasSuffix(task, ":latest")
+	}
+}
+
+// HasMutableTasks tells if one of the remote tasks of the annotations can
+// change without a new commit, the PipelineRuns using them cannot be cached
+func HasMutableTasks(annotations map[string]string) bool {
+	rtareg := regexp.MustCompile(fmt.Sprintf("%s/%s", pipelinesascode.GroupName, taskAnnotationsRegexp))
+	for annotationK, annotationV := range annotations {
+		if !rtareg.Match([]byte(annotationK)) {
+			continue
+		}
+		tasks, err := getAnnotationValues(annotationV)
+		if err != nil {
+			continue
+		}
+		for _, v := range tasks {
+			if isMutableTask(v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // GetTaskFromAnnotations Get task remotely if they are on Annotations
 func (rt RemoteTasks) GetTaskFromAnnotations(ctx context.Context, annotations map[string]string) ([]*tektonv1beta1.Task, error) {
 	var ret []*tektonv1beta1.Task
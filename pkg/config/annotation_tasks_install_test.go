@@ -188,7 +188,7 @@ spec:
 			httpTestClient := httptesthelper.MakeHTTPTestClient(t, tt.remoteURLS)
 			cs := &cli.Clients{
 				HTTPClient: *httpTestClient,
-				GithubClient: webvcs.GithubVCS{
+				VCSClient: webvcs.GithubVCS{
 					Client: fakeGHclient,
 				},
 			}
@@ -212,3 +212,44 @@ spec:
 		})
 	}
 }
+
+func TestHasMutableTasks(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        bool
+	}{
+		{
+			name:        "no-remote-tasks",
+			annotations: map[string]string{"other": "[https://remote.task]"},
+		},
+		{
+			name:        "inside-repo",
+			annotations: map[string]string{pipelinesascode.GroupName + "/task": "[be/healthy]"},
+		},
+		{
+			name:        "hub-specific-version",
+			annotations: map[string]string{pipelinesascode.GroupName + "/task": "[chmouzie:0.2]"},
+		},
+		{
+			name:        "hub-latest",
+			annotations: map[string]string{pipelinesascode.GroupName + "/task-1": "[be/healthy, chmouzie]"},
+			want:        true,
+		},
+		{
+			name:        "hub-latest-version",
+			annotations: map[string]string{pipelinesascode.GroupName + "/task": "[chmouzie:latest]"},
+			want:        true,
+		},
+		{
+			name:        "remote-url",
+			annotations: map[string]string{pipelinesascode.GroupName + "/task": "[http://remote.task]"},
+			want:        true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, HasMutableTasks(tt.annotations), tt.want)
+		})
+	}
+}
@@ -24,6 +24,21 @@ func branchMatch(prunBranch, baseBranch string) bool {
 	return g.Match(baseBranch)
 }
 
+// eventMatch tells if the eventType of a run is matched by the target event
+// type, a tag push is a push as well so it is matched by push and a pull
+// request closed or merged is matched by pull_request
+func eventMatch(target, eventType string) bool {
+	switch {
+	case target == eventType:
+		return true
+	case target == webvcs.EventTypePush:
+		return eventType == webvcs.EventTypeTagPush
+	case target == webvcs.EventTypePullRequest:
+		return eventType == webvcs.EventTypePullRequestClosed || eventType == webvcs.EventTypePullRequestMerged
+	}
+	return false
+}
+
 func GetRepoByCR(ctx context.Context, cs *cli.Clients, ns string, runinfo *webvcs.RunInfo) (*apipac.Repository, error) {
 	repositories, err := cs.PipelineAsCode.PipelinesascodeV1alpha1().Repositories(ns).List(
 		ctx, metav1.ListOptions{})
@@ -37,7 +52,7 @@ func GetRepoByCR(ctx context.Context, cs *cli.Clients, ns string, runinfo *webvc
 				value.Spec.EventType, value.Spec.Branch))
 
 		if value.Spec.URL == runinfo.URL &&
-			value.Spec.EventType == runinfo.EventType {
+			eventMatch(value.Spec.EventType, runinfo.EventType) {
 			if value.Spec.Branch != runinfo.BaseBranch {
 				if !branchMatch(value.Spec.Branch, runinfo.BaseBranch) {
 					continue
@@ -132,6 +132,66 @@ func Test_getRepoByCR(t *testing.T) {
 			wantTargetNS: targetNamespace,
 			wantErr:      false,
 		},
+		{
+			name: "tag-push-matching-push",
+			args: args{
+				data: testclient.Data{
+					Repositories: []*v1alpha1.Repository{
+						testnewrepo.NewRepo("test-good", targetURL, "refs/tags/*",
+							targetNamespace, targetNamespace, "push"),
+					},
+				},
+				runinfo: &webvcs.RunInfo{
+					URL:        targetURL,
+					BaseBranch: "refs/tags/1.0",
+					EventType:  "tag_push",
+				},
+			},
+			wantTargetNS: targetNamespace,
+			wantErr:      false,
+		},
+		{
+			name: "push-not-matching-tag-push",
+			args: args{
+				data: testclient.Data{
+					Repositories: []*v1alpha1.Repository{
+						testnewrepo.NewRepo("test-good", targetURL, mainBranch,
+							targetNamespace, targetNamespace, "tag_push"),
+					},
+				},
+				runinfo: &webvcs.RunInfo{URL: targetURL, BaseBranch: mainBranch, EventType: "push"},
+			},
+			wantTargetNS: "",
+			wantErr:      false,
+		},
+		{
+			name: "pull-request-merged-matching-pull-request",
+			args: args{
+				data: testclient.Data{
+					Repositories: []*v1alpha1.Repository{
+						testnewrepo.NewRepo("test-good", targetURL, mainBranch,
+							targetNamespace, targetNamespace, "pull_request"),
+					},
+				},
+				runinfo: &webvcs.RunInfo{URL: targetURL, BaseBranch: mainBranch, EventType: "pull_request_merged"},
+			},
+			wantTargetNS: targetNamespace,
+			wantErr:      false,
+		},
+		{
+			name: "pull-request-not-matching-pull-request-closed",
+			args: args{
+				data: testclient.Data{
+					Repositories: []*v1alpha1.Repository{
+						testnewrepo.NewRepo("test-good", targetURL, mainBranch,
+							targetNamespace, targetNamespace, "pull_request_closed"),
+					},
+				},
+				runinfo: &webvcs.RunInfo{URL: targetURL, BaseBranch: mainBranch, EventType: "pull_request"},
+			},
+			wantTargetNS: "",
+			wantErr:      false,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
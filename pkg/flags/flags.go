@@ -12,6 +12,7 @@ const (
 	kubeConfig = "kubeconfig"
 	tokenFlag  = "token"
 	apiURLFlag = "api-url"
+	vcsType    = "webvcs-type"
 )
 
 // PacOptions holds struct of Pipelines as code Options
@@ -47,6 +48,9 @@ func AddWebCVSOptions(cmd *cobra.Command) {
 
 	cmd.PersistentFlags().StringP(apiURLFlag, "", os.Getenv("PAC_WEBVCS_URL"),
 		"Web VCS (ie: GitHub Enteprise) API URL")
+
+	cmd.PersistentFlags().StringP(vcsType, "", os.Getenv("PAC_WEBVCS_TYPE"),
+		"Web VCS type (github, github-status, gitlab, bitbucket-cloud, bitbucket-server or gitea), default to github")
 }
 
 func GetWebCVSOptions(p cli.Params, cmd *cobra.Command) error {
@@ -61,5 +65,11 @@ func GetWebCVSOptions(p cli.Params, cmd *cobra.Command) error {
 		return err
 	}
 	p.SetGitHubAPIURL(githubAPIURL)
+
+	webvcsType, err := cmd.Flags().GetString(vcsType)
+	if err != nil {
+		return err
+	}
+	p.SetWebVCSType(webvcsType)
 	return nil
 }
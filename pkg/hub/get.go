@@ -7,7 +7,9 @@ import (
 	"io/ioutil"
 	"net/http"
 	"strings"
+	"time"
 
+	"github.com/openshift-pipelines/pipelines-as-code/pkg/cache"
 	"github.com/openshift-pipelines/pipelines-as-code/pkg/cli"
 )
 
@@ -16,6 +18,11 @@ var (
 	hubBaseURL           = `https://api.hub.tekton.dev/v1`
 )
 
+// latestVersionTTL is how long the latest version of a task is cached, so a
+// burst of events does not look it up each time while a new version is still
+// picked up soon enough
+const latestVersionTTL = 5 * time.Minute
+
 func getURL(ctx context.Context, cli *cli.Clients, url string) ([]byte, error) {
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
@@ -26,6 +33,10 @@ func getURL(ctx context.Context, cli *cli.Clients, url string) ([]byte, error) {
 		return []byte{}, err
 	}
 	defer res.Body.Close()
+	// Do not let an error page be cached as a task
+	if res.StatusCode >= http.StatusMultipleChoices {
+		return []byte{}, fmt.Errorf("cannot get %s: status code %d", url, res.StatusCode)
+	}
 	data, err := ioutil.ReadAll(res.Body)
 	if err != nil {
 		return []byte{}, err
@@ -51,6 +62,11 @@ func getSpecificVersion(ctx context.Context, cli *cli.Clients, task string) (str
 }
 
 func getLatestVersion(ctx context.Context, cli *cli.Clients, task string) (string, error) {
+	latestKey := cache.Key(hubBaseURL, tektonCatalogHubName, task)
+	if rawURL, ok := cli.Cache.Get(cache.LatestKind, latestKey); ok {
+		return string(rawURL), nil
+	}
+
 	hr := new(hubResource)
 	data, err := getURL(ctx, cli, fmt.Sprintf("%s/resource/%s/task/%s", hubBaseURL, tektonCatalogHubName, task))
 	if err != nil {
@@ -60,14 +76,22 @@ func getLatestVersion(ctx context.Context, cli *cli.Clients, task string) (strin
 	if err != nil {
 		return "", err
 	}
+	cli.Cache.SetWithTTL(cache.LatestKind, latestKey, []byte(*hr.Data.LatestVersion.RawURL), latestVersionTTL)
 	return *hr.Data.LatestVersion.RawURL, nil
 }
 
+// GetTask get a task from the hub, the versioned tasks never change so they are
+// cached by their version, the latest version of a task is only cached for
+// latestVersionTTL but its content is cached by its versioned raw URL
 func GetTask(ctx context.Context, cli *cli.Clients, task string) (string, error) {
 	var rawURL string
 	var err error
 
+	versionKey := cache.Key(hubBaseURL, tektonCatalogHubName, task)
 	if strings.Contains(task, ":") {
+		if data, ok := cli.Cache.Get(cache.TaskKind, versionKey); ok {
+			return string(data), nil
+		}
 		rawURL, err = getSpecificVersion(ctx, cli, task)
 	} else {
 		rawURL, err = getLatestVersion(ctx, cli, task)
@@ -76,9 +100,16 @@ func GetTask(ctx context.Context, cli *cli.Clients, task string) (string, error)
 		return "", err
 	}
 
+	if data, ok := cli.Cache.Get(cache.TaskKind, rawURL); ok {
+		return string(data), nil
+	}
 	data, err := getURL(ctx, cli, rawURL)
 	if err != nil {
 		return "", err
 	}
+	cli.Cache.Set(cache.TaskKind, rawURL, data)
+	if strings.Contains(task, ":") {
+		cli.Cache.Set(cache.TaskKind, versionKey, data)
+	}
 	return string(data), err
 }
@@ -4,8 +4,10 @@ import (
 	"fmt"
 	"testing"
 
+	"github.com/openshift-pipelines/pipelines-as-code/pkg/cache"
 	"github.com/openshift-pipelines/pipelines-as-code/pkg/cli"
 	httptesthelper "github.com/openshift-pipelines/pipelines-as-code/pkg/test/http"
+	"gotest.tools/v3/assert"
 	rtesting "knative.dev/pkg/reconciler/testing"
 )
 
@@ -49,6 +51,21 @@ func TestGetTask(t *testing.T) {
 				},
 			},
 		},
+		{
+			name:    "get-task-not-found",
+			task:    "task3:0.1",
+			wantErr: true,
+			config: map[string]map[string]string{
+				fmt.Sprintf("%s/resource/%s/task/task3/0.1", hubBaseURL, tektonCatalogHubName): {
+					"body": `{"data":{"rawURL": "https://get.me/task3"}}`,
+					"code": "200",
+				},
+				"https://get.me/task3": {
+					"body": "Not Found",
+					"code": "404",
+				},
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -69,3 +86,36 @@ func TestGetTask(t *testing.T) {
 		})
 	}
 }
+
+func TestGetTaskCache(t *testing.T) {
+	ctx, _ := rtesting.SetupFakeContext(t)
+	specificURL := fmt.Sprintf("%s/resource/%s/task/task2/1.1", hubBaseURL, tektonCatalogHubName)
+	latestURL := fmt.Sprintf("%s/resource/%s/task/task1", hubBaseURL, tektonCatalogHubName)
+	cs := &cli.Clients{
+		HTTPClient: *httptesthelper.MakeHTTPTestClient(t, map[string]map[string]string{
+			specificURL:                {"body": `{"data":{"rawURL": "https://get.me/task2/1.1"}}`, "code": "200"},
+			"https://get.me/task2/1.1": {"body": "This is Task2", "code": "200"},
+			latestURL:                  {"body": `{"data":{"latestVersion": {"rawURL": "https://get.me/task1/0.2"}}}`, "code": "200"},
+			"https://get.me/task1/0.2": {"body": "This is Task1", "code": "200"},
+		}),
+		Cache: cache.New(cache.DefaultMaxSize, ""),
+	}
+	for _, task := range []string{"task2:1.1", "task1"} {
+		_, err := GetTask(ctx, cs, task)
+		assert.NilError(t, err)
+	}
+
+	// everything is in the cache, the latest version too until it expires
+	cs.HTTPClient = *httptesthelper.MakeHTTPTestClient(t, map[string]map[string]string{
+		specificURL:                {"code": "500"},
+		"https://get.me/task2/1.1": {"code": "500"},
+		latestURL:                  {"code": "500"},
+		"https://get.me/task1/0.2": {"code": "500"},
+	})
+	got, err := GetTask(ctx, cs, "task2:1.1")
+	assert.NilError(t, err)
+	assert.Equal(t, got, "This is Task2")
+	got, err = GetTask(ctx, cs, "task1")
+	assert.NilError(t, err)
+	assert.Equal(t, got, "This is Task1")
+}
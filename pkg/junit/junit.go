@@ -0,0 +1,180 @@
+package junit
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// message is the failure, error or skipped element of a test case
+type message struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Body    string `xml:",chardata"`
+}
+
+type testCase struct {
+	Name      string   `xml:"name,attr"`
+	ClassName string   `xml:"classname,attr"`
+	File      string   `xml:"file,attr"`
+	Line      int      `xml:"line,attr"`
+	Failure   *message `xml:"failure"`
+	Error     *message `xml:"error"`
+	Skipped   *message `xml:"skipped"`
+}
+
+// Failure is a failed test case, Path and Line are where it failed in the
+// repository if the report has them, the path being relative to its root
+type Failure struct {
+	Name    string
+	Path    string
+	Line    int
+	Message string
+}
+
+// Report is the results of the test cases of one or more JUnit XML reports
+type Report struct {
+	Passed   int
+	Failed   int
+	Skipped  int
+	Failures []Failure
+	// Truncated is true when a report has been cut before its end, only the
+	// test cases before the cut are counted
+	Truncated bool
+}
+
+// Parse a JUnit XML report, its root element is either testsuites or
+// testsuite. The report is read test case by test case so a report cut
+// before its end, i.e: by the size limit of a task result, still has the
+// test cases before the cut.
+func Parse(data []byte) (*Report, error) {
+	return parse(data, false)
+}
+
+// ParseSuites parse a list of sibling testsuite elements up to the end of
+// data, i.e: the test suites of a testsuites report which lost its beginning.
+func ParseSuites(data []byte) (*Report, error) {
+	return parse(data, true)
+}
+
+// parse a report, or the sibling test suites up to the end of data when
+// siblings is true
+func parse(data []byte, siblings bool) (*Report, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	report := &Report{}
+	// the file of each enclosing test suite, a test case is in the closest one
+	files := []string{}
+	for {
+		token, err := decoder.Token()
+		if siblings && len(files) == 0 && errors.Is(err, io.EOF) {
+			return report, nil
+		}
+		if err != nil {
+			return report.cut(files, err)
+		}
+
+		switch element := token.(type) {
+		case xml.StartElement:
+			if len(files) == 0 && element.Name.Local != "testsuite" && (siblings || element.Name.Local != "testsuites") {
+				return nil, fmt.Errorf("cannot parse the JUnit report: unexpected root element %s", element.Name.Local)
+			}
+			switch element.Name.Local {
+			case "testsuites", "testsuite":
+				file := ""
+				if len(files) > 0 {
+					file = files[len(files)-1]
+				}
+				for _, attr := range element.Attr {
+					if attr.Name.Local == "file" && attr.Value != "" {
+						file = attr.Value
+					}
+				}
+				files = append(files, file)
+			case "testcase":
+				tc := testCase{}
+				if err := decoder.DecodeElement(&tc, &element); err != nil {
+					return report.cut(files, err)
+				}
+				report.addTestCase(tc, files[len(files)-1])
+			}
+		case xml.EndElement:
+			if element.Name.Local == "testsuites" || element.Name.Local == "testsuite" {
+				files = files[:len(files)-1]
+				// the end of the root element
+				if len(files) == 0 && !siblings {
+					return report, nil
+				}
+			}
+		}
+	}
+}
+
+// cut end the parsing of a report on err, the report is truncated when the
+// data ends after its root element has been opened
+func (r *Report) cut(files []string, err error) (*Report, error) {
+	var syntaxErr *xml.SyntaxError
+	if len(files) > 0 && (errors.Is(err, io.ErrUnexpectedEOF) ||
+		(errors.As(err, &syntaxErr) && syntaxErr.Msg == "unexpected EOF")) {
+		r.Truncated = true
+		return r, nil
+	}
+	return nil, fmt.Errorf("cannot parse the JUnit report: %w", err)
+}
+
+// Add the results of another report to this one
+func (r *Report) Add(other *Report) {
+	r.Passed += other.Passed
+	r.Failed += other.Failed
+	r.Skipped += other.Skipped
+	r.Failures = append(r.Failures, other.Failures...)
+	r.Truncated = r.Truncated || other.Truncated
+}
+
+// Total is the number of test cases of the report
+func (r *Report) Total() int {
+	return r.Passed + r.Failed + r.Skipped
+}
+
+// Summary is the count of the passed, failed and skipped test cases
+func (r *Report) Summary() string {
+	return fmt.Sprintf("%d passed, %d failed, %d skipped", r.Passed, r.Failed, r.Skipped)
+}
+
+func (r *Report) addTestCase(tc testCase, file string) {
+	switch {
+	case tc.Failure != nil || tc.Error != nil:
+		r.Failed++
+		r.Failures = append(r.Failures, newFailure(tc, file))
+	case tc.Skipped != nil:
+		r.Skipped++
+	default:
+		r.Passed++
+	}
+}
+
+func newFailure(tc testCase, file string) Failure {
+	msg := tc.Failure
+	if msg == nil {
+		msg = tc.Error
+	}
+	name := tc.Name
+	if tc.ClassName != "" {
+		name = tc.ClassName + "." + tc.Name
+	}
+	if tc.File != "" {
+		file = tc.File
+	}
+	text := strings.TrimSpace(msg.Body)
+	if text == "" {
+		text = msg.Message
+	}
+	return Failure{
+		Name:    name,
+		Path:    strings.TrimPrefix(file, "./"),
+		Line:    tc.Line,
+		Message: text,
+	}
+}
@@ -0,0 +1,126 @@
+package junit
+
+import (
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    *Report
+		wantErr string
+	}{
+		{
+			name: "testsuites",
+			data: `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites>
+  <testsuite name="pkg/foo" file="./pkg/foo/foo_test.go">
+    <testcase classname="pkg/foo" name="TestOne" line="12">
+      <failure message="expected 1">
+        foo_test.go:14: expected 1, got 2
+      </failure>
+    </testcase>
+    <testcase classname="pkg/foo" name="TestTwo"></testcase>
+    <testcase classname="pkg/foo" name="TestThree"><skipped/></testcase>
+  </testsuite>
+  <testsuite name="pkg/bar">
+    <testcase name="TestBar" file="pkg/bar/bar_test.go" line="3">
+      <error message="panic: nil pointer"></error>
+    </testcase>
+  </testsuite>
+</testsuites>`,
+			want: &Report{
+				Passed:  1,
+				Failed:  2,
+				Skipped: 1,
+				Failures: []Failure{
+					{Name: "pkg/foo.TestOne", Path: "pkg/foo/foo_test.go", Line: 12, Message: "foo_test.go:14: expected 1, got 2"},
+					{Name: "TestBar", Path: "pkg/bar/bar_test.go", Line: 3, Message: "panic: nil pointer"},
+				},
+			},
+		},
+		{
+			name: "testsuite",
+			data: `<testsuite name="tests">
+  <testsuite name="nested" file="tests/test_nested.py">
+    <testcase classname="tests.test_nested" name="test_nested"><failure message="assert False"/></testcase>
+  </testsuite>
+  <testcase classname="tests.test_root" name="test_root"/>
+</testsuite>`,
+			want: &Report{
+				Passed: 1,
+				Failed: 1,
+				Failures: []Failure{
+					{Name: "tests.test_nested.test_nested", Path: "tests/test_nested.py", Message: "assert False"},
+				},
+			},
+		},
+		{
+			name: "truncated",
+			data: `<testsuites>
+  <testsuite name="pkg/foo" file="foo_test.go">
+    <testcase name="TestOne"><failure message="nope"/></testcase>
+    <testcase name="TestTwo"></testcase>
+    <testcase name="TestThree"><failu`,
+			want: &Report{
+				Passed:    1,
+				Failed:    1,
+				Failures:  []Failure{{Name: "TestOne", Path: "foo_test.go", Message: "nope"}},
+				Truncated: true,
+			},
+		},
+		{
+			name:    "not xml",
+			data:    "PASS",
+			wantErr: "cannot parse the JUnit report",
+		},
+		{
+			name:    "not junit",
+			data:    "<html></html>",
+			wantErr: "unexpected root element html",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse([]byte(tt.data))
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.DeepEqual(t, got, tt.want)
+		})
+	}
+}
+
+func TestParseSuites(t *testing.T) {
+	got, err := ParseSuites([]byte(`<testsuite file="foo_test.go"><testcase name="TestOne"><failure message="nope"/></testcase></testsuite>
+<testsuite><testcase name="TestTwo"/></testsuite>
+<testsuite><testcase name="TestThree"><skipped/></testcase></testsuite>
+`))
+	assert.NilError(t, err)
+	assert.DeepEqual(t, got, &Report{
+		Passed:   1,
+		Failed:   1,
+		Skipped:  1,
+		Failures: []Failure{{Name: "TestOne", Path: "foo_test.go", Message: "nope"}},
+	})
+
+	got, err = ParseSuites([]byte(`<testsuite><testcase name="TestOne"/></testsuite><testsuite><testcase name="TestT`))
+	assert.NilError(t, err)
+	assert.DeepEqual(t, got, &Report{Passed: 1, Truncated: true})
+
+	_, err = ParseSuites([]byte(`<testsuites><testsuite/></testsuites>`))
+	assert.ErrorContains(t, err, "unexpected root element testsuites")
+}
+
+func TestReportAdd(t *testing.T) {
+	report := &Report{Passed: 1, Failures: []Failure{}}
+	report.Add(&Report{Passed: 2, Failed: 1, Skipped: 3, Failures: []Failure{{Name: "TestOne"}}, Truncated: true})
+	assert.DeepEqual(t, report, &Report{Passed: 3, Failed: 1, Skipped: 3, Failures: []Failure{{Name: "TestOne"}}, Truncated: true})
+	assert.Equal(t, report.Total(), 7)
+	assert.Equal(t, report.Summary(), "3 passed, 1 failed, 3 skipped")
+}
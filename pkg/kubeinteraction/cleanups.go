@@ -25,8 +25,11 @@ func (prs prByCompletionTime) Less(i, j int) bool {
 	return prs[j].Status.CompletionTime.Before(prs[i].Status.CompletionTime)
 }
 
-func (k Interaction) CleanupPipelines(ctx context.Context, namespace string, repositoryName string, maxKeep int) error {
-	labelSelector := fmt.Sprintf("pipelinesascode.tekton.dev/repository=%s", repositoryName)
+// CleanupPipelines keep only the maxKeep last PipelineRuns of the repository
+// created from the prName PipelineRun of its .tekton directory
+func (k Interaction) CleanupPipelines(ctx context.Context, namespace, repositoryName, prName string, maxKeep int) error {
+	labelSelector := fmt.Sprintf("pipelinesascode.tekton.dev/repository=%s,pipelinesascode.tekton.dev/original-prname=%s",
+		repositoryName, prName)
 
 	pruns, err := k.Clients.Tekton.TektonV1beta1().PipelineRuns(namespace).List(ctx, metav1.ListOptions{
 		LabelSelector: labelSelector,
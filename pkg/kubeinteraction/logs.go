@@ -0,0 +1,24 @@
+package kubeinteraction
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// maxPodLogLines bound the lines of a container log we get, the last ones
+// are the ones explaining why it failed
+const maxPodLogLines = int64(1000)
+
+// GetPodLogs get the last lines of the log of a container of a pod
+func (k Interaction) GetPodLogs(ctx context.Context, namespace, podName, containerName string) (string, error) {
+	tailLines := maxPodLogLines
+	logs, err := k.Clients.Kube.CoreV1().Pods(namespace).GetLogs(podName, &corev1.PodLogOptions{
+		Container: containerName,
+		TailLines: &tailLines,
+	}).DoRaw(ctx)
+	if err != nil {
+		return "", err
+	}
+	return string(logs), nil
+}
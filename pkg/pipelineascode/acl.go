@@ -2,15 +2,19 @@ package pipelineascode
 
 import (
 	"context"
+	"regexp"
 	"strings"
 
-	"github.com/google/go-github/v35/github"
 	"github.com/openshift-pipelines/pipelines-as-code/pkg/cli"
 	"github.com/openshift-pipelines/pipelines-as-code/pkg/webvcs"
 	"sigs.k8s.io/yaml"
 )
 
-var okToTestCommentRegexp = `(^|\n)/ok-to-test(\r\n|$)`
+var okToTestCommentRegexp = `(^|\n)/ok-to-test([ \t]+[0-9a-fA-F]{7,40})?[ \t]*(\r\n|$)`
+
+// okToTestCommitRegexp match the /ok-to-test of a comment approving a
+// commit, with its SHA or its abbreviated SHA
+var okToTestCommitRegexp = regexp.MustCompile(`(?m)^/ok-to-test[ \t]+([0-9a-fA-F]{7,40})[ \t]*\r?$`)
 
 // OwnersConfig prow owner, only supporting approvers or reviewers in yaml
 type OwnersConfig struct {
@@ -21,32 +25,56 @@ type OwnersConfig struct {
 // allowedOkToTestFromAnOwner Goes on evry comments in a pull-request and sess
 // if there is a /ok-to-test in there running an aclCheck again on the commment
 // Sender if she is an OWNER and then allow it to run CI.
-// TODO: pull out the github logic from there in an agnostic way.
 func aclAllowedOkToTestFromAnOwner(ctx context.Context, cs *cli.Clients, runinfo *webvcs.RunInfo) (bool, error) {
 	rinfo := &webvcs.RunInfo{}
 	runinfo.DeepCopyInto(rinfo)
 	rinfo.EventType = ""
 	rinfo.TriggerTarget = ""
-	if rinfo.Event == nil {
+	if rinfo.PullRequestNumber == 0 {
 		return false, nil
 	}
 
-	switch event := rinfo.Event.(type) {
-	case *github.IssueCommentEvent:
-		rinfo.URL = event.Issue.GetPullRequestLinks().GetHTMLURL()
-	case *github.PullRequestEvent:
-		rinfo.URL = event.GetPullRequest().GetHTMLURL()
-	default:
+	comments, err := cs.VCSClient.GetStringPullRequestComment(ctx, rinfo, okToTestCommentRegexp)
+	if err != nil {
+		return false, err
+	}
+
+	for _, comment := range comments {
+		rinfo.Sender = comment.Sender
+		allowed, err := aclCheckAll(ctx, cs, rinfo)
+		if err != nil {
+			return false, err
+		}
+		if allowed {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// aclApprovedCommitFromAnOwner tells if an owner approved the runinfo commit
+// with an /ok-to-test <sha> comment on the pull request. The approval is only
+// for that commit, the next commits pushed to the pull request have to be
+// approved again.
+func aclApprovedCommitFromAnOwner(ctx context.Context, cs *cli.Clients, runinfo *webvcs.RunInfo) (bool, error) {
+	rinfo := &webvcs.RunInfo{}
+	runinfo.DeepCopyInto(rinfo)
+	rinfo.EventType = ""
+	rinfo.TriggerTarget = ""
+	if rinfo.PullRequestNumber == 0 || rinfo.SHA == "" {
 		return false, nil
 	}
 
-	comments, err := cs.GithubClient.GetStringPullRequestComment(ctx, rinfo, okToTestCommentRegexp)
+	comments, err := cs.VCSClient.GetStringPullRequestComment(ctx, rinfo, okToTestCommitRegexp.String())
 	if err != nil {
 		return false, err
 	}
 
 	for _, comment := range comments {
-		rinfo.Sender = comment.User.GetLogin()
+		if !approvesCommit(comment.Body, runinfo.SHA) {
+			continue
+		}
+		rinfo.Sender = comment.Sender
 		allowed, err := aclCheckAll(ctx, cs, rinfo)
 		if err != nil {
 			return false, err
@@ -58,6 +86,16 @@ func aclAllowedOkToTestFromAnOwner(ctx context.Context, cs *cli.Clients, runinfo
 	return false, nil
 }
 
+// approvesCommit tells if a comment has an /ok-to-test for the sha commit
+func approvesCommit(comment, sha string) bool {
+	for _, match := range okToTestCommitRegexp.FindAllStringSubmatch(comment, -1) {
+		if strings.HasPrefix(strings.ToLower(sha), strings.ToLower(match[1])) {
+			return true
+		}
+	}
+	return false
+}
+
 // aclCheck check if we are allowed to run the pipeline on that PR
 func aclCheckAll(ctx context.Context, cs *cli.Clients, runinfo *webvcs.RunInfo) (bool, error) {
 	if runinfo.Owner == runinfo.Sender {
@@ -66,7 +104,7 @@ func aclCheckAll(ctx context.Context, cs *cli.Clients, runinfo *webvcs.RunInfo)
 
 	// If the user who has submitted the pr is a owner on the repo then allows
 	// the CI to be run.
-	isUserMemberRepo, err := cs.GithubClient.CheckSenderOrgMembership(ctx, runinfo)
+	isUserMemberRepo, err := cs.VCSClient.CheckSenderOrgMembership(ctx, runinfo)
 	if err != nil {
 		return false, err
 	}
@@ -77,7 +115,7 @@ func aclCheckAll(ctx context.Context, cs *cli.Clients, runinfo *webvcs.RunInfo)
 
 	// If we have a prow OWNERS file in the defaultBranch (ie: master) then
 	// parse it in approvers and reviewers field and check if sender is in there.
-	ownerFile, err := cs.GithubClient.GetFileFromDefaultBranch(ctx, "OWNERS", runinfo)
+	ownerFile, err := cs.VCSClient.GetFileFromDefaultBranch(ctx, "OWNERS", runinfo)
 
 	// Don't error out if the OWNERS file cannot be found
 	if err != nil && !strings.Contains(err.Error(), "cannot find") {
@@ -98,16 +136,32 @@ func aclCheckAll(ctx context.Context, cs *cli.Clients, runinfo *webvcs.RunInfo)
 	return false, nil
 }
 
-func aclCheck(ctx context.Context, cs *cli.Clients, runinfo *webvcs.RunInfo) (bool, error) {
+// aclCheck check if the sender is allowed to run the CI, it is trusted when it
+// is allowed by itself and not by the /ok-to-test of an owner
+func aclCheck(ctx context.Context, cs *cli.Clients, runinfo *webvcs.RunInfo) (allowed, trusted bool, err error) {
 	// Do most of the checks first, if user is a owner or in a organisation
-	allowed, err := aclCheckAll(ctx, cs, runinfo)
+	trusted, err = aclCheckAll(ctx, cs, runinfo)
 	if err != nil {
-		return false, err
+		return false, false, err
 	}
-	if allowed {
-		return true, nil
+	// The /test, /retest and /cancel commands are only taken from a comment
+	// author allowed by itself, the /ok-to-test of an owner does not let
+	// anyone else run them
+	if trusted || isCommentCommand(runinfo) {
+		return trusted, trusted, nil
 	}
 
 	// Finally try to parse all comments
-	return aclAllowedOkToTestFromAnOwner(ctx, cs, runinfo)
+	allowed, err = aclAllowedOkToTestFromAnOwner(ctx, cs, runinfo)
+	return allowed, false, err
+}
+
+// isCommentCommand tells if the run is for a pull request comment asking to
+// run or cancel the CI
+func isCommentCommand(runinfo *webvcs.RunInfo) bool {
+	switch runinfo.TriggerTarget {
+	case webvcs.TriggerTargetTestComment, webvcs.TriggerTargetRetestComment, webvcs.TriggerTargetCancelComment:
+		return true
+	}
+	return false
 }
@@ -6,7 +6,6 @@ import (
 	"net/http"
 	"testing"
 
-	"github.com/google/go-github/v35/github"
 	"github.com/openshift-pipelines/pipelines-as-code/pkg/cli"
 	ghtesthelper "github.com/openshift-pipelines/pipelines-as-code/pkg/test/github"
 	"github.com/openshift-pipelines/pipelines-as-code/pkg/webvcs"
@@ -32,6 +31,17 @@ func TestOkToTestComment(t *testing.T) {
 			allowed: true,
 			wantErr: false,
 		},
+		{
+			name:          "ok-to-test-a-commit",
+			commentsReply: `[{"body": "/ok-to-test 0123abcd", "user": {"login": "owner"}}]`,
+			runinfo: &webvcs.RunInfo{
+				Owner:     "owner",
+				Sender:    "nonowner",
+				EventType: "issue_comment",
+			},
+			allowed: true,
+			wantErr: false,
+		},
 		{
 			name:          "no-ok-to-test",
 			commentsReply: `[{"body": "Foo Bar", "user": {"login": "owner"}}]`,
@@ -57,14 +67,7 @@ func TestOkToTestComment(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			repoOwnerURL := "http://url.com/owner/repo/1"
-			tt.runinfo.Event = &github.IssueCommentEvent{
-				Issue: &github.Issue{
-					PullRequestLinks: &github.PullRequestLinks{
-						HTMLURL: &repoOwnerURL,
-					},
-				},
-			}
+			tt.runinfo.PullRequestNumber = 1
 			tt.runinfo.TriggerTarget = "ok-to-test-comment"
 			fakeclient, mux, _, teardown := ghtesthelper.SetupGH()
 			defer teardown()
@@ -73,11 +76,11 @@ func TestOkToTestComment(t *testing.T) {
 			})
 			ctx, _ := rtesting.SetupFakeContext(t)
 			cs := &cli.Clients{
-				GithubClient: webvcs.GithubVCS{
+				VCSClient: webvcs.GithubVCS{
 					Client: fakeclient,
 				},
 			}
-			got, err := aclCheck(ctx, cs, tt.runinfo)
+			got, trusted, err := aclCheck(ctx, cs, tt.runinfo)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("aclCheck() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -85,6 +88,32 @@ func TestOkToTestComment(t *testing.T) {
 			if got != tt.allowed {
 				t.Errorf("aclCheck() = %v, want %v", got, tt.allowed)
 			}
+			// only an owner /ok-to-test allows the sender
+			if trusted {
+				t.Errorf("aclCheck() trusted %s", tt.runinfo.Sender)
+			}
+		})
+	}
+}
+
+func TestApprovesCommit(t *testing.T) {
+	tests := []struct {
+		name    string
+		comment string
+		want    bool
+	}{
+		{name: "sha", comment: "/ok-to-test 0123abcd4567", want: true},
+		{name: "abbreviated sha", comment: "LGTM\r\n/ok-to-test 0123ABC\r\nthanks", want: true},
+		{name: "another commit", comment: "/ok-to-test 0123abce"},
+		{name: "too short", comment: "/ok-to-test 0123"},
+		{name: "without a sha", comment: "/ok-to-test"},
+		{name: "not alone on its line", comment: "no /ok-to-test 0123abcd"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := approvesCommit(tt.comment, "0123abcd4567"); got != tt.want {
+				t.Errorf("approvesCommit() = %v, want %v", got, tt.want)
+			}
 		})
 	}
 }
@@ -99,20 +128,24 @@ func TestAclCheckAll(t *testing.T) {
 
 	errit := "err"
 
-	mux.HandleFunc("/orgs/"+orgallowed+"/public_members", func(rw http.ResponseWriter, r *http.Request) {
-		fmt.Fprintf(rw, `[{"login": "login_%s"}]`, orgallowed)
+	mux.HandleFunc("/orgs/"+orgallowed+"/members/login_"+orgallowed, func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("/orgs/"+errit+"/members/error", func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusInternalServerError)
 	})
 
-	mux.HandleFunc("/orgs/"+orgdenied+"/public_members", func(rw http.ResponseWriter, r *http.Request) {
-		fmt.Fprint(rw, `[]`)
+	mux.HandleFunc("/repos/"+orgdenied+"/repo/collaborators/writer/permission", func(rw http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(rw, `{"permission": "write", "role_name": "write"}`)
 	})
 
-	mux.HandleFunc("/orgs/"+errit+"/public_members", func(rw http.ResponseWriter, r *http.Request) {
-		fmt.Fprint(rw, `x1x`)
+	mux.HandleFunc("/repos/"+orgdenied+"/repo/collaborators/reader/permission", func(rw http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(rw, `{"permission": "read", "role_name": "triage"}`)
 	})
 
-	mux.HandleFunc("/orgs/"+repoOwnerFileAllowed+"/public_members", func(rw http.ResponseWriter, r *http.Request) {
-		fmt.Fprint(rw, `[]`)
+	mux.HandleFunc("/repos/"+orgdenied+"/repo/collaborators/maintainer/permission", func(rw http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(rw, `{"permission": "write", "role_name": "maintain"}`)
 	})
 
 	mux.HandleFunc("/repos/"+repoOwnerFileAllowed+"/contents/OWNERS", func(rw http.ResponseWriter, r *http.Request) {
@@ -170,6 +203,54 @@ func TestAclCheckAll(t *testing.T) {
 			allowed: false,
 			wantErr: false,
 		},
+		{
+			name: "sender allowed as a collaborator with write",
+			runinfo: &webvcs.RunInfo{
+				Owner:      orgdenied,
+				Repository: "repo",
+				Sender:     "writer",
+			},
+			allowed: true,
+		},
+		{
+			name: "sender not allowed as a collaborator with triage",
+			runinfo: &webvcs.RunInfo{
+				Owner:      orgdenied,
+				Repository: "repo",
+				Sender:     "reader",
+			},
+			allowed: false,
+		},
+		{
+			name: "sender not allowed as a collaborator with write when maintain is needed",
+			runinfo: &webvcs.RunInfo{
+				Owner:                  orgdenied,
+				Repository:             "repo",
+				Sender:                 "writer",
+				CollaboratorPermission: "maintain",
+			},
+			allowed: false,
+		},
+		{
+			name: "sender allowed as a collaborator with maintain when maintain is needed",
+			runinfo: &webvcs.RunInfo{
+				Owner:                  orgdenied,
+				Repository:             "repo",
+				Sender:                 "maintainer",
+				CollaboratorPermission: "maintain",
+			},
+			allowed: true,
+		},
+		{
+			name: "invalid collaborator permission",
+			runinfo: &webvcs.RunInfo{
+				Owner:                  orgdenied,
+				Repository:             "repo",
+				Sender:                 "writer",
+				CollaboratorPermission: "moto",
+			},
+			wantErr: true,
+		},
 		{
 			name: "err it",
 			runinfo: &webvcs.RunInfo{
@@ -183,7 +264,7 @@ func TestAclCheckAll(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			cs := cli.Clients{
-				GithubClient: gvcs,
+				VCSClient: gvcs,
 			}
 
 			got, err := aclCheckAll(ctx, &cs, tt.runinfo)
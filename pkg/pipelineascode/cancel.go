@@ -0,0 +1,79 @@
+package pipelineascode
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/openshift-pipelines/pipelines-as-code/pkg/cli"
+	"github.com/openshift-pipelines/pipelines-as-code/pkg/webvcs"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const pullRequestLabel = "pipelinesascode.tekton.dev/pull-request"
+
+var cancelMergePatch = []byte(fmt.Sprintf(`{"spec":{"status":%q}}`, v1beta1.PipelineRunSpecStatusCancelled))
+
+// isPullRequestClosed tells if the run is for a pull request getting closed
+// or merged
+func isPullRequestClosed(runinfo *webvcs.RunInfo) bool {
+	return runinfo.EventType == webvcs.EventTypePullRequestClosed ||
+		runinfo.EventType == webvcs.EventTypePullRequestMerged
+}
+
+// ownerLabel get the runinfo owner as a label value, the owner of a project in
+// a GitLab subgroup is group/sub and K8s doesn't allow a "/" in a label value
+// so it is replaced by "-" like for the branch
+func ownerLabel(runinfo *webvcs.RunInfo) string {
+	return strings.ReplaceAll(runinfo.Owner, "/", "-")
+}
+
+// cancelPullRequestPipelineRuns cancel the PipelineRuns of the runinfo pull
+// request still running in namespace, or only the ones created from the
+// prName PipelineRun if it's not empty. There is no point to let them finish
+// once the pull request is closed, or when a /cancel comment asks for it
+func cancelPullRequestPipelineRuns(ctx context.Context, cs *cli.Clients, runinfo *webvcs.RunInfo, namespace, prName string) error {
+	selectorLabels := labels.Set{
+		"pipelinesascode.tekton.dev/url-org":        ownerLabel(runinfo),
+		"pipelinesascode.tekton.dev/url-repository": runinfo.Repository,
+		pullRequestLabel:                            strconv.Itoa(runinfo.PullRequestNumber),
+	}
+	if prName != "" {
+		selectorLabels[originalPRNameLabel] = prName
+	}
+	selector := selectorLabels.AsSelector().String()
+
+	reason := fmt.Sprintf("since the pull request %s/%s#%d has been closed",
+		runinfo.Owner, runinfo.Repository, runinfo.PullRequestNumber)
+	if runinfo.TriggerTarget == webvcs.TriggerTargetCancelComment {
+		reason = fmt.Sprintf("of the pull request %s/%s#%d as asked by %s",
+			runinfo.Owner, runinfo.Repository, runinfo.PullRequestNumber, runinfo.Sender)
+	}
+
+	prs, err := cs.Tekton.TektonV1beta1().PipelineRuns(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: selector,
+	})
+	if err != nil {
+		return err
+	}
+
+	for i := range prs.Items {
+		pr := &prs.Items[i]
+		if pr.IsDone() || pr.IsCancelled() {
+			continue
+		}
+		cs.Log.Infof("Cancelling PipelineRun %s/%s %s", namespace, pr.GetName(), reason)
+		_, err := cs.Tekton.TektonV1beta1().PipelineRuns(namespace).Patch(ctx, pr.GetName(),
+			types.MergePatchType, cancelMergePatch, metav1.PatchOptions{})
+		// It may have been cleaned up in between
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
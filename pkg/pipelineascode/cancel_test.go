@@ -0,0 +1,133 @@
+package pipelineascode
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/openshift-pipelines/pipelines-as-code/pkg/cli"
+	testclient "github.com/openshift-pipelines/pipelines-as-code/pkg/test/clients"
+	"github.com/openshift-pipelines/pipelines-as-code/pkg/webvcs"
+	tektonv1beta1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	"go.uber.org/zap"
+	zapobserver "go.uber.org/zap/zaptest/observer"
+	"gotest.tools/v3/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"knative.dev/pkg/apis"
+	duckv1beta1 "knative.dev/pkg/apis/duck/v1beta1"
+	rtesting "knative.dev/pkg/reconciler/testing"
+)
+
+func TestCancelPullRequestPipelineRuns(t *testing.T) {
+	tests := []struct {
+		name          string
+		owner         string
+		eventType     string
+		triggerTarget string
+		prName        string
+		wantCancelled map[string]bool
+		wantMessages  []string
+	}{
+		{
+			name:      "pull request closed",
+			eventType: webvcs.EventTypePullRequestClosed,
+			wantCancelled: map[string]bool{
+				"lint-running": true, "unit-running": true, "done": false, "other-pull-request": false,
+			},
+			wantMessages: []string{
+				"Cancelling PipelineRun namespace/lint-running since the pull request organizationes/lagaffe#6 has been closed",
+				"Cancelling PipelineRun namespace/unit-running since the pull request organizationes/lagaffe#6 has been closed",
+			},
+		},
+		{
+			name:          "cancel comment of a pipelinerun",
+			eventType:     "pull_request",
+			triggerTarget: webvcs.TriggerTargetCancelComment,
+			prName:        "unit",
+			wantCancelled: map[string]bool{
+				"lint-running": false, "unit-running": true, "done": false, "other-pull-request": false,
+			},
+			wantMessages: []string{
+				"Cancelling PipelineRun namespace/unit-running of the pull request organizationes/lagaffe#6 as asked by gaston",
+			},
+		},
+		{
+			name:      "pull request of a gitlab subgroup project closed",
+			owner:     "organizationes/sub",
+			eventType: webvcs.EventTypePullRequestClosed,
+			wantCancelled: map[string]bool{
+				"lint-running": true, "unit-running": true, "done": false, "other-pull-request": false,
+			},
+			wantMessages: []string{
+				"Cancelling PipelineRun namespace/lint-running since the pull request organizationes/sub/lagaffe#6 has been closed",
+				"Cancelling PipelineRun namespace/unit-running since the pull request organizationes/sub/lagaffe#6 has been closed",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.owner == "" {
+				tt.owner = "organizationes"
+			}
+			ctx, _ := rtesting.SetupFakeContext(t)
+			stdata, _ := testclient.SeedTestData(t, ctx, testclient.Data{})
+			observer, log := zapobserver.New(zap.InfoLevel)
+			cs := &cli.Clients{Tekton: stdata.Pipeline, Log: zap.New(observer).Sugar()}
+			runinfo := &webvcs.RunInfo{
+				Owner:             tt.owner,
+				Repository:        "lagaffe",
+				PullRequestNumber: 6,
+				Sender:            "gaston",
+				EventType:         tt.eventType,
+				TriggerTarget:     tt.triggerTarget,
+			}
+
+			pipelineRun := func(name, prName, pullRequest string, status corev1.ConditionStatus) *tektonv1beta1.PipelineRun {
+				return &tektonv1beta1.PipelineRun{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      name,
+						Namespace: "namespace",
+						Labels: map[string]string{
+							"pipelinesascode.tekton.dev/url-org":        ownerLabel(runinfo),
+							"pipelinesascode.tekton.dev/url-repository": "lagaffe",
+							pullRequestLabel:                            pullRequest,
+							originalPRNameLabel:                         prName,
+						},
+					},
+					Status: tektonv1beta1.PipelineRunStatus{
+						Status: duckv1beta1.Status{
+							Conditions: duckv1beta1.Conditions{
+								{Type: apis.ConditionSucceeded, Status: status},
+							},
+						},
+					},
+				}
+			}
+			assert.Assert(t, len(validation.IsValidLabelValue(ownerLabel(runinfo))) == 0, ownerLabel(runinfo))
+			for _, pr := range []*tektonv1beta1.PipelineRun{
+				pipelineRun("lint-running", "lint", "6", corev1.ConditionUnknown),
+				pipelineRun("unit-running", "unit", "6", corev1.ConditionUnknown),
+				pipelineRun("done", "unit", "6", corev1.ConditionTrue),
+				pipelineRun("other-pull-request", "unit", "7", corev1.ConditionUnknown),
+			} {
+				_, err := stdata.Pipeline.TektonV1beta1().PipelineRuns("namespace").Create(ctx, pr, metav1.CreateOptions{})
+				assert.NilError(t, err)
+			}
+
+			assert.NilError(t, cancelPullRequestPipelineRuns(ctx, cs, runinfo, "namespace", tt.prName))
+
+			for name, cancelled := range tt.wantCancelled {
+				pr, err := stdata.Pipeline.TektonV1beta1().PipelineRuns("namespace").Get(ctx, name, metav1.GetOptions{})
+				assert.NilError(t, err)
+				assert.Equal(t, pr.IsCancelled(), cancelled, name)
+			}
+			messages := []string{}
+			for _, entry := range log.All() {
+				messages = append(messages, entry.Message)
+			}
+			sort.Strings(messages)
+			assert.DeepEqual(t, messages, tt.wantMessages)
+		})
+	}
+}
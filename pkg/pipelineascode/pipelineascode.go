@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	apipac "github.com/openshift-pipelines/pipelines-as-code/pkg/apis/pipelinesascode/v1alpha1"
@@ -12,28 +13,52 @@ import (
 	"github.com/openshift-pipelines/pipelines-as-code/pkg/config"
 	"github.com/openshift-pipelines/pipelines-as-code/pkg/resolve"
 	"github.com/openshift-pipelines/pipelines-as-code/pkg/webvcs"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 const (
 	tektonDir               = ".tekton"
 	maxPipelineRunStatusRun = 5
+	originalPRNameLabel     = "pipelinesascode.tekton.dev/original-prname"
+)
+
+// The policies of a Repository for the .tekton directory of the pull
+// requests of the untrusted senders
+const (
+	untrustedTektonDirBaseBranch = "base-branch"
+	untrustedTektonDirApproval   = "approval"
 )
 
 type Options struct {
 	PayloadFile string
 	RunInfo     webvcs.RunInfo
+
+	// PayloadSignature is the signature header of the payload, or the token
+	// header for GitLab
+	PayloadSignature string
+	// WebhookSecretName and WebhookSecretNamespace reference the install
+	// wide Secret with the webhook shared secret
+	WebhookSecretName      string
+	WebhookSecretNamespace string
+	// WebhookSecrets keeps the Repository CRs and the webhook secrets
+	// across the events of a long running server, nil to get them from the
+	// API server on each event
+	WebhookSecrets *WebhookSecretStore
 }
 
 // The time to wait for a pipelineRun, maybe we should not restrict this?
 const pipelineRunTimeout = 2 * time.Hour
 
 func createStatus(ctx context.Context, cs *cli.Clients, runinfo *webvcs.RunInfo, status, conclusion, text, detailsURL string, logit bool) error {
-	if logit {
+	if logit || runinfo.CheckRunID == nil {
 		cs.Log.Infof(text)
 	}
-	_, err := cs.GithubClient.CreateStatus(ctx, runinfo, status, conclusion, text, detailsURL)
-	return err
+	// There is no status to update when the check run has not been created
+	if runinfo.CheckRunID == nil {
+		return nil
+	}
+	return cs.VCSClient.CreateStatus(ctx, runinfo, status, conclusion, text, detailsURL)
 }
 
 func fmtDuration(d time.Duration) string {
@@ -46,61 +71,105 @@ func fmtDuration(d time.Duration) string {
 func Run(ctx context.Context, cs *cli.Clients, k8int cli.KubeInteractionIntf, runinfo *webvcs.RunInfo) error {
 	var err error
 
-	// Create first check run to let know the user we have started the pipeline
-	// TODO: Refactor this bit in a function
-	checkRun, err := cs.GithubClient.CreateCheckRun(ctx, "in_progress", runinfo)
+	closed := isPullRequestClosed(runinfo)
+
+	// Match the Event to a Repository Resource,
+	// We are going to match on targetNamespace annotation later on in
+	// `MatchPipelinerunByAnnotation`
+	repo, err := config.GetRepoByCR(ctx, cs, "", runinfo)
 	if err != nil {
 		return err
 	}
-	// Set the runId on runInfo so if we have an error we can report it on UI (GH checks UI for GH PR)
-	runinfo.CheckRunID = checkRun.ID
+	// The Repository may require a higher permission from the collaborators,
+	// skip the draft pull requests or want the statuses in a comment
+	if repo != nil {
+		runinfo.CollaboratorPermission = repo.Spec.CollaboratorPermission
+		runinfo.SkipDraftPullRequests = repo.Spec.SkipDraftPullRequests
+		runinfo.PullRequestComment = repo.Spec.PullRequestComment
+	}
+
+	if runinfo.PullRequestDraft && runinfo.SkipDraftPullRequests {
+		cs.Log.Infof("Skipping the draft pull request %s/%s#%d until it is ready for review",
+			runinfo.Owner, runinfo.Repository, runinfo.PullRequestNumber)
+		return nil
+	}
+
+	cancelComment := runinfo.TriggerTarget == webvcs.TriggerTargetCancelComment
+
+	// Each matching PipelineRun is reported on its own check run, an event
+	// skipped before is reported on a check run named after the application
+	// unless it's a closed pull request or a pull request action the
+	// PipelineRuns have to opt in to, they have nothing to report without a
+	// match. A /cancel comment doesn't report anything either.
+	onMatchOnly := closed || cancelComment || !config.IsDefaultPullRequestAction(runinfo)
 
 	// Check if submitted is allowed to run this.
-	allowed, err := aclCheck(ctx, cs, runinfo)
+	allowed, trusted, err := aclCheck(ctx, cs, runinfo)
 	if err != nil {
 		return err
 	}
 
 	if !allowed {
 		msg := fmt.Sprintf("User %s is not allowed to run CI on this repo.", runinfo.Sender)
-		err = createStatus(ctx, cs, runinfo, "completed", "skipped", msg, "https://tenor.com/search/police-cat-gifs", true)
-		if err != nil {
-			return err
+		return createSkippedStatus(ctx, cs, runinfo, !onMatchOnly, msg, "https://tenor.com/search/police-cat-gifs")
+	}
+
+	if repo == nil || repo.Spec.Namespace == "" {
+		msg := fmt.Sprintf("Could not find a namespace match for %s/%s on target-branch:%s event-type: %s", runinfo.Owner, runinfo.Repository, runinfo.BaseBranch, runinfo.EventType)
+		if runinfo.EventType == "pull_request" || runinfo.TriggerTarget == "issue-recheck" {
+			return createSkippedStatus(ctx, cs, runinfo, !onMatchOnly, msg, "https://tenor.com/search/sad-cat-gifs")
 		}
+		cs.Log.Infof("Skipping creating status check: %s", msg)
 		return nil
 	}
 
-	// Match the Event to a Repository Resource,
-	// We are going to match on targetNamespace annotation later on in
-	// `MatchPipelinerunByAnnotation`
-	repo, err := config.GetRepoByCR(ctx, cs, "", runinfo)
-	if err != nil {
-		return err
+	if cancelComment {
+		return cancelPullRequestPipelineRuns(ctx, cs, runinfo, repo.Spec.Namespace, runinfo.TargetPipelineRun)
 	}
 
-	if repo == nil || repo.Spec.Namespace == "" {
-		msg := fmt.Sprintf("Could not find a namespace match for %s/%s on target-branch:%s event-type: %s", runinfo.Owner, runinfo.Repository, runinfo.BaseBranch, runinfo.EventType)
-		if runinfo.EventType == "pull_request" || runinfo.TriggerTarget == "issue-recheck" {
-			err = createStatus(ctx, cs, runinfo, "completed", "skipped", msg, "https://tenor.com/search/sad-cat-gifs", true)
+	// The PipelineRuns still running for a closed pull request are not needed anymore
+	if closed {
+		if err := cancelPullRequestPipelineRuns(ctx, cs, runinfo, repo.Spec.Namespace, ""); err != nil {
+			return err
+		}
+	}
+
+	// A sender only allowed by an /ok-to-test can rewrite the PipelineRuns of
+	// the pull request to get the secrets, the Repository can take them from
+	// the base branch or wait for an owner to approve the changes. The
+	// /ok-to-test comment itself runs the commits of the pull request author
+	// so the changes still need to be approved.
+	tektonRuninfo := runinfo
+	if !trusted || runinfo.TriggerTarget == webvcs.TriggerTargetOkToTestComment {
+		switch repo.Spec.UntrustedTektonDir {
+		case untrustedTektonDirBaseBranch:
+			sha, err := cs.VCSClient.GetBranchSHA(ctx, runinfo.BaseBranch, runinfo)
+			if err != nil {
+				return err
+			}
+			tektonRuninfo = &webvcs.RunInfo{}
+			runinfo.DeepCopyInto(tektonRuninfo)
+			tektonRuninfo.SHA = sha
+		case untrustedTektonDirApproval:
+			approved, err := tektonDirApproved(ctx, cs, runinfo)
 			if err != nil {
 				return err
 			}
-		} else {
-			cs.Log.Infof("Skipping creating status check: %s", msg)
+			if !approved {
+				msg := fmt.Sprintf("The pull request changes the <b>%s/</b> directory and its author is not trusted to run it, "+
+					"an owner has to review the changes and comment <code>/ok-to-test %s</code> to run this commit.",
+					tektonDir, runinfo.SHA)
+				return createUnmatchedStatus(ctx, cs, runinfo, !onMatchOnly, "action_required", msg,
+					"https://tenor.com/search/waiting-cat-gifs")
+			}
 		}
-		return nil
 	}
 
-	// Get everything in tekton directory
-	objects, err := cs.GithubClient.GetTektonDir(ctx, tektonDir, runinfo)
-	if len(objects) == 0 || err != nil {
+	// Get everything in tekton directory as one multi document yaml string
+	allTemplates, err := cs.VCSClient.GetTektonDir(ctx, tektonDir, tektonRuninfo)
+	if allTemplates == "" || err != nil {
 		msg := "😿 Could not find a <b>.tekton/</b> directory for this repository"
-		err := createStatus(ctx, cs, runinfo, "completed", "skipped",
-			msg, "https://tenor.com/search/sad-cat-gifs", true)
-		if err != nil {
-			return err
-		}
-		return err
+		return createSkippedStatus(ctx, cs, runinfo, !onMatchOnly, msg, "https://tenor.com/search/sad-cat-gifs")
 	}
 	cs.Log.Infow("Loading payload",
 		"url", runinfo.URL,
@@ -116,51 +185,193 @@ func Run(ctx context.Context, cs *cli.Clients, k8int cli.KubeInteractionIntf, ru
 		return err
 	}
 
-	// Concat all yaml files as one multi document yaml string
-	allTemplates, err := cs.GithubClient.ConcatAllYamlFiles(ctx, objects, runinfo)
-	if err != nil {
-		return err
-	}
-
 	// Replace those {{var}} placeholders user has in her template to the runinfo variable
-	allTemplates = ReplacePlaceHoldersVariables(allTemplates, map[string]string{
-		"revision": runinfo.SHA,
-		"repo_url": runinfo.URL,
-	})
+	variables := tagVariables(runinfo.Tag)
+	variables["revision"] = runinfo.SHA
+	variables["repo_url"] = runinfo.URL
+	allTemplates = ReplacePlaceHoldersVariables(allTemplates, variables)
 
 	ropt := &resolve.Opts{
 		GenerateName: true,
 		RemoteTasks:  true,
 	}
 	// Merge everything (i.e: tasks/pipeline etc..) as a single pipelinerun
-	pipelineRuns, err := resolve.Resolve(ctx, cs, runinfo, allTemplates, ropt)
+	pipelineRuns, err := resolve.Resolve(ctx, cs, tektonRuninfo, allTemplates, ropt)
 	if err != nil {
 		return err
 	}
 
-	// Match the pipelinerun with annotation
-	pipelineRun, annotationRepo, config, err := config.MatchPipelinerunByAnnotation(ctx, pipelineRuns, cs, runinfo)
+	// A /test comment runs the PipelineRun it names on its event and target
+	// branch even if its other annotations don't match the pull request
+	if runinfo.TriggerTarget == webvcs.TriggerTargetTestComment {
+		matches, err := config.MatchPipelinerunByName(ctx, pipelineRuns, cs, runinfo, runinfo.TargetPipelineRun)
+		if err != nil {
+			return createSkippedStatus(ctx, cs, runinfo, true, err.Error(), "https://tenor.com/search/sad-cat-gifs")
+		}
+		return runMatches(ctx, cs, k8int, runinfo, repo, matches)
+	}
+
+	// Match the pipelineruns with annotation
+	matches, err := config.MatchPipelinerunByAnnotation(ctx, pipelineRuns, cs, runinfo)
 	if err != nil {
+		if onMatchOnly {
+			cs.Log.Infof("No PipelineRun to run on the %s %s event of %s/%s#%d: %s",
+				runinfo.EventType, runinfo.PullRequestAction, runinfo.Owner, runinfo.Repository,
+				runinfo.PullRequestNumber, err)
+			return nil
+		}
 		return err
 	}
 
-	if annotationRepo.Spec.Namespace != "" {
-		repo = annotationRepo
+	// A /retest comment naming a PipelineRun only runs that one again
+	if runinfo.TargetPipelineRun != "" {
+		matches = matchesNamed(matches, runinfo.TargetPipelineRun)
+		if len(matches) == 0 {
+			msg := fmt.Sprintf("The PipelineRun %s doesn't match this pull request", runinfo.TargetPipelineRun)
+			return createSkippedStatus(ctx, cs, runinfo, !onMatchOnly, msg, "https://tenor.com/search/sad-cat-gifs")
+		}
+	}
+
+	return runMatches(ctx, cs, k8int, runinfo, repo, matches)
+}
+
+// runMatches start the PipelineRuns of the matches and wait for them
+func runMatches(ctx context.Context, cs *cli.Clients, k8int cli.KubeInteractionIntf, runinfo *webvcs.RunInfo,
+	repo *apipac.Repository, matches []config.Match) error {
+	// Start all the matching PipelineRuns, each one with its own check run,
+	// the ones already started are still followed if one cannot be started
+	runs := make([]*matchedRun, 0, len(matches))
+	var startErr error
+	for _, match := range matches {
+		run, err := startPipelineRun(ctx, cs, k8int, runinfo, repo, match)
+		if err != nil {
+			startErr = err
+			break
+		}
+		runs = append(runs, run)
+	}
+
+	// Wait for the PipelineRuns all at once, each of them is reported as soon
+	// as it is done
+	var wg sync.WaitGroup
+	// the Repository status is updated by one PipelineRun at a time
+	var repoStatusLock sync.Mutex
+	errs := make([]error, len(runs))
+	for i, run := range runs {
+		wg.Add(1)
+		go func(i int, run *matchedRun) {
+			defer wg.Done()
+			errs[i] = finishPipelineRun(ctx, cs, k8int, run, &repoStatusLock)
+		}(i, run)
+	}
+	wg.Wait()
+
+	if startErr != nil {
+		return startErr
+	}
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matchesNamed get the matches of the PipelineRun named name
+func matchesNamed(matches []config.Match, name string) []config.Match {
+	named := []config.Match{}
+	for _, match := range matches {
+		if config.OriginalPipelineRunName(match.PipelineRun) == name {
+			named = append(named, match)
+		}
+	}
+	return named
+}
+
+// matchedRun is a PipelineRun started for a match, runinfo is a copy of the
+// event runinfo for its own check run
+type matchedRun struct {
+	name       string
+	runinfo    *webvcs.RunInfo
+	repo       *apipac.Repository
+	config     map[string]string
+	pr         *v1beta1.PipelineRun
+	consoleURL string
+}
+
+// createSkippedStatus report an event skipped before matching any
+// PipelineRun on a check run named after the application, it is only logged
+// when report is false
+func createSkippedStatus(ctx context.Context, cs *cli.Clients, runinfo *webvcs.RunInfo, report bool, text, detailsURL string) error {
+	return createUnmatchedStatus(ctx, cs, runinfo, report, "skipped", text, detailsURL)
+}
+
+// createUnmatchedStatus report the conclusion of an event before matching any
+// PipelineRun on a check run named after the application, it is only logged
+// when report is false
+func createUnmatchedStatus(ctx context.Context, cs *cli.Clients, runinfo *webvcs.RunInfo, report bool,
+	conclusion, text, detailsURL string) error {
+	if !report {
+		cs.Log.Infof(text)
+		return nil
+	}
+	if runinfo.CheckRunID == nil {
+		if err := cs.VCSClient.CreateCheckRun(ctx, "in_progress", runinfo); err != nil {
+			return err
+		}
+	}
+	return createStatus(ctx, cs, runinfo, "completed", conclusion, text, detailsURL, true)
+}
+
+// tektonDirApproved tells if the changes of the runinfo pull request to the
+// tekton directory can run, there are none or an owner approved them at the
+// runinfo commit
+func tektonDirApproved(ctx context.Context, cs *cli.Clients, runinfo *webvcs.RunInfo) (bool, error) {
+	files, err := cs.VCSClient.GetFilesChanged(ctx, runinfo)
+	if err != nil {
+		return false, err
+	}
+	for _, file := range files {
+		if strings.HasPrefix(file, tektonDir+"/") {
+			return aclApprovedCommitFromAnOwner(ctx, cs, runinfo)
+		}
+	}
+	return true, nil
+}
+
+// startPipelineRun create the PipelineRun of a match and its check run named
+// after it
+func startPipelineRun(ctx context.Context, cs *cli.Clients, k8int cli.KubeInteractionIntf, runinfo *webvcs.RunInfo,
+	repo *apipac.Repository, match config.Match) (*matchedRun, error) {
+	pipelineRun := match.PipelineRun
+	if match.Repo != nil && match.Repo.Spec.Namespace != "" {
+		repo = match.Repo
 	}
+	name := config.OriginalPipelineRunName(pipelineRun)
+
+	prRuninfo := *runinfo
+	prRuninfo.CheckRunID = nil
+	prRuninfo.ApplicationName = fmt.Sprintf("%s / %s", runinfo.ApplicationName, name)
 
 	// Add labels on the soon to be created pipelinerun so UI/CLI can easily
 	// query them. Since K8s do not like slash in labels value and on push we
 	// have the full ref, we replace the "/" by "-". The tools probably need to
 	// be aware of it when querying.
 	refTomakeK8Happy := strings.ReplaceAll(runinfo.BaseBranch, "/", "-")
+	// Same for the Bitbucket Cloud account ids and uuids we use as sender
+	senderTomakeK8Happy := strings.Trim(strings.ReplaceAll(runinfo.Sender, ":", "-"), "{}")
 	pipelineRun.Labels = map[string]string{
-		"pipelinesascode.tekton.dev/url-org":        runinfo.Owner,
+		"pipelinesascode.tekton.dev/url-org":        ownerLabel(runinfo),
 		"pipelinesascode.tekton.dev/url-repository": runinfo.Repository,
 		"pipelinesascode.tekton.dev/sha":            runinfo.SHA,
-		"pipelinesascode.tekton.dev/sender":         runinfo.Sender,
+		"pipelinesascode.tekton.dev/sender":         senderTomakeK8Happy,
 		"pipelinesascode.tekton.dev/event-type":     runinfo.EventType,
 		"pipelinesascode.tekton.dev/branch":         refTomakeK8Happy,
 		"pipelinesascode.tekton.dev/repository":     repo.GetName(),
+		originalPRNameLabel:                         name,
+	}
+	if runinfo.PullRequestNumber != 0 {
+		pipelineRun.Labels[pullRequestLabel] = strconv.Itoa(runinfo.PullRequestNumber)
 	}
 
 	pipelineRun.Annotations["pipelinesascode.tekton.dev/sha-title"] = runinfo.SHATitle
@@ -169,7 +380,7 @@ func Run(ctx context.Context, cs *cli.Clients, k8int cli.KubeInteractionIntf, ru
 	// Create the actual pipeline
 	pr, err := cs.Tekton.TektonV1beta1().PipelineRuns(repo.Spec.Namespace).Create(ctx, pipelineRun, metav1.CreateOptions{})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Get the UI/webconsole URL for this pipeline to watch the log (only openshift console supported atm)
@@ -179,28 +390,48 @@ func Run(ctx context.Context, cs *cli.Clients, k8int cli.KubeInteractionIntf, ru
 		consoleURL = "https://giphy.com/explore/cat-exercise-wheel"
 	}
 
-	// Create status with the log url
-	err = createStatus(ctx, cs, runinfo, "in_progress", "",
+	// Create the check run of the PipelineRun with the log url
+	if err := cs.VCSClient.CreateCheckRun(ctx, "in_progress", &prRuninfo); err != nil {
+		return nil, err
+	}
+	err = createStatus(ctx, cs, &prRuninfo, "in_progress", "",
 		fmt.Sprintf(`Starting Pipelinerun <b>%s</b> in namespace <b>%s</b><br><br>You can follow the execution on the command line with : <br><br><code>tkn pr logs -f -n %s %s</code>`,
 			pr.GetName(), repo.Spec.Namespace, repo.Spec.Namespace, pr.GetName()),
 		consoleURL, false)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
+	return &matchedRun{
+		name:       name,
+		runinfo:    &prRuninfo,
+		repo:       repo,
+		config:     match.Config,
+		pr:         pr,
+		consoleURL: consoleURL,
+	}, nil
+}
+
+// finishPipelineRun wait for a started PipelineRun, cleanup the old runs of
+// that PipelineRun and report its status on its check run and in the
+// Repository status
+func finishPipelineRun(ctx context.Context, cs *cli.Clients, k8int cli.KubeInteractionIntf, run *matchedRun,
+	repoStatusLock *sync.Mutex) error {
+	pr, repo, runinfo := run.pr, run.repo, run.runinfo
+
 	cs.Log.Infof("Waiting for PipelineRun %s/%s to Succeed in a maximum time of %s minutes", pr.Namespace, pr.Name, fmtDuration(pipelineRunTimeout))
 	if err := k8int.WaitForPipelineRunSucceed(ctx, cs.Tekton.TektonV1beta1(), pr, pipelineRunTimeout); err != nil {
-		cs.Log.Info("PipelineRun has failed.")
+		cs.Log.Infof("PipelineRun %s/%s has failed.", pr.Namespace, pr.Name)
 	}
 
 	// Do cleanups
-	if keepMaxPipeline, ok := config["max-keep-runs"]; ok {
+	if keepMaxPipeline, ok := run.config["max-keep-runs"]; ok {
 		max, err := strconv.Atoi(keepMaxPipeline)
 		if err != nil {
 			return err
 		}
 
-		err = k8int.CleanupPipelines(ctx, repo.Spec.Namespace, repo.Name, max)
+		err = k8int.CleanupPipelines(ctx, repo.Spec.Namespace, repo.Name, run.name, max)
 		if err != nil {
 			return err
 		}
@@ -220,9 +451,12 @@ func Run(ctx context.Context, cs *cli.Clients, k8int cli.KubeInteractionIntf, ru
 		SHA:             &runinfo.SHA,
 		SHAURL:          &runinfo.SHAURL,
 		Title:           &runinfo.SHATitle,
-		LogURL:          &consoleURL,
+		LogURL:          &run.consoleURL,
 	}
 
+	repoStatusLock.Lock()
+	defer repoStatusLock.Unlock()
+
 	// Get repo again in case it was updated while we were running the CI
 	// NOTE: there may be a race issue we should maybe solve here, between the Get and
 	// Update but we are talking sub-milliseconds issue here.
@@ -245,5 +479,5 @@ func Run(ctx context.Context, cs *cli.Clients, k8int cli.KubeInteractionIntf, ru
 	}
 
 	cs.Log.Infof("Repository status of %s has been updated", nrepo.Name)
-	return err
+	return nil
 }
@@ -8,7 +8,10 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 
 	testDynamic "github.com/openshift-pipelines/pipelines-as-code/pkg/test/dynamic"
@@ -21,11 +24,14 @@ import (
 	ghtesthelper "github.com/openshift-pipelines/pipelines-as-code/pkg/test/github"
 	kitesthelper "github.com/openshift-pipelines/pipelines-as-code/pkg/test/kubernetestint"
 	"github.com/openshift-pipelines/pipelines-as-code/pkg/webvcs"
+	tektonv1beta1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
 	"go.uber.org/zap"
 	zapobserver "go.uber.org/zap/zaptest/observer"
 	"gotest.tools/v3/assert"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ktesting "k8s.io/client-go/testing"
 	rtesting "knative.dev/pkg/reconciler/testing"
 )
 
@@ -41,12 +47,11 @@ func testSetupTektonDir(mux *http.ServeMux, runinfo *webvcs.RunInfo, directory s
 		basename := filepath.Base(path)
 		trimmed := strings.TrimSuffix(basename, filepath.Ext(basename))
 		tektonDirContent += fmt.Sprintf(`{
-			"name": "%s",
-			"path": ".tekton/%s",
+			"path": "%s",
 			"sha": "shaof%s",
 			"size": %d,
-			"type": "file"
-		},`, basename, basename, trimmed, info.Size())
+			"type": "blob"
+		},`, basename, trimmed, info.Size())
 
 		contentB, _ := ioutil.ReadFile(path)
 		replyString(mux,
@@ -58,20 +63,20 @@ func testSetupTektonDir(mux *http.ServeMux, runinfo *webvcs.RunInfo, directory s
 	})
 
 	replyString(mux,
-		fmt.Sprintf("/repos/%s/%s/contents/.tekton", runinfo.Owner, runinfo.Repository),
-		fmt.Sprintf("[%s]", strings.TrimSuffix(tektonDirContent, ",")))
+		fmt.Sprintf("/repos/%s/%s/git/trees/%s:.tekton", runinfo.Owner, runinfo.Repository, runinfo.SHA),
+		fmt.Sprintf(`{"tree": [%s]}`, strings.TrimSuffix(tektonDirContent, ",")))
 }
 
 func testSetupCommonGhReplies(t *testing.T, mux *http.ServeMux, runinfo *webvcs.RunInfo, finalStatus, finalStatusText string,
-	noReplyOrgPublicMembers bool) {
+	noReplyOrgMembers bool) {
 	// Take a directory and generate replies as Github for it
 	replyString(mux,
 		fmt.Sprintf("/repos/%s/%s/contents/internal/task", runinfo.Owner, runinfo.Repository),
 		`{"sha": "internaltasksha"}`)
 
-	if !noReplyOrgPublicMembers {
-		mux.HandleFunc("/orgs/"+runinfo.Owner+"/public_members", func(rw http.ResponseWriter, r *http.Request) {
-			fmt.Fprintf(rw, `[{"login": "%s"}]`, runinfo.Sender)
+	if !noReplyOrgMembers {
+		mux.HandleFunc("/orgs/"+runinfo.Owner+"/members/"+runinfo.Sender, func(rw http.ResponseWriter, r *http.Request) {
+			rw.WriteHeader(http.StatusNoContent)
 		})
 	}
 
@@ -95,6 +100,9 @@ func testSetupCommonGhReplies(t *testing.T, mux *http.ServeMux, runinfo *webvcs.
 }
 
 func TestRun(t *testing.T) {
+	draftSkippingRepo := repository.NewRepo("test-run", "https://service/documentation",
+		"main", "namespace", "namespace", "pull_request")
+	draftSkippingRepo.Spec.SkipDraftPullRequests = true
 	observer, log := zapobserver.New(zap.InfoLevel)
 	logger := zap.New(observer).Sugar()
 	tests := []struct {
@@ -283,6 +291,100 @@ func TestRun(t *testing.T) {
 			finalLogText:             "<th>Status</th><th>Duration</th><th>Name</th>",
 			expectedNumberofCleanups: 10,
 		},
+		{
+			name: "pull request merged",
+			runinfo: &webvcs.RunInfo{
+				SHA:               "principale",
+				Owner:             "organizationes",
+				Repository:        "lagaffe",
+				URL:               "https://service/documentation",
+				HeadBranch:        "press",
+				BaseBranch:        "main",
+				Sender:            "fantasio",
+				EventType:         "pull_request_merged",
+				PullRequestNumber: 6,
+			},
+			tektondir:   "testdata/pull_request_merged",
+			finalStatus: "neutral",
+			repositories: []*v1alpha1.Repository{
+				repository.NewRepo("test-run", "https://service/documentation",
+					"main", "namespace", "namespace", "pull_request"),
+			},
+		},
+		{
+			name: "Skipped/pull request closed without a match",
+			runinfo: &webvcs.RunInfo{
+				SHA:               "principale",
+				Owner:             "organizationes",
+				Repository:        "lagaffe",
+				URL:               "https://service/documentation",
+				HeadBranch:        "press",
+				BaseBranch:        "main",
+				Sender:            "fantasio",
+				EventType:         "pull_request_closed",
+				PullRequestNumber: 6,
+			},
+			tektondir:   "testdata/pull_request",
+			finalStatus: "skipped",
+			repositories: []*v1alpha1.Repository{
+				repository.NewRepo("test-run", "https://service/documentation",
+					"main", "namespace", "namespace", "pull_request"),
+			},
+		},
+		{
+			name: "Skipped/pull request action not opted in",
+			runinfo: &webvcs.RunInfo{
+				SHA:               "principale",
+				Owner:             "organizationes",
+				Repository:        "lagaffe",
+				URL:               "https://service/documentation",
+				HeadBranch:        "press",
+				BaseBranch:        "main",
+				Sender:            "fantasio",
+				EventType:         "pull_request",
+				PullRequestNumber: 6,
+				PullRequestAction: "labeled",
+			},
+			tektondir:   "testdata/pull_request",
+			finalStatus: "skipped",
+		},
+		{
+			name: "Skipped/draft pull request",
+			runinfo: &webvcs.RunInfo{
+				SHA:               "principale",
+				Owner:             "organizationes",
+				Repository:        "lagaffe",
+				URL:               "https://service/documentation",
+				HeadBranch:        "press",
+				BaseBranch:        "main",
+				Sender:            "fantasio",
+				EventType:         "pull_request",
+				PullRequestNumber: 6,
+				PullRequestAction: "opened",
+				PullRequestDraft:  true,
+			},
+			tektondir:    "testdata/pull_request",
+			finalStatus:  "skipped",
+			repositories: []*v1alpha1.Repository{draftSkippingRepo},
+		},
+		{
+			name: "pull request ready for review",
+			runinfo: &webvcs.RunInfo{
+				SHA:               "principale",
+				Owner:             "organizationes",
+				Repository:        "lagaffe",
+				URL:               "https://service/documentation",
+				HeadBranch:        "press",
+				BaseBranch:        "main",
+				Sender:            "fantasio",
+				EventType:         "pull_request",
+				PullRequestNumber: 6,
+				PullRequestAction: "ready_for_review",
+			},
+			tektondir:    "testdata/pull_request",
+			finalStatus:  "neutral",
+			repositories: []*v1alpha1.Repository{draftSkippingRepo},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -315,7 +417,7 @@ func TestRun(t *testing.T) {
 			tdc := testDynamic.Options{}
 			dc, _ := tdc.Client()
 			cs := &cli.Clients{
-				GithubClient: webvcs.GithubVCS{
+				VCSClient: webvcs.GithubVCS{
 					Client: fakeclient,
 				},
 				PipelineAsCode: stdata.PipelineAsCode,
@@ -347,3 +449,464 @@ func TestRun(t *testing.T) {
 		})
 	}
 }
+
+func TestRunMultiplePipelineRuns(t *testing.T) {
+	tests := []struct {
+		name              string
+		triggerTarget     string
+		targetPipelineRun string
+		wantCheckRuns     []string
+		wantCompleted     map[string]string
+		wantPRNames       []string
+		// the statuses are reported in the pull request comments as well
+		pullRequestComment bool
+		wantComments       []string
+		// the sender is only allowed by an /ok-to-test of an owner
+		untrusted          bool
+		untrustedTektonDir string
+		changedFiles       string
+		// the /ok-to-test comments approving a commit, by their author
+		approvals map[string]string
+	}{
+		{
+			name:          "all the matching pipelineruns",
+			wantCheckRuns: []string{"Pipelines as Code CI / lint", "Pipelines as Code CI / unit"},
+			wantCompleted: map[string]string{
+				"Pipelines as Code CI / lint": "neutral",
+				"Pipelines as Code CI / unit": "neutral",
+			},
+			wantPRNames: []string{"lint", "unit"},
+		},
+		{
+			name:          "statuses in pull request comments",
+			wantCheckRuns: []string{"Pipelines as Code CI / lint", "Pipelines as Code CI / unit"},
+			wantCompleted: map[string]string{
+				"Pipelines as Code CI / lint": "neutral",
+				"Pipelines as Code CI / unit": "neutral",
+			},
+			wantPRNames:        []string{"lint", "unit"},
+			pullRequestComment: true,
+			wantComments: []string{
+				"<!-- pipelines-as-code: Pipelines as Code CI / lint -->\n**❓ Unknown**",
+				"<!-- pipelines-as-code: Pipelines as Code CI / unit -->\n**❓ Unknown**",
+			},
+		},
+		{
+			name:          "retest comment",
+			triggerTarget: webvcs.TriggerTargetRetestComment,
+			wantCheckRuns: []string{"Pipelines as Code CI / lint", "Pipelines as Code CI / unit"},
+			wantCompleted: map[string]string{
+				"Pipelines as Code CI / lint": "neutral",
+				"Pipelines as Code CI / unit": "neutral",
+			},
+			wantPRNames: []string{"lint", "unit"},
+		},
+		{
+			name:              "retest comment of a pipelinerun",
+			triggerTarget:     webvcs.TriggerTargetRetestComment,
+			targetPipelineRun: "unit",
+			wantCheckRuns:     []string{"Pipelines as Code CI / unit"},
+			wantCompleted:     map[string]string{"Pipelines as Code CI / unit": "neutral"},
+			wantPRNames:       []string{"unit"},
+		},
+		{
+			name:              "retest comment of a pipelinerun not matching",
+			triggerTarget:     webvcs.TriggerTargetRetestComment,
+			targetPipelineRun: "push",
+			wantCheckRuns:     []string{"Pipelines as Code CI"},
+			wantCompleted:     map[string]string{"Pipelines as Code CI": "skipped"},
+			wantPRNames:       []string{},
+		},
+		{
+			name:              "test comment of a pipelinerun",
+			triggerTarget:     webvcs.TriggerTargetTestComment,
+			targetPipelineRun: "lint",
+			wantCheckRuns:     []string{"Pipelines as Code CI / lint"},
+			wantCompleted:     map[string]string{"Pipelines as Code CI / lint": "neutral"},
+			wantPRNames:       []string{"lint"},
+		},
+		{
+			name:              "test comment of a pipelinerun not on the event",
+			triggerTarget:     webvcs.TriggerTargetTestComment,
+			targetPipelineRun: "push",
+			wantCheckRuns:     []string{"Pipelines as Code CI"},
+			wantCompleted:     map[string]string{"Pipelines as Code CI": "skipped"},
+			wantPRNames:       []string{},
+		},
+		{
+			name:              "test comment of an untrusted sender",
+			untrusted:         true,
+			triggerTarget:     webvcs.TriggerTargetTestComment,
+			targetPipelineRun: "lint",
+			wantCheckRuns:     []string{"Pipelines as Code CI"},
+			wantCompleted:     map[string]string{"Pipelines as Code CI": "skipped"},
+			wantPRNames:       []string{},
+		},
+		{
+			name:          "retest comment of an untrusted sender",
+			untrusted:     true,
+			triggerTarget: webvcs.TriggerTargetRetestComment,
+			wantCheckRuns: []string{"Pipelines as Code CI"},
+			wantCompleted: map[string]string{"Pipelines as Code CI": "skipped"},
+			wantPRNames:   []string{},
+		},
+		{
+			name:              "test comment of an unknown pipelinerun",
+			triggerTarget:     webvcs.TriggerTargetTestComment,
+			targetPipelineRun: "unknown",
+			wantCheckRuns:     []string{"Pipelines as Code CI"},
+			wantCompleted:     map[string]string{"Pipelines as Code CI": "skipped"},
+			wantPRNames:       []string{},
+		},
+		{
+			name:          "untrusted sender",
+			untrusted:     true,
+			wantCheckRuns: []string{"Pipelines as Code CI / lint", "Pipelines as Code CI / unit"},
+			wantCompleted: map[string]string{
+				"Pipelines as Code CI / lint": "neutral",
+				"Pipelines as Code CI / unit": "neutral",
+			},
+			wantPRNames: []string{"lint", "unit"},
+		},
+		{
+			name:               "untrusted sender with the tekton dir of the base branch",
+			untrusted:          true,
+			untrustedTektonDir: untrustedTektonDirBaseBranch,
+			wantCheckRuns:      []string{"Pipelines as Code CI / base"},
+			wantCompleted:      map[string]string{"Pipelines as Code CI / base": "neutral"},
+			wantPRNames:        []string{"base"},
+		},
+		{
+			name:               "trusted sender with the tekton dir of the base branch",
+			untrustedTektonDir: untrustedTektonDirBaseBranch,
+			wantCheckRuns:      []string{"Pipelines as Code CI / lint", "Pipelines as Code CI / unit"},
+			wantCompleted: map[string]string{
+				"Pipelines as Code CI / lint": "neutral",
+				"Pipelines as Code CI / unit": "neutral",
+			},
+			wantPRNames: []string{"lint", "unit"},
+		},
+		{
+			name:               "untrusted sender changing the tekton dir waits for an approval",
+			untrusted:          true,
+			untrustedTektonDir: untrustedTektonDirApproval,
+			changedFiles:       `[{"filename": "main.go"}, {"filename": ".tekton/unit.yaml"}]`,
+			wantCheckRuns:      []string{"Pipelines as Code CI"},
+			wantCompleted:      map[string]string{"Pipelines as Code CI": "action_required"},
+			wantPRNames:        []string{},
+		},
+		{
+			name:               "untrusted sender changing the tekton dir approved at its commit",
+			untrusted:          true,
+			untrustedTektonDir: untrustedTektonDirApproval,
+			changedFiles:       `[{"filename": ".tekton/unit.yaml"}]`,
+			approvals:          map[string]string{"fantasio": "LGTM\r\n/ok-to-test C0FFEE1"},
+			wantCheckRuns:      []string{"Pipelines as Code CI / lint", "Pipelines as Code CI / unit"},
+			wantCompleted: map[string]string{
+				"Pipelines as Code CI / lint": "neutral",
+				"Pipelines as Code CI / unit": "neutral",
+			},
+			wantPRNames: []string{"lint", "unit"},
+		},
+		{
+			name:               "untrusted sender changing the tekton dir approved at another commit",
+			untrusted:          true,
+			untrustedTektonDir: untrustedTektonDirApproval,
+			changedFiles:       `[{"filename": ".tekton/unit.yaml"}]`,
+			approvals:          map[string]string{"fantasio": "/ok-to-test deadbeef"},
+			wantCheckRuns:      []string{"Pipelines as Code CI"},
+			wantCompleted:      map[string]string{"Pipelines as Code CI": "action_required"},
+			wantPRNames:        []string{},
+		},
+		{
+			name:               "untrusted sender changing the tekton dir approved by a non owner",
+			untrusted:          true,
+			untrustedTektonDir: untrustedTektonDirApproval,
+			changedFiles:       `[{"filename": ".tekton/unit.yaml"}]`,
+			approvals:          map[string]string{"gaston": "/ok-to-test c0ffee1234"},
+			wantCheckRuns:      []string{"Pipelines as Code CI"},
+			wantCompleted:      map[string]string{"Pipelines as Code CI": "action_required"},
+			wantPRNames:        []string{},
+		},
+		{
+			name:               "ok-to-test comment of an owner without approving the commit",
+			triggerTarget:      webvcs.TriggerTargetOkToTestComment,
+			untrustedTektonDir: untrustedTektonDirApproval,
+			changedFiles:       `[{"filename": ".tekton/unit.yaml"}]`,
+			wantCheckRuns:      []string{"Pipelines as Code CI"},
+			wantCompleted:      map[string]string{"Pipelines as Code CI": "action_required"},
+			wantPRNames:        []string{},
+		},
+		{
+			name:               "untrusted sender not changing the tekton dir",
+			untrusted:          true,
+			untrustedTektonDir: untrustedTektonDirApproval,
+			changedFiles:       `[{"filename": "main.go"}, {"filename": "docs/.tekton.md"}]`,
+			wantCheckRuns:      []string{"Pipelines as Code CI / lint", "Pipelines as Code CI / unit"},
+			wantCompleted: map[string]string{
+				"Pipelines as Code CI / lint": "neutral",
+				"Pipelines as Code CI / unit": "neutral",
+			},
+			wantPRNames: []string{"lint", "unit"},
+		},
+		{
+			name:          "cancel comment",
+			triggerTarget: webvcs.TriggerTargetCancelComment,
+			wantCheckRuns: []string{},
+			wantCompleted: map[string]string{},
+			wantPRNames:   []string{},
+		},
+		{
+			name:          "cancel comment of an untrusted sender",
+			untrusted:     true,
+			triggerTarget: webvcs.TriggerTargetCancelComment,
+			wantCheckRuns: []string{},
+			wantCompleted: map[string]string{},
+			wantPRNames:   []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, _ := rtesting.SetupFakeContext(t)
+			runinfo := &webvcs.RunInfo{
+				SHA:               "c0ffee1234",
+				Owner:             "organizationes",
+				Repository:        "lagaffe",
+				URL:               "https://service/documentation",
+				HeadBranch:        "press",
+				BaseBranch:        "main",
+				Sender:            "fantasio",
+				EventType:         "pull_request",
+				PullRequestNumber: 6,
+				ApplicationName:   "Pipelines as Code CI",
+				TriggerTarget:     tt.triggerTarget,
+				TargetPipelineRun: tt.targetPipelineRun,
+			}
+			if tt.untrusted {
+				runinfo.Sender = "gaston"
+			}
+			fakeclient, mux, _, teardown := ghtesthelper.SetupGH()
+			defer teardown()
+			testSetupTektonDir(mux, runinfo, "testdata/multiple_pipelineruns")
+			// the head of the base branch
+			replyString(mux, "/repos/organizationes/lagaffe/branches/main", `{"name": "main", "commit": {"sha": "basesha"}}`)
+			testSetupTektonDir(mux, &webvcs.RunInfo{Owner: runinfo.Owner, Repository: runinfo.Repository, SHA: "basesha"},
+				"testdata/base_branch")
+			mux.HandleFunc("/orgs/organizationes/members/fantasio", func(rw http.ResponseWriter, r *http.Request) {
+				rw.WriteHeader(http.StatusNoContent)
+			})
+			if tt.changedFiles != "" {
+				replyString(mux, "/repos/organizationes/lagaffe/pulls/6/files", tt.changedFiles)
+			}
+
+			var lock sync.Mutex
+			checkRuns := []string{}
+			completed := map[string]string{}
+			mux.HandleFunc("/repos/organizationes/lagaffe/check-runs", func(rw http.ResponseWriter, r *http.Request) {
+				created := github.CreateCheckRunOptions{}
+				assert.NilError(t, json.NewDecoder(r.Body).Decode(&created))
+				lock.Lock()
+				defer lock.Unlock()
+				checkRuns = append(checkRuns, created.Name)
+				fmt.Fprintf(rw, `{"id": %d}`, len(checkRuns))
+			})
+			mux.HandleFunc("/repos/organizationes/lagaffe/check-runs/", func(rw http.ResponseWriter, r *http.Request) {
+				updated := github.UpdateCheckRunOptions{}
+				assert.NilError(t, json.NewDecoder(r.Body).Decode(&updated))
+				lock.Lock()
+				defer lock.Unlock()
+				assert.Equal(t, r.URL.Path, fmt.Sprintf("/repos/organizationes/lagaffe/check-runs/%d",
+					indexOf(checkRuns, updated.Name)+1))
+				if updated.GetStatus() == "completed" {
+					completed[updated.Name] = updated.GetConclusion()
+				}
+			})
+
+			// the pull request comments, edited in place
+			comments := []*github.IssueComment{}
+			if tt.untrusted {
+				comments = append(comments, &github.IssueComment{
+					ID: github.Int64(1), Body: github.String("/ok-to-test"), User: &github.User{Login: github.String("fantasio")},
+				})
+			}
+			for author, body := range tt.approvals {
+				comments = append(comments, &github.IssueComment{
+					ID: github.Int64(int64(len(comments) + 1)), Body: github.String(body), User: &github.User{Login: github.String(author)},
+				})
+			}
+			initialComments := len(comments)
+			mux.HandleFunc("/repos/organizationes/lagaffe/issues/6/comments", func(rw http.ResponseWriter, r *http.Request) {
+				lock.Lock()
+				defer lock.Unlock()
+				if r.Method == http.MethodGet {
+					assert.NilError(t, json.NewEncoder(rw).Encode(comments))
+					return
+				}
+				comment := &github.IssueComment{}
+				assert.NilError(t, json.NewDecoder(r.Body).Decode(comment))
+				comment.ID = github.Int64(int64(len(comments) + 1))
+				comment.User = &github.User{Login: github.String("pac[bot]")}
+				comments = append(comments, comment)
+				assert.NilError(t, json.NewEncoder(rw).Encode(comment))
+			})
+			mux.HandleFunc("/repos/organizationes/lagaffe/issues/comments/", func(rw http.ResponseWriter, r *http.Request) {
+				lock.Lock()
+				defer lock.Unlock()
+				assert.Equal(t, r.Method, http.MethodPatch)
+				id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/repos/organizationes/lagaffe/issues/comments/"))
+				assert.NilError(t, err)
+				assert.NilError(t, json.NewDecoder(r.Body).Decode(comments[id-1]))
+				assert.NilError(t, json.NewEncoder(rw).Encode(comments[id-1]))
+			})
+
+			repo := repository.NewRepo("test-run", runinfo.URL, runinfo.BaseBranch, "namespace", "namespace", runinfo.EventType)
+			repo.Spec.PullRequestComment = tt.pullRequestComment
+			repo.Spec.UntrustedTektonDir = tt.untrustedTektonDir
+			stdata, _ := testclient.SeedTestData(t, ctx, testclient.Data{
+				Namespaces:   []*corev1.Namespace{{ObjectMeta: metav1.ObjectMeta{Name: "namespace"}}},
+				Repositories: []*v1alpha1.Repository{repo},
+			})
+			// the fake clientset doesn't generate the names
+			stdata.Pipeline.PrependReactor("create", "pipelineruns", func(action ktesting.Action) (bool, runtime.Object, error) {
+				pr := action.(ktesting.CreateAction).GetObject().(*tektonv1beta1.PipelineRun)
+				pr.Name = pr.GenerateName + "generated"
+				return false, nil, nil
+			})
+			observer, _ := zapobserver.New(zap.InfoLevel)
+			cs := &cli.Clients{
+				VCSClient:      webvcs.GithubVCS{Client: fakeclient, Login: "pac[bot]"},
+				PipelineAsCode: stdata.PipelineAsCode,
+				Log:            zap.New(observer).Sugar(),
+				Kube:           stdata.Kube,
+				Tekton:         stdata.Pipeline,
+			}
+			k8int := &kitesthelper.KinterfaceTest{ConsoleURL: "https://console.url"}
+
+			assert.NilError(t, Run(ctx, cs, k8int, runinfo))
+
+			assert.DeepEqual(t, checkRuns, tt.wantCheckRuns)
+			assert.DeepEqual(t, completed, tt.wantCompleted)
+			commentStarts := []string{}
+			for _, comment := range comments[initialComments:] {
+				commentStarts = append(commentStarts, strings.SplitN(comment.GetBody(), "\n\n", 2)[0])
+			}
+			sort.Strings(commentStarts)
+			if tt.wantComments == nil {
+				tt.wantComments = []string{}
+			}
+			assert.DeepEqual(t, commentStarts, tt.wantComments)
+
+			prs, err := stdata.Pipeline.TektonV1beta1().PipelineRuns("namespace").List(ctx, metav1.ListOptions{})
+			assert.NilError(t, err)
+			prNames := []string{}
+			for _, pr := range prs.Items {
+				prNames = append(prNames, pr.Labels[originalPRNameLabel])
+			}
+			sort.Strings(prNames)
+			assert.DeepEqual(t, prNames, tt.wantPRNames)
+			if len(tt.wantPRNames) == 0 {
+				return
+			}
+
+			gotRepo, err := stdata.PipelineAsCode.PipelinesascodeV1alpha1().Repositories("namespace").Get(ctx, "test-run", metav1.GetOptions{})
+			assert.NilError(t, err)
+			statusNames := []string{}
+			for _, status := range gotRepo.Status[len(gotRepo.Status)-len(tt.wantPRNames):] {
+				statusNames = append(statusNames, status.PipelineRunName)
+			}
+			sort.Strings(statusNames)
+			wantStatusNames := []string{}
+			for _, name := range tt.wantPRNames {
+				wantStatusNames = append(wantStatusNames, name+"-generated")
+			}
+			assert.DeepEqual(t, statusNames, wantStatusNames)
+		})
+	}
+}
+
+func indexOf(values []string, value string) int {
+	for i, v := range values {
+		if v == value {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestRunClosedPullRequest(t *testing.T) {
+	tests := []struct {
+		name          string
+		sender        string
+		wantCancelled bool
+	}{
+		{
+			name:          "closed by a member",
+			sender:        "fantasio",
+			wantCancelled: true,
+		},
+		{
+			name:          "closed by a sender not allowed",
+			sender:        "gaston",
+			wantCancelled: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, _ := rtesting.SetupFakeContext(t)
+			runinfo := &webvcs.RunInfo{
+				SHA:               "principale",
+				Owner:             "organizationes",
+				Repository:        "lagaffe",
+				URL:               "https://service/documentation",
+				HeadBranch:        "press",
+				BaseBranch:        "main",
+				Sender:            tt.sender,
+				EventType:         webvcs.EventTypePullRequestClosed,
+				PullRequestNumber: 6,
+			}
+			fakeclient, mux, _, teardown := ghtesthelper.SetupGH()
+			defer teardown()
+			testSetupTektonDir(mux, runinfo, "testdata/pull_request")
+			mux.HandleFunc("/orgs/organizationes/members/fantasio", func(rw http.ResponseWriter, r *http.Request) {
+				rw.WriteHeader(http.StatusNoContent)
+			})
+			replyString(mux, "/repos/organizationes/lagaffe/issues/6/comments", `[]`)
+
+			running := &tektonv1beta1.PipelineRun{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "unit-running",
+					Namespace: "namespace",
+					Labels: map[string]string{
+						"pipelinesascode.tekton.dev/url-org":        ownerLabel(runinfo),
+						"pipelinesascode.tekton.dev/url-repository": "lagaffe",
+						pullRequestLabel:                            "6",
+						originalPRNameLabel:                         "unit",
+					},
+				},
+			}
+			stdata, _ := testclient.SeedTestData(t, ctx, testclient.Data{
+				Namespaces: []*corev1.Namespace{{ObjectMeta: metav1.ObjectMeta{Name: "namespace"}}},
+				Repositories: []*v1alpha1.Repository{
+					repository.NewRepo("test-run", runinfo.URL, runinfo.BaseBranch, "namespace", "namespace", "pull_request"),
+				},
+			})
+			_, err := stdata.Pipeline.TektonV1beta1().PipelineRuns("namespace").Create(ctx, running, metav1.CreateOptions{})
+			assert.NilError(t, err)
+			observer, _ := zapobserver.New(zap.InfoLevel)
+			cs := &cli.Clients{
+				VCSClient:      webvcs.GithubVCS{Client: fakeclient},
+				PipelineAsCode: stdata.PipelineAsCode,
+				Log:            zap.New(observer).Sugar(),
+				Kube:           stdata.Kube,
+				Tekton:         stdata.Pipeline,
+			}
+			k8int := &kitesthelper.KinterfaceTest{ConsoleURL: "https://console.url"}
+
+			assert.NilError(t, Run(ctx, cs, k8int, runinfo))
+
+			running, err = stdata.Pipeline.TektonV1beta1().PipelineRuns("namespace").Get(ctx, "unit-running", metav1.GetOptions{})
+			assert.NilError(t, err)
+			assert.Equal(t, running.IsCancelled(), tt.wantCancelled)
+		})
+	}
+}
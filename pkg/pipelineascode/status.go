@@ -5,9 +5,12 @@ import (
 	"context"
 	"fmt"
 	"sort"
+	"strings"
 	"text/template"
 
 	"github.com/openshift-pipelines/pipelines-as-code/pkg/cli"
+	"github.com/openshift-pipelines/pipelines-as-code/pkg/junit"
+	"github.com/openshift-pipelines/pipelines-as-code/pkg/problemmatcher"
 	"github.com/openshift-pipelines/pipelines-as-code/pkg/webvcs"
 	tektonv1beta1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
 	corev1 "k8s.io/api/core/v1"
@@ -15,7 +18,59 @@ import (
 	knative1 "knative.dev/pkg/apis/duck/v1beta1"
 )
 
-const checkStatustmpl = `{{.taskStatus}}`
+const checkStatustmpl = `{{.taskStatus}}{{.testStatus}}{{.problemStatus}}`
+
+// junitResultName is the result where a task publish its JUnit XML report,
+// junitStepName is the step printing it in its logs for the reports too large
+// for a result
+const (
+	junitResultName = "junit-report"
+	junitStepName   = "junit-report"
+)
+
+// maxListedTestFailures is the number of failed test cases listed in the
+// status, the others are only counted
+const maxListedTestFailures = 20
+
+// problemMatchersAnnotation is the PipelineRun annotation with the problem
+// matchers to apply to the logs of its failed steps
+const problemMatchersAnnotation = "pipelinesascode.tekton.dev/problem-matchers"
+
+// maxListedProblems is the number of log lines with a problem in the status
+const maxListedProblems = 20
+
+const problemStatustmpl = `
+
+<h4>Problems</h4>
+
+<pre>
+{{- range $problem := .Problems }}
+{{ html $problem.Text }}
+{{- end }}
+{{- if .More }}
+and {{ .More }} more
+{{- end }}
+</pre>`
+
+const testStatustmpl = `
+
+<h4>Tests</h4>
+
+{{ .Report.Summary }}
+{{- if .Report.Truncated }}
+
+A JUnit report has been truncated, the tests after the cut are not counted.
+{{- end }}
+{{- if .Failures }}
+<ul>
+{{- range $failure := .Failures }}
+<li><b>{{ html $failure.Name }}</b>{{ if $failure.Path }} in {{ html $failure.Path }}{{ if $failure.Line }}:{{ $failure.Line }}{{ end }}{{ end }}</li>
+{{- end }}
+{{- if .More }}
+<li>and {{ .More }} more</li>
+{{- end }}
+</ul>
+{{- end }}`
 
 const taskStatustmpl = `
 <table>
@@ -132,6 +187,225 @@ func statusOfAllTaskListForCheckRun(pr *tektonv1beta1.PipelineRun, consoleURL st
 	return outputBuffer.String(), nil
 }
 
+// junitReportOfPipelineRun get the JUnit reports published by the tasks of
+// the PipelineRun as one report, it returns nil if there isn't any. A task
+// publishes its report in its junit-report result or, since a result is
+// limited to a few kilobytes, prints it in the logs of its junit-report step
+// (i.e: from a file of its workspace). The reports which cannot be parsed are
+// only logged.
+func junitReportOfPipelineRun(ctx context.Context, cs *cli.Clients, k8int cli.KubeInteractionIntf,
+	pr *tektonv1beta1.PipelineRun) *junit.Report {
+	taskrunNames := make([]string, 0, len(pr.Status.TaskRuns))
+	for taskrunName := range pr.Status.TaskRuns {
+		taskrunNames = append(taskrunNames, taskrunName)
+	}
+	sort.Strings(taskrunNames)
+
+	var report *junit.Report
+	add := func(taskrunName string, taskReport *junit.Report, err error) {
+		if err != nil {
+			cs.Log.Infof("Skipping the JUnit report of TaskRun %s/%s: %s", pr.Namespace, taskrunName, err)
+			return
+		}
+		if report == nil {
+			report = &junit.Report{}
+		}
+		report.Add(taskReport)
+	}
+	for _, taskrunName := range taskrunNames {
+		taskrunStatus := pr.Status.TaskRuns[taskrunName]
+		if taskrunStatus.Status == nil {
+			continue
+		}
+		for _, result := range taskrunStatus.Status.TaskRunResults {
+			if result.Name == junitResultName {
+				taskReport, err := junit.Parse([]byte(result.Value))
+				add(taskrunName, taskReport, err)
+			}
+		}
+		for _, step := range taskrunStatus.Status.Steps {
+			if step.Name != junitStepName || step.Terminated == nil {
+				continue
+			}
+			logs, err := k8int.GetPodLogs(ctx, pr.Namespace, taskrunStatus.Status.PodName, step.ContainerName)
+			if err != nil {
+				cs.Log.Infof("Cannot get the logs of the step %s of TaskRun %s/%s: %s", step.Name, pr.Namespace, taskrunName, err)
+				continue
+			}
+			taskReport, err := parseJUnitLogs(logs)
+			add(taskrunName, taskReport, err)
+		}
+	}
+	return report
+}
+
+// parseJUnitLogs parse a JUnit report printed in the logs of a step, after
+// the lines the step may print before it. Only the last lines of the logs are
+// kept so a long report may have lost its beginning, the test suites left are
+// then read up to the end and the report is marked as truncated.
+func parseJUnitLogs(logs string) (*junit.Report, error) {
+	for _, root := range []string{"<?xml", "<testsuites"} {
+		if start := strings.Index(logs, root); start != -1 {
+			return junit.Parse([]byte(logs[start:]))
+		}
+	}
+	start := strings.Index(logs, "<testsuite")
+	if start == -1 {
+		return junit.Parse([]byte(strings.TrimSpace(logs)))
+	}
+
+	// the closing tag of a testsuites report which lost its beginning
+	suites, missingRoot := logs[start:], false
+	if end := strings.LastIndex(suites, "</testsuites>"); end != -1 {
+		suites, missingRoot = suites[:end], true
+	}
+	report, err := junit.ParseSuites([]byte(suites))
+	if err != nil {
+		return nil, err
+	}
+	// the end of a test suite cut before the first one left
+	before := logs[:start]
+	report.Truncated = report.Truncated || missingRoot ||
+		strings.Contains(before, "<testcase") || strings.Contains(before, "</testsuite>")
+	return report, nil
+}
+
+// statusOfJUnitReport get the count of the test cases and the list of the
+// failed ones
+func statusOfJUnitReport(report *junit.Report) (string, error) {
+	var outputBuffer bytes.Buffer
+
+	failures, more := report.Failures, 0
+	if len(failures) > maxListedTestFailures {
+		failures, more = failures[:maxListedTestFailures], len(failures)-maxListedTestFailures
+	}
+	data := struct {
+		Report   *junit.Report
+		Failures []junit.Failure
+		More     int
+	}{
+		Report:   report,
+		Failures: failures,
+		More:     more,
+	}
+
+	t := template.Must(template.New("Test Status").Parse(testStatustmpl))
+	if err := t.Execute(&outputBuffer, data); err != nil {
+		return "", err
+	}
+	return outputBuffer.String(), nil
+}
+
+// junitAnnotations get an annotation for each failed test case with a path
+func junitAnnotations(report *junit.Report) []webvcs.Annotation {
+	annotations := []webvcs.Annotation{}
+	for _, failure := range report.Failures {
+		if failure.Path == "" {
+			continue
+		}
+		message := failure.Message
+		if message == "" {
+			message = "The test has failed"
+		}
+		annotations = append(annotations, webvcs.Annotation{
+			Path:      failure.Path,
+			StartLine: failure.Line,
+			EndLine:   failure.Line,
+			Level:     webvcs.AnnotationLevelFailure,
+			Title:     failure.Name,
+			Message:   message,
+		})
+	}
+	return annotations
+}
+
+// problemsOfPipelineRun apply the problem matchers of the PipelineRun
+// annotation to the logs of its failed steps
+func problemsOfPipelineRun(ctx context.Context, cs *cli.Clients, k8int cli.KubeInteractionIntf,
+	pr *tektonv1beta1.PipelineRun) []problemmatcher.Problem {
+	annotation, ok := pr.GetAnnotations()[problemMatchersAnnotation]
+	if !ok {
+		return nil
+	}
+	matchers, err := problemmatcher.Parse(annotation)
+	if err != nil {
+		cs.Log.Infof("Skipping the problem matchers of PipelineRun %s/%s: %s", pr.Namespace, pr.Name, err)
+		return nil
+	}
+
+	taskrunNames := make([]string, 0, len(pr.Status.TaskRuns))
+	for taskrunName := range pr.Status.TaskRuns {
+		taskrunNames = append(taskrunNames, taskrunName)
+	}
+	sort.Strings(taskrunNames)
+
+	problems := []problemmatcher.Problem{}
+	for _, taskrunName := range taskrunNames {
+		taskrunStatus := pr.Status.TaskRuns[taskrunName]
+		if taskrunStatus.Status == nil {
+			continue
+		}
+		for _, step := range taskrunStatus.Status.Steps {
+			if step.Terminated == nil || step.Terminated.ExitCode == 0 {
+				continue
+			}
+			logs, err := k8int.GetPodLogs(ctx, pr.Namespace, taskrunStatus.Status.PodName, step.ContainerName)
+			if err != nil {
+				cs.Log.Infof("Cannot get the logs of the step %s of TaskRun %s/%s: %s", step.Name, pr.Namespace, taskrunName, err)
+				continue
+			}
+			problems = append(problems, problemmatcher.Match(matchers, logs)...)
+		}
+	}
+	return problems
+}
+
+// statusOfProblems get an excerpt of the log lines with a problem
+func statusOfProblems(problems []problemmatcher.Problem) (string, error) {
+	var outputBuffer bytes.Buffer
+
+	more := 0
+	if len(problems) > maxListedProblems {
+		problems, more = problems[:maxListedProblems], len(problems)-maxListedProblems
+	}
+	data := struct {
+		Problems []problemmatcher.Problem
+		More     int
+	}{
+		Problems: problems,
+		More:     more,
+	}
+
+	t := template.Must(template.New("Problem Status").Parse(problemStatustmpl))
+	if err := t.Execute(&outputBuffer, data); err != nil {
+		return "", err
+	}
+	return outputBuffer.String(), nil
+}
+
+// problemAnnotations get an annotation for each problem
+func problemAnnotations(problems []problemmatcher.Problem) []webvcs.Annotation {
+	annotations := []webvcs.Annotation{}
+	for _, problem := range problems {
+		level := webvcs.AnnotationLevelFailure
+		switch problem.Severity {
+		case problemmatcher.SeverityWarning:
+			level = webvcs.AnnotationLevelWarning
+		case problemmatcher.SeverityNotice:
+			level = webvcs.AnnotationLevelNotice
+		}
+		annotations = append(annotations, webvcs.Annotation{
+			Path:      problem.File,
+			StartLine: problem.Line,
+			EndLine:   problem.Line,
+			Level:     level,
+			Title:     problem.Owner,
+			Message:   problem.Message,
+		})
+	}
+	return annotations
+}
+
 func postFinalStatus(ctx context.Context, cs *cli.Clients, k8int cli.KubeInteractionIntf, runinfo *webvcs.RunInfo, prName, namespace string) (*tektonv1beta1.PipelineRun, error) {
 	var outputBuffer bytes.Buffer
 
@@ -151,7 +425,29 @@ func postFinalStatus(ctx context.Context, cs *cli.Clients, k8int cli.KubeInterac
 	}
 
 	data := map[string]string{
-		"taskStatus": taskStatus,
+		"taskStatus":    taskStatus,
+		"testStatus":    "",
+		"problemStatus": "",
+	}
+
+	// Add the test results of the JUnit reports and annotate the failed tests
+	if report := junitReportOfPipelineRun(ctx, cs, k8int, pr); report != nil {
+		testStatus, err := statusOfJUnitReport(report)
+		if err != nil {
+			return pr, err
+		}
+		data["testStatus"] = testStatus
+		runinfo.Annotations = append(runinfo.Annotations, junitAnnotations(report)...)
+	}
+
+	// Add the problems found in the logs of the failed steps and annotate them
+	if problems := problemsOfPipelineRun(ctx, cs, k8int, pr); len(problems) > 0 {
+		problemStatus, err := statusOfProblems(problems)
+		if err != nil {
+			return pr, err
+		}
+		data["problemStatus"] = problemStatus
+		runinfo.Annotations = append(runinfo.Annotations, problemAnnotations(problems)...)
 	}
 
 	t := template.Must(template.New("Pipeline Status").Parse(checkStatustmpl))
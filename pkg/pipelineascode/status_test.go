@@ -1,17 +1,26 @@
 package pipelineascode
 
 import (
+	"fmt"
 	"sort"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/jonboulle/clockwork"
+	"github.com/openshift-pipelines/pipelines-as-code/pkg/cli"
+	"github.com/openshift-pipelines/pipelines-as-code/pkg/junit"
+	"github.com/openshift-pipelines/pipelines-as-code/pkg/problemmatcher"
+	kitesthelper "github.com/openshift-pipelines/pipelines-as-code/pkg/test/kubernetestint"
+	"github.com/openshift-pipelines/pipelines-as-code/pkg/webvcs"
 	tektonv1beta1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	"go.uber.org/zap"
+	zapobserver "go.uber.org/zap/zaptest/observer"
 	"gotest.tools/v3/assert"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	duckv1beta1 "knative.dev/pkg/apis/duck/v1beta1"
+	rtesting "knative.dev/pkg/reconciler/testing"
 )
 
 func TestPipelineRunStatus(t *testing.T) {
@@ -168,3 +177,285 @@ func TestConditionEmoji(t *testing.T) {
 		})
 	}
 }
+
+func TestJUnitReportOfPipelineRun(t *testing.T) {
+	taskrunStatus := func(results ...tektonv1beta1.TaskRunResult) *tektonv1beta1.PipelineRunTaskRunStatus {
+		return &tektonv1beta1.PipelineRunTaskRunStatus{
+			Status: &tektonv1beta1.TaskRunStatus{
+				TaskRunStatusFields: tektonv1beta1.TaskRunStatusFields{TaskRunResults: results},
+			},
+		}
+	}
+	unitReport := tektonv1beta1.TaskRunResult{
+		Name: junitResultName,
+		Value: `<testsuite><testcase name="TestOne" file="foo_test.go" line="3"><failure message="nope"/></testcase>
+<testcase name="TestTwo"/></testsuite>`,
+	}
+	e2eReport := tektonv1beta1.TaskRunResult{
+		Name:  junitResultName,
+		Value: `<testsuites><testsuite><testcase name="TestE2E"><skipped/></testcase></testsuite></testsuites>`,
+	}
+	invalidReport := tektonv1beta1.TaskRunResult{Name: junitResultName, Value: "PASS"}
+	truncatedReport := tektonv1beta1.TaskRunResult{
+		Name:  junitResultName,
+		Value: `<testsuite><testcase name="TestOne"/><testcase name="TestT`,
+	}
+	// the reports too large for a result are printed by a step
+	stepStatus := func(podName string, steps ...string) *tektonv1beta1.PipelineRunTaskRunStatus {
+		status := &tektonv1beta1.PipelineRunTaskRunStatus{
+			Status: &tektonv1beta1.TaskRunStatus{TaskRunStatusFields: tektonv1beta1.TaskRunStatusFields{PodName: podName}},
+		}
+		for _, name := range steps {
+			status.Status.Steps = append(status.Status.Steps, tektonv1beta1.StepState{
+				Name:           name,
+				ContainerName:  "step-" + name,
+				ContainerState: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{}},
+			})
+		}
+		return status
+	}
+	podLogs := map[string]string{
+		"unit-pod/step-test":         "<testsuite><testcase name=\"NotAReport\"/></testsuite>",
+		"unit-pod/step-junit-report": "<?xml version=\"1.0\"?>\n<testsuite><testcase name=\"TestLogs\"/></testsuite>\n",
+		// the first lines are not in the logs we get
+		"long-pod/step-junit-report": "e=\"TestGone\"/></testsuite>\n<testsuite><testcase name=\"TestKept\"/></testsuite>\n" +
+			"<testsuite><testcase name=\"TestAlsoKept\"/><testcase name=\"TestKeptToo\"/></testsuite>\n</testsuites>",
+		// the lines printed before and after a whole report
+		"printed-pod/step-junit-report": "Running the tests\n<testsuite><testcase name=\"TestPrinted\"/></testsuite>\n" +
+			"<testsuite><testcase name=\"TestPrintedToo\"/></testsuite>\nDone\n",
+	}
+
+	tests := []struct {
+		name     string
+		taskruns map[string]*tektonv1beta1.PipelineRunTaskRunStatus
+		want     *junit.Report
+		wantLog  string
+	}{
+		{
+			name: "reports",
+			taskruns: map[string]*tektonv1beta1.PipelineRunTaskRunStatus{
+				"pr-unit": taskrunStatus(tektonv1beta1.TaskRunResult{Name: "digest", Value: "sha"}, unitReport),
+				"pr-e2e":  taskrunStatus(e2eReport),
+				"pr-lint": {},
+			},
+			want: &junit.Report{
+				Passed:   1,
+				Failed:   1,
+				Skipped:  1,
+				Failures: []junit.Failure{{Name: "TestOne", Path: "foo_test.go", Line: 3, Message: "nope"}},
+			},
+		},
+		{
+			name: "truncated report",
+			taskruns: map[string]*tektonv1beta1.PipelineRunTaskRunStatus{
+				"pr-unit": taskrunStatus(truncatedReport),
+			},
+			want: &junit.Report{Passed: 1, Truncated: true},
+		},
+		{
+			name: "reports in the step logs",
+			taskruns: map[string]*tektonv1beta1.PipelineRunTaskRunStatus{
+				"pr-unit": stepStatus("unit-pod", "test", junitStepName),
+				"pr-long": stepStatus("long-pod", junitStepName),
+			},
+			want: &junit.Report{Passed: 4, Truncated: true},
+		},
+		{
+			name: "reports after some log lines",
+			taskruns: map[string]*tektonv1beta1.PipelineRunTaskRunStatus{
+				"pr-printed": stepStatus("printed-pod", junitStepName),
+			},
+			want: &junit.Report{Passed: 2},
+		},
+		{
+			name: "missing step logs",
+			taskruns: map[string]*tektonv1beta1.PipelineRunTaskRunStatus{
+				"pr-e2e": stepStatus("e2e-pod", junitStepName),
+			},
+			wantLog: "Cannot get the logs of the step junit-report of TaskRun ns/pr-e2e",
+		},
+		{
+			name: "invalid report",
+			taskruns: map[string]*tektonv1beta1.PipelineRunTaskRunStatus{
+				"pr-unit": taskrunStatus(invalidReport),
+			},
+			wantLog: "Skipping the JUnit report of TaskRun ns/pr-unit: cannot parse the JUnit report",
+		},
+		{
+			name: "no report",
+			taskruns: map[string]*tektonv1beta1.PipelineRunTaskRunStatus{
+				"pr-unit": taskrunStatus(tektonv1beta1.TaskRunResult{Name: "digest", Value: "sha"}),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, _ := rtesting.SetupFakeContext(t)
+			observer, log := zapobserver.New(zap.InfoLevel)
+			cs := &cli.Clients{Log: zap.New(observer).Sugar()}
+			k8int := &kitesthelper.KinterfaceTest{PodLogs: podLogs}
+			pr := &tektonv1beta1.PipelineRun{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "ns"},
+				Status: tektonv1beta1.PipelineRunStatus{
+					PipelineRunStatusFields: tektonv1beta1.PipelineRunStatusFields{TaskRuns: tt.taskruns},
+				},
+			}
+			got := junitReportOfPipelineRun(ctx, cs, k8int, pr)
+			assert.DeepEqual(t, got, tt.want)
+			if tt.wantLog != "" {
+				assert.Equal(t, log.Len(), 1)
+				assert.Assert(t, strings.Contains(log.All()[0].Message, tt.wantLog), log.All()[0].Message)
+			}
+		})
+	}
+}
+
+func TestStatusOfJUnitReport(t *testing.T) {
+	report := &junit.Report{Passed: 3, Skipped: 1}
+	for i := 0; i < maxListedTestFailures+2; i++ {
+		report.Failed++
+		report.Failures = append(report.Failures, junit.Failure{Name: fmt.Sprintf("Test<%d>", i), Path: "foo_test.go", Line: i})
+	}
+
+	got, err := statusOfJUnitReport(report)
+	assert.NilError(t, err)
+	assert.Assert(t, strings.Contains(got, "3 passed, 22 failed, 1 skipped"), got)
+	assert.Assert(t, strings.Contains(got, "<li><b>Test&lt;0&gt;</b> in foo_test.go</li>"), got)
+	assert.Assert(t, strings.Contains(got, "<li><b>Test&lt;1&gt;</b> in foo_test.go:1</li>"), got)
+	assert.Assert(t, !strings.Contains(got, "Test&lt;20&gt;"), got)
+	assert.Assert(t, strings.Contains(got, "<li>and 2 more</li>"), got)
+
+	got, err = statusOfJUnitReport(&junit.Report{Passed: 3})
+	assert.NilError(t, err)
+	assert.Assert(t, !strings.Contains(got, "<ul>"), got)
+	assert.Assert(t, !strings.Contains(got, "truncated"), got)
+
+	got, err = statusOfJUnitReport(&junit.Report{Passed: 3, Truncated: true})
+	assert.NilError(t, err)
+	assert.Assert(t, strings.Contains(got, "A JUnit report has been truncated"), got)
+}
+
+func TestJUnitAnnotations(t *testing.T) {
+	report := &junit.Report{
+		Failed: 2,
+		Failures: []junit.Failure{
+			{Name: "TestOne", Path: "foo_test.go", Line: 3, Message: "nope"},
+			{Name: "TestTwo", Path: "bar_test.go"},
+			{Name: "TestThree", Message: "no path"},
+		},
+	}
+	assert.DeepEqual(t, junitAnnotations(report), []webvcs.Annotation{
+		{Path: "foo_test.go", StartLine: 3, EndLine: 3, Level: webvcs.AnnotationLevelFailure, Title: "TestOne", Message: "nope"},
+		{Path: "bar_test.go", Level: webvcs.AnnotationLevelFailure, Title: "TestTwo", Message: "The test has failed"},
+	})
+}
+
+func TestProblemsOfPipelineRun(t *testing.T) {
+	step := func(name string, exitCode int32) tektonv1beta1.StepState {
+		return tektonv1beta1.StepState{
+			Name:          name,
+			ContainerName: "step-" + name,
+			ContainerState: corev1.ContainerState{
+				Terminated: &corev1.ContainerStateTerminated{ExitCode: exitCode},
+			},
+		}
+	}
+	taskruns := map[string]*tektonv1beta1.PipelineRunTaskRunStatus{
+		"pr-build": {
+			Status: &tektonv1beta1.TaskRunStatus{
+				TaskRunStatusFields: tektonv1beta1.TaskRunStatusFields{
+					PodName: "pr-build-pod",
+					Steps:   []tektonv1beta1.StepState{step("fetch", 0), step("build", 1)},
+				},
+			},
+		},
+		"pr-lint": {
+			Status: &tektonv1beta1.TaskRunStatus{
+				TaskRunStatusFields: tektonv1beta1.TaskRunStatusFields{
+					PodName: "pr-lint-pod",
+					Steps:   []tektonv1beta1.StepState{step("lint", 2)},
+				},
+			},
+		},
+		"pr-pending": {},
+	}
+	podLogs := map[string]string{
+		"pr-build-pod/step-fetch": "main.go:1:1: not a failed step",
+		"pr-build-pod/step-build": "# github.com/linda/project\nmain.go:12:3: undefined: bar\n",
+	}
+	goMatcher := `[{"owner": "go", "regexp": "^(.+):(\\d+):(\\d+): (.+)$", "file": 1, "line": 2, "column": 3, "message": 4}]`
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        []problemmatcher.Problem
+		wantLogs    []string
+	}{
+		{
+			name:        "problems of the failed steps",
+			annotations: map[string]string{problemMatchersAnnotation: goMatcher},
+			want: []problemmatcher.Problem{
+				{
+					Owner: "go", Severity: problemmatcher.SeverityError, File: "main.go", Line: 12, Column: 3,
+					Message: "undefined: bar", Text: "main.go:12:3: undefined: bar",
+				},
+			},
+			wantLogs: []string{"Cannot get the logs of the step lint of TaskRun ns/pr-lint: cannot find the logs of pr-lint-pod/step-lint"},
+		},
+		{
+			name:        "invalid problem matchers",
+			annotations: map[string]string{problemMatchersAnnotation: "owner: go"},
+			wantLogs:    []string{"Skipping the problem matchers of PipelineRun ns/pr: cannot parse the problem matchers"},
+		},
+		{
+			name: "no problem matchers",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, _ := rtesting.SetupFakeContext(t)
+			observer, log := zapobserver.New(zap.InfoLevel)
+			cs := &cli.Clients{Log: zap.New(observer).Sugar()}
+			k8int := &kitesthelper.KinterfaceTest{PodLogs: podLogs}
+			pr := &tektonv1beta1.PipelineRun{
+				ObjectMeta: metav1.ObjectMeta{Name: "pr", Namespace: "ns", Annotations: tt.annotations},
+				Status: tektonv1beta1.PipelineRunStatus{
+					PipelineRunStatusFields: tektonv1beta1.PipelineRunStatusFields{TaskRuns: taskruns},
+				},
+			}
+
+			got := problemsOfPipelineRun(ctx, cs, k8int, pr)
+			assert.DeepEqual(t, got, tt.want)
+			assert.Equal(t, log.Len(), len(tt.wantLogs))
+			for i, entry := range log.All() {
+				assert.Assert(t, strings.Contains(entry.Message, tt.wantLogs[i]), entry.Message)
+			}
+		})
+	}
+}
+
+func TestStatusOfProblems(t *testing.T) {
+	problems := []problemmatcher.Problem{}
+	for i := 0; i < maxListedProblems+3; i++ {
+		problems = append(problems, problemmatcher.Problem{Text: fmt.Sprintf("main.go:%d:1: <nil> is not a type", i)})
+	}
+
+	got, err := statusOfProblems(problems)
+	assert.NilError(t, err)
+	assert.Assert(t, strings.Contains(got, "<pre>\nmain.go:0:1: &lt;nil&gt; is not a type\n"), got)
+	assert.Assert(t, strings.Contains(got, "main.go:19:1: &lt;nil&gt; is not a type\nand 3 more\n</pre>"), got)
+	assert.Assert(t, !strings.Contains(got, "main.go:20:1"), got)
+}
+
+func TestProblemAnnotations(t *testing.T) {
+	problems := []problemmatcher.Problem{
+		{Owner: "go", Severity: problemmatcher.SeverityError, File: "main.go", Line: 12, Message: "undefined: bar"},
+		{Owner: "lint", Severity: problemmatcher.SeverityWarning, File: "README.md", Message: "line too long"},
+		{Owner: "lint", Severity: problemmatcher.SeverityNotice, File: "main.go", Line: 1, Message: "package comment"},
+	}
+	assert.DeepEqual(t, problemAnnotations(problems), []webvcs.Annotation{
+		{Path: "main.go", StartLine: 12, EndLine: 12, Level: webvcs.AnnotationLevelFailure, Title: "go", Message: "undefined: bar"},
+		{Path: "README.md", Level: webvcs.AnnotationLevelWarning, Title: "lint", Message: "line too long"},
+		{Path: "main.go", StartLine: 1, EndLine: 1, Level: webvcs.AnnotationLevelNotice, Title: "lint", Message: "package comment"},
+	})
+}
@@ -5,7 +5,14 @@ import (
 	"strings"
 )
 
-var reTemplate = regexp.MustCompile(`{{([^}]{2,})}}`)
+var (
+	reTemplate = regexp.MustCompile(`{{([^}]{2,})}}`)
+	// reSemver match a semantic version (see https://semver.org) with an
+	// optional v prefix
+	reSemver = regexp.MustCompile(`^v?(0|[1-9]\d*)\.(0|[1-9]\d*)\.(0|[1-9]\d*)` +
+		`(?:-((?:0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*)(?:\.(?:0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*))*))?` +
+		`(?:\+([0-9a-zA-Z-]+(?:\.[0-9a-zA-Z-]+)*))?$`)
+)
 
 // ReplacePlaceHoldersVariables Replace those {{var}} placeholders to the runinfo variable
 func ReplacePlaceHoldersVariables(template string, dico map[string]string) string {
@@ -18,3 +25,23 @@ func ReplacePlaceHoldersVariables(template string, dico map[string]string) strin
 		return dico[key]
 	})
 }
+
+// tagVariables get the placeholders variables of a tag, when the tag is a
+// semantic version its components are set as well
+func tagVariables(tag string) map[string]string {
+	if tag == "" {
+		return map[string]string{}
+	}
+	variables := map[string]string{"tag": tag}
+	parts := reSemver.FindStringSubmatch(tag)
+	if parts == nil {
+		return variables
+	}
+	variables["tag_version"] = strings.TrimPrefix(tag, "v")
+	variables["tag_major"] = parts[1]
+	variables["tag_minor"] = parts[2]
+	variables["tag_patch"] = parts[3]
+	variables["tag_prerelease"] = parts[4]
+	variables["tag_build"] = parts[5]
+	return variables
+}
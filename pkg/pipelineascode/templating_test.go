@@ -24,3 +24,35 @@ bar: {{ bar}}
 		t.Fatalf("-got, +want: %v", d)
 	}
 }
+
+func TestTagVariables(t *testing.T) {
+	tests := []struct {
+		tag  string
+		want map[string]string
+	}{
+		{tag: "", want: map[string]string{}},
+		{tag: "nightly", want: map[string]string{"tag": "nightly"}},
+		{tag: "v1.2", want: map[string]string{"tag": "v1.2"}},
+		{
+			tag: "v1.2.3",
+			want: map[string]string{
+				"tag": "v1.2.3", "tag_version": "1.2.3", "tag_major": "1", "tag_minor": "2", "tag_patch": "3",
+				"tag_prerelease": "", "tag_build": "",
+			},
+		},
+		{
+			tag: "10.0.1-rc.1+build.5",
+			want: map[string]string{
+				"tag": "10.0.1-rc.1+build.5", "tag_version": "10.0.1-rc.1+build.5", "tag_major": "10", "tag_minor": "0",
+				"tag_patch": "1", "tag_prerelease": "rc.1", "tag_build": "build.5",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.tag, func(t *testing.T) {
+			if d := cmp.Diff(tagVariables(tt.tag), tt.want); d != "" {
+				t.Fatalf("-got, +want: %v", d)
+			}
+		})
+	}
+}
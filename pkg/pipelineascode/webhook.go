@@ -0,0 +1,224 @@
+package pipelineascode
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/openshift-pipelines/pipelines-as-code/pkg/apis/pipelinesascode/v1alpha1"
+	"github.com/openshift-pipelines/pipelines-as-code/pkg/cli"
+	"github.com/openshift-pipelines/pipelines-as-code/pkg/config"
+	pacv1alpha1listers "github.com/openshift-pipelines/pipelines-as-code/pkg/generated/listers/pipelinesascode/v1alpha1"
+	"github.com/openshift-pipelines/pipelines-as-code/pkg/webvcs"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	defaultWebhookSecretKey = "webhook.secret"
+
+	// webhookSecretTTL is how long the value of a webhook secret is kept by a
+	// WebhookSecretStore, a rotated secret is picked up after that
+	webhookSecretTTL = time.Minute
+)
+
+// WebhookSecretStore keeps what the payloads are verified with across the
+// events of a long running server, so an unauthenticated request neither
+// lists the Repository CRs nor gets their Secrets from the API server. The
+// Repository CRs come from an informer and the webhook secret values, or the
+// error getting them, are kept for webhookSecretTTL. A nil WebhookSecretStore
+// gets them from the API server on each verification.
+type WebhookSecretStore struct {
+	repositories pacv1alpha1listers.RepositoryLister
+	now          func() time.Time
+
+	mu     sync.Mutex
+	values map[string]storedSecretValue
+}
+
+type storedSecretValue struct {
+	value   string
+	err     error
+	expires time.Time
+}
+
+// NewWebhookSecretStore create a WebhookSecretStore listing the Repository
+// CRs from the lister of a started informer
+func NewWebhookSecretStore(repositories pacv1alpha1listers.RepositoryLister) *WebhookSecretStore {
+	return &WebhookSecretStore{
+		repositories: repositories,
+		now:          time.Now,
+		values:       map[string]storedSecretValue{},
+	}
+}
+
+// listRepositories list the Repository CRs of all the namespaces, the ones
+// from the lister must not be modified
+func (s *WebhookSecretStore) listRepositories(ctx context.Context, cs *cli.Clients) ([]*v1alpha1.Repository, error) {
+	if s != nil {
+		return s.repositories.List(labels.Everything())
+	}
+	repositories, err := cs.PipelineAsCode.PipelinesascodeV1alpha1().Repositories("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]*v1alpha1.Repository, 0, len(repositories.Items))
+	for i := range repositories.Items {
+		ret = append(ret, &repositories.Items[i])
+	}
+	return ret, nil
+}
+
+// secretValue get the value of key in the ns/name Secret like getSecretValue,
+// the value got less than webhookSecretTTL ago is reused
+func (s *WebhookSecretStore) secretValue(ctx context.Context, cs *cli.Clients, ns, name, key string) (string, error) {
+	if s == nil {
+		return getSecretValue(ctx, cs, ns, name, key)
+	}
+	id := fmt.Sprintf("%s/%s/%s", ns, name, key)
+	s.mu.Lock()
+	stored, ok := s.values[id]
+	s.mu.Unlock()
+	if ok && s.now().Before(stored.expires) {
+		return stored.value, stored.err
+	}
+
+	value, err := getSecretValue(ctx, cs, ns, name, key)
+	now := s.now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// the Secrets of the Repository CRs deleted since are dropped with the
+	// other expired values
+	for storedID, stored := range s.values {
+		if !now.Before(stored.expires) {
+			delete(s.values, storedID)
+		}
+	}
+	s.values[id] = storedSecretValue{value: value, err: err, expires: now.Add(webhookSecretTTL)}
+	return value, err
+}
+
+// getSecretValue get the value of key in the ns/name Secret, it returns an
+// empty string if the Secret or the key doesn't exist
+func getSecretValue(ctx context.Context, cs *cli.Clients, ns, name, key string) (string, error) {
+	if key == "" {
+		key = defaultWebhookSecretKey
+	}
+	secret, err := cs.Kube.CoreV1().Secrets(ns).Get(ctx, name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(secret.Data[key]), nil
+}
+
+// webhookSecret is a webhook secret configured on a Repository CR, or the
+// install wide one when repo is nil
+type webhookSecret struct {
+	value string
+	repo  *v1alpha1.Repository
+}
+
+// webhookSecrets get all the webhook secrets configured, the install wide one
+// and the ones of the Repository CRs. None of them depend on the payload so it
+// can be verified before anything in it is trusted. The secret of a Repository
+// we cannot read is returned empty, it is still expected.
+func webhookSecrets(ctx context.Context, cs *cli.Clients, opts *Options) ([]webhookSecret, error) {
+	secrets := []webhookSecret{}
+	if opts.WebhookSecretName != "" {
+		value, err := opts.WebhookSecrets.secretValue(ctx, cs, opts.WebhookSecretNamespace, opts.WebhookSecretName, "")
+		if err != nil {
+			return nil, err
+		}
+		if value != "" {
+			secrets = append(secrets, webhookSecret{value: value})
+		}
+	}
+
+	repositories, err := opts.WebhookSecrets.listRepositories(ctx, cs)
+	if err != nil {
+		return nil, err
+	}
+	for _, repo := range repositories {
+		if repo.Spec.WebhookSecret == nil {
+			continue
+		}
+		value, err := opts.WebhookSecrets.secretValue(ctx, cs, repo.Namespace, repo.Spec.WebhookSecret.Name, repo.Spec.WebhookSecret.Key)
+		if err != nil {
+			cs.Log.Warnf("Cannot get the webhook secret %s/%s of the repository %s: %s",
+				repo.Namespace, repo.Spec.WebhookSecret.Name, repo.Name, err.Error())
+		} else if value == "" {
+			cs.Log.Warnf("Cannot find the webhook secret %s/%s of the repository %s",
+				repo.Namespace, repo.Spec.WebhookSecret.Name, repo.Name)
+		}
+		secrets = append(secrets, webhookSecret{value: value, repo: repo})
+	}
+	return secrets, nil
+}
+
+// WebhookVerification is the result of the verification of a payload signature
+type WebhookVerification struct {
+	// Repository is the Repository CR with the webhook secret the payload
+	// is signed with, nil for the install wide webhook secret or when there
+	// is no webhook secret configured
+	Repository *v1alpha1.Repository
+	// Skipped is true when there is no webhook secret configured to verify
+	// the payload with
+	Skipped bool
+}
+
+// VerifyWebhookSignature verify the payload signature against the configured
+// webhook secrets, it has to be called before anything is done with the
+// payload. Once a webhook secret is configured, install wide or on a
+// Repository, every payload has to be signed with one of them, the
+// verification is only skipped when there is none.
+func VerifyWebhookSignature(ctx context.Context, cs *cli.Clients, opts *Options, payload []byte) (*WebhookVerification, error) {
+	secrets, err := webhookSecrets(ctx, cs, opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(secrets) == 0 {
+		cs.Log.Debug("No webhook secret configured, skipping the payload signature verification")
+		return &WebhookVerification{Skipped: true}, nil
+	}
+
+	err = fmt.Errorf("none of the configured webhook secrets can be found")
+	for _, secret := range secrets {
+		if secret.value == "" {
+			continue
+		}
+		if err = cs.VCSClient.ValidateWebhook(secret.value, opts.PayloadSignature, payload); err == nil {
+			return &WebhookVerification{Repository: secret.repo}, nil
+		}
+	}
+	cs.Log.Errorf("Rejecting the %s event payload: %s", opts.RunInfo.EventType, err.Error())
+	return nil, fmt.Errorf("webhook payload rejected: %w", err)
+}
+
+// CheckRepository check the payload has been signed with a webhook secret
+// accepted by the runinfo repository: a repository with its own webhook secret
+// only accepts that one and the webhook secret of a repository is not accepted
+// for the other ones.
+func (v *WebhookVerification) CheckRepository(ctx context.Context, cs *cli.Clients, runinfo *webvcs.RunInfo) error {
+	repo, err := config.GetRepoByCR(ctx, cs, "", runinfo)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case repo != nil && repo.Spec.WebhookSecret != nil:
+		if v.Repository == nil || v.Repository.Namespace != repo.Namespace ||
+			*v.Repository.Spec.WebhookSecret != *repo.Spec.WebhookSecret {
+			return fmt.Errorf("webhook payload rejected: %s/%s is not signed with the webhook secret %s/%s of the repository %s",
+				runinfo.Owner, runinfo.Repository, repo.Namespace, repo.Spec.WebhookSecret.Name, repo.Name)
+		}
+	case v.Repository != nil:
+		return fmt.Errorf("webhook payload rejected: %s/%s is signed with the webhook secret of the repository %s/%s",
+			runinfo.Owner, runinfo.Repository, v.Repository.Namespace, v.Repository.Name)
+	}
+	return nil
+}
@@ -0,0 +1,255 @@
+package pipelineascode
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/openshift-pipelines/pipelines-as-code/pkg/apis/pipelinesascode/v1alpha1"
+	"github.com/openshift-pipelines/pipelines-as-code/pkg/cli"
+	testclient "github.com/openshift-pipelines/pipelines-as-code/pkg/test/clients"
+	"github.com/openshift-pipelines/pipelines-as-code/pkg/test/repository"
+	"github.com/openshift-pipelines/pipelines-as-code/pkg/webvcs"
+	"go.uber.org/zap"
+	zapobserver "go.uber.org/zap/zaptest/observer"
+	"gotest.tools/v3/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	rtesting "knative.dev/pkg/reconciler/testing"
+)
+
+func sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifyWebhookSignature(t *testing.T) {
+	payload := []byte(`{"hello": "moto"}`)
+	repoWithSecret := repository.NewRepo("repo", "https://forge/owner/withsecret", "main", "ns", "ns", "pull_request")
+	repoWithSecret.Spec.WebhookSecret = &v1alpha1.Secret{Name: "repo-secret", Key: "token"}
+	repoWithMissingSecret := repository.NewRepo("missing", "https://forge/owner/missing", "main", "ns", "ns", "pull_request")
+	repoWithMissingSecret.Spec.WebhookSecret = &v1alpha1.Secret{Name: "nothere"}
+	allRepositories := []*v1alpha1.Repository{repoWithSecret, repoWithMissingSecret}
+
+	tests := []struct {
+		name         string
+		vcs          webvcs.Interface
+		repositories []*v1alpha1.Repository
+		url          string
+		secretName   string
+		signature    string
+		wantErr      string
+		wantLog      string
+	}{
+		{
+			name:         "install secret",
+			repositories: allRepositories,
+			url:          "https://forge/owner/repo",
+			secretName:   "install-secret",
+			signature:    sign("installsecret", payload),
+		},
+		{
+			name:         "install secret bad signature",
+			repositories: allRepositories,
+			url:          "https://forge/owner/repo",
+			secretName:   "install-secret",
+			signature:    sign("badsecret", payload),
+			wantErr:      "webhook payload rejected: payload signature does not match",
+			wantLog:      "Rejecting the pull_request event payload",
+		},
+		{
+			name:         "install secret no signature",
+			repositories: allRepositories,
+			url:          "https://forge/owner/repo",
+			secretName:   "install-secret",
+			wantErr:      "webhook payload rejected: payload has no signature",
+		},
+		{
+			name: "no secret configured",
+			url:  "https://forge/owner/repo",
+		},
+		{
+			name:       "install secret not created",
+			url:        "https://forge/owner/repo",
+			secretName: "nothere",
+		},
+		{
+			name:         "repository secret overrides the install secret",
+			repositories: allRepositories,
+			url:          "https://forge/owner/withsecret",
+			secretName:   "install-secret",
+			signature:    sign("reposecret", payload),
+		},
+		{
+			name:         "repository secret required",
+			repositories: allRepositories,
+			url:          "https://forge/owner/withsecret",
+			secretName:   "install-secret",
+			signature:    sign("installsecret", payload),
+			wantErr:      "owner/repo is not signed with the webhook secret ns/repo-secret of the repository repo",
+		},
+		{
+			name:         "repository secret used for another repository",
+			repositories: allRepositories,
+			url:          "https://forge/owner/repo",
+			secretName:   "install-secret",
+			signature:    sign("reposecret", payload),
+			wantErr:      "owner/repo is signed with the webhook secret of the repository ns/repo",
+		},
+		{
+			name:         "repository secret expected for unsigned payloads of other repositories",
+			repositories: []*v1alpha1.Repository{repoWithSecret},
+			url:          "https://forge/owner/repo",
+			wantErr:      "webhook payload rejected: payload has no signature",
+		},
+		{
+			name:         "repository secret missing",
+			repositories: []*v1alpha1.Repository{repoWithMissingSecret},
+			url:          "https://forge/owner/missing",
+			signature:    sign("installsecret", payload),
+			wantErr:      "none of the configured webhook secrets can be found",
+			wantLog:      "Cannot find the webhook secret ns/nothere of the repository missing",
+		},
+		{
+			name:       "gitlab token",
+			vcs:        webvcs.GitlabVCS{},
+			url:        "https://forge/owner/repo",
+			secretName: "install-secret",
+			signature:  "installsecret",
+		},
+		{
+			name:       "gitlab bad token",
+			vcs:        webvcs.GitlabVCS{},
+			url:        "https://forge/owner/repo",
+			secretName: "install-secret",
+			signature:  sign("installsecret", payload),
+			wantErr:    "payload token does not match the webhook secret",
+		},
+	}
+	for _, tt := range tests {
+		for _, withStore := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s/store=%t", tt.name, withStore), func(t *testing.T) {
+				ctx, _ := rtesting.SetupFakeContext(t)
+				stdata, informers := testclient.SeedTestData(t, ctx, testclient.Data{
+					Repositories: tt.repositories,
+					Secrets: []*corev1.Secret{
+						{
+							ObjectMeta: metav1.ObjectMeta{Name: "install-secret", Namespace: "pipelines-as-code"},
+							Data:       map[string][]byte{"webhook.secret": []byte("installsecret")},
+						},
+						{
+							ObjectMeta: metav1.ObjectMeta{Name: "repo-secret", Namespace: "ns"},
+							Data:       map[string][]byte{"token": []byte("reposecret")},
+						},
+					},
+				})
+				observer, log := zapobserver.New(zap.InfoLevel)
+				vcs := tt.vcs
+				if vcs == nil {
+					vcs = webvcs.GithubVCS{}
+				}
+				cs := &cli.Clients{
+					PipelineAsCode: stdata.PipelineAsCode,
+					Kube:           stdata.Kube,
+					VCSClient:      vcs,
+					Log:            zap.New(observer).Sugar(),
+				}
+				opts := &Options{
+					RunInfo:                webvcs.RunInfo{EventType: "pull_request"},
+					PayloadSignature:       tt.signature,
+					WebhookSecretName:      tt.secretName,
+					WebhookSecretNamespace: "pipelines-as-code",
+				}
+				if withStore {
+					opts.WebhookSecrets = NewWebhookSecretStore(informers.Repository.Lister())
+				}
+				runinfo := &webvcs.RunInfo{
+					Owner:      "owner",
+					Repository: "repo",
+					URL:        tt.url,
+					EventType:  "pull_request",
+					BaseBranch: "main",
+					Sender:     "sender",
+				}
+
+				verification, err := VerifyWebhookSignature(ctx, cs, opts, payload)
+				if err == nil {
+					err = verification.CheckRepository(ctx, cs, runinfo)
+				}
+				if tt.wantErr != "" {
+					assert.ErrorContains(t, err, tt.wantErr)
+				} else {
+					assert.NilError(t, err)
+				}
+				if tt.wantLog != "" {
+					assert.Assert(t, log.FilterMessageSnippet(tt.wantLog).Len() == 1, log.All())
+				}
+			})
+		}
+	}
+}
+
+func TestWebhookSecretStore(t *testing.T) {
+	payload := []byte(`{"hello": "moto"}`)
+	repo := repository.NewRepo("repo", "https://forge/owner/withsecret", "main", "ns", "ns", "pull_request")
+	repo.Spec.WebhookSecret = &v1alpha1.Secret{Name: "repo-secret", Key: "token"}
+	ctx, _ := rtesting.SetupFakeContext(t)
+	stdata, informers := testclient.SeedTestData(t, ctx, testclient.Data{
+		Repositories: []*v1alpha1.Repository{repo},
+		Secrets: []*corev1.Secret{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "install-secret", Namespace: "pipelines-as-code"},
+				Data:       map[string][]byte{"webhook.secret": []byte("installsecret")},
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "repo-secret", Namespace: "ns"},
+				Data:       map[string][]byte{"token": []byte("reposecret")},
+			},
+		},
+	})
+	cs := &cli.Clients{
+		PipelineAsCode: stdata.PipelineAsCode,
+		Kube:           stdata.Kube,
+		VCSClient:      webvcs.GithubVCS{},
+		Log:            zap.NewNop().Sugar(),
+	}
+	now := time.Now()
+	store := NewWebhookSecretStore(informers.Repository.Lister())
+	store.now = func() time.Time { return now }
+	opts := &Options{
+		RunInfo:                webvcs.RunInfo{EventType: "pull_request"},
+		PayloadSignature:       sign("badsecret", payload),
+		WebhookSecretName:      "install-secret",
+		WebhookSecretNamespace: "pipelines-as-code",
+		WebhookSecrets:         store,
+	}
+	stdata.Kube.ClearActions()
+	stdata.PipelineAsCode.ClearActions()
+
+	// the unauthenticated payloads don't get the Secrets again
+	for i := 0; i < 3; i++ {
+		_, err := VerifyWebhookSignature(ctx, cs, opts, payload)
+		assert.ErrorContains(t, err, "payload signature does not match")
+	}
+	assert.Equal(t, len(stdata.Kube.Actions()), 2)
+	assert.Equal(t, len(stdata.PipelineAsCode.Actions()), 0)
+
+	// a rotated secret is picked up once the value has expired
+	secret, err := stdata.Kube.CoreV1().Secrets("ns").Get(ctx, "repo-secret", metav1.GetOptions{})
+	assert.NilError(t, err)
+	secret.Data["token"] = []byte("rotated")
+	_, err = stdata.Kube.CoreV1().Secrets("ns").Update(ctx, secret, metav1.UpdateOptions{})
+	assert.NilError(t, err)
+	opts.PayloadSignature = sign("rotated", payload)
+	_, err = VerifyWebhookSignature(ctx, cs, opts, payload)
+	assert.ErrorContains(t, err, "payload signature does not match")
+
+	now = now.Add(webhookSecretTTL)
+	verification, err := VerifyWebhookSignature(ctx, cs, opts, payload)
+	assert.NilError(t, err)
+	assert.Equal(t, verification.Repository.Name, "repo")
+}
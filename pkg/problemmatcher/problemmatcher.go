@@ -0,0 +1,162 @@
+package problemmatcher
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// The severities of a problem
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+	SeverityNotice  = "notice"
+)
+
+// ansiEscapeRegexp match the colors and the other terminal escape sequences
+// of a log
+var ansiEscapeRegexp = regexp.MustCompile(`\x1b\[[0-9;?]*[a-zA-Z]`)
+
+// workspacePathRegexp match the directory where Tekton mounts a workspace by
+// default, the repository is usually checked out at its root
+var workspacePathRegexp = regexp.MustCompile(`^/workspace/[^/]+/`)
+
+// Matcher is a regexp applied on each line of a log to find the problems it
+// reports, File, Line, Column and Message are the index of the regexp groups
+// with those parts of the problem. Root is the directory where the repository
+// is checked out, stripped from the absolute file paths, the root of the
+// workspace it is in by default.
+type Matcher struct {
+	Owner    string `json:"owner"`
+	Regexp   string `json:"regexp"`
+	Severity string `json:"severity,omitempty"`
+	File     int    `json:"file"`
+	Line     int    `json:"line,omitempty"`
+	Column   int    `json:"column,omitempty"`
+	Message  int    `json:"message"`
+	Root     string `json:"root,omitempty"`
+
+	regexp *regexp.Regexp
+}
+
+// Problem is a log line matched by a Matcher
+type Problem struct {
+	Owner    string
+	Severity string
+	File     string
+	Line     int
+	Column   int
+	Message  string
+	// Text is the whole log line
+	Text string
+}
+
+// Parse the matchers of an annotation, a YAML or JSON list of matchers :
+//
+//   - owner: go
+//     regexp: '^([^:\s]+):(\d+):(\d+): (.*)$'
+//     file: 1
+//     line: 2
+//     column: 3
+//     message: 4
+//
+// the severity of the problems is error unless the matcher sets another one,
+// the file paths are made relative to the root of the repository
+func Parse(annotation string) ([]*Matcher, error) {
+	matchers := []*Matcher{}
+	if err := yaml.Unmarshal([]byte(annotation), &matchers); err != nil {
+		return nil, fmt.Errorf("cannot parse the problem matchers: %w", err)
+	}
+	for i, matcher := range matchers {
+		if err := matcher.compile(); err != nil {
+			return nil, fmt.Errorf("problem matcher %d %s: %w", i+1, matcher.Owner, err)
+		}
+	}
+	return matchers, nil
+}
+
+func (m *Matcher) compile() error {
+	if m.Owner == "" {
+		return fmt.Errorf("the owner is missing")
+	}
+	switch m.Severity {
+	case "":
+		m.Severity = SeverityError
+	case SeverityError, SeverityWarning, SeverityNotice:
+	default:
+		return fmt.Errorf("unknown severity %s", m.Severity)
+	}
+
+	reg, err := regexp.Compile(m.Regexp)
+	if err != nil {
+		return err
+	}
+	for _, group := range []struct {
+		name  string
+		index int
+	}{{"file", m.File}, {"line", m.Line}, {"column", m.Column}, {"message", m.Message}} {
+		if group.index < 0 || group.index > reg.NumSubexp() {
+			return fmt.Errorf("the %s group %d is not in the regexp", group.name, group.index)
+		}
+	}
+	if m.File == 0 || m.Message == 0 {
+		return fmt.Errorf("the file and the message groups are mandatory")
+	}
+	m.regexp = reg
+	if m.Root != "" {
+		m.Root = strings.TrimSuffix(m.Root, "/") + "/"
+	}
+	return nil
+}
+
+// relativePath get the path of a file relative to the root of the repository,
+// the paths are absolute when the tools are given the absolute path of the
+// directory to check
+func (m *Matcher) relativePath(file string) string {
+	if m.Root != "" && strings.HasPrefix(file, m.Root) {
+		return strings.TrimPrefix(file, m.Root)
+	}
+	file = workspacePathRegexp.ReplaceAllString(file, "")
+	return strings.TrimPrefix(file, "./")
+}
+
+// match a log line, it returns nil if it doesn't report a problem
+func (m *Matcher) match(line string) *Problem {
+	groups := m.regexp.FindStringSubmatch(line)
+	if groups == nil {
+		return nil
+	}
+	problem := &Problem{
+		Owner:    m.Owner,
+		Severity: m.Severity,
+		File:     m.relativePath(groups[m.File]),
+		Message:  groups[m.Message],
+		Text:     line,
+	}
+	if m.Line != 0 {
+		problem.Line, _ = strconv.Atoi(groups[m.Line])
+	}
+	if m.Column != 0 {
+		problem.Column, _ = strconv.Atoi(groups[m.Column])
+	}
+	return problem
+}
+
+// Match the lines of a log with the matchers, a line is reported by the first
+// matcher matching it
+func Match(matchers []*Matcher, log string) []Problem {
+	problems := []Problem{}
+	for _, line := range strings.Split(log, "\n") {
+		line = strings.TrimRight(ansiEscapeRegexp.ReplaceAllString(line, ""), "\r")
+		for _, matcher := range matchers {
+			if problem := matcher.match(line); problem != nil {
+				problems = append(problems, *problem)
+				break
+			}
+		}
+	}
+	return problems
+}
@@ -0,0 +1,165 @@
+package problemmatcher
+
+import (
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+const goMatcher = `
+- owner: go
+  regexp: '^([^:\s]+):(\d+):(\d+): (.*)$'
+  file: 1
+  line: 2
+  column: 3
+  message: 4
+`
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name       string
+		annotation string
+		wantOwners []string
+		wantErr    string
+	}{
+		{
+			name:       "yaml",
+			annotation: goMatcher,
+			wantOwners: []string{"go"},
+		},
+		{
+			name:       "no message group",
+			annotation: `[{"owner": "shellcheck", "regexp": "^In (.+) line (\\d+):$", "file": 1, "line": 2, "message": 0}, {"owner": "eslint", "regexp": "^(.+): (.+)$", "file": 1, "message": 2, "severity": "warning"}]`,
+			wantErr:    "problem matcher 1 shellcheck: the file and the message groups are mandatory",
+		},
+		{
+			name:       "several matchers",
+			annotation: `[{"owner": "go", "regexp": "^(.+):(\\d+): (.+)$", "file": 1, "line": 2, "message": 3}, {"owner": "eslint", "regexp": "^(.+): (.+)$", "file": 1, "message": 2, "severity": "warning"}]`,
+			wantOwners: []string{"go", "eslint"},
+		},
+		{
+			name:       "not a list",
+			annotation: "owner: go",
+			wantErr:    "cannot parse the problem matchers",
+		},
+		{
+			name:       "no owner",
+			annotation: `[{"regexp": "^(.+): (.+)$", "file": 1, "message": 2}]`,
+			wantErr:    "problem matcher 1 : the owner is missing",
+		},
+		{
+			name:       "bad regexp",
+			annotation: `[{"owner": "go", "regexp": "^(.+: (.+)$", "file": 1, "message": 2}]`,
+			wantErr:    "problem matcher 1 go: error parsing regexp",
+		},
+		{
+			name:       "group not in the regexp",
+			annotation: `[{"owner": "go", "regexp": "^(.+): (.+)$", "file": 1, "line": 3, "message": 2}]`,
+			wantErr:    "problem matcher 1 go: the line group 3 is not in the regexp",
+		},
+		{
+			name:       "unknown severity",
+			annotation: `[{"owner": "go", "regexp": "^(.+): (.+)$", "file": 1, "message": 2, "severity": "fatal"}]`,
+			wantErr:    "problem matcher 1 go: unknown severity fatal",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matchers, err := Parse(tt.annotation)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			owners := []string{}
+			for _, matcher := range matchers {
+				owners = append(owners, matcher.Owner)
+			}
+			assert.DeepEqual(t, owners, tt.wantOwners)
+		})
+	}
+}
+
+func TestMatch(t *testing.T) {
+	matchers, err := Parse(goMatcher + `
+- owner: lint
+  regexp: '^(\S+): (.*)$'
+  severity: warning
+  file: 1
+  message: 2
+`)
+	assert.NilError(t, err)
+
+	log := "go: downloading github.com/google/go-cmp v0.5.5\n" +
+		"# github.com/linda/project/pkg/foo\r\n" +
+		"\x1b[31m./pkg/foo/foo.go:12:3: undefined: bar\x1b[0m\r\n" +
+		"pkg/foo/foo.go:14:1: missing return\n" +
+		"README.md: line too long\n" +
+		"FAIL\n"
+	assert.DeepEqual(t, Match(matchers, log), []Problem{
+		{
+			Owner: "lint", Severity: SeverityWarning, File: "go", Message: "downloading github.com/google/go-cmp v0.5.5",
+			Text: "go: downloading github.com/google/go-cmp v0.5.5",
+		},
+		{
+			Owner: "go", Severity: SeverityError, File: "pkg/foo/foo.go", Line: 12, Column: 3, Message: "undefined: bar",
+			Text: "./pkg/foo/foo.go:12:3: undefined: bar",
+		},
+		{
+			Owner: "go", Severity: SeverityError, File: "pkg/foo/foo.go", Line: 14, Column: 1, Message: "missing return",
+			Text: "pkg/foo/foo.go:14:1: missing return",
+		},
+		{
+			Owner: "lint", Severity: SeverityWarning, File: "README.md", Message: "line too long",
+			Text: "README.md: line too long",
+		},
+	})
+	assert.DeepEqual(t, Match(matchers, "PASS\n"), []Problem{})
+}
+
+func TestMatchRelativePath(t *testing.T) {
+	tests := []struct {
+		name     string
+		root     string
+		line     string
+		wantFile string
+	}{
+		{
+			name:     "relative",
+			line:     "pkg/foo.go:1:1: nope",
+			wantFile: "pkg/foo.go",
+		},
+		{
+			name:     "in the workspace",
+			line:     "/workspace/source/pkg/foo.go:1:1: nope",
+			wantFile: "pkg/foo.go",
+		},
+		{
+			name:     "in a root",
+			root:     "/workspace/source/repo/",
+			line:     "/workspace/source/repo/pkg/foo.go:1:1: nope",
+			wantFile: "pkg/foo.go",
+		},
+		{
+			name:     "in a root without a trailing slash",
+			root:     "/src",
+			line:     "/src/pkg/foo.go:1:1: nope",
+			wantFile: "pkg/foo.go",
+		},
+		{
+			name:     "in the workspace but not in the root",
+			root:     "/src",
+			line:     "/workspace/source/pkg/foo.go:1:1: nope",
+			wantFile: "pkg/foo.go",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matchers, err := Parse(goMatcher + "  root: " + tt.root + "\n")
+			assert.NilError(t, err)
+			problems := Match(matchers, tt.line)
+			assert.Equal(t, len(problems), 1)
+			assert.Equal(t, problems[0].File, tt.wantFile)
+		})
+	}
+}
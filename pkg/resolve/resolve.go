@@ -2,10 +2,12 @@ package resolve
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"strings"
 
+	"github.com/openshift-pipelines/pipelines-as-code/pkg/cache"
 	"github.com/openshift-pipelines/pipelines-as-code/pkg/cli"
 	"github.com/openshift-pipelines/pipelines-as-code/pkg/config"
 	"github.com/openshift-pipelines/pipelines-as-code/pkg/webvcs"
@@ -99,23 +101,62 @@ type Opts struct {
 	SkipInlining []string // task to skip inlining
 }
 
+// cacheKey is the key of the PipelineRuns resolved from data, the remote tasks
+// inside the repository are at the commit of runinfo so it is part of the key
+func cacheKey(runinfo *webvcs.RunInfo, data string, ropt *Opts) string {
+	return cache.Digest([]byte(runinfo.URL), []byte{0}, []byte(runinfo.SHA), []byte{0},
+		[]byte(fmt.Sprintf("%t/%t/%s", ropt.GenerateName, ropt.RemoteTasks, strings.Join(ropt.SkipInlining, ","))),
+		[]byte{0}, []byte(data))
+}
+
 // Resolve gets a large string which is a yaml multi documents containing
 // Pipeline/PipelineRuns/Tasks and resolve them inline as a single PipelineRun
 // generateName can be set as True to set the name as a generateName + "-" for
 // unique pipelinerun
+//
+// The PipelineRuns resolved for a commit are cached, so the remote tasks are
+// not fetched again when the same templates are resolved another time, unless
+// one of them comes from an URL or is the latest version of a hub task since
+// those can change without a new commit.
 func Resolve(ctx context.Context, cs *cli.Clients, runinfo *webvcs.RunInfo, data string, ropt *Opts) (
 	[]*tektonv1beta1.PipelineRun, error) {
+	key := cacheKey(runinfo, data, ropt)
+	if cached, ok := cs.Cache.Get(cache.PipelineRunKind, key); ok {
+		pipelineRuns := []*tektonv1beta1.PipelineRun{}
+		if err := json.Unmarshal(cached, &pipelineRuns); err == nil {
+			return pipelineRuns, nil
+		}
+	}
+
+	pipelineRuns, mutable, err := resolve(ctx, cs, runinfo, data, ropt)
+	if err != nil {
+		return pipelineRuns, err
+	}
+	if mutable {
+		return pipelineRuns, nil
+	}
+	if encoded, err := json.Marshal(pipelineRuns); err == nil {
+		cs.Cache.Set(cache.PipelineRunKind, key, encoded)
+	}
+	return pipelineRuns, nil
+}
+
+// resolve resolves the PipelineRuns of data and tells if they use a remote task
+// which can change without a new commit
+func resolve(ctx context.Context, cs *cli.Clients, runinfo *webvcs.RunInfo, data string, ropt *Opts) (
+	[]*tektonv1beta1.PipelineRun, bool, error) {
 	s := k8scheme.Scheme
 	if err := tektonv1beta1.AddToScheme(s); err != nil {
-		return []*tektonv1beta1.PipelineRun{}, err
+		return []*tektonv1beta1.PipelineRun{}, false, err
 	}
 
 	types := readTypes(cs, data)
 	if len(types.PipelineRuns) == 0 {
-		return []*tektonv1beta1.PipelineRun{}, errors.New("we need at least one pipelinerun to start with")
+		return []*tektonv1beta1.PipelineRun{}, false, errors.New("we need at least one pipelinerun to start with")
 	}
 
 	// First resolve Annotations Tasks
+	mutable := false
 	for _, pipelinerun := range types.PipelineRuns {
 		if ropt.RemoteTasks && pipelinerun.GetObjectMeta().GetAnnotations() != nil {
 			rt := config.RemoteTasks{
@@ -124,8 +165,9 @@ func Resolve(ctx context.Context, cs *cli.Clients, runinfo *webvcs.RunInfo, data
 			}
 			remoteTasks, err := rt.GetTaskFromAnnotations(ctx, pipelinerun.GetObjectMeta().GetAnnotations())
 			if err != nil {
-				return []*tektonv1beta1.PipelineRun{}, err
+				return []*tektonv1beta1.PipelineRun{}, false, err
 			}
+			mutable = mutable || config.HasMutableTasks(pipelinerun.GetObjectMeta().GetAnnotations())
 			// Merge remote tasks with local tasks
 			types.Tasks = append(types.Tasks, remoteTasks...)
 		}
@@ -135,13 +177,13 @@ func Resolve(ctx context.Context, cs *cli.Clients, runinfo *webvcs.RunInfo, data
 	for _, pipeline := range types.Pipelines {
 		pipelineTasks, err := inlineTasks(pipeline.Spec.Tasks, ropt, types)
 		if err != nil {
-			return nil, err
+			return nil, false, err
 		}
 		pipeline.Spec.Tasks = pipelineTasks
 
 		finallyTasks, err := inlineTasks(pipeline.Spec.Finally, ropt, types)
 		if err != nil {
-			return nil, err
+			return nil, false, err
 		}
 		pipeline.Spec.Finally = finallyTasks
 	}
@@ -151,13 +193,13 @@ func Resolve(ctx context.Context, cs *cli.Clients, runinfo *webvcs.RunInfo, data
 		if pipelinerun.Spec.PipelineSpec != nil {
 			truns, err := inlineTasks(pipelinerun.Spec.PipelineSpec.Tasks, ropt, types)
 			if err != nil {
-				return nil, err
+				return nil, false, err
 			}
 			pipelinerun.Spec.PipelineSpec.Tasks = truns
 
 			fruns, err := inlineTasks(pipelinerun.Spec.PipelineSpec.Finally, ropt, types)
 			if err != nil {
-				return nil, err
+				return nil, false, err
 			}
 			pipelinerun.Spec.PipelineSpec.Finally = fruns
 		}
@@ -166,7 +208,7 @@ func Resolve(ctx context.Context, cs *cli.Clients, runinfo *webvcs.RunInfo, data
 		if pipelinerun.Spec.PipelineRef != nil {
 			pipelineResolved, err := getPipelineByName(pipelinerun.Spec.PipelineRef.Name, types.Pipelines)
 			if err != nil {
-				return []*tektonv1beta1.PipelineRun{}, err
+				return []*tektonv1beta1.PipelineRun{}, false, err
 			}
 			pipelinerun.Spec.PipelineRef = nil
 			pipelinerun.Spec.PipelineSpec = &pipelineResolved.Spec
@@ -177,5 +219,5 @@ func Resolve(ctx context.Context, cs *cli.Clients, runinfo *webvcs.RunInfo, data
 			pipelinerun.ObjectMeta.Name = ""
 		}
 	}
-	return types.PipelineRuns, nil
+	return types.PipelineRuns, mutable, nil
 }
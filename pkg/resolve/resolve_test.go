@@ -1,13 +1,16 @@
 package resolve
 
 import (
+	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
 	"strings"
 	"testing"
 
+	"github.com/openshift-pipelines/pipelines-as-code/pkg/cache"
 	"github.com/openshift-pipelines/pipelines-as-code/pkg/cli"
+	httptesthelper "github.com/openshift-pipelines/pipelines-as-code/pkg/test/http"
 	"github.com/openshift-pipelines/pipelines-as-code/pkg/webvcs"
 	tektonv1beta1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
 	"go.uber.org/zap"
@@ -157,3 +160,72 @@ func TestIgnoreDocSpace(t *testing.T) {
 	_, _, err := readTDfile(t, "empty-spaces", false)
 	assert.NilError(t, err)
 }
+
+func TestResolveCache(t *testing.T) {
+	ctx, _ := rtesting.SetupFakeContext(t)
+	data, err := ioutil.ReadFile("testdata/pipelinerun-pipeline-task.yaml")
+	assert.NilError(t, err)
+	cs := &cli.Clients{
+		Log:   zap.NewNop().Sugar(),
+		Cache: cache.New(cache.DefaultMaxSize, ""),
+	}
+	runinfo := &webvcs.RunInfo{URL: "https://github.com/owner/repo", SHA: "sha1"}
+	ropt := &Opts{GenerateName: true}
+
+	first, err := Resolve(ctx, cs, runinfo, string(data), ropt)
+	assert.NilError(t, err)
+	first[0].Labels = map[string]string{"modified": "after"}
+
+	// the cached PipelineRuns are not affected by the changes on the returned ones
+	second, err := Resolve(ctx, cs, runinfo, string(data), ropt)
+	assert.NilError(t, err)
+	assert.Equal(t, len(second[0].Labels), 0)
+	assert.Equal(t, second[0].Spec.PipelineSpec.Tasks[0].TaskSpec.Steps[0].Name, "first-step")
+	assert.Assert(t, second[0].GenerateName != "")
+
+	// another commit is resolved again
+	_, err = Resolve(ctx, cs, &webvcs.RunInfo{URL: runinfo.URL, SHA: "sha2"}, string(data), ropt)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, cs.Cache.Stats(), map[string]cache.Stats{
+		cache.PipelineRunKind: {Hits: 1, Misses: 2},
+	})
+}
+
+func TestResolveNoCacheRemoteURLTask(t *testing.T) {
+	ctx, _ := rtesting.SetupFakeContext(t)
+	data, err := ioutil.ReadFile("testdata/pipelinerun-remote-task.yaml")
+	assert.NilError(t, err)
+	remoteTask := `---
+apiVersion: tekton.dev/v1beta1
+kind: Task
+metadata:
+  name: remote-task
+spec:
+  steps:
+    - name: %s
+      image: image`
+	cs := &cli.Clients{
+		Log:   zap.NewNop().Sugar(),
+		Cache: cache.New(cache.DefaultMaxSize, ""),
+		HTTPClient: *httptesthelper.MakeHTTPTestClient(t, map[string]map[string]string{
+			"https://remote.task": {"body": fmt.Sprintf(remoteTask, "first-step"), "code": "200"},
+		}),
+	}
+	runinfo := &webvcs.RunInfo{URL: "https://github.com/owner/repo", SHA: "sha1"}
+	ropt := &Opts{RemoteTasks: true}
+
+	first, err := Resolve(ctx, cs, runinfo, string(data), ropt)
+	assert.NilError(t, err)
+	assert.Equal(t, first[0].Spec.PipelineSpec.Tasks[0].TaskSpec.Steps[0].Name, "first-step")
+
+	// the task behind the URL has changed on the same commit
+	cs.HTTPClient = *httptesthelper.MakeHTTPTestClient(t, map[string]map[string]string{
+		"https://remote.task": {"body": fmt.Sprintf(remoteTask, "changed-step"), "code": "200"},
+	})
+	second, err := Resolve(ctx, cs, runinfo, string(data), ropt)
+	assert.NilError(t, err)
+	assert.Equal(t, second[0].Spec.PipelineSpec.Tasks[0].TaskSpec.Steps[0].Name, "changed-step")
+	assert.DeepEqual(t, cs.Cache.Stats(), map[string]cache.Stats{
+		cache.PipelineRunKind: {Misses: 2},
+	})
+}
@@ -0,0 +1,35 @@
+package bitbucketcloud
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+)
+
+const (
+	// bitbucketCloudAPIPath is the path where the Bitbucket Cloud API is served
+	bitbucketCloudAPIPath = "/2.0"
+)
+
+// SetupBBCloud Setup a Bitbucket Cloud httptest connexion, the mux handlers
+// are relative to the API path and the serverURL can be passed to
+// webvcs.NewBitbucketCloudVCS
+func SetupBBCloud() (mux *http.ServeMux, serverURL string, teardown func()) {
+	// mux is the HTTP request multiplexer used with the test server.
+	mux = http.NewServeMux()
+
+	apiHandler := http.NewServeMux()
+	apiHandler.Handle(bitbucketCloudAPIPath+"/", http.StripPrefix(bitbucketCloudAPIPath, mux))
+	apiHandler.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprintln(os.Stderr, "FAIL: Bitbucket Cloud API path prefix is not preserved in the request URL:")
+		fmt.Fprintln(os.Stderr)
+		fmt.Fprintln(os.Stderr, "\t"+req.URL.String())
+		http.Error(w, "Bitbucket Cloud API path prefix is not preserved in the request URL.", http.StatusInternalServerError)
+	})
+
+	// server is a test HTTP server used to provide mock API responses.
+	server := httptest.NewServer(apiHandler)
+
+	return mux, server.URL, server.Close
+}
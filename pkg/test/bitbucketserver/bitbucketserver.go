@@ -0,0 +1,20 @@
+package bitbucketserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+)
+
+// SetupBBServer Setup a Bitbucket Server httptest connexion, the mux handlers
+// are relative to the server root (ie: /rest/api/1.0/projects) since the REST
+// API and the build status API are served from different paths and the
+// serverURL can be passed to webvcs.NewBitbucketServerVCS
+func SetupBBServer() (mux *http.ServeMux, serverURL string, teardown func()) {
+	// mux is the HTTP request multiplexer used with the test server.
+	mux = http.NewServeMux()
+
+	// server is a test HTTP server used to provide mock API responses.
+	server := httptest.NewServer(mux)
+
+	return mux, server.URL, server.Close
+}
@@ -36,6 +36,7 @@ type Data struct {
 	PipelineRuns []*pipelinev1alpha1.PipelineRun
 	Repositories []*v1alpha1.Repository
 	Namespaces   []*corev1.Namespace
+	Secrets      []*corev1.Secret
 }
 
 // SeedTestData returns Clients and Informers populated with the
@@ -70,6 +71,12 @@ func SeedTestData(t *testing.T, ctx context.Context, d Data) (Clients, Informers
 		}
 	}
 
+	for _, secret := range d.Secrets {
+		if _, err := c.Kube.CoreV1().Secrets(secret.Namespace).Create(ctx, secret, metav1.CreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
 	c.PipelineAsCode.ClearActions()
 	return c, i
 }
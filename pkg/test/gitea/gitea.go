@@ -0,0 +1,34 @@
+package gitea
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+)
+
+const (
+	// giteaAPIPath is the path where the Gitea API is served
+	giteaAPIPath = "/api/v1"
+)
+
+// SetupGT Setup a Gitea httptest connexion, the mux handlers are relative to
+// the API path and the serverURL can be passed to webvcs.NewGiteaVCS
+func SetupGT() (mux *http.ServeMux, serverURL string, teardown func()) {
+	// mux is the HTTP request multiplexer used with the test server.
+	mux = http.NewServeMux()
+
+	apiHandler := http.NewServeMux()
+	apiHandler.Handle(giteaAPIPath+"/", http.StripPrefix(giteaAPIPath, mux))
+	apiHandler.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprintln(os.Stderr, "FAIL: Gitea API path prefix is not preserved in the request URL:")
+		fmt.Fprintln(os.Stderr)
+		fmt.Fprintln(os.Stderr, "\t"+req.URL.String())
+		http.Error(w, "Gitea API path prefix is not preserved in the request URL.", http.StatusInternalServerError)
+	})
+
+	// server is a test HTTP server used to provide mock API responses.
+	server := httptest.NewServer(apiHandler)
+
+	return mux, server.URL, server.Close
+}
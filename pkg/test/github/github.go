@@ -6,6 +6,7 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"strconv"
 
 	"github.com/google/go-github/v35/github"
 )
@@ -48,3 +49,26 @@ func SetupGH() (client *github.Client, mux *http.ServeMux, serverURL string, tea
 
 	return client, mux, server.URL, server.Close
 }
+
+// PaginatedHandler serve one page after the other according to the page query
+// parameter, setting the Link header to the next page like the GitHub API does
+func PaginatedHandler(pages ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		page := 1
+		if p := r.URL.Query().Get("page"); p != "" {
+			page, _ = strconv.Atoi(p)
+		}
+		if page < 1 || page > len(pages) {
+			http.Error(w, fmt.Sprintf("no page %d", page), http.StatusNotFound)
+			return
+		}
+		if page < len(pages) {
+			next := *r.URL
+			q := next.Query()
+			q.Set("page", strconv.Itoa(page+1))
+			next.RawQuery = q.Encode()
+			w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, next.String()))
+		}
+		fmt.Fprint(w, pages[page-1])
+	}
+}
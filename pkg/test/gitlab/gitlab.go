@@ -0,0 +1,34 @@
+package gitlab
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+)
+
+const (
+	// gitlabAPIPath is the path where the GitLab API is served
+	gitlabAPIPath = "/api/v4"
+)
+
+// SetupGL Setup a GitLab httptest connexion, the mux handlers are relative to
+// the API path and the serverURL can be passed to webvcs.NewGitlabVCS
+func SetupGL() (mux *http.ServeMux, serverURL string, teardown func()) {
+	// mux is the HTTP request multiplexer used with the test server.
+	mux = http.NewServeMux()
+
+	apiHandler := http.NewServeMux()
+	apiHandler.Handle(gitlabAPIPath+"/", http.StripPrefix(gitlabAPIPath, mux))
+	apiHandler.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprintln(os.Stderr, "FAIL: GitLab API path prefix is not preserved in the request URL:")
+		fmt.Fprintln(os.Stderr)
+		fmt.Fprintln(os.Stderr, "\t"+req.URL.String())
+		http.Error(w, "GitLab API path prefix is not preserved in the request URL.", http.StatusInternalServerError)
+	})
+
+	// server is a test HTTP server used to provide mock API responses.
+	server := httptest.NewServer(apiHandler)
+
+	return mux, server.URL, server.Close
+}
@@ -14,6 +14,8 @@ type KinterfaceTest struct {
 	ConsoleURL               string
 	NamespaceError           bool
 	ExpectedNumberofCleanups int
+	// PodLogs are the container logs by "pod/container"
+	PodLogs map[string]string
 }
 
 func (k *KinterfaceTest) GetConsoleUI(ctx context.Context, ns string, pr string) (string, error) {
@@ -31,9 +33,17 @@ func (k *KinterfaceTest) WaitForPipelineRunSucceed(ctx context.Context, tektonbe
 	return nil
 }
 
-func (k *KinterfaceTest) CleanupPipelines(ctx context.Context, namespace string, repoName string, maxKeep int) error {
+func (k *KinterfaceTest) CleanupPipelines(ctx context.Context, namespace, repoName, prName string, maxKeep int) error {
 	if k.ExpectedNumberofCleanups != maxKeep {
 		return fmt.Errorf("we wanted %d and we got %d", k.ExpectedNumberofCleanups, maxKeep)
 	}
 	return nil
 }
+
+func (k *KinterfaceTest) GetPodLogs(ctx context.Context, ns, pod, container string) (string, error) {
+	logs, ok := k.PodLogs[pod+"/"+container]
+	if !ok {
+		return "", fmt.Errorf("cannot find the logs of %s/%s", pod, container)
+	}
+	return logs, nil
+}
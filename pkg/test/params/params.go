@@ -27,6 +27,10 @@ func (p FakeParams) SetGitHubToken(string) {
 func (p FakeParams) SetGitHubAPIURL(string) {
 }
 
+// SetWebVCSType Set the Web VCS provider type
+func (p FakeParams) SetWebVCSType(string) {
+}
+
 func (p FakeParams) Clients() (*cli.Clients, error) {
 	return p.Fakeclients, nil
 }
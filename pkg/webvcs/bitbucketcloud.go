@@ -0,0 +1,735 @@
+package webvcs
+
+import (
+	"context"
+	"crypto/sha1" // nolint: gosec
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+const (
+	bitbucketCloudDefaultAPIURL = "https://api.bitbucket.org"
+	bitbucketCloudAPIPath       = "/2.0"
+
+	// bitbucketCloudMaxKeyLength is the maximum length of a build status key
+	bitbucketCloudMaxKeyLength = 40
+)
+
+var _ Interface = BitbucketCloudVCS{}
+
+type BitbucketCloudVCS struct {
+	rest restClient
+}
+
+type BitbucketCloudLinks struct {
+	HTML struct {
+		Href string `json:"href"`
+	} `json:"html"`
+}
+
+type BitbucketCloudUser struct {
+	Nickname    string `json:"nickname"`
+	DisplayName string `json:"display_name"`
+	AccountID   string `json:"account_id"`
+	UUID        string `json:"uuid"`
+}
+
+type BitbucketCloudRepository struct {
+	Name       string              `json:"name"`
+	FullName   string              `json:"full_name"`
+	Links      BitbucketCloudLinks `json:"links"`
+	MainBranch struct {
+		Name string `json:"name"`
+	} `json:"mainbranch"`
+}
+
+type BitbucketCloudCommit struct {
+	Hash    string              `json:"hash"`
+	Message string              `json:"message"`
+	Links   BitbucketCloudLinks `json:"links"`
+}
+
+type BitbucketCloudEndpoint struct {
+	Branch struct {
+		Name string `json:"name"`
+	} `json:"branch"`
+	Commit     BitbucketCloudCommit     `json:"commit"`
+	Repository BitbucketCloudRepository `json:"repository"`
+}
+
+type BitbucketCloudPullRequest struct {
+	ID          int                    `json:"id"`
+	Title       string                 `json:"title"`
+	State       string                 `json:"state"`
+	Author      BitbucketCloudUser     `json:"author"`
+	Source      BitbucketCloudEndpoint `json:"source"`
+	Destination BitbucketCloudEndpoint `json:"destination"`
+	Links       BitbucketCloudLinks    `json:"links"`
+}
+
+// BitbucketCloudPullRequestEvent a "pullrequest:created" or
+// "pullrequest:updated" webhook payload
+type BitbucketCloudPullRequestEvent struct {
+	Actor       BitbucketCloudUser        `json:"actor"`
+	Repository  BitbucketCloudRepository  `json:"repository"`
+	PullRequest BitbucketCloudPullRequest `json:"pullrequest"`
+}
+
+// BitbucketCloudPullRequestCommentEvent a "pullrequest:comment_created" webhook payload
+type BitbucketCloudPullRequestCommentEvent struct {
+	Actor       BitbucketCloudUser        `json:"actor"`
+	Repository  BitbucketCloudRepository  `json:"repository"`
+	PullRequest BitbucketCloudPullRequest `json:"pullrequest"`
+	Comment     bitbucketCloudComment     `json:"comment"`
+}
+
+// BitbucketCloudPushChange a ref change of a "repo:push" webhook payload,
+// New is null when the ref is deleted and Old when it is created
+type BitbucketCloudPushChange struct {
+	New *struct {
+		Type   string               `json:"type"`
+		Name   string               `json:"name"`
+		Target BitbucketCloudCommit `json:"target"`
+	} `json:"new"`
+	Old *struct {
+		Target BitbucketCloudCommit `json:"target"`
+	} `json:"old"`
+}
+
+// BitbucketCloudPushEvent a "repo:push" webhook payload
+type BitbucketCloudPushEvent struct {
+	Actor      BitbucketCloudUser       `json:"actor"`
+	Repository BitbucketCloudRepository `json:"repository"`
+	Push       struct {
+		Changes []BitbucketCloudPushChange `json:"changes"`
+	} `json:"push"`
+}
+
+// branchUpdates get the changes of the push creating or updating a branch,
+// the deleted branches and the tags are not run on
+func (e *BitbucketCloudPushEvent) branchUpdates() []BitbucketCloudPushChange {
+	changes := []BitbucketCloudPushChange{}
+	for _, change := range e.Push.Changes {
+		if change.New == nil || change.New.Type != "branch" {
+			continue
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+// bitbucketCloudActivity an entry of the activity of a pull request, Update is
+// only set for the updates of the pull request
+type bitbucketCloudActivity struct {
+	Update *struct {
+		Source BitbucketCloudEndpoint `json:"source"`
+	} `json:"update"`
+}
+
+type bitbucketCloudComment struct {
+	ID      int64 `json:"id"`
+	Deleted bool  `json:"deleted"`
+	Content struct {
+		Raw string `json:"raw"`
+	} `json:"content"`
+	User BitbucketCloudUser `json:"user"`
+}
+
+type bitbucketCloudSrcEntry struct {
+	Type string `json:"type"`
+	Path string `json:"path"`
+}
+
+type bitbucketCloudDiffStat struct {
+	Old *bitbucketCloudSrcEntry `json:"old"`
+	New *bitbucketCloudSrcEntry `json:"new"`
+}
+
+type bitbucketCloudMember struct {
+	User BitbucketCloudUser `json:"user"`
+}
+
+// NewBitbucketCloudVCS Create a new Bitbucket Cloud VCS object, token is
+// either a repository/workspace access token or a username:app_password
+func NewBitbucketCloudVCS(token string, apiURL string) BitbucketCloudVCS {
+	if apiURL == "" {
+		apiURL = bitbucketCloudDefaultAPIURL
+	}
+	if !strings.HasPrefix(apiURL, "http") {
+		apiURL = "https://" + apiURL
+	}
+	apiURL = strings.TrimSuffix(apiURL, "/")
+	if !strings.HasSuffix(apiURL, bitbucketCloudAPIPath) {
+		apiURL += bitbucketCloudAPIPath
+	}
+
+	auth := "Bearer " + token
+	if strings.Contains(token, ":") {
+		auth = "Basic " + base64.StdEncoding.EncodeToString([]byte(token))
+	}
+	return BitbucketCloudVCS{
+		rest: restClient{
+			BaseURL: apiURL,
+			Headers: map[string]string{"Authorization": auth},
+		},
+	}
+}
+
+// bitbucketCloudUserID get the identifier of a user we check the permissions
+// against, the nickname is not unique and can be changed by its user
+func bitbucketCloudUserID(user BitbucketCloudUser) string {
+	if user.AccountID != "" {
+		return user.AccountID
+	}
+	return user.UUID
+}
+
+// bitbucketCloudStatusKey get the key of the build status of an application,
+// a name too long for a key is replaced by its sha1 which is just as long
+func bitbucketCloudStatusKey(applicationName string) string {
+	if len(applicationName) <= bitbucketCloudMaxKeyLength {
+		return applicationName
+	}
+	return fmt.Sprintf("%x", sha1.Sum([]byte(applicationName))) // nolint: gosec
+}
+
+// paginate get all the pages of the Bitbucket Cloud list API at path,
+// following the next link of each page and passing its values to listPage.
+// It gives up after restMaxPages pages or when the context is cancelled.
+func (v BitbucketCloudVCS) paginate(ctx context.Context, path string, listPage func(values []byte) error) error {
+	next := path
+	for i := 0; i < restMaxPages; i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		page := struct {
+			Values json.RawMessage `json:"values"`
+			Next   string          `json:"next"`
+		}{}
+		if _, err := v.rest.do(ctx, http.MethodGet, next, nil, &page); err != nil {
+			return err
+		}
+		if len(page.Values) > 0 {
+			if err := listPage(page.Values); errors.Is(err, errPaginateDone) {
+				return nil
+			} else if err != nil {
+				return err
+			}
+		}
+		if page.Next == "" {
+			return nil
+		}
+		// the next page is a full URL
+		next = strings.TrimPrefix(page.Next, strings.TrimSuffix(v.rest.BaseURL, "/"))
+	}
+	return fmt.Errorf("giving up after listing %d pages of results", restMaxPages)
+}
+
+// bitbucketCloudRepoPath get the API path of the runinfo repository
+func bitbucketCloudRepoPath(runinfo *RunInfo) string {
+	return fmt.Sprintf("/repositories/%s/%s", url.PathEscape(runinfo.Owner), url.PathEscape(runinfo.Repository))
+}
+
+// WebhookSignature get the X-Hub-Signature signature of a raw webhook request
+func (v BitbucketCloudVCS) WebhookSignature(header http.Header) string {
+	return header.Get(bitbucketSignatureHeader)
+}
+
+// ValidateWebhook check the X-Hub-Signature signature of the payload against the webhook secret
+func (v BitbucketCloudVCS) ValidateWebhook(secret, signature string, payload []byte) error {
+	return ValidateSignature(secret, signature, payload)
+}
+
+// ParseEventType get the event type from the X-Event-Key header and the
+// trigger target of the events we want to run on
+func (v BitbucketCloudVCS) ParseEventType(header http.Header, payload []byte) (string, string) {
+	eventType := header.Get("X-Event-Key")
+	switch eventType {
+	case "pullrequest:created", "pullrequest:updated":
+		return eventType, TriggerTargetPullRequest
+	case "pullrequest:comment_created":
+		commentEvent := &BitbucketCloudPullRequestCommentEvent{}
+		if json.Unmarshal(payload, commentEvent) != nil {
+			return eventType, ""
+		}
+		return eventType, commentTriggerTarget(commentEvent.Comment.Content.Raw)
+	case "repo:push":
+		pushEvent := &BitbucketCloudPushEvent{}
+		if json.Unmarshal(payload, pushEvent) != nil || len(pushEvent.branchUpdates()) == 0 {
+			return eventType, ""
+		}
+		return eventType, TriggerTargetPush
+	}
+	return eventType, ""
+}
+
+// ParsePayload parse a Bitbucket Cloud webhook payload, eventType being the X-Event-Key header
+func (v BitbucketCloudVCS) ParsePayload(ctx context.Context, log *zap.SugaredLogger, eventType, triggerTarget, payload string) (*RunInfo, error) {
+	var runinfo RunInfo
+	var event interface{}
+	var err error
+	payload = payloadFix(payload)
+
+	switch eventType {
+	case "pullrequest:created", "pullrequest:updated":
+		prEvent := &BitbucketCloudPullRequestEvent{}
+		if err := json.Unmarshal([]byte(payload), prEvent); err != nil {
+			return &runinfo, err
+		}
+		runinfo, err = v.getPullRequest(ctx, prEvent.Repository, prEvent.PullRequest.ID)
+		if err != nil {
+			return &runinfo, err
+		}
+		if eventType == "pullrequest:updated" {
+			// an update keeping the source commit is a change of the title,
+			// the description or the reviewers, not a new commit to run on
+			changed, err := v.sourceCommitChanged(ctx, &runinfo)
+			if err != nil {
+				return &runinfo, err
+			}
+			runinfo.PullRequestAction = PullRequestActionSynchronize
+			if !changed {
+				runinfo.PullRequestAction = PullRequestActionEdited
+			}
+		}
+		event = prEvent
+	case "pullrequest:comment_created":
+		commentEvent := &BitbucketCloudPullRequestCommentEvent{}
+		if err := json.Unmarshal([]byte(payload), commentEvent); err != nil {
+			return &runinfo, err
+		}
+		log.Infof("PR recheck from comment on %s#%d has been requested", commentEvent.Repository.FullName,
+			commentEvent.PullRequest.ID)
+		runinfo, err = v.getPullRequest(ctx, commentEvent.Repository, commentEvent.PullRequest.ID)
+		if err != nil {
+			return &runinfo, err
+		}
+		setCommentCommand(&runinfo, commentEvent.Comment.Content.Raw, bitbucketCloudUserID(commentEvent.Comment.User))
+		event = commentEvent
+	case "repo:push":
+		pushEvent := &BitbucketCloudPushEvent{}
+		if err := json.Unmarshal([]byte(payload), pushEvent); err != nil {
+			return &runinfo, err
+		}
+		runinfo, err = v.getRepository(ctx, pushEvent.Repository)
+		if err != nil {
+			return &runinfo, err
+		}
+		for _, change := range pushEvent.branchUpdates() {
+			runinfo.SHA = change.New.Target.Hash
+			runinfo.BaseBranch = "refs/heads/" + change.New.Name
+		}
+		if runinfo.SHA == "" {
+			return &runinfo, fmt.Errorf("push event has no branch update")
+		}
+		runinfo.HeadBranch = runinfo.BaseBranch // in push events Head Branch is the same as Basebranch
+		runinfo.Sender = bitbucketCloudUserID(pushEvent.Actor)
+		runinfo.EventType = "push"
+		event = pushEvent
+	default:
+		return &runinfo, errors.New("this event is not supported")
+	}
+
+	err = v.populateCommitInfo(ctx, &runinfo)
+	if err != nil {
+		return nil, err
+	}
+
+	runinfo.Event = event
+	// a comment command has its own trigger target
+	if runinfo.TriggerTarget == "" {
+		runinfo.TriggerTarget = triggerTarget
+	}
+	return &runinfo, nil
+}
+
+// getRepository get the repository details missing from the webhook payload
+// like the main branch
+func (v BitbucketCloudVCS) getRepository(ctx context.Context, repository BitbucketCloudRepository) (RunInfo, error) {
+	runinfo := RunInfo{}
+	split := strings.SplitN(repository.FullName, "/", 2)
+	if len(split) != 2 {
+		return runinfo, fmt.Errorf("invalid repository full name: %s", repository.FullName)
+	}
+	runinfo.Owner, runinfo.Repository = split[0], split[1]
+
+	repo := &BitbucketCloudRepository{}
+	if _, err := v.rest.do(ctx, http.MethodGet, bitbucketCloudRepoPath(&runinfo), nil, repo); err != nil {
+		return runinfo, err
+	}
+	runinfo.URL = repo.Links.HTML.Href
+	runinfo.DefaultBranch = repo.MainBranch.Name
+	return runinfo, nil
+}
+
+// getPullRequest get a pull request details, repository is the destination
+// repository as set in the webhook
+func (v BitbucketCloudVCS) getPullRequest(ctx context.Context, repository BitbucketCloudRepository, prID int) (RunInfo, error) {
+	// Make sure to use the destination repository for the default branch and
+	// the URL or there would be a potential hijack from a fork
+	runinfo, err := v.getRepository(ctx, repository)
+	if err != nil {
+		return runinfo, err
+	}
+
+	pr := &BitbucketCloudPullRequest{}
+	if _, err := v.rest.do(ctx, http.MethodGet,
+		fmt.Sprintf("%s/pullrequests/%d", bitbucketCloudRepoPath(&runinfo), prID), nil, pr); err != nil {
+		return runinfo, err
+	}
+
+	runinfo.SHA = pr.Source.Commit.Hash
+	runinfo.Sender = bitbucketCloudUserID(pr.Author)
+	runinfo.HeadBranch = pr.Source.Branch.Name
+	runinfo.BaseBranch = pr.Destination.Branch.Name
+	runinfo.EventType = "pull_request"
+	runinfo.PullRequestNumber = prID
+	return runinfo, nil
+}
+
+// sourceCommitChanged tells if the last update of the runinfo pull request
+// changed its source commit, the activity of a pull request lists its updates
+// from the newest with the source commit of each
+func (v BitbucketCloudVCS) sourceCommitChanged(ctx context.Context, runinfo *RunInfo) (bool, error) {
+	hashes := []string{}
+	err := v.paginate(ctx, fmt.Sprintf("%s/pullrequests/%d/activity", bitbucketCloudRepoPath(runinfo),
+		runinfo.PullRequestNumber), func(values []byte) error {
+		activities := []bitbucketCloudActivity{}
+		if err := json.Unmarshal(values, &activities); err != nil {
+			return err
+		}
+		for _, activity := range activities {
+			if activity.Update != nil {
+				hashes = append(hashes, activity.Update.Source.Commit.Hash)
+			}
+			if len(hashes) == 2 {
+				return errPaginateDone
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	// the first update is the creation of the pull request
+	return len(hashes) < 2 || hashes[0] != hashes[1], nil
+}
+
+// populateCommitInfo get info on a commit in runinfo, the pull request
+// payloads only have the short hash so we set the full one from there
+func (v BitbucketCloudVCS) populateCommitInfo(ctx context.Context, runinfo *RunInfo) error {
+	commit := &BitbucketCloudCommit{}
+	if _, err := v.rest.do(ctx, http.MethodGet,
+		fmt.Sprintf("%s/commit/%s", bitbucketCloudRepoPath(runinfo), url.PathEscape(runinfo.SHA)), nil, commit); err != nil {
+		return err
+	}
+
+	runinfo.SHA = commit.Hash
+	runinfo.SHAURL = commit.Links.HTML.Href
+	runinfo.SHATitle = strings.Split(commit.Message, "\n")[0]
+	return nil
+}
+
+// CheckSenderOrgMembership check if the sender is a member of the workspace
+// owning the repository
+func (v BitbucketCloudVCS) CheckSenderOrgMembership(ctx context.Context, runinfo *RunInfo) (bool, error) {
+	members := []bitbucketCloudMember{}
+	err := v.paginate(ctx, fmt.Sprintf("/workspaces/%s/members?pagelen=100", url.PathEscape(runinfo.Owner)),
+		func(values []byte) error {
+			page := []bitbucketCloudMember{}
+			if err := json.Unmarshal(values, &page); err != nil {
+				return err
+			}
+			members = append(members, page...)
+			return nil
+		})
+	if isNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	for _, member := range members {
+		if bitbucketCloudUserID(member.User) == runinfo.Sender {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// GetStringPullRequestComment return the comments of a pull request matching the regexp
+func (v BitbucketCloudVCS) GetStringPullRequestComment(ctx context.Context, runinfo *RunInfo, reg string) ([]*Comment, error) {
+	var ret []*Comment
+	comments := []bitbucketCloudComment{}
+	if err := v.paginate(ctx,
+		fmt.Sprintf("%s/pullrequests/%d/comments?pagelen=100", bitbucketCloudRepoPath(runinfo), runinfo.PullRequestNumber),
+		func(values []byte) error {
+			page := []bitbucketCloudComment{}
+			if err := json.Unmarshal(values, &page); err != nil {
+				return err
+			}
+			comments = append(comments, page...)
+			return nil
+		}); err != nil {
+		return nil, err
+	}
+
+	re := regexp.MustCompile(reg)
+	for _, comment := range comments {
+		if comment.Deleted {
+			continue
+		}
+		if string(re.Find([]byte(comment.Content.Raw))) != "" {
+			ret = append(ret, &Comment{
+				ID:     comment.ID,
+				Sender: bitbucketCloudUserID(comment.User),
+				Body:   comment.Content.Raw,
+			})
+		}
+	}
+	return ret, nil
+}
+
+// GetTektonDir Get all yaml files from the tekton directory of a repository
+// as one multi document yaml string
+func (v BitbucketCloudVCS) GetTektonDir(ctx context.Context, dirPath string, runinfo *RunInfo) (string, error) {
+	filePaths, err := v.listFiles(ctx, dirPath, runinfo)
+	if isNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	var allTemplates string
+	for _, filePath := range filePaths {
+		if !(strings.HasSuffix(filePath, ".yaml") || strings.HasSuffix(filePath, ".yml")) {
+			continue
+		}
+		data, _, err := v.rest.raw(ctx, http.MethodGet,
+			fmt.Sprintf("%s/src/%s/%s", bitbucketCloudRepoPath(runinfo), url.PathEscape(runinfo.SHA), filePath), nil)
+		if err != nil {
+			return "", err
+		}
+		if allTemplates != "" && !strings.HasPrefix(string(data), "---") {
+			allTemplates += "---"
+		}
+		allTemplates += "\n" + string(data) + "\n"
+	}
+	return allTemplates, nil
+}
+
+// listFiles list the path of the files in dirPath and its subdirectories at
+// the runinfo SHA
+func (v BitbucketCloudVCS) listFiles(ctx context.Context, dirPath string, runinfo *RunInfo) ([]string, error) {
+	var filePaths, subDirs []string
+	err := v.paginate(ctx,
+		fmt.Sprintf("%s/src/%s/%s/?pagelen=100", bitbucketCloudRepoPath(runinfo), url.PathEscape(runinfo.SHA), dirPath),
+		func(values []byte) error {
+			page := []bitbucketCloudSrcEntry{}
+			if err := json.Unmarshal(values, &page); err != nil {
+				return err
+			}
+			for _, entry := range page {
+				switch entry.Type {
+				case "commit_file":
+					filePaths = append(filePaths, entry.Path)
+				case "commit_directory":
+					subDirs = append(subDirs, entry.Path)
+				}
+			}
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, subDir := range subDirs {
+		subDirFilePaths, err := v.listFiles(ctx, subDir, runinfo)
+		if err != nil {
+			return nil, err
+		}
+		filePaths = append(filePaths, subDirFilePaths...)
+	}
+	return filePaths, nil
+}
+
+// GetFileInsideRepo Get a file via the Bitbucket Cloud src API using the
+// runinfo information, if branch is true, use the branch as ref instead of the SHA
+func (v BitbucketCloudVCS) GetFileInsideRepo(ctx context.Context, filePath string, branch bool, runinfo *RunInfo) (string, error) {
+	ref := runinfo.SHA
+	if branch {
+		ref = runinfo.BaseBranch
+	}
+
+	data, _, err := v.rest.raw(ctx, http.MethodGet,
+		fmt.Sprintf("%s/src/%s/%s", bitbucketCloudRepoPath(runinfo), url.PathEscape(ref), filePath), nil)
+	if isNotFound(err) {
+		return "", fmt.Errorf("cannot find %s in this repository", filePath)
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// GetFileFromDefaultBranch will get a file directly from the main branch of the repository
+func (v BitbucketCloudVCS) GetFileFromDefaultBranch(ctx context.Context, filePath string, runinfo *RunInfo) (string, error) {
+	return getFileFromDefaultBranch(ctx, v, filePath, runinfo)
+}
+
+// GetBranchSHA get the SHA of the head commit of a branch
+func (v BitbucketCloudVCS) GetBranchSHA(ctx context.Context, branch string, runinfo *RunInfo) (string, error) {
+	ref := struct {
+		Target struct {
+			Hash string `json:"hash"`
+		} `json:"target"`
+	}{}
+	if _, err := v.rest.do(ctx, http.MethodGet,
+		fmt.Sprintf("%s/refs/branches/%s", bitbucketCloudRepoPath(runinfo), url.PathEscape(branch)), nil, &ref); err != nil {
+		return "", err
+	}
+	return ref.Target.Hash, nil
+}
+
+// GetFilesChanged get the files changed by the pull request or by the
+// commits of the push, a push creating a branch only has the files changed by
+// its head commit
+func (v BitbucketCloudVCS) GetFilesChanged(ctx context.Context, runinfo *RunInfo) ([]string, error) {
+	spec := url.PathEscape(runinfo.SHA)
+	if event, ok := runinfo.Event.(*BitbucketCloudPushEvent); ok {
+		for _, change := range event.branchUpdates() {
+			if change.Old != nil && strings.HasPrefix(runinfo.SHA, change.New.Target.Hash) {
+				spec += ".." + url.PathEscape(change.Old.Target.Hash)
+			}
+		}
+	}
+	diffstatPath := fmt.Sprintf("%s/diffstat/%s?pagelen=500", bitbucketCloudRepoPath(runinfo), spec)
+	if runinfo.PullRequestNumber != 0 {
+		diffstatPath = fmt.Sprintf("%s/pullrequests/%d/diffstat?pagelen=500", bitbucketCloudRepoPath(runinfo),
+			runinfo.PullRequestNumber)
+	}
+
+	files := changedFiles{}
+	if err := v.paginate(ctx, diffstatPath, func(values []byte) error {
+		diffstats := []bitbucketCloudDiffStat{}
+		if err := json.Unmarshal(values, &diffstats); err != nil {
+			return err
+		}
+		for _, diffstat := range diffstats {
+			if diffstat.Old != nil {
+				files.add(diffstat.Old.Path)
+			}
+			if diffstat.New != nil {
+				files.add(diffstat.New.Path)
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return files.list(), nil
+}
+
+// bitbucketCloudState convert a check run status and conclusion to a
+// Bitbucket Cloud build status state
+func bitbucketCloudState(status, conclusion string) string {
+	if status != "completed" {
+		return "INPROGRESS"
+	}
+	switch conclusion {
+	case "success":
+		return "SUCCESSFUL"
+	case "failure":
+		return "FAILED"
+	}
+	return "STOPPED"
+}
+
+func (v BitbucketCloudVCS) setBuildStatus(ctx context.Context, runinfo *RunInfo, state, description, detailsURL string) error {
+	// the url is mandatory for a build status
+	if detailsURL == "" {
+		detailsURL = runinfo.URL
+	}
+	opts := map[string]string{
+		"key":         bitbucketCloudStatusKey(runinfo.ApplicationName),
+		"name":        runinfo.ApplicationName,
+		"state":       state,
+		"description": description,
+		"url":         detailsURL,
+	}
+	_, err := v.rest.do(ctx, http.MethodPost,
+		fmt.Sprintf("%s/commit/%s/statuses/build", bitbucketCloudRepoPath(runinfo), url.PathEscape(runinfo.SHA)), opts, nil)
+	return err
+}
+
+// CreateCheckRun create an in progress build status, build statuses are
+// keyed by the application name and don't have an id so the CheckRunID is
+// only set to tell a status has been created
+func (v BitbucketCloudVCS) CreateCheckRun(ctx context.Context, status string, runinfo *RunInfo) error {
+	title, _ := statusTitleSummary(runinfo, status, "")
+	if err := v.setBuildStatus(ctx, runinfo, bitbucketCloudState(status, ""), title, runinfo.LogURL); err != nil {
+		return err
+	}
+	runinfo.CheckRunID = new(int64)
+	return nil
+}
+
+// CreateStatus set the build status and when completed report the details
+// as a comment on the pull request
+func (v BitbucketCloudVCS) CreateStatus(ctx context.Context, runinfo *RunInfo, status, conclusion, text, detailsURL string) error {
+	title, _ := statusTitleSummary(runinfo, status, conclusion)
+	if err := v.setBuildStatus(ctx, runinfo, bitbucketCloudState(status, conclusion), title, detailsURL); err != nil {
+		return err
+	}
+
+	if runinfo.PullRequestNumber == 0 {
+		return nil
+	}
+	if runinfo.PullRequestComment {
+		return v.createOrUpdatePullRequestComment(ctx, runinfo, markedStatusComment(runinfo, status, conclusion, text))
+	}
+	if status != "completed" {
+		return nil
+	}
+
+	comment := map[string]interface{}{
+		"content": map[string]string{"raw": statusComment(runinfo, status, conclusion, text)},
+	}
+	_, err := v.rest.do(ctx, http.MethodPost,
+		fmt.Sprintf("%s/pullrequests/%d/comments", bitbucketCloudRepoPath(runinfo), runinfo.PullRequestNumber),
+		comment, nil)
+	return err
+}
+
+// createOrUpdatePullRequestComment edit the comment of the runinfo pull
+// request marked for the runinfo application, or create it
+func (v BitbucketCloudVCS) createOrUpdatePullRequestComment(ctx context.Context, runinfo *RunInfo, body string) error {
+	self := BitbucketCloudUser{}
+	if _, err := v.rest.do(ctx, http.MethodGet, "/user", nil, &self); err != nil {
+		return fmt.Errorf("cannot get the account of the token: %w", err)
+	}
+	comment, err := findPullRequestComment(ctx, v, runinfo, runinfo.ApplicationName, bitbucketCloudUserID(self))
+	if err != nil {
+		return err
+	}
+	commentsPath := fmt.Sprintf("%s/pullrequests/%d/comments", bitbucketCloudRepoPath(runinfo), runinfo.PullRequestNumber)
+	content := map[string]interface{}{
+		"content": map[string]string{"raw": body},
+	}
+	if comment != nil {
+		_, err = v.rest.do(ctx, http.MethodPut, fmt.Sprintf("%s/%d", commentsPath, comment.ID), content, nil)
+		return err
+	}
+	_, err = v.rest.do(ctx, http.MethodPost, commentsPath, content, nil)
+	return err
+}
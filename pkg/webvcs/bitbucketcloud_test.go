@@ -0,0 +1,551 @@
+package webvcs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	bbctesthelper "github.com/openshift-pipelines/pipelines-as-code/pkg/test/bitbucketcloud"
+	"gotest.tools/v3/assert"
+	rtesting "knative.dev/pkg/reconciler/testing"
+)
+
+func TestBitbucketCloudParsePayload(t *testing.T) {
+	repositoryJSON := `{"name": "repo", "full_name": "workspace/repo"}`
+	prJSON := `{"id": 5, "source": {"commit": {"hash": "shortsha"}}}`
+	tests := []struct {
+		name          string
+		eventType     string
+		payload       string
+		wantErr       string
+		eventTypeR    string
+		sender        string
+		baseBranch    string
+		headBranch    string
+		prNumber      int
+		triggerTarget string
+		activity      string
+		prAction      string
+	}{
+		{
+			name:       "pull request created",
+			eventType:  "pullrequest:created",
+			payload:    fmt.Sprintf(`{"actor": {"nickname": "actor", "account_id": "557058:actor"}, "repository": %s, "pullrequest": %s}`, repositoryJSON, prJSON),
+			eventTypeR: "pull_request",
+			sender:     "557058:author",
+			baseBranch: "main",
+			headBranch: "feature",
+			prNumber:   5,
+		},
+		{
+			name:       "pull request updated",
+			eventType:  "pullrequest:updated",
+			payload:    fmt.Sprintf(`{"actor": {"nickname": "actor", "account_id": "557058:actor"}, "repository": %s, "pullrequest": %s}`, repositoryJSON, prJSON),
+			eventTypeR: "pull_request",
+			sender:     "557058:author",
+			baseBranch: "main",
+			headBranch: "feature",
+			prNumber:   5,
+			activity:   `{"update": {"source": {"commit": {"hash": "shortsha"}}}}`,
+			prAction:   PullRequestActionSynchronize,
+		},
+		{
+			name:       "pull request title updated",
+			eventType:  "pullrequest:updated",
+			payload:    fmt.Sprintf(`{"actor": {"nickname": "actor", "account_id": "557058:actor"}, "repository": %s, "pullrequest": %s}`, repositoryJSON, prJSON),
+			eventTypeR: "pull_request",
+			sender:     "557058:author",
+			baseBranch: "main",
+			headBranch: "feature",
+			prNumber:   5,
+			activity:   `{"update": {"source": {"commit": {"hash": "prevsha"}}}}`,
+			prAction:   PullRequestActionEdited,
+		},
+		{
+			name:      "pull request comment",
+			eventType: "pullrequest:comment_created",
+			payload: fmt.Sprintf(`{"actor": {"nickname": "commenter", "account_id": "557058:commenter"}, "repository": %s, "pullrequest": %s,
+				"comment": {"content": {"raw": "/retest"}, "user": {"nickname": "commenter", "account_id": "557058:commenter"}}}`, repositoryJSON, prJSON),
+			triggerTarget: TriggerTargetRetestComment,
+			eventTypeR:    "pull_request",
+			sender:        "557058:commenter",
+			baseBranch:    "main",
+			headBranch:    "feature",
+			prNumber:      5,
+		},
+		{
+			name:      "push",
+			eventType: "repo:push",
+			payload: fmt.Sprintf(`{"actor": {"nickname": "pusher", "uuid": "{pusher}"}, "repository": %s, "push": {"changes": [
+				{"new": null},
+				{"new": {"type": "branch", "name": "main", "target": {"hash": "shortsha"}}}]}}`, repositoryJSON),
+			eventTypeR: "push",
+			sender:     "{pusher}",
+			baseBranch: "refs/heads/main",
+			headBranch: "refs/heads/main",
+		},
+		{
+			name:      "push of a tag",
+			eventType: "repo:push",
+			payload: fmt.Sprintf(`{"actor": {"nickname": "pusher", "uuid": "{pusher}"}, "repository": %s, "push": {"changes": [
+				{"new": {"type": "tag", "name": "v1", "target": {"hash": "shortsha"}}}]}}`, repositoryJSON),
+			wantErr: "push event has no branch update",
+		},
+		{
+			name:      "unknown event",
+			eventType: "repo:fork",
+			payload:   `{}`,
+			wantErr:   "this event is not supported",
+		},
+		{
+			name:      "invalid payload",
+			eventType: "repo:push",
+			payload:   `hello moto`,
+			wantErr:   "invalid character",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux, serverURL, teardown := bbctesthelper.SetupBBCloud()
+			defer teardown()
+			mux.HandleFunc("/repositories/workspace/repo", func(rw http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(rw, `{"full_name": "workspace/repo", "mainbranch": {"name": "main"},
+					"links": {"html": {"href": "https://bitbucket.org/workspace/repo"}}}`)
+			})
+			mux.HandleFunc("/repositories/workspace/repo/pullrequests/5", func(rw http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(rw, `{"id": 5, "author": {"nickname": "author", "account_id": "557058:author"},
+					"source": {"branch": {"name": "feature"}, "commit": {"hash": "shortsha"}},
+					"destination": {"branch": {"name": "main"}}}`)
+			})
+			mux.HandleFunc("/repositories/workspace/repo/pullrequests/5/activity", func(rw http.ResponseWriter, r *http.Request) {
+				if r.URL.Query().Get("page") == "2" {
+					fmt.Fprint(rw, `{"values": [{"approval": {}}, {"update": {"source": {"commit": {"hash": "prevsha"}}}},
+						{"update": {"source": {"commit": {"hash": "firstsha"}}}}]}`)
+					return
+				}
+				fmt.Fprintf(rw, `{"values": [{"comment": {}}, %s],
+					"next": "%s/2.0/repositories/workspace/repo/pullrequests/5/activity?page=2"}`, tt.activity, serverURL)
+			})
+			mux.HandleFunc("/repositories/workspace/repo/commit/shortsha", func(rw http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(rw, `{"hash": "fullsha", "message": "HELLO\n\nmoto",
+					"links": {"html": {"href": "https://bitbucket.org/workspace/repo/commits/fullsha"}}}`)
+			})
+
+			ctx, _ := rtesting.SetupFakeContext(t)
+			logger, _ := getLogger()
+			bbcvcs := NewBitbucketCloudVCS("token", serverURL)
+			runinfo, err := bbcvcs.ParsePayload(ctx, logger, tt.eventType, "target", tt.payload)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, runinfo.Owner, "workspace")
+			assert.Equal(t, runinfo.Repository, "repo")
+			assert.Equal(t, runinfo.URL, "https://bitbucket.org/workspace/repo")
+			assert.Equal(t, runinfo.DefaultBranch, "main")
+			assert.Equal(t, runinfo.SHA, "fullsha")
+			assert.Equal(t, runinfo.SHAURL, "https://bitbucket.org/workspace/repo/commits/fullsha")
+			assert.Equal(t, runinfo.SHATitle, "HELLO")
+			assert.Equal(t, runinfo.EventType, tt.eventTypeR)
+			assert.Equal(t, runinfo.Sender, tt.sender)
+			assert.Equal(t, runinfo.BaseBranch, tt.baseBranch)
+			assert.Equal(t, runinfo.HeadBranch, tt.headBranch)
+			assert.Equal(t, runinfo.PullRequestNumber, tt.prNumber)
+			assert.Equal(t, runinfo.PullRequestAction, tt.prAction)
+			wantTriggerTarget := "target"
+			if tt.triggerTarget != "" {
+				wantTriggerTarget = tt.triggerTarget
+			}
+			assert.Equal(t, runinfo.TriggerTarget, wantTriggerTarget)
+		})
+	}
+}
+
+func TestBitbucketCloudAuthorization(t *testing.T) {
+	tests := []struct {
+		name, token, auth string
+	}{
+		{
+			name:  "access token",
+			token: "token",
+			auth:  "Bearer token",
+		},
+		{
+			name:  "app password",
+			token: "user:password",
+			auth:  "Basic dXNlcjpwYXNzd29yZA==",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux, serverURL, teardown := bbctesthelper.SetupBBCloud()
+			defer teardown()
+			mux.HandleFunc("/repositories/owner/repo/src/main/OWNERS", func(rw http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, r.Header.Get("Authorization"), tt.auth)
+				fmt.Fprint(rw, "approvers: []")
+			})
+			ctx, _ := rtesting.SetupFakeContext(t)
+			bbcvcs := NewBitbucketCloudVCS(tt.token, serverURL)
+			_, err := bbcvcs.GetFileFromDefaultBranch(ctx, "OWNERS",
+				&RunInfo{Owner: "owner", Repository: "repo", DefaultBranch: "main"})
+			assert.NilError(t, err)
+		})
+	}
+}
+
+func TestBitbucketCloudGetTektonDir(t *testing.T) {
+	mux, serverURL, teardown := bbctesthelper.SetupBBCloud()
+	defer teardown()
+	mux.HandleFunc("/repositories/tekton/dir/src/sha/.tekton/", func(rw http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(rw, `{"values": [
+			{"type": "commit_file", "path": ".tekton/pipeline.yaml"},
+			{"type": "commit_file", "path": ".tekton/README.md"},
+			{"type": "commit_directory", "path": ".tekton/tasks"},
+			{"type": "commit_file", "path": ".tekton/run.yml"}]}`)
+	})
+	mux.HandleFunc("/repositories/tekton/dir/src/sha/.tekton/tasks/", func(rw http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(rw, `{"values": [{"type": "commit_file", "path": ".tekton/tasks/task.yaml"}]}`)
+	})
+	mux.HandleFunc("/repositories/tekton/dir/src/sha/.tekton/tasks/task.yaml", func(rw http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(rw, "hello taskyaml")
+	})
+	mux.HandleFunc("/repositories/tekton/dir/src/sha/.tekton/pipeline.yaml", func(rw http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(rw, "hello pipelineyaml")
+	})
+	mux.HandleFunc("/repositories/tekton/dir/src/sha/.tekton/run.yml", func(rw http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(rw, "hello runyaml")
+	})
+	mux.HandleFunc("/repositories/pas/la/src/sha/.tekton/", func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(rw, `{"type": "error", "error": {"message": "No such file or directory: .tekton"}}`)
+	})
+
+	ctx, _ := rtesting.SetupFakeContext(t)
+	bbcvcs := NewBitbucketCloudVCS("token", serverURL)
+
+	got, err := bbcvcs.GetTektonDir(ctx, ".tekton", &RunInfo{Owner: "tekton", Repository: "dir", SHA: "sha"})
+	assert.NilError(t, err)
+	assert.Equal(t, got, "\nhello pipelineyaml\n---\nhello runyaml\n---\nhello taskyaml\n")
+
+	got, err = bbcvcs.GetTektonDir(ctx, ".tekton", &RunInfo{Owner: "pas", Repository: "la", SHA: "sha"})
+	assert.NilError(t, err)
+	assert.Equal(t, got, "")
+}
+
+func TestBitbucketCloudGetFileInsideRepo(t *testing.T) {
+	mux, serverURL, teardown := bbctesthelper.SetupBBCloud()
+	defer teardown()
+	mux.HandleFunc("/repositories/foo/bar/src/", func(rw http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repositories/foo/bar/src/sha/dir/README.md":
+			fmt.Fprint(rw, "hello sha")
+		case "/repositories/foo/bar/src/branch/dir/README.md":
+			fmt.Fprint(rw, "hello branch")
+		default:
+			rw.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	ctx, _ := rtesting.SetupFakeContext(t)
+	bbcvcs := NewBitbucketCloudVCS("token", serverURL)
+	runinfo := &RunInfo{Owner: "foo", Repository: "bar", SHA: "sha", BaseBranch: "branch"}
+
+	got, err := bbcvcs.GetFileInsideRepo(ctx, "dir/README.md", false, runinfo)
+	assert.NilError(t, err)
+	assert.Equal(t, got, "hello sha")
+
+	got, err = bbcvcs.GetFileInsideRepo(ctx, "dir/README.md", true, runinfo)
+	assert.NilError(t, err)
+	assert.Equal(t, got, "hello branch")
+
+	_, err = bbcvcs.GetFileInsideRepo(ctx, "OWNERS", false, runinfo)
+	assert.ErrorContains(t, err, "cannot find OWNERS in this repository")
+}
+
+func TestBitbucketCloudGetBranchSHA(t *testing.T) {
+	mux, serverURL, teardown := bbctesthelper.SetupBBCloud()
+	defer teardown()
+	mux.HandleFunc("/repositories/owner/repo/refs/branches/main", func(rw http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(rw, `{"name": "main", "target": {"hash": "headsha"}}`)
+	})
+
+	ctx, _ := rtesting.SetupFakeContext(t)
+	bbcvcs := NewBitbucketCloudVCS("token", serverURL)
+	got, err := bbcvcs.GetBranchSHA(ctx, "main", &RunInfo{Owner: "owner", Repository: "repo"})
+	assert.NilError(t, err)
+	assert.Equal(t, got, "headsha")
+}
+
+func TestBitbucketCloudCheckSenderOrgMembership(t *testing.T) {
+	mux, serverURL, teardown := bbctesthelper.SetupBBCloud()
+	defer teardown()
+	mux.HandleFunc("/workspaces/workspace/members", func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("page") == "2" {
+			fmt.Fprint(rw, `{"values": [{"user": {"nickname": "member", "account_id": "557058:member"}}]}`)
+			return
+		}
+		fmt.Fprintf(rw, `{"values": [{"user": {"nickname": "other", "account_id": "557058:other"}},
+			{"user": {"nickname": "member", "account_id": "557058:impostor"}}],
+			"next": "%s/2.0/workspaces/workspace/members?pagelen=100&page=2"}`, serverURL)
+	})
+	mux.HandleFunc("/workspaces/private/members", func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusNotFound)
+	})
+
+	ctx, _ := rtesting.SetupFakeContext(t)
+	bbcvcs := NewBitbucketCloudVCS("token", serverURL)
+
+	allowed, err := bbcvcs.CheckSenderOrgMembership(ctx, &RunInfo{Owner: "workspace", Sender: "557058:member"})
+	assert.NilError(t, err)
+	assert.Assert(t, allowed)
+
+	allowed, err = bbcvcs.CheckSenderOrgMembership(ctx, &RunInfo{Owner: "workspace", Sender: "member"})
+	assert.NilError(t, err)
+	assert.Assert(t, !allowed)
+
+	allowed, err = bbcvcs.CheckSenderOrgMembership(ctx, &RunInfo{Owner: "private", Sender: "557058:member"})
+	assert.NilError(t, err)
+	assert.Assert(t, !allowed)
+}
+
+func TestBitbucketCloudGetStringPullRequestComment(t *testing.T) {
+	mux, serverURL, teardown := bbctesthelper.SetupBBCloud()
+	defer teardown()
+	mux.HandleFunc("/repositories/owner/repo/pullrequests/5/comments", func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("page") == "2" {
+			fmt.Fprint(rw, `{"values": [{"content": {"raw": "/ok-to-test"}, "user": {"nickname": "next", "account_id": "557058:next"}}]}`)
+			return
+		}
+		fmt.Fprintf(rw, `{"values": [
+			{"content": {"raw": "/ok-to-test"}, "user": {"nickname": "owner", "account_id": "557058:owner"}},
+			{"content": {"raw": "/ok-to-test"}, "deleted": true, "user": {"nickname": "deleted"}},
+			{"content": {"raw": "hello"}, "user": {"nickname": "other"}}],
+			"next": "%s/2.0/repositories/owner/repo/pullrequests/5/comments?pagelen=100&page=2"}`, serverURL)
+	})
+	ctx, _ := rtesting.SetupFakeContext(t)
+	bbcvcs := NewBitbucketCloudVCS("token", serverURL)
+	comments, err := bbcvcs.GetStringPullRequestComment(ctx,
+		&RunInfo{Owner: "owner", Repository: "repo", PullRequestNumber: 5}, `(^|\n)/ok-to-test(\r\n|$)`)
+	assert.NilError(t, err)
+	assert.Equal(t, len(comments), 2)
+	assert.Equal(t, comments[0].Sender, "557058:owner")
+	assert.Equal(t, comments[1].Sender, "557058:next")
+}
+
+func TestBitbucketCloudCreateStatus(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      string
+		conclusion  string
+		detailsURL  string
+		prNumber    int
+		state       string
+		url         string
+		wantComment bool
+	}{
+		{
+			name:       "in progress",
+			status:     "in_progress",
+			detailsURL: "https://url",
+			state:      "INPROGRESS",
+			url:        "https://url",
+		},
+		{
+			name:        "success on pull request",
+			status:      "completed",
+			conclusion:  "success",
+			detailsURL:  "https://url",
+			prNumber:    5,
+			state:       "SUCCESSFUL",
+			url:         "https://url",
+			wantComment: true,
+		},
+		{
+			name:       "failure on push without details url",
+			status:     "completed",
+			conclusion: "failure",
+			state:      "FAILED",
+			url:        "https://bitbucket.org/owner/repo",
+		},
+		{
+			name:       "skipped",
+			status:     "completed",
+			conclusion: "skipped",
+			detailsURL: "https://url",
+			state:      "STOPPED",
+			url:        "https://url",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux, serverURL, teardown := bbctesthelper.SetupBBCloud()
+			defer teardown()
+			gotComment := false
+			mux.HandleFunc("/repositories/owner/repo/commit/sha/statuses/build", func(rw http.ResponseWriter, r *http.Request) {
+				body, _ := ioutil.ReadAll(r.Body)
+				opts := map[string]string{}
+				assert.NilError(t, json.Unmarshal(body, &opts))
+				assert.Equal(t, opts["state"], tt.state)
+				assert.Equal(t, opts["key"], "PAC")
+				assert.Equal(t, opts["url"], tt.url)
+				fmt.Fprint(rw, `{}`)
+			})
+			mux.HandleFunc("/repositories/owner/repo/pullrequests/5/comments", func(rw http.ResponseWriter, r *http.Request) {
+				gotComment = true
+				body, _ := ioutil.ReadAll(r.Body)
+				comment := &bitbucketCloudComment{}
+				assert.NilError(t, json.Unmarshal(body, comment))
+				assert.Assert(t, comment.Content.Raw != "")
+				fmt.Fprint(rw, `{}`)
+			})
+			ctx, _ := rtesting.SetupFakeContext(t)
+			bbcvcs := NewBitbucketCloudVCS("token", serverURL)
+			runinfo := &RunInfo{
+				Owner: "owner", Repository: "repo", SHA: "sha", URL: "https://bitbucket.org/owner/repo",
+				ApplicationName: "PAC", PullRequestNumber: tt.prNumber,
+			}
+			err := bbcvcs.CreateStatus(ctx, runinfo, tt.status, tt.conclusion, "text", tt.detailsURL)
+			assert.NilError(t, err)
+			assert.Equal(t, gotComment, tt.wantComment)
+		})
+	}
+}
+
+func TestBitbucketCloudCreateStatusPullRequestComment(t *testing.T) {
+	tests := []struct {
+		name         string
+		comments     string
+		wantRequests []string
+	}{
+		{
+			name:     "create the comment",
+			comments: `{"values": [{"id": 6, "content": {"raw": "LGTM"}, "user": {"nickname": "reviewer", "account_id": "1"}}]}`,
+			wantRequests: []string{
+				"GET /repositories/owner/repo/pullrequests/5/comments",
+				"POST /repositories/owner/repo/pullrequests/5/comments",
+			},
+		},
+		{
+			name: "update the comment",
+			comments: `{"values": [{"id": 6, "content": {"raw": "LGTM"}, "user": {"nickname": "reviewer", "account_id": "1"}},
+				{"id": 7, "content": {"raw": "<!-- pipelines-as-code: PAC -->\n**CI has Started**"}, "user": {"nickname": "bot", "account_id": "2"}}]}`,
+			wantRequests: []string{
+				"GET /repositories/owner/repo/pullrequests/5/comments",
+				"PUT /repositories/owner/repo/pullrequests/5/comments/7",
+			},
+		},
+		{
+			name: "ignore the comment of another account",
+			comments: `{"values": [{"id": 6, "content": {"raw": "<!-- pipelines-as-code: PAC -->\n**CI has Started**"},
+				"user": {"nickname": "bot", "account_id": "1"}}]}`,
+			wantRequests: []string{
+				"GET /repositories/owner/repo/pullrequests/5/comments",
+				"POST /repositories/owner/repo/pullrequests/5/comments",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux, serverURL, teardown := bbctesthelper.SetupBBCloud()
+			defer teardown()
+			mux.HandleFunc("/repositories/owner/repo/commit/sha/statuses/build", func(rw http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(rw, `{}`)
+			})
+			mux.HandleFunc("/user", func(rw http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(rw, `{"nickname": "bot", "account_id": "2"}`)
+			})
+			requests := []string{}
+			commentHandler := func(rw http.ResponseWriter, r *http.Request) {
+				requests = append(requests, r.Method+" "+r.URL.Path)
+				if r.Method == http.MethodGet {
+					fmt.Fprint(rw, tt.comments)
+					return
+				}
+				comment := &bitbucketCloudComment{}
+				assert.NilError(t, json.NewDecoder(r.Body).Decode(comment))
+				assert.Assert(t, strings.HasPrefix(comment.Content.Raw, "<!-- pipelines-as-code: PAC -->\n**✅ Success**"), comment.Content.Raw)
+				fmt.Fprint(rw, `{}`)
+			}
+			mux.HandleFunc("/repositories/owner/repo/pullrequests/5/comments", commentHandler)
+			mux.HandleFunc("/repositories/owner/repo/pullrequests/5/comments/7", commentHandler)
+			ctx, _ := rtesting.SetupFakeContext(t)
+			bbcvcs := NewBitbucketCloudVCS("token", serverURL)
+			runinfo := &RunInfo{
+				Owner: "owner", Repository: "repo", SHA: "sha", URL: "https://bitbucket.org/owner/repo",
+				ApplicationName: "PAC", PullRequestNumber: 5, PullRequestComment: true,
+			}
+			assert.NilError(t, bbcvcs.CreateStatus(ctx, runinfo, "completed", "success", "text", "https://url"))
+			assert.DeepEqual(t, requests, tt.wantRequests)
+		})
+	}
+}
+
+func TestBitbucketCloudCreateCheckRun(t *testing.T) {
+	mux, serverURL, teardown := bbctesthelper.SetupBBCloud()
+	defer teardown()
+	mux.HandleFunc("/repositories/owner/repo/commit/sha/statuses/build", func(rw http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(rw, `{}`)
+	})
+	ctx, _ := rtesting.SetupFakeContext(t)
+	bbcvcs := NewBitbucketCloudVCS("token", serverURL)
+	runinfo := &RunInfo{Owner: "owner", Repository: "repo", SHA: "sha", LogURL: "https://log"}
+	err := bbcvcs.CreateCheckRun(ctx, "in_progress", runinfo)
+	assert.NilError(t, err)
+	assert.Assert(t, runinfo.CheckRunID != nil)
+}
+
+func TestBitbucketCloudGetFilesChanged(t *testing.T) {
+	mux, serverURL, teardown := bbctesthelper.SetupBBCloud()
+	defer teardown()
+	mux.HandleFunc("/repositories/owner/repo/pullrequests/6/diffstat", func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("page") == "2" {
+			fmt.Fprint(rw, `{"values": [{"old": null, "new": {"path": "docs/README.md"}}]}`)
+			return
+		}
+		fmt.Fprintf(rw, `{"values": [{"old": {"path": "pkg/old.go"}, "new": {"path": "pkg/new.go"}},
+			{"old": {"path": "Makefile"}, "new": null}],
+			"next": "%s/2.0/repositories/owner/repo/pullrequests/6/diffstat?pagelen=500&page=2"}`, serverURL)
+	})
+	mux.HandleFunc("/repositories/owner/repo/diffstat/", func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/repositories/owner/repo/diffstat/sha..before" {
+			fmt.Fprint(rw, `{"values": [{"old": {"path": "main.go"}, "new": {"path": "main.go"}},
+				{"old": null, "new": {"path": "docs/index.md"}}]}`)
+			return
+		}
+		fmt.Fprint(rw, `{"values": [{"old": {"path": "main.go"}, "new": {"path": "main.go"}}]}`)
+	})
+
+	ctx, _ := rtesting.SetupFakeContext(t)
+	bbcvcs := NewBitbucketCloudVCS("token", serverURL)
+
+	got, err := bbcvcs.GetFilesChanged(ctx, &RunInfo{Owner: "owner", Repository: "repo", SHA: "sha", PullRequestNumber: 6})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, got, []string{"Makefile", "docs/README.md", "pkg/new.go", "pkg/old.go"})
+
+	pushEvent := &BitbucketCloudPushEvent{}
+	assert.NilError(t, json.Unmarshal([]byte(`{"push": {"changes": [
+		{"new": {"type": "branch", "target": {"hash": "sha"}}, "old": {"target": {"hash": "before"}}}]}}`), pushEvent))
+	got, err = bbcvcs.GetFilesChanged(ctx, &RunInfo{Owner: "owner", Repository: "repo", SHA: "sha", Event: pushEvent})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, got, []string{"docs/index.md", "main.go"})
+
+	newBranchEvent := &BitbucketCloudPushEvent{}
+	assert.NilError(t, json.Unmarshal([]byte(`{"push": {"changes": [
+		{"new": {"type": "branch", "target": {"hash": "sha"}}, "old": null}]}}`), newBranchEvent))
+	got, err = bbcvcs.GetFilesChanged(ctx, &RunInfo{Owner: "owner", Repository: "repo", SHA: "sha", Event: newBranchEvent})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, got, []string{"main.go"})
+}
+
+func TestBitbucketCloudStatusKey(t *testing.T) {
+	assert.Equal(t, bitbucketCloudStatusKey("PAC"), "PAC")
+	longName := "Pipelines as Code CI / a-pipelinerun-with-a-long-name"
+	key := bitbucketCloudStatusKey(longName)
+	assert.Equal(t, len(key), bitbucketCloudMaxKeyLength)
+	assert.Equal(t, key, bitbucketCloudStatusKey(longName))
+	assert.Assert(t, key != bitbucketCloudStatusKey(longName+"-2"))
+}
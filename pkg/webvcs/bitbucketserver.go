@@ -0,0 +1,665 @@
+package webvcs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+const (
+	bitbucketServerAPIPath         = "/rest/api/1.0"
+	bitbucketServerBuildStatusPath = "/rest/build-status/1.0"
+)
+
+var _ Interface = BitbucketServerVCS{}
+
+type BitbucketServerVCS struct {
+	rest restClient
+}
+
+type BitbucketServerLinks struct {
+	Self []struct {
+		Href string `json:"href"`
+	} `json:"self"`
+}
+
+type BitbucketServerUser struct {
+	Name         string `json:"name"`
+	Slug         string `json:"slug"`
+	DisplayName  string `json:"displayName"`
+	EmailAddress string `json:"emailAddress"`
+}
+
+type BitbucketServerRepository struct {
+	Slug    string `json:"slug"`
+	Name    string `json:"name"`
+	Project struct {
+		Key string `json:"key"`
+	} `json:"project"`
+	Links BitbucketServerLinks `json:"links"`
+}
+
+type BitbucketServerRef struct {
+	ID           string                    `json:"id"`
+	DisplayID    string                    `json:"displayId"`
+	LatestCommit string                    `json:"latestCommit"`
+	Repository   BitbucketServerRepository `json:"repository"`
+}
+
+type BitbucketServerPullRequest struct {
+	ID      int                `json:"id"`
+	Title   string             `json:"title"`
+	State   string             `json:"state"`
+	FromRef BitbucketServerRef `json:"fromRef"`
+	ToRef   BitbucketServerRef `json:"toRef"`
+	Author  struct {
+		User BitbucketServerUser `json:"user"`
+	} `json:"author"`
+	Links BitbucketServerLinks `json:"links"`
+}
+
+// BitbucketServerPullRequestEvent a "pr:opened", "pr:from_ref_updated" or
+// "pr:comment:added" webhook payload
+type BitbucketServerPullRequestEvent struct {
+	EventKey    string                     `json:"eventKey"`
+	Actor       BitbucketServerUser        `json:"actor"`
+	PullRequest BitbucketServerPullRequest `json:"pullRequest"`
+	Comment     *bitbucketServerComment    `json:"comment,omitempty"`
+}
+
+// BitbucketServerRefChange a ref change of a "repo:refs_changed" webhook payload
+type BitbucketServerRefChange struct {
+	Ref struct {
+		ID        string `json:"id"`
+		DisplayID string `json:"displayId"`
+		Type      string `json:"type"`
+	} `json:"ref"`
+	FromHash string `json:"fromHash"`
+	ToHash   string `json:"toHash"`
+	Type     string `json:"type"`
+}
+
+// BitbucketServerPushEvent a "repo:refs_changed" webhook payload
+type BitbucketServerPushEvent struct {
+	EventKey   string                     `json:"eventKey"`
+	Actor      BitbucketServerUser        `json:"actor"`
+	Repository BitbucketServerRepository  `json:"repository"`
+	Changes    []BitbucketServerRefChange `json:"changes"`
+}
+
+// branchUpdates get the changes of the push creating or updating a branch,
+// the deleted branches and the tags are not run on
+func (e *BitbucketServerPushEvent) branchUpdates() []BitbucketServerRefChange {
+	changes := []BitbucketServerRefChange{}
+	for _, change := range e.Changes {
+		if change.Ref.Type != "BRANCH" || change.Type == "DELETE" {
+			continue
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+type bitbucketServerComment struct {
+	ID      int                 `json:"id"`
+	Version int                 `json:"version"`
+	Text    string              `json:"text"`
+	Author  BitbucketServerUser `json:"author"`
+	// Comments are the replies to the comment
+	Comments []bitbucketServerComment `json:"comments"`
+}
+
+type bitbucketServerActivity struct {
+	Action  string                  `json:"action"`
+	Comment *bitbucketServerComment `json:"comment"`
+}
+
+type bitbucketServerCommit struct {
+	ID      string `json:"id"`
+	Message string `json:"message"`
+}
+
+type bitbucketServerPath struct {
+	ToString string `json:"toString"`
+}
+
+type bitbucketServerChange struct {
+	Path    bitbucketServerPath  `json:"path"`
+	SrcPath *bitbucketServerPath `json:"srcPath"`
+}
+
+// NewBitbucketServerVCS Create a new Bitbucket Server VCS object, token is a
+// personal access token and apiURL the Bitbucket Server URL
+func NewBitbucketServerVCS(token string, apiURL string) BitbucketServerVCS {
+	if !strings.HasPrefix(apiURL, "http") {
+		apiURL = "https://" + apiURL
+	}
+	apiURL = strings.TrimSuffix(strings.TrimSuffix(apiURL, "/"), bitbucketServerAPIPath)
+	return BitbucketServerVCS{
+		rest: restClient{
+			BaseURL: apiURL,
+			Headers: map[string]string{"Authorization": "Bearer " + token},
+		},
+	}
+}
+
+// paginate get all the pages of the Bitbucket Server list API at path,
+// starting each page at the nextPageStart of the previous one until the last
+// page and passing its values to listPage.
+// It gives up after restMaxPages pages or when the context is cancelled.
+func (v BitbucketServerVCS) paginate(ctx context.Context, path string, listPage func(values []byte) error) error {
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	start := 0
+	for i := 0; i < restMaxPages; i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		page := struct {
+			Values        json.RawMessage `json:"values"`
+			IsLastPage    bool            `json:"isLastPage"`
+			NextPageStart int             `json:"nextPageStart"`
+		}{}
+		if _, err := v.rest.do(ctx, http.MethodGet, fmt.Sprintf("%s%sstart=%d", path, sep, start), nil, &page); err != nil {
+			return err
+		}
+		if len(page.Values) > 0 {
+			if err := listPage(page.Values); err != nil {
+				return err
+			}
+		}
+		if page.IsLastPage {
+			return nil
+		}
+		start = page.NextPageStart
+	}
+	return fmt.Errorf("giving up after listing %d pages of results", restMaxPages)
+}
+
+// findUser get the first user of the users API query matching match, the
+// filter of the query matching the start of the name, display name or email
+// of the users
+func (v BitbucketServerVCS) findUser(ctx context.Context, query string, match func(user BitbucketServerUser) bool) (*BitbucketServerUser, error) {
+	var found *BitbucketServerUser
+	err := v.paginate(ctx, bitbucketServerAPIPath+"/users?"+query, func(values []byte) error {
+		users := []BitbucketServerUser{}
+		if err := json.Unmarshal(values, &users); err != nil {
+			return err
+		}
+		for i := range users {
+			if found == nil && match(users[i]) {
+				found = &users[i]
+			}
+		}
+		return nil
+	})
+	return found, err
+}
+
+// bitbucketServerRepoPath get the API path of the runinfo repository, the
+// Owner being the project key and the Repository the repository slug
+func bitbucketServerRepoPath(runinfo *RunInfo) string {
+	return fmt.Sprintf("%s/projects/%s/repos/%s", bitbucketServerAPIPath,
+		url.PathEscape(runinfo.Owner), url.PathEscape(runinfo.Repository))
+}
+
+// WebhookSignature get the X-Hub-Signature signature of a raw webhook request
+func (v BitbucketServerVCS) WebhookSignature(header http.Header) string {
+	return header.Get(bitbucketSignatureHeader)
+}
+
+// ValidateWebhook check the X-Hub-Signature signature of the payload against the webhook secret
+func (v BitbucketServerVCS) ValidateWebhook(secret, signature string, payload []byte) error {
+	return ValidateSignature(secret, signature, payload)
+}
+
+// ParseEventType get the event type from the X-Event-Key header and the
+// trigger target of the events we want to run on
+func (v BitbucketServerVCS) ParseEventType(header http.Header, payload []byte) (string, string) {
+	eventType := header.Get("X-Event-Key")
+	switch eventType {
+	case "pr:opened", "pr:from_ref_updated":
+		return eventType, TriggerTargetPullRequest
+	case "pr:comment:added":
+		prEvent := &BitbucketServerPullRequestEvent{}
+		if json.Unmarshal(payload, prEvent) != nil || prEvent.Comment == nil {
+			return eventType, ""
+		}
+		return eventType, commentTriggerTarget(prEvent.Comment.Text)
+	case "repo:refs_changed":
+		pushEvent := &BitbucketServerPushEvent{}
+		if json.Unmarshal(payload, pushEvent) != nil || len(pushEvent.branchUpdates()) == 0 {
+			return eventType, ""
+		}
+		return eventType, TriggerTargetPush
+	}
+	return eventType, ""
+}
+
+// ParsePayload parse a Bitbucket Server webhook payload, eventType being the X-Event-Key header
+func (v BitbucketServerVCS) ParsePayload(ctx context.Context, log *zap.SugaredLogger, eventType, triggerTarget, payload string) (*RunInfo, error) {
+	var runinfo RunInfo
+	var event interface{}
+	var err error
+	payload = payloadFix(payload)
+
+	switch eventType {
+	case "pr:opened", "pr:from_ref_updated", "pr:comment:added":
+		prEvent := &BitbucketServerPullRequestEvent{}
+		if err := json.Unmarshal([]byte(payload), prEvent); err != nil {
+			return &runinfo, err
+		}
+		if eventType == "pr:comment:added" {
+			log.Infof("PR recheck from comment on %s/%s#%d has been requested",
+				prEvent.PullRequest.ToRef.Repository.Project.Key, prEvent.PullRequest.ToRef.Repository.Slug,
+				prEvent.PullRequest.ID)
+		}
+		runinfo, err = v.getPullRequest(ctx, prEvent.PullRequest.ToRef.Repository, prEvent.PullRequest.ID)
+		if err != nil {
+			return &runinfo, err
+		}
+		if prEvent.Comment != nil {
+			setCommentCommand(&runinfo, prEvent.Comment.Text, prEvent.Comment.Author.Slug)
+		}
+		event = prEvent
+	case "repo:refs_changed":
+		pushEvent := &BitbucketServerPushEvent{}
+		if err := json.Unmarshal([]byte(payload), pushEvent); err != nil {
+			return &runinfo, err
+		}
+		runinfo, err = v.getRepository(ctx, pushEvent.Repository)
+		if err != nil {
+			return &runinfo, err
+		}
+		for _, change := range pushEvent.branchUpdates() {
+			runinfo.SHA = change.ToHash
+			runinfo.BaseBranch = change.Ref.ID
+		}
+		if runinfo.SHA == "" {
+			return &runinfo, fmt.Errorf("push event has no branch update")
+		}
+		runinfo.HeadBranch = runinfo.BaseBranch // in push events Head Branch is the same as Basebranch
+		runinfo.Sender = pushEvent.Actor.Slug
+		runinfo.EventType = "push"
+		event = pushEvent
+	default:
+		return &runinfo, errors.New("this event is not supported")
+	}
+
+	err = v.populateCommitInfo(ctx, &runinfo)
+	if err != nil {
+		return nil, err
+	}
+
+	runinfo.Event = event
+	// a comment command has its own trigger target
+	if runinfo.TriggerTarget == "" {
+		runinfo.TriggerTarget = triggerTarget
+	}
+	return &runinfo, nil
+}
+
+// getRepository get the repository URL and its default branch
+func (v BitbucketServerVCS) getRepository(ctx context.Context, repository BitbucketServerRepository) (RunInfo, error) {
+	runinfo := RunInfo{
+		Owner:      repository.Project.Key,
+		Repository: repository.Slug,
+	}
+
+	repo := &BitbucketServerRepository{}
+	if _, err := v.rest.do(ctx, http.MethodGet, bitbucketServerRepoPath(&runinfo), nil, repo); err != nil {
+		return runinfo, err
+	}
+	if len(repo.Links.Self) > 0 {
+		runinfo.URL = strings.TrimSuffix(repo.Links.Self[0].Href, "/browse")
+	}
+
+	defaultBranch := &BitbucketServerRef{}
+	if _, err := v.rest.do(ctx, http.MethodGet, bitbucketServerRepoPath(&runinfo)+"/branches/default",
+		nil, defaultBranch); err != nil {
+		return runinfo, err
+	}
+	runinfo.DefaultBranch = defaultBranch.DisplayID
+	return runinfo, nil
+}
+
+// getPullRequest get a pull request details, repository is the target
+// repository of the pull request as set in the webhook
+func (v BitbucketServerVCS) getPullRequest(ctx context.Context, repository BitbucketServerRepository, prID int) (RunInfo, error) {
+	// Make sure to use the target repository for the default branch and the
+	// URL or there would be a potential hijack from a fork
+	runinfo, err := v.getRepository(ctx, repository)
+	if err != nil {
+		return runinfo, err
+	}
+
+	pr := &BitbucketServerPullRequest{}
+	if _, err := v.rest.do(ctx, http.MethodGet,
+		fmt.Sprintf("%s/pull-requests/%d", bitbucketServerRepoPath(&runinfo), prID), nil, pr); err != nil {
+		return runinfo, err
+	}
+
+	runinfo.SHA = pr.FromRef.LatestCommit
+	runinfo.Sender = pr.Author.User.Slug
+	runinfo.HeadBranch = pr.FromRef.DisplayID
+	runinfo.BaseBranch = pr.ToRef.DisplayID
+	runinfo.EventType = "pull_request"
+	runinfo.PullRequestNumber = prID
+	return runinfo, nil
+}
+
+// populateCommitInfo get info on a commit in runinfo
+func (v BitbucketServerVCS) populateCommitInfo(ctx context.Context, runinfo *RunInfo) error {
+	commit := &bitbucketServerCommit{}
+	if _, err := v.rest.do(ctx, http.MethodGet,
+		fmt.Sprintf("%s/commits/%s", bitbucketServerRepoPath(runinfo), url.PathEscape(runinfo.SHA)), nil, commit); err != nil {
+		return err
+	}
+
+	runinfo.SHAURL = fmt.Sprintf("%s/commits/%s", runinfo.URL, commit.ID)
+	runinfo.SHATitle = strings.Split(commit.Message, "\n")[0]
+	return nil
+}
+
+// CheckSenderOrgMembership check if the sender has the effective write
+// permission on the repository, whether it has been granted on the repository
+// or on its project, directly or through a group, or implied by a global permission
+func (v BitbucketServerVCS) CheckSenderOrgMembership(ctx context.Context, runinfo *RunInfo) (bool, error) {
+	user, err := v.findUser(ctx,
+		fmt.Sprintf("filter=%s&permission.1=REPO_WRITE&permission.1.projectKey=%s&permission.1.repositorySlug=%s",
+			url.QueryEscape(runinfo.Sender), url.QueryEscape(runinfo.Owner), url.QueryEscape(runinfo.Repository)),
+		func(user BitbucketServerUser) bool { return user.Slug == runinfo.Sender })
+	if isNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return user != nil, nil
+}
+
+// self get the slug of the account the token is from. Bitbucket Server has no
+// endpoint for it, the name is in the X-AUSERNAME header of the authenticated
+// replies and the slug is looked up from it.
+func (v BitbucketServerVCS) self(ctx context.Context) (string, error) {
+	_, resp, err := v.rest.raw(ctx, http.MethodGet, bitbucketServerAPIPath+"/application-properties", nil)
+	if err != nil {
+		return "", fmt.Errorf("cannot get the account of the token: %w", err)
+	}
+	name := resp.Header.Get("X-AUSERNAME")
+	if name == "" {
+		return "", fmt.Errorf("cannot get the account of the token: the request is not authenticated")
+	}
+
+	user, err := v.findUser(ctx, "filter="+url.QueryEscape(name),
+		func(user BitbucketServerUser) bool { return user.Name == name })
+	if err != nil {
+		return "", fmt.Errorf("cannot get the account of the token: %w", err)
+	}
+	if user != nil {
+		return user.Slug, nil
+	}
+	return "", fmt.Errorf("cannot get the account of the token: cannot find the user %s", name)
+}
+
+// matchingComments append to ret the comment and its replies matching re
+func (v BitbucketServerVCS) matchingComments(ret []*Comment, re *regexp.Regexp, comment *bitbucketServerComment) []*Comment {
+	if string(re.Find([]byte(comment.Text))) != "" {
+		ret = append(ret, &Comment{
+			ID:     int64(comment.ID),
+			Sender: comment.Author.Slug,
+			Body:   comment.Text,
+		})
+	}
+	for i := range comment.Comments {
+		ret = v.matchingComments(ret, re, &comment.Comments[i])
+	}
+	return ret
+}
+
+// GetStringPullRequestComment return the comments of a pull request matching
+// the regexp, the replies to the comments included
+func (v BitbucketServerVCS) GetStringPullRequestComment(ctx context.Context, runinfo *RunInfo, reg string) ([]*Comment, error) {
+	var ret []*Comment
+	re := regexp.MustCompile(reg)
+	err := v.paginate(ctx,
+		fmt.Sprintf("%s/pull-requests/%d/activities?limit=100", bitbucketServerRepoPath(runinfo), runinfo.PullRequestNumber),
+		func(values []byte) error {
+			activities := []bitbucketServerActivity{}
+			if err := json.Unmarshal(values, &activities); err != nil {
+				return err
+			}
+			for _, activity := range activities {
+				if activity.Action != "COMMENTED" || activity.Comment == nil {
+					continue
+				}
+				ret = v.matchingComments(ret, re, activity.Comment)
+			}
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// GetTektonDir Get all yaml files from the tekton directory of a repository
+// and its subdirectories as one multi document yaml string
+func (v BitbucketServerVCS) GetTektonDir(ctx context.Context, dirPath string, runinfo *RunInfo) (string, error) {
+	files := []string{}
+	// the files API lists the files of the subdirectories too
+	err := v.paginate(ctx,
+		fmt.Sprintf("%s/files/%s?at=%s&limit=1000", bitbucketServerRepoPath(runinfo), dirPath, url.QueryEscape(runinfo.SHA)),
+		func(values []byte) error {
+			page := []string{}
+			if err := json.Unmarshal(values, &page); err != nil {
+				return err
+			}
+			files = append(files, page...)
+			return nil
+		})
+	if isNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	var allTemplates string
+	for _, file := range files {
+		if !(strings.HasSuffix(file, ".yaml") || strings.HasSuffix(file, ".yml")) {
+			continue
+		}
+		data, err := v.GetFileInsideRepo(ctx, dirPath+"/"+file, false, runinfo)
+		if err != nil {
+			return "", err
+		}
+		if allTemplates != "" && !strings.HasPrefix(data, "---") {
+			allTemplates += "---"
+		}
+		allTemplates += "\n" + data + "\n"
+	}
+	return allTemplates, nil
+}
+
+// GetFileInsideRepo Get a file via the Bitbucket Server raw API using the
+// runinfo information, if branch is true, use the branch as ref instead of the SHA
+func (v BitbucketServerVCS) GetFileInsideRepo(ctx context.Context, filePath string, branch bool, runinfo *RunInfo) (string, error) {
+	ref := runinfo.SHA
+	if branch {
+		ref = runinfo.BaseBranch
+	}
+
+	data, _, err := v.rest.raw(ctx, http.MethodGet,
+		fmt.Sprintf("%s/raw/%s?at=%s", bitbucketServerRepoPath(runinfo), filePath, url.QueryEscape(ref)), nil)
+	if isNotFound(err) {
+		return "", fmt.Errorf("cannot find %s in this repository", filePath)
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// GetFileFromDefaultBranch will get a file directly from the default branch of the repository
+func (v BitbucketServerVCS) GetFileFromDefaultBranch(ctx context.Context, filePath string, runinfo *RunInfo) (string, error) {
+	return getFileFromDefaultBranch(ctx, v, filePath, runinfo)
+}
+
+// GetBranchSHA get the SHA of the head commit of a branch, the last commit
+// listed until the branch ref
+func (v BitbucketServerVCS) GetBranchSHA(ctx context.Context, branch string, runinfo *RunInfo) (string, error) {
+	commits := struct {
+		Values []bitbucketServerCommit `json:"values"`
+	}{}
+	if _, err := v.rest.do(ctx, http.MethodGet,
+		fmt.Sprintf("%s/commits?until=%s&limit=1", bitbucketServerRepoPath(runinfo), url.QueryEscape("refs/heads/"+branch)),
+		nil, &commits); err != nil {
+		return "", err
+	}
+	if len(commits.Values) == 0 {
+		return "", fmt.Errorf("cannot find the branch %s in this repository", branch)
+	}
+	return commits.Values[0].ID, nil
+}
+
+// GetFilesChanged get the files changed by the pull request or by the
+// commits of the push, a push creating a branch only has the files changed by
+// its head commit
+func (v BitbucketServerVCS) GetFilesChanged(ctx context.Context, runinfo *RunInfo) ([]string, error) {
+	changesPath := fmt.Sprintf("%s/commits/%s/changes?limit=1000", bitbucketServerRepoPath(runinfo),
+		url.PathEscape(runinfo.SHA))
+	if event, ok := runinfo.Event.(*BitbucketServerPushEvent); ok {
+		for _, change := range event.branchUpdates() {
+			if change.ToHash == runinfo.SHA && !isZeroSHA(change.FromHash) {
+				changesPath = fmt.Sprintf("%s/changes?since=%s&until=%s&limit=1000", bitbucketServerRepoPath(runinfo),
+					url.QueryEscape(change.FromHash), url.QueryEscape(runinfo.SHA))
+			}
+		}
+	}
+	if runinfo.PullRequestNumber != 0 {
+		changesPath = fmt.Sprintf("%s/pull-requests/%d/changes?limit=1000", bitbucketServerRepoPath(runinfo),
+			runinfo.PullRequestNumber)
+	}
+
+	files := changedFiles{}
+	err := v.paginate(ctx, changesPath, func(values []byte) error {
+		changes := []bitbucketServerChange{}
+		if err := json.Unmarshal(values, &changes); err != nil {
+			return err
+		}
+		for _, change := range changes {
+			files.add(change.Path.ToString)
+			if change.SrcPath != nil {
+				files.add(change.SrcPath.ToString)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files.list(), nil
+}
+
+// bitbucketServerState convert a check run status and conclusion to a
+// Bitbucket Server build status state, the build status API doesn't have a
+// neutral state so anything not successful is failed
+func bitbucketServerState(status, conclusion string) string {
+	if status != "completed" {
+		return "INPROGRESS"
+	}
+	if conclusion == "success" {
+		return "SUCCESSFUL"
+	}
+	return "FAILED"
+}
+
+func (v BitbucketServerVCS) setBuildStatus(ctx context.Context, runinfo *RunInfo, state, description, detailsURL string) error {
+	// the url is mandatory for a build status
+	if detailsURL == "" {
+		detailsURL = runinfo.URL
+	}
+	opts := map[string]string{
+		"key":         runinfo.ApplicationName,
+		"name":        runinfo.ApplicationName,
+		"state":       state,
+		"description": description,
+		"url":         detailsURL,
+	}
+	_, err := v.rest.do(ctx, http.MethodPost,
+		fmt.Sprintf("%s/commits/%s", bitbucketServerBuildStatusPath, url.PathEscape(runinfo.SHA)), opts, nil)
+	return err
+}
+
+// CreateCheckRun create an in progress build status, build statuses are
+// keyed by the application name and don't have an id so the CheckRunID is
+// only set to tell a status has been created
+func (v BitbucketServerVCS) CreateCheckRun(ctx context.Context, status string, runinfo *RunInfo) error {
+	title, _ := statusTitleSummary(runinfo, status, "")
+	if err := v.setBuildStatus(ctx, runinfo, bitbucketServerState(status, ""), title, runinfo.LogURL); err != nil {
+		return err
+	}
+	runinfo.CheckRunID = new(int64)
+	return nil
+}
+
+// CreateStatus set the build status and when completed report the details
+// as a comment on the pull request
+func (v BitbucketServerVCS) CreateStatus(ctx context.Context, runinfo *RunInfo, status, conclusion, text, detailsURL string) error {
+	title, _ := statusTitleSummary(runinfo, status, conclusion)
+	if err := v.setBuildStatus(ctx, runinfo, bitbucketServerState(status, conclusion), title, detailsURL); err != nil {
+		return err
+	}
+
+	if runinfo.PullRequestNumber == 0 {
+		return nil
+	}
+	if runinfo.PullRequestComment {
+		return v.createOrUpdatePullRequestComment(ctx, runinfo, markedStatusComment(runinfo, status, conclusion, text))
+	}
+	if status != "completed" {
+		return nil
+	}
+
+	_, err := v.rest.do(ctx, http.MethodPost,
+		fmt.Sprintf("%s/pull-requests/%d/comments", bitbucketServerRepoPath(runinfo), runinfo.PullRequestNumber),
+		map[string]string{"text": statusComment(runinfo, status, conclusion, text)}, nil)
+	return err
+}
+
+// createOrUpdatePullRequestComment edit the comment of the runinfo pull
+// request marked for the runinfo application, or create it. A comment is
+// edited at its current version
+func (v BitbucketServerVCS) createOrUpdatePullRequestComment(ctx context.Context, runinfo *RunInfo, body string) error {
+	self, err := v.self(ctx)
+	if err != nil {
+		return err
+	}
+	comment, err := findPullRequestComment(ctx, v, runinfo, runinfo.ApplicationName, self)
+	if err != nil {
+		return err
+	}
+	commentsPath := fmt.Sprintf("%s/pull-requests/%d/comments", bitbucketServerRepoPath(runinfo), runinfo.PullRequestNumber)
+	if comment != nil {
+		commentPath := fmt.Sprintf("%s/%d", commentsPath, comment.ID)
+		current := bitbucketServerComment{}
+		if _, err := v.rest.do(ctx, http.MethodGet, commentPath, nil, &current); err != nil {
+			return err
+		}
+		_, err = v.rest.do(ctx, http.MethodPut, commentPath,
+			map[string]interface{}{"text": body, "version": current.Version}, nil)
+		return err
+	}
+	_, err = v.rest.do(ctx, http.MethodPost, commentsPath, map[string]string{"text": body}, nil)
+	return err
+}
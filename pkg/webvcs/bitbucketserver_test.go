@@ -0,0 +1,512 @@
+package webvcs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	bbstesthelper "github.com/openshift-pipelines/pipelines-as-code/pkg/test/bitbucketserver"
+	"gotest.tools/v3/assert"
+	rtesting "knative.dev/pkg/reconciler/testing"
+)
+
+const bbsRepoAPI = "/rest/api/1.0/projects/PROJ/repos/repo"
+
+func TestBitbucketServerParsePayload(t *testing.T) {
+	prJSON := `{"id": 5, "toRef": {"repository": {"slug": "repo", "project": {"key": "PROJ"}}}}`
+	tests := []struct {
+		name          string
+		eventType     string
+		payload       string
+		wantErr       string
+		eventTypeR    string
+		sender        string
+		baseBranch    string
+		headBranch    string
+		prNumber      int
+		triggerTarget string
+	}{
+		{
+			name:       "pull request opened",
+			eventType:  "pr:opened",
+			payload:    fmt.Sprintf(`{"eventKey": "pr:opened", "actor": {"slug": "actor"}, "pullRequest": %s}`, prJSON),
+			eventTypeR: "pull_request",
+			sender:     "author",
+			baseBranch: "main",
+			headBranch: "feature",
+			prNumber:   5,
+		},
+		{
+			name:       "pull request updated",
+			eventType:  "pr:from_ref_updated",
+			payload:    fmt.Sprintf(`{"eventKey": "pr:from_ref_updated", "actor": {"slug": "actor"}, "pullRequest": %s}`, prJSON),
+			eventTypeR: "pull_request",
+			sender:     "author",
+			baseBranch: "main",
+			headBranch: "feature",
+			prNumber:   5,
+		},
+		{
+			name:      "pull request comment",
+			eventType: "pr:comment:added",
+			payload: fmt.Sprintf(`{"eventKey": "pr:comment:added", "actor": {"slug": "commenter"}, "pullRequest": %s,
+				"comment": {"text": "/retest", "author": {"slug": "commenter"}}}`, prJSON),
+			triggerTarget: TriggerTargetRetestComment,
+			eventTypeR:    "pull_request",
+			sender:        "commenter",
+			baseBranch:    "main",
+			headBranch:    "feature",
+			prNumber:      5,
+		},
+		{
+			name:      "push",
+			eventType: "repo:refs_changed",
+			payload: `{"eventKey": "repo:refs_changed", "actor": {"slug": "pusher"},
+				"repository": {"slug": "repo", "project": {"key": "PROJ"}}, "changes": [
+				{"ref": {"id": "refs/heads/old", "type": "BRANCH"}, "toHash": "0000", "type": "DELETE"},
+				{"ref": {"id": "refs/heads/main", "type": "BRANCH"}, "toHash": "sha", "type": "UPDATE"}]}`,
+			eventTypeR: "push",
+			sender:     "pusher",
+			baseBranch: "refs/heads/main",
+			headBranch: "refs/heads/main",
+		},
+		{
+			name:      "push of a tag",
+			eventType: "repo:refs_changed",
+			payload: `{"eventKey": "repo:refs_changed", "repository": {"slug": "repo", "project": {"key": "PROJ"}}, "changes": [
+				{"ref": {"id": "refs/tags/v1", "type": "TAG"}, "toHash": "sha", "type": "ADD"}]}`,
+			wantErr: "push event has no branch update",
+		},
+		{
+			name:      "unknown event",
+			eventType: "repo:forked",
+			payload:   `{}`,
+			wantErr:   "this event is not supported",
+		},
+		{
+			name:      "invalid payload",
+			eventType: "repo:refs_changed",
+			payload:   `hello moto`,
+			wantErr:   "invalid character",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux, serverURL, teardown := bbstesthelper.SetupBBServer()
+			defer teardown()
+			mux.HandleFunc(bbsRepoAPI, func(rw http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(rw, `{"slug": "repo", "project": {"key": "PROJ"},
+					"links": {"self": [{"href": "https://bitbucket.example.com/projects/PROJ/repos/repo/browse"}]}}`)
+			})
+			mux.HandleFunc(bbsRepoAPI+"/branches/default", func(rw http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(rw, `{"id": "refs/heads/main", "displayId": "main"}`)
+			})
+			mux.HandleFunc(bbsRepoAPI+"/pull-requests/5", func(rw http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(rw, `{"id": 5, "author": {"user": {"slug": "author"}},
+					"fromRef": {"displayId": "feature", "latestCommit": "sha"},
+					"toRef": {"displayId": "main"}}`)
+			})
+			mux.HandleFunc(bbsRepoAPI+"/commits/sha", func(rw http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(rw, `{"id": "sha", "message": "HELLO\n\nmoto"}`)
+			})
+
+			ctx, _ := rtesting.SetupFakeContext(t)
+			logger, _ := getLogger()
+			bbsvcs := NewBitbucketServerVCS("token", serverURL)
+			runinfo, err := bbsvcs.ParsePayload(ctx, logger, tt.eventType, "target", tt.payload)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, runinfo.Owner, "PROJ")
+			assert.Equal(t, runinfo.Repository, "repo")
+			assert.Equal(t, runinfo.URL, "https://bitbucket.example.com/projects/PROJ/repos/repo")
+			assert.Equal(t, runinfo.DefaultBranch, "main")
+			assert.Equal(t, runinfo.SHA, "sha")
+			assert.Equal(t, runinfo.SHAURL, "https://bitbucket.example.com/projects/PROJ/repos/repo/commits/sha")
+			assert.Equal(t, runinfo.SHATitle, "HELLO")
+			assert.Equal(t, runinfo.EventType, tt.eventTypeR)
+			assert.Equal(t, runinfo.Sender, tt.sender)
+			assert.Equal(t, runinfo.BaseBranch, tt.baseBranch)
+			assert.Equal(t, runinfo.HeadBranch, tt.headBranch)
+			assert.Equal(t, runinfo.PullRequestNumber, tt.prNumber)
+			wantTriggerTarget := "target"
+			if tt.triggerTarget != "" {
+				wantTriggerTarget = tt.triggerTarget
+			}
+			assert.Equal(t, runinfo.TriggerTarget, wantTriggerTarget)
+		})
+	}
+}
+
+func TestBitbucketServerGetTektonDir(t *testing.T) {
+	mux, serverURL, teardown := bbstesthelper.SetupBBServer()
+	defer teardown()
+	mux.HandleFunc(bbsRepoAPI+"/files/.tekton", func(rw http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, r.URL.Query().Get("at"), "sha")
+		assert.Equal(t, r.Header.Get("Authorization"), "Bearer token")
+		if r.URL.Query().Get("start") == "0" {
+			fmt.Fprint(rw, `{"values": ["pipeline.yaml", "README.md"], "isLastPage": false, "nextPageStart": 2}`)
+			return
+		}
+		assert.Equal(t, r.URL.Query().Get("start"), "2")
+		fmt.Fprint(rw, `{"values": ["tasks/task.yaml", "run.yml"], "isLastPage": true}`)
+	})
+	mux.HandleFunc(bbsRepoAPI+"/raw/.tekton/pipeline.yaml", func(rw http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(rw, "hello pipelineyaml")
+	})
+	mux.HandleFunc(bbsRepoAPI+"/raw/.tekton/tasks/task.yaml", func(rw http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(rw, "hello taskyaml")
+	})
+	mux.HandleFunc(bbsRepoAPI+"/raw/.tekton/run.yml", func(rw http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(rw, "hello runyaml")
+	})
+	mux.HandleFunc("/rest/api/1.0/projects/PAS/repos/la/files/.tekton", func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusNotFound)
+	})
+
+	ctx, _ := rtesting.SetupFakeContext(t)
+	bbsvcs := NewBitbucketServerVCS("token", serverURL)
+
+	got, err := bbsvcs.GetTektonDir(ctx, ".tekton", &RunInfo{Owner: "PROJ", Repository: "repo", SHA: "sha"})
+	assert.NilError(t, err)
+	assert.Equal(t, got, "\nhello pipelineyaml\n---\nhello taskyaml\n---\nhello runyaml\n")
+
+	got, err = bbsvcs.GetTektonDir(ctx, ".tekton", &RunInfo{Owner: "PAS", Repository: "la", SHA: "sha"})
+	assert.NilError(t, err)
+	assert.Equal(t, got, "")
+}
+
+func TestBitbucketServerGetFileInsideRepo(t *testing.T) {
+	mux, serverURL, teardown := bbstesthelper.SetupBBServer()
+	defer teardown()
+	mux.HandleFunc(bbsRepoAPI+"/raw/", func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != bbsRepoAPI+"/raw/dir/README.md" {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprintf(rw, "hello %s", r.URL.Query().Get("at"))
+	})
+
+	ctx, _ := rtesting.SetupFakeContext(t)
+	bbsvcs := NewBitbucketServerVCS("token", serverURL+"/rest/api/1.0")
+	runinfo := &RunInfo{Owner: "PROJ", Repository: "repo", SHA: "sha", BaseBranch: "branch", DefaultBranch: "main"}
+
+	got, err := bbsvcs.GetFileInsideRepo(ctx, "dir/README.md", false, runinfo)
+	assert.NilError(t, err)
+	assert.Equal(t, got, "hello sha")
+
+	got, err = bbsvcs.GetFileInsideRepo(ctx, "dir/README.md", true, runinfo)
+	assert.NilError(t, err)
+	assert.Equal(t, got, "hello branch")
+
+	got, err = bbsvcs.GetFileFromDefaultBranch(ctx, "dir/README.md", runinfo)
+	assert.NilError(t, err)
+	assert.Equal(t, got, "hello main")
+
+	_, err = bbsvcs.GetFileInsideRepo(ctx, "OWNERS", false, runinfo)
+	assert.ErrorContains(t, err, "cannot find OWNERS in this repository")
+}
+
+func TestBitbucketServerGetBranchSHA(t *testing.T) {
+	mux, serverURL, teardown := bbstesthelper.SetupBBServer()
+	defer teardown()
+	mux.HandleFunc(bbsRepoAPI+"/commits", func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("until") != "refs/heads/main" {
+			fmt.Fprint(rw, `{"values": []}`)
+			return
+		}
+		assert.Equal(t, r.URL.Query().Get("limit"), "1")
+		fmt.Fprint(rw, `{"values": [{"id": "headsha"}]}`)
+	})
+
+	ctx, _ := rtesting.SetupFakeContext(t)
+	bbsvcs := NewBitbucketServerVCS("token", serverURL+"/rest/api/1.0")
+	runinfo := &RunInfo{Owner: "PROJ", Repository: "repo"}
+	got, err := bbsvcs.GetBranchSHA(ctx, "main", runinfo)
+	assert.NilError(t, err)
+	assert.Equal(t, got, "headsha")
+
+	_, err = bbsvcs.GetBranchSHA(ctx, "gone", runinfo)
+	assert.ErrorContains(t, err, "cannot find the branch gone in this repository")
+}
+
+func TestBitbucketServerCheckSenderOrgMembership(t *testing.T) {
+	tests := []struct {
+		name    string
+		users   []string
+		allowed bool
+	}{
+		{
+			name:    "effective write permission",
+			users:   []string{`{"values": [{"slug": "me"}], "isLastPage": true}`},
+			allowed: true,
+		},
+		{
+			name:    "no write permission",
+			users:   []string{`{"values": [], "isLastPage": true}`},
+			allowed: false,
+		},
+		{
+			name:    "other user matching the filter",
+			users:   []string{`{"values": [{"slug": "meme"}], "isLastPage": true}`},
+			allowed: false,
+		},
+		{
+			name: "effective write permission on another page",
+			users: []string{
+				`{"values": [{"slug": "meme"}], "isLastPage": false, "nextPageStart": 1}`,
+				`{"values": [{"slug": "me"}], "isLastPage": true}`,
+			},
+			allowed: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux, serverURL, teardown := bbstesthelper.SetupBBServer()
+			defer teardown()
+			mux.HandleFunc("/rest/api/1.0/users", func(rw http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, r.URL.Query().Get("filter"), "me")
+				assert.Equal(t, r.URL.Query().Get("permission.1"), "REPO_WRITE")
+				assert.Equal(t, r.URL.Query().Get("permission.1.projectKey"), "PROJ")
+				assert.Equal(t, r.URL.Query().Get("permission.1.repositorySlug"), "repo")
+				start, err := strconv.Atoi(r.URL.Query().Get("start"))
+				assert.NilError(t, err)
+				fmt.Fprint(rw, tt.users[start])
+			})
+			ctx, _ := rtesting.SetupFakeContext(t)
+			bbsvcs := NewBitbucketServerVCS("token", serverURL)
+			allowed, err := bbsvcs.CheckSenderOrgMembership(ctx, &RunInfo{Owner: "PROJ", Repository: "repo", Sender: "me"})
+			assert.NilError(t, err)
+			assert.Equal(t, tt.allowed, allowed)
+		})
+	}
+}
+
+func TestBitbucketServerGetStringPullRequestComment(t *testing.T) {
+	mux, serverURL, teardown := bbstesthelper.SetupBBServer()
+	defer teardown()
+	mux.HandleFunc(bbsRepoAPI+"/pull-requests/5/activities", func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("start") == "0" {
+			fmt.Fprint(rw, `{"values": [
+				{"action": "COMMENTED", "comment": {"text": "/ok-to-test", "author": {"slug": "owner"}}},
+				{"action": "APPROVED"}], "isLastPage": false, "nextPageStart": 2}`)
+			return
+		}
+		fmt.Fprint(rw, `{"values": [
+			{"action": "COMMENTED", "comment": {"text": "hello", "author": {"slug": "other"},
+				"comments": [{"text": "hi", "author": {"slug": "other"},
+					"comments": [{"text": "/ok-to-test", "author": {"slug": "maintainer"}}]}]}}],
+			"isLastPage": true}`)
+	})
+	ctx, _ := rtesting.SetupFakeContext(t)
+	bbsvcs := NewBitbucketServerVCS("token", serverURL)
+	comments, err := bbsvcs.GetStringPullRequestComment(ctx,
+		&RunInfo{Owner: "PROJ", Repository: "repo", PullRequestNumber: 5}, `(^|\n)/ok-to-test(\r\n|$)`)
+	assert.NilError(t, err)
+	assert.Equal(t, len(comments), 2)
+	assert.Equal(t, comments[0].Sender, "owner")
+	assert.Equal(t, comments[1].Sender, "maintainer")
+}
+
+func TestBitbucketServerCreateStatus(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      string
+		conclusion  string
+		prNumber    int
+		state       string
+		wantComment bool
+	}{
+		{
+			name:   "in progress",
+			status: "in_progress",
+			state:  "INPROGRESS",
+		},
+		{
+			name:        "success on pull request",
+			status:      "completed",
+			conclusion:  "success",
+			prNumber:    5,
+			state:       "SUCCESSFUL",
+			wantComment: true,
+		},
+		{
+			name:       "failure on push",
+			status:     "completed",
+			conclusion: "failure",
+			state:      "FAILED",
+		},
+		{
+			name:        "skipped",
+			status:      "completed",
+			conclusion:  "skipped",
+			prNumber:    5,
+			state:       "FAILED",
+			wantComment: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux, serverURL, teardown := bbstesthelper.SetupBBServer()
+			defer teardown()
+			gotComment := false
+			mux.HandleFunc("/rest/build-status/1.0/commits/sha", func(rw http.ResponseWriter, r *http.Request) {
+				body, _ := ioutil.ReadAll(r.Body)
+				opts := map[string]string{}
+				assert.NilError(t, json.Unmarshal(body, &opts))
+				assert.Equal(t, opts["state"], tt.state)
+				assert.Equal(t, opts["key"], "PAC")
+				assert.Equal(t, opts["url"], "https://url")
+				rw.WriteHeader(http.StatusNoContent)
+			})
+			mux.HandleFunc(bbsRepoAPI+"/pull-requests/5/comments", func(rw http.ResponseWriter, r *http.Request) {
+				gotComment = true
+				body, _ := ioutil.ReadAll(r.Body)
+				comment := &bitbucketServerComment{}
+				assert.NilError(t, json.Unmarshal(body, comment))
+				assert.Assert(t, comment.Text != "")
+				fmt.Fprint(rw, `{}`)
+			})
+			ctx, _ := rtesting.SetupFakeContext(t)
+			bbsvcs := NewBitbucketServerVCS("token", serverURL)
+			runinfo := &RunInfo{
+				Owner: "PROJ", Repository: "repo", SHA: "sha",
+				ApplicationName: "PAC", PullRequestNumber: tt.prNumber,
+			}
+			err := bbsvcs.CreateStatus(ctx, runinfo, tt.status, tt.conclusion, "text", "https://url")
+			assert.NilError(t, err)
+			assert.Equal(t, gotComment, tt.wantComment)
+		})
+	}
+}
+
+func TestBitbucketServerCreateStatusPullRequestComment(t *testing.T) {
+	tests := []struct {
+		name         string
+		activities   string
+		wantRequests []string
+	}{
+		{
+			name: "create the comment",
+			activities: `{"values": [{"action": "COMMENTED", "comment": {"id": 6, "text": "LGTM", "author": {"slug": "reviewer"}}},
+				{"action": "APPROVED"}], "isLastPage": true}`,
+			wantRequests: []string{
+				"GET " + bbsRepoAPI + "/pull-requests/5/activities",
+				"POST " + bbsRepoAPI + "/pull-requests/5/comments",
+			},
+		},
+		{
+			name: "update the comment",
+			activities: `{"values": [{"action": "COMMENTED", "comment": {"id": 6, "text": "LGTM", "author": {"slug": "reviewer"}}},
+				{"action": "COMMENTED", "comment": {"id": 7, "text": "<!-- pipelines-as-code: PAC -->\n**CI has Started**", "author": {"slug": "bot"}}}],
+				"isLastPage": true}`,
+			wantRequests: []string{
+				"GET " + bbsRepoAPI + "/pull-requests/5/activities",
+				"GET " + bbsRepoAPI + "/pull-requests/5/comments/7",
+				"PUT " + bbsRepoAPI + "/pull-requests/5/comments/7",
+			},
+		},
+		{
+			name: "ignore the comment of another account",
+			activities: `{"values": [{"action": "COMMENTED", "comment": {"id": 6,
+				"text": "<!-- pipelines-as-code: PAC -->\n**CI has Started**", "author": {"slug": "reviewer"}}}], "isLastPage": true}`,
+			wantRequests: []string{
+				"GET " + bbsRepoAPI + "/pull-requests/5/activities",
+				"POST " + bbsRepoAPI + "/pull-requests/5/comments",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux, serverURL, teardown := bbstesthelper.SetupBBServer()
+			defer teardown()
+			mux.HandleFunc("/rest/build-status/1.0/commits/sha", func(rw http.ResponseWriter, r *http.Request) {
+				rw.WriteHeader(http.StatusNoContent)
+			})
+			mux.HandleFunc("/rest/api/1.0/application-properties", func(rw http.ResponseWriter, r *http.Request) {
+				rw.Header().Set("X-AUSERNAME", "Bot")
+				fmt.Fprint(rw, `{}`)
+			})
+			mux.HandleFunc("/rest/api/1.0/users", func(rw http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, r.URL.Query().Get("filter"), "Bot")
+				fmt.Fprint(rw, `{"values": [{"name": "Bot2", "slug": "bot2"}, {"name": "Bot", "slug": "bot"}], "isLastPage": true}`)
+			})
+			requests := []string{}
+			mux.HandleFunc(bbsRepoAPI+"/pull-requests/5/activities", func(rw http.ResponseWriter, r *http.Request) {
+				requests = append(requests, r.Method+" "+r.URL.Path)
+				fmt.Fprint(rw, tt.activities)
+			})
+			commentHandler := func(rw http.ResponseWriter, r *http.Request) {
+				requests = append(requests, r.Method+" "+r.URL.Path)
+				if r.Method == http.MethodGet {
+					fmt.Fprint(rw, `{"id": 7, "version": 3}`)
+					return
+				}
+				comment := &bitbucketServerComment{}
+				assert.NilError(t, json.NewDecoder(r.Body).Decode(comment))
+				assert.Assert(t, strings.HasPrefix(comment.Text, "<!-- pipelines-as-code: PAC -->\n**✅ Success**"), comment.Text)
+				if r.Method == http.MethodPut {
+					assert.Equal(t, comment.Version, 3)
+				}
+				fmt.Fprint(rw, `{}`)
+			}
+			mux.HandleFunc(bbsRepoAPI+"/pull-requests/5/comments", commentHandler)
+			mux.HandleFunc(bbsRepoAPI+"/pull-requests/5/comments/7", commentHandler)
+			ctx, _ := rtesting.SetupFakeContext(t)
+			bbsvcs := NewBitbucketServerVCS("token", serverURL)
+			runinfo := &RunInfo{
+				Owner: "PROJ", Repository: "repo", SHA: "sha",
+				ApplicationName: "PAC", PullRequestNumber: 5, PullRequestComment: true,
+			}
+			assert.NilError(t, bbsvcs.CreateStatus(ctx, runinfo, "completed", "success", "text", "https://url"))
+			assert.DeepEqual(t, requests, tt.wantRequests)
+		})
+	}
+}
+
+func TestBitbucketServerGetFilesChanged(t *testing.T) {
+	mux, serverURL, teardown := bbstesthelper.SetupBBServer()
+	defer teardown()
+	mux.HandleFunc(bbsRepoAPI+"/pull-requests/6/changes", func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("start") == "0" {
+			fmt.Fprint(rw, `{"values": [{"path": {"toString": "pkg/new.go"}, "srcPath": {"toString": "pkg/old.go"}}],
+				"isLastPage": false, "nextPageStart": 1}`)
+			return
+		}
+		fmt.Fprint(rw, `{"values": [{"path": {"toString": "Makefile"}}], "isLastPage": true}`)
+	})
+	mux.HandleFunc(bbsRepoAPI+"/changes", func(rw http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, r.URL.Query().Get("since"), "before")
+		assert.Equal(t, r.URL.Query().Get("until"), "sha")
+		fmt.Fprint(rw, `{"values": [{"path": {"toString": "main.go"}}, {"path": {"toString": "docs/index.md"}}], "isLastPage": true}`)
+	})
+	mux.HandleFunc(bbsRepoAPI+"/commits/sha/changes", func(rw http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(rw, `{"values": [{"path": {"toString": "main.go"}}], "isLastPage": true}`)
+	})
+
+	ctx, _ := rtesting.SetupFakeContext(t)
+	bbsvcs := NewBitbucketServerVCS("token", serverURL)
+
+	got, err := bbsvcs.GetFilesChanged(ctx, &RunInfo{Owner: "PROJ", Repository: "repo", SHA: "sha", PullRequestNumber: 6})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, got, []string{"Makefile", "pkg/new.go", "pkg/old.go"})
+
+	pushEvent := &BitbucketServerPushEvent{}
+	assert.NilError(t, json.Unmarshal([]byte(`{"changes": [{"ref": {"type": "BRANCH"}, "fromHash": "before", "toHash": "sha"}]}`), pushEvent))
+	got, err = bbsvcs.GetFilesChanged(ctx, &RunInfo{Owner: "PROJ", Repository: "repo", SHA: "sha", Event: pushEvent})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, got, []string{"docs/index.md", "main.go"})
+
+	newBranchEvent := &BitbucketServerPushEvent{}
+	assert.NilError(t, json.Unmarshal([]byte(`{"changes": [
+		{"ref": {"type": "BRANCH"}, "fromHash": "0000000000000000000000000000000000000000", "toHash": "sha", "type": "ADD"}]}`), newBranchEvent))
+	got, err = bbsvcs.GetFilesChanged(ctx, &RunInfo{Owner: "PROJ", Repository: "repo", SHA: "sha", Event: newBranchEvent})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, got, []string{"main.go"})
+}
@@ -0,0 +1,625 @@
+package webvcs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+const (
+	giteaAPIPath = "/api/v1"
+
+	// giteaPerPage is the number of items we ask per page to the Gitea list
+	// APIs, the default maximum of a Gitea instance
+	giteaPerPage = 50
+)
+
+var _ Interface = GiteaVCS{}
+
+type GiteaVCS struct {
+	rest restClient
+}
+
+type GiteaUser struct {
+	Login string `json:"login"`
+}
+
+type GiteaRepository struct {
+	Name          string    `json:"name"`
+	FullName      string    `json:"full_name"`
+	Owner         GiteaUser `json:"owner"`
+	HTMLURL       string    `json:"html_url"`
+	DefaultBranch string    `json:"default_branch"`
+}
+
+type GiteaPRBranch struct {
+	Ref  string          `json:"ref"`
+	SHA  string          `json:"sha"`
+	Repo GiteaRepository `json:"repo"`
+}
+
+type GiteaPullRequest struct {
+	Number  int           `json:"number"`
+	Title   string        `json:"title"`
+	HTMLURL string        `json:"html_url"`
+	User    GiteaUser     `json:"user"`
+	Head    GiteaPRBranch `json:"head"`
+	Base    GiteaPRBranch `json:"base"`
+}
+
+type GiteaPayloadCommit struct {
+	ID       string   `json:"id"`
+	Message  string   `json:"message"`
+	URL      string   `json:"url"`
+	Added    []string `json:"added"`
+	Removed  []string `json:"removed"`
+	Modified []string `json:"modified"`
+}
+
+// GiteaPullRequestEvent a "pull_request" webhook payload
+type GiteaPullRequestEvent struct {
+	Action      string           `json:"action"`
+	Number      int              `json:"number"`
+	PullRequest GiteaPullRequest `json:"pull_request"`
+	Repository  GiteaRepository  `json:"repository"`
+	Sender      GiteaUser        `json:"sender"`
+}
+
+// GiteaPushEvent a "push" webhook payload
+type GiteaPushEvent struct {
+	Ref        string               `json:"ref"`
+	After      string               `json:"after"`
+	HeadCommit GiteaPayloadCommit   `json:"head_commit"`
+	Commits    []GiteaPayloadCommit `json:"commits"`
+	Repository GiteaRepository      `json:"repository"`
+	Pusher     GiteaUser            `json:"pusher"`
+	Sender     GiteaUser            `json:"sender"`
+}
+
+// GiteaIssueCommentEvent a "issue_comment" webhook payload
+type GiteaIssueCommentEvent struct {
+	Action string `json:"action"`
+	Issue  struct {
+		Number      int       `json:"number"`
+		PullRequest *struct{} `json:"pull_request"`
+	} `json:"issue"`
+	Comment    giteaComment    `json:"comment"`
+	Repository GiteaRepository `json:"repository"`
+	Sender     GiteaUser       `json:"sender"`
+	IsPull     bool            `json:"is_pull"`
+}
+
+type giteaComment struct {
+	ID   int64     `json:"id"`
+	Body string    `json:"body"`
+	User GiteaUser `json:"user"`
+}
+
+type giteaContentEntry struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+	Type string `json:"type"`
+}
+
+type giteaCommit struct {
+	SHA     string `json:"sha"`
+	HTMLURL string `json:"html_url"`
+	Commit  struct {
+		Message string `json:"message"`
+	} `json:"commit"`
+}
+
+type giteaChangedFile struct {
+	Filename         string `json:"filename"`
+	PreviousFilename string `json:"previous_filename"`
+}
+
+type giteaCommitStatus struct {
+	ID int64 `json:"id"`
+}
+
+// NewGiteaVCS Create a new Gitea (or Forgejo) VCS object for token, apiURL
+// is the Gitea instance URL
+func NewGiteaVCS(token string, apiURL string) GiteaVCS {
+	if !strings.HasPrefix(apiURL, "http") {
+		apiURL = "https://" + apiURL
+	}
+	apiURL = strings.TrimSuffix(apiURL, "/")
+	if !strings.HasSuffix(apiURL, giteaAPIPath) {
+		apiURL += giteaAPIPath
+	}
+	return GiteaVCS{
+		rest: restClient{
+			BaseURL: apiURL,
+			Headers: map[string]string{"Authorization": "token " + token},
+		},
+	}
+}
+
+// giteaRepoPath get the API path of the runinfo repository
+func giteaRepoPath(runinfo *RunInfo) string {
+	return fmt.Sprintf("/repos/%s/%s", url.PathEscape(runinfo.Owner), url.PathEscape(runinfo.Repository))
+}
+
+// WebhookSignature get the X-Gitea-Signature signature of a raw webhook request
+func (v GiteaVCS) WebhookSignature(header http.Header) string {
+	return header.Get(giteaSignatureHeader)
+}
+
+// ValidateWebhook check the X-Gitea-Signature signature of the payload against the webhook secret
+func (v GiteaVCS) ValidateWebhook(secret, signature string, payload []byte) error {
+	return ValidateSignature(secret, signature, payload)
+}
+
+// ParseEventType get the event type from the X-Gitea-Event header and the
+// trigger target of the events we want to run on
+func (v GiteaVCS) ParseEventType(header http.Header, payload []byte) (string, string) {
+	eventType := header.Get("X-Gitea-Event")
+	switch eventType {
+	case "pull_request":
+		prEvent := &GiteaPullRequestEvent{}
+		if json.Unmarshal(payload, prEvent) != nil {
+			return eventType, ""
+		}
+		switch prEvent.Action {
+		case "opened", "reopened", "synchronized":
+			return eventType, TriggerTargetPullRequest
+		}
+	case "issue_comment":
+		commentEvent := &GiteaIssueCommentEvent{}
+		if json.Unmarshal(payload, commentEvent) != nil || commentEvent.Action != "created" ||
+			(!commentEvent.IsPull && commentEvent.Issue.PullRequest == nil) {
+			return eventType, ""
+		}
+		return eventType, commentTriggerTarget(commentEvent.Comment.Body)
+	case "push":
+		// a branch deletion is pushed with an all zero after commit
+		pushEvent := &GiteaPushEvent{}
+		if json.Unmarshal(payload, pushEvent) != nil || isZeroSHA(pushEvent.After) {
+			return eventType, ""
+		}
+		return eventType, TriggerTargetPush
+	}
+	return eventType, ""
+}
+
+// ParsePayload parse a Gitea webhook payload, eventType being the X-Gitea-Event header
+func (v GiteaVCS) ParsePayload(ctx context.Context, log *zap.SugaredLogger, eventType, triggerTarget, payload string) (*RunInfo, error) {
+	var runinfo RunInfo
+	var event interface{}
+	var err error
+	payload = payloadFix(payload)
+
+	switch eventType {
+	case "pull_request":
+		prEvent := &GiteaPullRequestEvent{}
+		if err := json.Unmarshal([]byte(payload), prEvent); err != nil {
+			return &runinfo, err
+		}
+		runinfo = RunInfo{
+			Owner:             prEvent.Repository.Owner.Login,
+			Repository:        prEvent.Repository.Name,
+			DefaultBranch:     prEvent.Repository.DefaultBranch,
+			URL:               prEvent.Repository.HTMLURL,
+			SHA:               prEvent.PullRequest.Head.SHA,
+			Sender:            prEvent.PullRequest.User.Login,
+			HeadBranch:        prEvent.PullRequest.Head.Ref,
+			BaseBranch:        prEvent.PullRequest.Base.Ref,
+			EventType:         eventType,
+			PullRequestNumber: prEvent.PullRequest.Number,
+		}
+		event = prEvent
+	case "issue_comment":
+		commentEvent := &GiteaIssueCommentEvent{}
+		if err := json.Unmarshal([]byte(payload), commentEvent); err != nil {
+			return &runinfo, err
+		}
+		if !commentEvent.IsPull && commentEvent.Issue.PullRequest == nil {
+			return &runinfo, fmt.Errorf("issue comment is not coming from a pull_request")
+		}
+		log.Infof("PR recheck from issue commment on %s#%d has been requested", commentEvent.Repository.FullName,
+			commentEvent.Issue.Number)
+		runinfo, err = v.getPullRequest(ctx, commentEvent.Repository, commentEvent.Issue.Number)
+		if err != nil {
+			return &runinfo, err
+		}
+		setCommentCommand(&runinfo, commentEvent.Comment.Body, commentEvent.Comment.User.Login)
+		event = commentEvent
+	case "push":
+		pushEvent := &GiteaPushEvent{}
+		if err := json.Unmarshal([]byte(payload), pushEvent); err != nil {
+			return &runinfo, err
+		}
+		runinfo = RunInfo{
+			Owner:         pushEvent.Repository.Owner.Login,
+			Repository:    pushEvent.Repository.Name,
+			DefaultBranch: pushEvent.Repository.DefaultBranch,
+			URL:           pushEvent.Repository.HTMLURL,
+			SHA:           pushEvent.After,
+			Sender:        pushEvent.Sender.Login,
+			BaseBranch:    pushEvent.Ref,
+			HeadBranch:    pushEvent.Ref, // in push events Head Branch is the same as Basebranch
+		}
+		setPushEventType(&runinfo)
+		event = pushEvent
+	default:
+		return &runinfo, errors.New("this event is not supported")
+	}
+
+	err = v.populateCommitInfo(ctx, &runinfo)
+	if err != nil {
+		return nil, err
+	}
+
+	runinfo.Event = event
+	// a comment command has its own trigger target
+	if runinfo.TriggerTarget == "" {
+		runinfo.TriggerTarget = triggerTarget
+	}
+	return &runinfo, nil
+}
+
+// getPullRequest get a pull request details, repository is the base
+// repository as set in the webhook
+func (v GiteaVCS) getPullRequest(ctx context.Context, repository GiteaRepository, prNumber int) (RunInfo, error) {
+	runinfo := RunInfo{
+		Owner:      repository.Owner.Login,
+		Repository: repository.Name,
+	}
+
+	pr := &GiteaPullRequest{}
+	if _, err := v.rest.do(ctx, http.MethodGet,
+		fmt.Sprintf("%s/pulls/%d", giteaRepoPath(&runinfo), prNumber), nil, pr); err != nil {
+		return runinfo, err
+	}
+
+	// Make sure to use the Base for Default BaseBranch or there would be a potential hijack
+	runinfo.DefaultBranch = pr.Base.Repo.DefaultBranch
+	runinfo.URL = pr.Base.Repo.HTMLURL
+	runinfo.SHA = pr.Head.SHA
+	runinfo.Sender = pr.User.Login
+	runinfo.HeadBranch = pr.Head.Ref
+	runinfo.BaseBranch = pr.Base.Ref
+	runinfo.EventType = "pull_request"
+	runinfo.PullRequestNumber = prNumber
+	return runinfo, nil
+}
+
+// populateCommitInfo get info on a commit in runinfo
+func (v GiteaVCS) populateCommitInfo(ctx context.Context, runinfo *RunInfo) error {
+	commit := &giteaCommit{}
+	if _, err := v.rest.do(ctx, http.MethodGet,
+		fmt.Sprintf("%s/git/commits/%s", giteaRepoPath(runinfo), url.PathEscape(runinfo.SHA)), nil, commit); err != nil {
+		return err
+	}
+
+	runinfo.SHAURL = commit.HTMLURL
+	runinfo.SHATitle = strings.Split(commit.Commit.Message, "\n\n")[0]
+	return nil
+}
+
+// paginate get all the pages of the Gitea list API at path, listPage decodes
+// each page body and returns its number of items, a page not full being the last.
+// It gives up after restMaxPages pages or when the context is cancelled.
+func (v GiteaVCS) paginate(ctx context.Context, path string, listPage func(data []byte) (int, error)) error {
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	for page := 1; page <= restMaxPages; page++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		data, _, err := v.rest.raw(ctx, http.MethodGet, fmt.Sprintf("%s%slimit=%d&page=%d", path, sep, giteaPerPage, page), nil)
+		if err != nil {
+			return err
+		}
+		count, err := listPage(data)
+		if err != nil {
+			return err
+		}
+		if count < giteaPerPage {
+			return nil
+		}
+	}
+	return fmt.Errorf("giving up after listing %d pages of results", restMaxPages)
+}
+
+// giteaPermissionLevel the permissions on a repository allowed to run the CI,
+// on the levels of githubPermissionLevel. Gitea has no maintain permission,
+// asking for it requires the admin one.
+var giteaPermissionLevel = map[string]int{
+	"write": 1,
+	"admin": 3,
+	"owner": 3,
+}
+
+// CheckSenderOrgMembership check if the sender has at least the
+// runinfo.CollaboratorPermission permission on the repository, whether it is
+// granted as a collaborator, through a team of the organization or as its owner
+func (v GiteaVCS) CheckSenderOrgMembership(ctx context.Context, runinfo *RunInfo) (bool, error) {
+	minimum := runinfo.CollaboratorPermission
+	if minimum == "" {
+		minimum = DefaultCollaboratorPermission
+	}
+	minimumLevel, ok := githubPermissionLevel[minimum]
+	if !ok {
+		return false, fmt.Errorf("invalid collaborator permission %q, it needs to be one of write, maintain or admin", minimum)
+	}
+
+	perm := struct {
+		Permission string `json:"permission"`
+	}{}
+	_, err := v.rest.do(ctx, http.MethodGet,
+		fmt.Sprintf("%s/collaborators/%s/permission", giteaRepoPath(runinfo), url.PathEscape(runinfo.Sender)), nil, &perm)
+	// The sender is not a user Gitea knows about
+	if isNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return giteaPermissionLevel[perm.Permission] >= minimumLevel, nil
+}
+
+// GetStringPullRequestComment return the comments of a pull request matching the regexp
+func (v GiteaVCS) GetStringPullRequestComment(ctx context.Context, runinfo *RunInfo, reg string) ([]*Comment, error) {
+	var ret []*Comment
+	comments := []giteaComment{}
+	if err := v.paginate(ctx, fmt.Sprintf("%s/issues/%d/comments", giteaRepoPath(runinfo), runinfo.PullRequestNumber),
+		func(data []byte) (int, error) {
+			page := []giteaComment{}
+			if err := json.Unmarshal(data, &page); err != nil {
+				return 0, err
+			}
+			comments = append(comments, page...)
+			return len(page), nil
+		}); err != nil {
+		return nil, err
+	}
+
+	re := regexp.MustCompile(reg)
+	for _, comment := range comments {
+		if string(re.Find([]byte(comment.Body))) != "" {
+			ret = append(ret, &Comment{
+				ID:     comment.ID,
+				Sender: comment.User.Login,
+				Body:   comment.Body,
+			})
+		}
+	}
+	return ret, nil
+}
+
+// GetTektonDir Get all yaml files from the tekton directory of a repository
+// as one multi document yaml string
+func (v GiteaVCS) GetTektonDir(ctx context.Context, dirPath string, runinfo *RunInfo) (string, error) {
+	filePaths, err := v.listFiles(ctx, dirPath, runinfo)
+	if isNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	var allTemplates string
+	for _, filePath := range filePaths {
+		if !(strings.HasSuffix(filePath, ".yaml") || strings.HasSuffix(filePath, ".yml")) {
+			continue
+		}
+		data, err := v.GetFileInsideRepo(ctx, filePath, false, runinfo)
+		if err != nil {
+			return "", err
+		}
+		if allTemplates != "" && !strings.HasPrefix(data, "---") {
+			allTemplates += "---"
+		}
+		allTemplates += "\n" + data + "\n"
+	}
+	return allTemplates, nil
+}
+
+// listFiles list the path of the files in dirPath and its subdirectories at
+// the runinfo SHA
+func (v GiteaVCS) listFiles(ctx context.Context, dirPath string, runinfo *RunInfo) ([]string, error) {
+	entries := []giteaContentEntry{}
+	if _, err := v.rest.do(ctx, http.MethodGet,
+		fmt.Sprintf("%s/contents/%s?ref=%s", giteaRepoPath(runinfo), dirPath, url.QueryEscape(runinfo.SHA)),
+		nil, &entries); err != nil {
+		return nil, err
+	}
+
+	var filePaths, subDirs []string
+	for _, entry := range entries {
+		switch entry.Type {
+		case "file":
+			filePaths = append(filePaths, entry.Path)
+		case "dir":
+			subDirs = append(subDirs, entry.Path)
+		}
+	}
+
+	for _, subDir := range subDirs {
+		subDirFilePaths, err := v.listFiles(ctx, subDir, runinfo)
+		if err != nil {
+			return nil, err
+		}
+		filePaths = append(filePaths, subDirFilePaths...)
+	}
+	return filePaths, nil
+}
+
+// GetFileInsideRepo Get a file via the Gitea raw API using the runinfo
+// information, if branch is true, use the branch as ref instead of the SHA
+func (v GiteaVCS) GetFileInsideRepo(ctx context.Context, filePath string, branch bool, runinfo *RunInfo) (string, error) {
+	ref := runinfo.SHA
+	if branch {
+		ref = runinfo.BaseBranch
+	}
+
+	data, _, err := v.rest.raw(ctx, http.MethodGet,
+		fmt.Sprintf("%s/raw/%s?ref=%s", giteaRepoPath(runinfo), filePath, url.QueryEscape(ref)), nil)
+	if isNotFound(err) {
+		return "", fmt.Errorf("cannot find %s in this repository", filePath)
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// GetFileFromDefaultBranch will get a file directly from the default branch of the repository
+func (v GiteaVCS) GetFileFromDefaultBranch(ctx context.Context, filePath string, runinfo *RunInfo) (string, error) {
+	return getFileFromDefaultBranch(ctx, v, filePath, runinfo)
+}
+
+// GetBranchSHA get the SHA of the head commit of a branch
+func (v GiteaVCS) GetBranchSHA(ctx context.Context, branch string, runinfo *RunInfo) (string, error) {
+	gtbranch := struct {
+		Commit struct {
+			ID string `json:"id"`
+		} `json:"commit"`
+	}{}
+	if _, err := v.rest.do(ctx, http.MethodGet,
+		fmt.Sprintf("%s/branches/%s", giteaRepoPath(runinfo), url.PathEscape(branch)), nil, &gtbranch); err != nil {
+		return "", err
+	}
+	return gtbranch.Commit.ID, nil
+}
+
+// GetFilesChanged get the files changed by the pull request or by the
+// commits of the push, a recheck of a push only has the files changed by its
+// head commit
+func (v GiteaVCS) GetFilesChanged(ctx context.Context, runinfo *RunInfo) ([]string, error) {
+	files := changedFiles{}
+	if runinfo.PullRequestNumber != 0 {
+		if err := v.paginate(ctx, fmt.Sprintf("%s/pulls/%d/files", giteaRepoPath(runinfo), runinfo.PullRequestNumber),
+			func(data []byte) (int, error) {
+				prFiles := []giteaChangedFile{}
+				if err := json.Unmarshal(data, &prFiles); err != nil {
+					return 0, err
+				}
+				for _, file := range prFiles {
+					files.add(file.Filename, file.PreviousFilename)
+				}
+				return len(prFiles), nil
+			}); err != nil {
+			return nil, err
+		}
+		return files.list(), nil
+	}
+
+	if event, ok := runinfo.Event.(*GiteaPushEvent); ok && len(event.Commits) > 0 {
+		for _, commit := range event.Commits {
+			files.add(commit.Added...)
+			files.add(commit.Removed...)
+			files.add(commit.Modified...)
+		}
+		return files.list(), nil
+	}
+
+	commit := struct {
+		Files []giteaChangedFile `json:"files"`
+	}{}
+	if _, err := v.rest.do(ctx, http.MethodGet,
+		fmt.Sprintf("%s/git/commits/%s", giteaRepoPath(runinfo), url.PathEscape(runinfo.SHA)), nil, &commit); err != nil {
+		return nil, err
+	}
+	for _, file := range commit.Files {
+		files.add(file.Filename, file.PreviousFilename)
+	}
+	return files.list(), nil
+}
+
+// giteaState convert a check run status and conclusion to a Gitea commit status state
+func giteaState(status, conclusion string) string {
+	if status != "completed" {
+		return "pending"
+	}
+	switch conclusion {
+	case "success":
+		return "success"
+	case "failure":
+		return "failure"
+	}
+	return "warning"
+}
+
+func (v GiteaVCS) setCommitStatus(ctx context.Context, runinfo *RunInfo, state, description, detailsURL string) (*giteaCommitStatus, error) {
+	opts := map[string]string{
+		"state":       state,
+		"context":     runinfo.ApplicationName,
+		"description": description,
+		"target_url":  detailsURL,
+	}
+
+	cstatus := &giteaCommitStatus{}
+	_, err := v.rest.do(ctx, http.MethodPost,
+		fmt.Sprintf("%s/statuses/%s", giteaRepoPath(runinfo), url.PathEscape(runinfo.SHA)), opts, cstatus)
+	return cstatus, err
+}
+
+// CreateCheckRun create a pending commit status and set its ID in runinfo
+func (v GiteaVCS) CreateCheckRun(ctx context.Context, status string, runinfo *RunInfo) error {
+	title, _ := statusTitleSummary(runinfo, status, "")
+	cstatus, err := v.setCommitStatus(ctx, runinfo, giteaState(status, ""), title, runinfo.LogURL)
+	if err != nil {
+		return err
+	}
+	runinfo.CheckRunID = &cstatus.ID
+	return nil
+}
+
+// CreateStatus set the commit status and when completed report the details
+// as a comment on the pull request
+func (v GiteaVCS) CreateStatus(ctx context.Context, runinfo *RunInfo, status, conclusion, text, detailsURL string) error {
+	title, _ := statusTitleSummary(runinfo, status, conclusion)
+	if _, err := v.setCommitStatus(ctx, runinfo, giteaState(status, conclusion), title, detailsURL); err != nil {
+		return err
+	}
+
+	if runinfo.PullRequestNumber == 0 {
+		return nil
+	}
+	if runinfo.PullRequestComment {
+		return v.createOrUpdatePullRequestComment(ctx, runinfo, markedStatusComment(runinfo, status, conclusion, text))
+	}
+	if status != "completed" {
+		return nil
+	}
+
+	_, err := v.rest.do(ctx, http.MethodPost,
+		fmt.Sprintf("%s/issues/%d/comments", giteaRepoPath(runinfo), runinfo.PullRequestNumber),
+		map[string]string{"body": statusComment(runinfo, status, conclusion, text)}, nil)
+	return err
+}
+
+// createOrUpdatePullRequestComment edit the comment of the runinfo pull
+// request marked for the runinfo application, or create it
+func (v GiteaVCS) createOrUpdatePullRequestComment(ctx context.Context, runinfo *RunInfo, body string) error {
+	self := GiteaUser{}
+	if _, err := v.rest.do(ctx, http.MethodGet, "/user", nil, &self); err != nil {
+		return fmt.Errorf("cannot get the account of the token: %w", err)
+	}
+	comment, err := findPullRequestComment(ctx, v, runinfo, runinfo.ApplicationName, self.Login)
+	if err != nil {
+		return err
+	}
+	if comment != nil {
+		_, err = v.rest.do(ctx, http.MethodPatch,
+			fmt.Sprintf("%s/issues/comments/%d", giteaRepoPath(runinfo), comment.ID),
+			map[string]string{"body": body}, nil)
+		return err
+	}
+	_, err = v.rest.do(ctx, http.MethodPost,
+		fmt.Sprintf("%s/issues/%d/comments", giteaRepoPath(runinfo), runinfo.PullRequestNumber),
+		map[string]string{"body": body}, nil)
+	return err
+}
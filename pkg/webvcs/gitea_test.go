@@ -0,0 +1,488 @@
+package webvcs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	gttesthelper "github.com/openshift-pipelines/pipelines-as-code/pkg/test/gitea"
+	"gotest.tools/v3/assert"
+	rtesting "knative.dev/pkg/reconciler/testing"
+)
+
+func TestGiteaParsePayload(t *testing.T) {
+	repositoryJSON := `{"name": "repo", "full_name": "owner/repo", "owner": {"login": "owner"},
+		"html_url": "https://gitea.com/owner/repo", "default_branch": "main"}`
+	tests := []struct {
+		name          string
+		eventType     string
+		payload       string
+		wantErr       string
+		eventTypeR    string
+		sender        string
+		baseBranch    string
+		headBranch    string
+		tag           string
+		prNumber      int
+		triggerTarget string
+	}{
+		{
+			name:      "pull request",
+			eventType: "pull_request",
+			payload: fmt.Sprintf(`{"action": "opened", "number": 5, "repository": %s, "sender": {"login": "sender"},
+				"pull_request": {"number": 5, "user": {"login": "author"},
+				"head": {"ref": "feature", "sha": "sha"}, "base": {"ref": "main"}}}`, repositoryJSON),
+			eventTypeR: "pull_request",
+			sender:     "author",
+			baseBranch: "main",
+			headBranch: "feature",
+			prNumber:   5,
+		},
+		{
+			name:      "issue comment on pull request",
+			eventType: "issue_comment",
+			payload: fmt.Sprintf(`{"action": "created", "is_pull": true, "issue": {"number": 5},
+				"comment": {"body": "/retest", "user": {"login": "commenter"}}, "repository": %s}`, repositoryJSON),
+			triggerTarget: TriggerTargetRetestComment,
+			eventTypeR:    "pull_request",
+			sender:        "commenter",
+			baseBranch:    "main",
+			headBranch:    "feature",
+			prNumber:      5,
+		},
+		{
+			name:      "issue comment on issue",
+			eventType: "issue_comment",
+			payload:   fmt.Sprintf(`{"action": "created", "is_pull": false, "issue": {"number": 6}, "repository": %s}`, repositoryJSON),
+			wantErr:   "issue comment is not coming from a pull_request",
+		},
+		{
+			name:       "push",
+			eventType:  "push",
+			payload:    fmt.Sprintf(`{"ref": "refs/heads/main", "after": "sha", "sender": {"login": "pusher"}, "repository": %s}`, repositoryJSON),
+			eventTypeR: "push",
+			sender:     "pusher",
+			baseBranch: "refs/heads/main",
+			headBranch: "refs/heads/main",
+		},
+		{
+			name:       "tag push",
+			eventType:  "push",
+			payload:    fmt.Sprintf(`{"ref": "refs/tags/1.0", "after": "sha", "sender": {"login": "pusher"}, "repository": %s}`, repositoryJSON),
+			eventTypeR: "tag_push",
+			sender:     "pusher",
+			baseBranch: "refs/tags/1.0",
+			headBranch: "refs/tags/1.0",
+			tag:        "1.0",
+		},
+		{
+			name:      "unknown event",
+			eventType: "release",
+			payload:   `{}`,
+			wantErr:   "this event is not supported",
+		},
+		{
+			name:      "invalid payload",
+			eventType: "push",
+			payload:   `hello moto`,
+			wantErr:   "invalid character",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux, serverURL, teardown := gttesthelper.SetupGT()
+			defer teardown()
+			mux.HandleFunc("/repos/owner/repo/pulls/5", func(rw http.ResponseWriter, r *http.Request) {
+				fmt.Fprintf(rw, `{"number": 5, "user": {"login": "author"}, "head": {"ref": "feature", "sha": "sha"},
+					"base": {"ref": "main", "repo": %s}}`, repositoryJSON)
+			})
+			mux.HandleFunc("/repos/owner/repo/git/commits/sha", func(rw http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(rw, `{"sha": "sha", "html_url": "https://gitea.com/owner/repo/commit/sha",
+					"commit": {"message": "HELLO\n\nmoto"}}`)
+			})
+
+			ctx, _ := rtesting.SetupFakeContext(t)
+			logger, _ := getLogger()
+			gtvcs := NewGiteaVCS("token", serverURL)
+			runinfo, err := gtvcs.ParsePayload(ctx, logger, tt.eventType, "target", tt.payload)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, runinfo.Owner, "owner")
+			assert.Equal(t, runinfo.Repository, "repo")
+			assert.Equal(t, runinfo.URL, "https://gitea.com/owner/repo")
+			assert.Equal(t, runinfo.DefaultBranch, "main")
+			assert.Equal(t, runinfo.SHA, "sha")
+			assert.Equal(t, runinfo.SHAURL, "https://gitea.com/owner/repo/commit/sha")
+			assert.Equal(t, runinfo.SHATitle, "HELLO")
+			assert.Equal(t, runinfo.EventType, tt.eventTypeR)
+			assert.Equal(t, runinfo.Sender, tt.sender)
+			assert.Equal(t, runinfo.BaseBranch, tt.baseBranch)
+			assert.Equal(t, runinfo.HeadBranch, tt.headBranch)
+			assert.Equal(t, runinfo.Tag, tt.tag)
+			assert.Equal(t, runinfo.PullRequestNumber, tt.prNumber)
+			wantTriggerTarget := "target"
+			if tt.triggerTarget != "" {
+				wantTriggerTarget = tt.triggerTarget
+			}
+			assert.Equal(t, runinfo.TriggerTarget, wantTriggerTarget)
+		})
+	}
+}
+
+func TestGiteaGetTektonDir(t *testing.T) {
+	mux, serverURL, teardown := gttesthelper.SetupGT()
+	defer teardown()
+	mux.HandleFunc("/repos/tekton/dir/contents/.tekton", func(rw http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, r.URL.Query().Get("ref"), "sha")
+		assert.Equal(t, r.Header.Get("Authorization"), "token token")
+		fmt.Fprint(rw, `[
+			{"name": "pipeline.yaml", "path": ".tekton/pipeline.yaml", "type": "file"},
+			{"name": "README.md", "path": ".tekton/README.md", "type": "file"},
+			{"name": "tasks", "path": ".tekton/tasks", "type": "dir"},
+			{"name": "run.yml", "path": ".tekton/run.yml", "type": "file"}]`)
+	})
+	mux.HandleFunc("/repos/tekton/dir/contents/.tekton/tasks", func(rw http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, r.URL.Query().Get("ref"), "sha")
+		fmt.Fprint(rw, `[{"name": "task.yaml", "path": ".tekton/tasks/task.yaml", "type": "file"}]`)
+	})
+	mux.HandleFunc("/repos/tekton/dir/raw/.tekton/tasks/task.yaml", func(rw http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(rw, "hello taskyaml")
+	})
+	mux.HandleFunc("/repos/tekton/dir/raw/.tekton/pipeline.yaml", func(rw http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, r.URL.Query().Get("ref"), "sha")
+		fmt.Fprint(rw, "hello pipelineyaml")
+	})
+	mux.HandleFunc("/repos/tekton/dir/raw/.tekton/run.yml", func(rw http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(rw, "hello runyaml")
+	})
+	mux.HandleFunc("/repos/pas/la/contents/.tekton", func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusNotFound)
+	})
+
+	ctx, _ := rtesting.SetupFakeContext(t)
+	gtvcs := NewGiteaVCS("token", serverURL)
+
+	got, err := gtvcs.GetTektonDir(ctx, ".tekton", &RunInfo{Owner: "tekton", Repository: "dir", SHA: "sha"})
+	assert.NilError(t, err)
+	assert.Equal(t, got, "\nhello pipelineyaml\n---\nhello runyaml\n---\nhello taskyaml\n")
+
+	got, err = gtvcs.GetTektonDir(ctx, ".tekton", &RunInfo{Owner: "pas", Repository: "la", SHA: "sha"})
+	assert.NilError(t, err)
+	assert.Equal(t, got, "")
+}
+
+func TestGiteaGetFileInsideRepo(t *testing.T) {
+	mux, serverURL, teardown := gttesthelper.SetupGT()
+	defer teardown()
+	mux.HandleFunc("/repos/foo/bar/raw/", func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repos/foo/bar/raw/dir/README.md" {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprintf(rw, "hello %s", r.URL.Query().Get("ref"))
+	})
+
+	ctx, _ := rtesting.SetupFakeContext(t)
+	gtvcs := NewGiteaVCS("token", serverURL)
+	runinfo := &RunInfo{Owner: "foo", Repository: "bar", SHA: "sha", BaseBranch: "branch", DefaultBranch: "main"}
+
+	got, err := gtvcs.GetFileInsideRepo(ctx, "dir/README.md", false, runinfo)
+	assert.NilError(t, err)
+	assert.Equal(t, got, "hello sha")
+
+	got, err = gtvcs.GetFileFromDefaultBranch(ctx, "dir/README.md", runinfo)
+	assert.NilError(t, err)
+	assert.Equal(t, got, "hello main")
+
+	_, err = gtvcs.GetFileInsideRepo(ctx, "OWNERS", false, runinfo)
+	assert.ErrorContains(t, err, "cannot find OWNERS in this repository")
+}
+
+func TestGiteaGetBranchSHA(t *testing.T) {
+	mux, serverURL, teardown := gttesthelper.SetupGT()
+	defer teardown()
+	mux.HandleFunc("/repos/owner/repo/branches/main", func(rw http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(rw, `{"name": "main", "commit": {"id": "headsha"}}`)
+	})
+
+	ctx, _ := rtesting.SetupFakeContext(t)
+	gtvcs := NewGiteaVCS("token", serverURL)
+	got, err := gtvcs.GetBranchSHA(ctx, "main", &RunInfo{Owner: "owner", Repository: "repo"})
+	assert.NilError(t, err)
+	assert.Equal(t, got, "headsha")
+}
+
+func TestGiteaCheckSenderOrgMembership(t *testing.T) {
+	tests := []struct {
+		name                   string
+		permission             string
+		collaboratorPermission string
+		allowed, wantErr       bool
+	}{
+		{
+			name:       "write permission",
+			permission: `{"permission": "write"}`,
+			allowed:    true,
+		},
+		{
+			name:       "owner",
+			permission: `{"permission": "owner"}`,
+			allowed:    true,
+		},
+		{
+			name:       "read permission",
+			permission: `{"permission": "read"}`,
+			allowed:    false,
+		},
+		{
+			name:    "unknown user",
+			allowed: false,
+		},
+		{
+			name:                   "write permission asking for maintain",
+			permission:             `{"permission": "write"}`,
+			collaboratorPermission: "maintain",
+			allowed:                false,
+		},
+		{
+			name:                   "admin permission asking for maintain",
+			permission:             `{"permission": "admin"}`,
+			collaboratorPermission: "maintain",
+			allowed:                true,
+		},
+		{
+			name:                   "asking for read",
+			permission:             `{"permission": "read"}`,
+			collaboratorPermission: "read",
+			wantErr:                true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux, serverURL, teardown := gttesthelper.SetupGT()
+			defer teardown()
+			if tt.permission != "" {
+				mux.HandleFunc("/repos/org/repo/collaborators/me/permission", func(rw http.ResponseWriter, r *http.Request) {
+					fmt.Fprint(rw, tt.permission)
+				})
+			}
+			ctx, _ := rtesting.SetupFakeContext(t)
+			gtvcs := NewGiteaVCS("token", serverURL)
+			allowed, err := gtvcs.CheckSenderOrgMembership(ctx, &RunInfo{
+				Owner: "org", Repository: "repo", Sender: "me", CollaboratorPermission: tt.collaboratorPermission,
+			})
+			if tt.wantErr {
+				assert.ErrorContains(t, err, "invalid collaborator permission")
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, allowed, tt.allowed)
+		})
+	}
+}
+
+func TestGiteaGetStringPullRequestComment(t *testing.T) {
+	mux, serverURL, teardown := gttesthelper.SetupGT()
+	defer teardown()
+	mux.HandleFunc("/repos/owner/repo/issues/5/comments", func(rw http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, r.URL.Query().Get("limit"), "50")
+		if r.URL.Query().Get("page") == "2" {
+			fmt.Fprint(rw, `[{"body": "/ok-to-test", "user": {"login": "nextpage"}}]`)
+			return
+		}
+		comments := []string{`{"body": "/ok-to-test", "user": {"login": "owner"}}`}
+		for i := 1; i < 50; i++ {
+			comments = append(comments, `{"body": "hello", "user": {"login": "other"}}`)
+		}
+		fmt.Fprintf(rw, "[%s]", strings.Join(comments, ","))
+	})
+	ctx, _ := rtesting.SetupFakeContext(t)
+	gtvcs := NewGiteaVCS("token", serverURL)
+	comments, err := gtvcs.GetStringPullRequestComment(ctx,
+		&RunInfo{Owner: "owner", Repository: "repo", PullRequestNumber: 5}, `(^|\n)/ok-to-test(\r\n|$)`)
+	assert.NilError(t, err)
+	assert.Equal(t, len(comments), 2)
+	assert.Equal(t, comments[0].Sender, "owner")
+	assert.Equal(t, comments[1].Sender, "nextpage")
+}
+
+func TestGiteaCreateStatus(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      string
+		conclusion  string
+		prNumber    int
+		state       string
+		wantComment bool
+	}{
+		{
+			name:   "in progress",
+			status: "in_progress",
+			state:  "pending",
+		},
+		{
+			name:        "success on pull request",
+			status:      "completed",
+			conclusion:  "success",
+			prNumber:    5,
+			state:       "success",
+			wantComment: true,
+		},
+		{
+			name:       "failure on push",
+			status:     "completed",
+			conclusion: "failure",
+			state:      "failure",
+		},
+		{
+			name:       "skipped",
+			status:     "completed",
+			conclusion: "skipped",
+			state:      "warning",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux, serverURL, teardown := gttesthelper.SetupGT()
+			defer teardown()
+			gotComment := false
+			mux.HandleFunc("/repos/owner/repo/statuses/sha", func(rw http.ResponseWriter, r *http.Request) {
+				body, _ := ioutil.ReadAll(r.Body)
+				opts := map[string]string{}
+				assert.NilError(t, json.Unmarshal(body, &opts))
+				assert.Equal(t, opts["state"], tt.state)
+				assert.Equal(t, opts["context"], "PAC")
+				assert.Equal(t, opts["target_url"], "https://url")
+				fmt.Fprint(rw, `{"id": 42}`)
+			})
+			mux.HandleFunc("/repos/owner/repo/issues/5/comments", func(rw http.ResponseWriter, r *http.Request) {
+				gotComment = true
+				fmt.Fprint(rw, `{}`)
+			})
+			ctx, _ := rtesting.SetupFakeContext(t)
+			gtvcs := NewGiteaVCS("token", serverURL)
+			runinfo := &RunInfo{
+				Owner: "owner", Repository: "repo", SHA: "sha",
+				ApplicationName: "PAC", PullRequestNumber: tt.prNumber,
+			}
+			err := gtvcs.CreateStatus(ctx, runinfo, tt.status, tt.conclusion, "text", "https://url")
+			assert.NilError(t, err)
+			assert.Equal(t, gotComment, tt.wantComment)
+		})
+	}
+}
+
+func TestGiteaCreateStatusPullRequestComment(t *testing.T) {
+	tests := []struct {
+		name         string
+		comments     string
+		wantRequests []string
+	}{
+		{
+			name:         "create the comment",
+			comments:     `[{"id": 6, "body": "LGTM", "user": {"login": "reviewer"}}]`,
+			wantRequests: []string{"GET /repos/owner/repo/issues/5/comments", "POST /repos/owner/repo/issues/5/comments"},
+		},
+		{
+			name: "update the comment",
+			comments: `[{"id": 6, "body": "LGTM", "user": {"login": "reviewer"}},
+				{"id": 7, "body": "<!-- pipelines-as-code: PAC -->\n**CI has Started**", "user": {"login": "bot"}}]`,
+			wantRequests: []string{"GET /repos/owner/repo/issues/5/comments", "PATCH /repos/owner/repo/issues/comments/7"},
+		},
+		{
+			name:         "ignore the comment of another account",
+			comments:     `[{"id": 6, "body": "<!-- pipelines-as-code: PAC -->\n**CI has Started**", "user": {"login": "reviewer"}}]`,
+			wantRequests: []string{"GET /repos/owner/repo/issues/5/comments", "POST /repos/owner/repo/issues/5/comments"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux, serverURL, teardown := gttesthelper.SetupGT()
+			defer teardown()
+			mux.HandleFunc("/repos/owner/repo/statuses/sha", func(rw http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(rw, `{"id": 42}`)
+			})
+			mux.HandleFunc("/user", func(rw http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(rw, `{"login": "bot"}`)
+			})
+			requests := []string{}
+			commentHandler := func(rw http.ResponseWriter, r *http.Request) {
+				requests = append(requests, r.Method+" "+r.URL.Path)
+				if r.Method == http.MethodGet {
+					fmt.Fprint(rw, tt.comments)
+					return
+				}
+				comment := map[string]string{}
+				assert.NilError(t, json.NewDecoder(r.Body).Decode(&comment))
+				assert.Assert(t, strings.HasPrefix(comment["body"], "<!-- pipelines-as-code: PAC -->\n**✅ Success**"), comment["body"])
+				fmt.Fprint(rw, `{}`)
+			}
+			mux.HandleFunc("/repos/owner/repo/issues/5/comments", commentHandler)
+			mux.HandleFunc("/repos/owner/repo/issues/comments/7", commentHandler)
+			ctx, _ := rtesting.SetupFakeContext(t)
+			gtvcs := NewGiteaVCS("token", serverURL)
+			runinfo := &RunInfo{
+				Owner: "owner", Repository: "repo", SHA: "sha",
+				ApplicationName: "PAC", PullRequestNumber: 5, PullRequestComment: true,
+			}
+			assert.NilError(t, gtvcs.CreateStatus(ctx, runinfo, "completed", "success", "text", "https://url"))
+			assert.DeepEqual(t, requests, tt.wantRequests)
+		})
+	}
+}
+
+func TestGiteaCreateCheckRun(t *testing.T) {
+	mux, serverURL, teardown := gttesthelper.SetupGT()
+	defer teardown()
+	mux.HandleFunc("/repos/owner/repo/statuses/sha", func(rw http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(rw, `{"id": 42}`)
+	})
+	ctx, _ := rtesting.SetupFakeContext(t)
+	gtvcs := NewGiteaVCS("token", serverURL)
+	runinfo := &RunInfo{Owner: "owner", Repository: "repo", SHA: "sha"}
+	err := gtvcs.CreateCheckRun(ctx, "in_progress", runinfo)
+	assert.NilError(t, err)
+	assert.Equal(t, *runinfo.CheckRunID, int64(42))
+}
+
+func TestGiteaGetFilesChanged(t *testing.T) {
+	mux, serverURL, teardown := gttesthelper.SetupGT()
+	defer teardown()
+	mux.HandleFunc("/repos/owner/repo/pulls/6/files", func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("page") != "1" {
+			fmt.Fprint(rw, `[]`)
+			return
+		}
+		fmt.Fprint(rw, `[{"filename": "pkg/new.go", "previous_filename": "pkg/old.go"}, {"filename": "Makefile"}]`)
+	})
+	mux.HandleFunc("/repos/owner/repo/git/commits/sha", func(rw http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(rw, `{"files": [{"filename": "main.go"}]}`)
+	})
+
+	ctx, _ := rtesting.SetupFakeContext(t)
+	gtvcs := NewGiteaVCS("token", serverURL)
+
+	got, err := gtvcs.GetFilesChanged(ctx, &RunInfo{Owner: "owner", Repository: "repo", SHA: "sha", PullRequestNumber: 6})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, got, []string{"Makefile", "pkg/new.go", "pkg/old.go"})
+
+	got, err = gtvcs.GetFilesChanged(ctx, &RunInfo{
+		Owner: "owner", Repository: "repo", SHA: "sha",
+		Event: &GiteaPushEvent{Commits: []GiteaPayloadCommit{
+			{Added: []string{"docs/index.md"}, Modified: []string{"main.go"}},
+			{Removed: []string{"OWNERS"}, Modified: []string{"main.go"}},
+		}},
+	})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, got, []string{"OWNERS", "docs/index.md", "main.go"})
+
+	got, err = gtvcs.GetFilesChanged(ctx, &RunInfo{Owner: "owner", Repository: "repo", SHA: "sha"})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, got, []string{"main.go"})
+}
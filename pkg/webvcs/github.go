@@ -7,55 +7,49 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"path"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/go-github/v35/github"
+	"github.com/openshift-pipelines/pipelines-as-code/pkg/cache"
 	"go.uber.org/zap"
 	"golang.org/x/oauth2"
 )
 
+var _ Interface = GithubVCS{}
+
+const (
+	// githubPerPage is the maximum number of items the GitHub list APIs
+	// return per page
+	githubPerPage = 100
+	// githubMaxPages bound the number of pages we go through on a list API
+	githubMaxPages = 50
+	// githubConcurrentFetches is the number of files we get at the same time
+	githubConcurrentFetches = 8
+	// maxCheckRunAnnotations is the maximum number of annotations GitHub
+	// takes in a check run update
+	maxCheckRunAnnotations = 50
+	// githubNotAccessibleByIntegration is the message of the Checks API
+	// refusing a token which is not from a GitHub App
+	githubNotAccessibleByIntegration = "Resource not accessible by integration"
+)
+
 type GithubVCS struct {
 	Client *github.Client
-}
-
-// RunInfo Information about current run
-type RunInfo struct {
-	BaseBranch      string // branch against where we are making the PR
-	CheckRunID      *int64
-	DefaultBranch   string
-	Event           interface{}
-	EventType       string
-	HeadBranch      string // branch from where our SHA get tested
-	Owner           string
-	Repository      string
-	SHA             string
-	SHAURL          string
-	Sender          string
-	TriggerTarget   string
-	URL             string
-	LogURL          string
-	SHATitle        string
-	ApplicationName string // The Application Name for example "Pipelines as Code"
-}
-
-// Check check if the runinfo is properly set
-func (r RunInfo) Check() error {
-	if r.SHA != "" && r.BaseBranch != "" &&
-		r.Repository != "" && r.DefaultBranch != "" &&
-		r.HeadBranch != "" && r.Owner != "" && r.URL != "" &&
-		r.Sender != "" && r.EventType != "" && r.TriggerTarget != "" {
-		return nil
-	}
-	return fmt.Errorf("missing values in runInfo")
-}
-
-// DeepCopyInto deep copy runinfo in another instance
-func (r *RunInfo) DeepCopyInto(out *RunInfo) {
-	*out = *r
+	// Cache the blobs and trees we get, nil to not cache them
+	Cache *cache.Cache
+	// CommitStatus report the statuses with the commit status API instead
+	// of the check runs, for the tokens which are not from a GitHub App
+	CommitStatus bool
+	// Login is the account the token is from, the one we comment with. It is
+	// asked to the API when it is empty, which a GitHub App token cannot do.
+	Login string
 }
 
 // NewGithubVCS Create a new GitHub VCS object for token
@@ -65,18 +59,46 @@ func NewGithubVCS(token string, apiURL string) GithubVCS {
 	)
 	tc := oauth2.NewClient(context.Background(), ts)
 
-	var client *github.Client
-	if apiURL != "" {
-		if !strings.HasPrefix(apiURL, "https") {
-			apiURL = "https://" + apiURL
-		}
-		client, _ = github.NewEnterpriseClient(apiURL, apiURL, tc)
-	} else {
-		client = github.NewClient(tc)
-	}
 	return GithubVCS{
-		Client: client,
+		Client: newGithubClient(apiURL, tc),
+	}
+}
+
+// newGithubClient create a go-github client, against GitHub Enterprise if
+// apiURL is set, the calls hitting the rate limits are retried
+func newGithubClient(apiURL string, tc *http.Client) *github.Client {
+	retryClient := *tc
+	retryClient.Transport = newGithubRetryTransport(tc.Transport)
+	tc = &retryClient
+	if apiURL == "" {
+		return github.NewClient(tc)
 	}
+	if !strings.HasPrefix(apiURL, "https://") && !strings.HasPrefix(apiURL, "http://") {
+		apiURL = "https://" + apiURL
+	}
+	client, _ := github.NewEnterpriseClient(apiURL, apiURL, tc)
+	return client
+}
+
+// paginate call listPage with the options of each page of a GitHub list API
+// until there is no next page, listPage returns a nil response to stop early.
+// It gives up after githubMaxPages pages or when the context is cancelled.
+func paginate(ctx context.Context, listPage func(opts *github.ListOptions) (*github.Response, error)) error {
+	opts := &github.ListOptions{PerPage: githubPerPage}
+	for page := 0; page < githubMaxPages; page++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		resp, err := listPage(opts)
+		if err != nil {
+			return err
+		}
+		if resp == nil || resp.NextPage == 0 {
+			return nil
+		}
+		opts.Page = resp.NextPage
+	}
+	return fmt.Errorf("giving up after listing %d pages of results", githubMaxPages)
 }
 
 // payloadFix since we are getting a bunch of \r\n or \n and others from triggers/github, so let just
@@ -119,6 +141,30 @@ func (v GithubVCS) handleReRequestEvent(ctx context.Context, log *zap.SugaredLog
 	return v.getPullRequest(ctx, runinfo, prNumber)
 }
 
+// handleReleaseEvent get the runinfo of a release, it runs on the commit of
+// the release tag
+func (v GithubVCS) handleReleaseEvent(ctx context.Context, event *github.ReleaseEvent) (RunInfo, error) {
+	runinfo := RunInfo{
+		Owner:         event.GetRepo().GetOwner().GetLogin(),
+		Repository:    event.GetRepo().GetName(),
+		DefaultBranch: event.GetRepo().GetDefaultBranch(),
+		URL:           event.GetRepo().GetHTMLURL(),
+		Sender:        event.GetSender().GetLogin(),
+		BaseBranch:    tagRefPrefix + event.GetRelease().GetTagName(),
+		Tag:           event.GetRelease().GetTagName(),
+		EventType:     EventTypeRelease,
+	}
+	runinfo.HeadBranch = runinfo.BaseBranch
+
+	// The tag may be annotated, this gets the commit it points to
+	sha, _, err := v.Client.Repositories.GetCommitSHA1(ctx, runinfo.Owner, runinfo.Repository, runinfo.BaseBranch, "")
+	if err != nil {
+		return runinfo, fmt.Errorf("cannot get the commit of the release tag %s: %w", runinfo.Tag, err)
+	}
+	runinfo.SHA = sha
+	return runinfo, nil
+}
+
 func convertPullRequestURLtoNumber(pullRequest string) (int, error) {
 	prNumber, err := strconv.Atoi(path.Base(pullRequest))
 	if err != nil {
@@ -146,7 +192,12 @@ func (v GithubVCS) handleIssueCommentEvent(ctx context.Context, log *zap.Sugared
 	}
 
 	log.Infof("PR recheck from issue commment on %s/%s#%d has been requested", runinfo.Owner, runinfo.Repository, prNumber)
-	return v.getPullRequest(ctx, runinfo, prNumber)
+	runinfo, err = v.getPullRequest(ctx, runinfo, prNumber)
+	if err != nil {
+		return runinfo, err
+	}
+	setCommentCommand(&runinfo, event.GetComment().GetBody(), event.GetComment().GetUser().GetLogin())
+	return runinfo, nil
 }
 
 // getPullRequest get a pull request details
@@ -167,9 +218,20 @@ func (v GithubVCS) getPullRequest(ctx context.Context, runinfo RunInfo, prNumber
 	runinfo.HeadBranch = pr.GetHead().GetRef()
 	runinfo.BaseBranch = pr.GetBase().GetRef()
 	runinfo.EventType = "pull_request"
+	runinfo.PullRequestNumber = prNumber
+	runinfo.PullRequestLabels = labelNames(pr.Labels)
 	return runinfo, nil
 }
 
+// labelNames get the names of the labels of a pull request
+func labelNames(labels []*github.Label) []string {
+	names := []string{}
+	for _, label := range labels {
+		names = append(names, label.GetName())
+	}
+	return names
+}
+
 // populateCommitInfo get info on a commit in runinfo
 func (v GithubVCS) populateCommitInfo(ctx context.Context, runinfo *RunInfo) error {
 	commit, _, err := v.Client.Git.GetCommit(ctx, runinfo.Owner, runinfo.Repository, runinfo.SHA)
@@ -183,6 +245,57 @@ func (v GithubVCS) populateCommitInfo(ctx context.Context, runinfo *RunInfo) err
 	return nil
 }
 
+// WebhookSignature get the X-Hub-Signature-256 signature of a raw webhook request
+func (v GithubVCS) WebhookSignature(header http.Header) string {
+	return header.Get(SignatureHeader)
+}
+
+// ValidateWebhook check the X-Hub-Signature-256 signature of the payload against the webhook secret
+func (v GithubVCS) ValidateWebhook(secret, signature string, payload []byte) error {
+	return ValidateSignature(secret, signature, payload)
+}
+
+// ParseEventType get the event type from the X-GitHub-Event header and the
+// trigger target of the events we want to run on
+func (v GithubVCS) ParseEventType(header http.Header, payload []byte) (string, string) {
+	eventType := header.Get("X-GitHub-Event")
+	event, err := github.ParseWebHook(eventType, payload)
+	if err != nil {
+		return eventType, ""
+	}
+
+	switch event := event.(type) {
+	case *github.CheckRunEvent:
+		if event.GetAction() == "rerequested" {
+			return eventType, TriggerTargetRecheck
+		}
+	case *github.IssueCommentEvent:
+		if event.GetAction() == "created" && event.GetIssue().IsPullRequest() &&
+			event.GetIssue().GetState() == "open" {
+			return eventType, commentTriggerTarget(event.GetComment().GetBody())
+		}
+	case *github.PushEvent:
+		if !event.GetDeleted() {
+			return eventType, TriggerTargetPush
+		}
+	case *github.ReleaseEvent:
+		if event.GetAction() == "published" {
+			return eventType, TriggerTargetRelease
+		}
+	case *github.PullRequestEvent:
+		// The PipelineRuns may opt in to any action, they are filtered when
+		// matching them
+		switch event.GetAction() {
+		case "":
+		case "closed":
+			return eventType, TriggerTargetPullRequestClosed
+		default:
+			return eventType, TriggerTargetPullRequest
+		}
+	}
+	return eventType, ""
+}
+
 // ParsePayload parse payload event
 func (v GithubVCS) ParsePayload(ctx context.Context, log *zap.SugaredLogger, eventType, triggerTarget, payload string) (*RunInfo, error) {
 	var runinfo RunInfo
@@ -220,21 +333,42 @@ func (v GithubVCS) ParsePayload(ctx context.Context, log *zap.SugaredLogger, eve
 			SHATitle:      event.GetHeadCommit().GetMessage(),
 			Sender:        event.GetSender().GetLogin(),
 			BaseBranch:    event.GetRef(),
-			EventType:     eventType,
 		}
 
 		runinfo.HeadBranch = runinfo.BaseBranch // in push events Head Branch is the same as Basebranch
+		setPushEventType(&runinfo)
+	case *github.ReleaseEvent:
+		runinfo, err = v.handleReleaseEvent(ctx, event)
+		if err != nil {
+			return &runinfo, err
+		}
 	case *github.PullRequestEvent:
 		runinfo = RunInfo{
-			Owner:         event.GetRepo().Owner.GetLogin(),
-			Repository:    event.GetRepo().GetName(),
-			DefaultBranch: event.GetRepo().GetDefaultBranch(),
-			SHA:           event.GetPullRequest().Head.GetSHA(),
-			URL:           event.GetRepo().GetHTMLURL(),
-			BaseBranch:    event.GetPullRequest().Base.GetRef(),
-			HeadBranch:    event.GetPullRequest().Head.GetRef(),
-			Sender:        event.GetPullRequest().GetUser().GetLogin(),
-			EventType:     eventType,
+			Owner:             event.GetRepo().Owner.GetLogin(),
+			Repository:        event.GetRepo().GetName(),
+			DefaultBranch:     event.GetRepo().GetDefaultBranch(),
+			SHA:               event.GetPullRequest().Head.GetSHA(),
+			URL:               event.GetRepo().GetHTMLURL(),
+			BaseBranch:        event.GetPullRequest().Base.GetRef(),
+			HeadBranch:        event.GetPullRequest().Head.GetRef(),
+			Sender:            event.GetPullRequest().GetUser().GetLogin(),
+			EventType:         eventType,
+			PullRequestNumber: event.GetPullRequest().GetNumber(),
+			PullRequestAction: event.GetAction(),
+			PullRequestDraft:  event.GetPullRequest().GetDraft(),
+			PullRequestLabels: labelNames(event.GetPullRequest().Labels),
+		}
+		if event.GetAction() == PullRequestActionLabeled {
+			runinfo.PullRequestLabel = event.GetLabel().GetName()
+		}
+		// The one closing or merging the pull request is the one running the
+		// CI, not the author, i.e: a maintainer merging a contributor change
+		if event.GetAction() == "closed" {
+			runinfo.Sender = event.GetSender().GetLogin()
+			runinfo.EventType = EventTypePullRequestClosed
+			if event.GetPullRequest().GetMerged() {
+				runinfo.EventType = EventTypePullRequestMerged
+			}
 		}
 	default:
 		return &runinfo, errors.New("this event is not supported")
@@ -246,74 +380,146 @@ func (v GithubVCS) ParsePayload(ctx context.Context, log *zap.SugaredLogger, eve
 	}
 
 	runinfo.Event = event
-	runinfo.TriggerTarget = triggerTarget
+	// a comment command has its own trigger target
+	if runinfo.TriggerTarget == "" {
+		runinfo.TriggerTarget = triggerTarget
+	}
 	return &runinfo, nil
 }
 
-// CheckSenderOrgMembership Get sender user's organization. We can
-// only get the one that the user sets as public 🤷
+// CheckSenderOrgMembership check if the sender is a member of the organization
+// owning the repository, private members included since we ask with the app
+// own permissions, or else if the sender has at least the
+// runinfo.CollaboratorPermission permission on the repository.
 func (v GithubVCS) CheckSenderOrgMembership(ctx context.Context, runinfo *RunInfo) (bool, error) {
-	users, resp, err := v.Client.Organizations.ListMembers(ctx, runinfo.Owner,
-		&github.ListMembersOptions{
-			PublicOnly: true, // We can't list private member in a org
-		})
-	// If we are 404 it means we are checking a repo owner and not a org so let's bail out with grace
-	if resp != nil && resp.Response.StatusCode == http.StatusNotFound {
-		return false, nil
-	}
-
+	// 404 means we are checking a repo owner and not a org, so that's just a no
+	isMember, _, err := v.Client.Organizations.IsMember(ctx, runinfo.Owner, runinfo.Sender)
 	if err != nil {
 		return false, err
 	}
-	for _, v := range users {
-		if v.GetLogin() == runinfo.Sender {
-			return true, nil
-		}
+	if isMember {
+		return true, nil
 	}
 
-	return false, nil
+	return v.checkSenderCollaboratorPermission(ctx, runinfo)
 }
 
-// GetStringPullRequestComment return the comment if we find a regexp in one of
-// the comments text of a pull request
-func (v GithubVCS) GetStringPullRequestComment(ctx context.Context, runinfo *RunInfo, reg string) ([]*github.IssueComment, error) {
-	var ret []*github.IssueComment
-	prNumber, err := convertPullRequestURLtoNumber(runinfo.URL)
+// githubPermissionLevel the collaborator permission levels allowed to run the
+// CI, from the lowest to the highest. The lower ones (none, read and triage)
+// are not in there so they can neither be asked for nor be granted.
+var githubPermissionLevel = map[string]int{
+	"write":    1,
+	"maintain": 2,
+	"admin":    3,
+}
+
+// githubCollaboratorPermission the role_name field is not in go-github
+// RepositoryPermissionLevel, it is the only one telling triage and maintain
+// apart from read and write.
+type githubCollaboratorPermission struct {
+	Permission string `json:"permission"`
+	RoleName   string `json:"role_name"`
+}
+
+// checkSenderCollaboratorPermission check if the sender has at least the
+// runinfo.CollaboratorPermission permission level on the repository
+func (v GithubVCS) checkSenderCollaboratorPermission(ctx context.Context, runinfo *RunInfo) (bool, error) {
+	minimum := runinfo.CollaboratorPermission
+	if minimum == "" {
+		minimum = DefaultCollaboratorPermission
+	}
+	minimumLevel, ok := githubPermissionLevel[minimum]
+	if !ok {
+		return false, fmt.Errorf("invalid collaborator permission %q, it needs to be one of write, maintain or admin", minimum)
+	}
+
+	u := fmt.Sprintf("repos/%v/%v/collaborators/%v/permission", runinfo.Owner, runinfo.Repository, runinfo.Sender)
+	req, err := v.Client.NewRequest(http.MethodGet, u, nil)
 	if err != nil {
-		return nil, err
+		return false, err
+	}
+	perm := &githubCollaboratorPermission{}
+	resp, err := v.Client.Do(ctx, req, perm)
+	// The sender is not a user GitHub knows about
+	if resp != nil && resp.Response.StatusCode == http.StatusNotFound {
+		return false, nil
 	}
-	comments, _, err := v.Client.Issues.ListComments(ctx, runinfo.Owner, runinfo.Repository,
-		prNumber, &github.IssueListCommentsOptions{})
 	if err != nil {
-		return nil, err
+		return false, err
 	}
 
+	level := githubPermissionLevel[perm.Permission]
+	if roleLevel, ok := githubPermissionLevel[perm.RoleName]; ok {
+		level = roleLevel
+	}
+	return level >= minimumLevel, nil
+}
+
+// GetStringPullRequestComment return the comment if we find a regexp in one of
+// the comments text of a pull request
+func (v GithubVCS) GetStringPullRequestComment(ctx context.Context, runinfo *RunInfo, reg string) ([]*Comment, error) {
+	var ret []*Comment
 	re := regexp.MustCompile(reg)
-	for _, v := range comments {
-		if string(re.Find([]byte(v.GetBody()))) != "" {
-			ret = append(ret, v)
+	opts := &github.IssueListCommentsOptions{}
+	err := paginate(ctx, func(listOpts *github.ListOptions) (*github.Response, error) {
+		opts.ListOptions = *listOpts
+		comments, resp, err := v.Client.Issues.ListComments(ctx, runinfo.Owner, runinfo.Repository,
+			runinfo.PullRequestNumber, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range comments {
+			if string(re.Find([]byte(v.GetBody()))) != "" {
+				ret = append(ret, &Comment{
+					ID:     v.GetID(),
+					Sender: v.GetUser().GetLogin(),
+					Body:   v.GetBody(),
+				})
+			}
 		}
+		return resp, nil
+	})
+	if err != nil {
+		return nil, err
 	}
 	return ret, nil
 }
 
-// GetTektonDir Get tekton directory from a repository
-func (v GithubVCS) GetTektonDir(ctx context.Context, path string, runinfo *RunInfo) ([]*github.RepositoryContent, error) {
-	fp, objects, resp, err := v.Client.Repositories.GetContents(ctx, runinfo.Owner,
-		runinfo.Repository, path, &github.RepositoryContentGetOptions{Ref: runinfo.SHA})
-
-	if fp != nil {
-		return nil, fmt.Errorf("the object %s is a file instead of a directory", path)
+// GetTektonDir Get all yaml files from the tekton directory of a repository
+// and its subdirectories as one multi document yaml string, sorted by path.
+func (v GithubVCS) GetTektonDir(ctx context.Context, path string, runinfo *RunInfo) (string, error) {
+	// A <sha>:<path> tree-ish gets the whole tree of the directory in one call,
+	// it never changes for a commit so it is cached as well as the tree SHA
+	treeish := fmt.Sprintf("%s:%s", runinfo.SHA, path)
+	treeishKey := cache.Key(runinfo.Owner, runinfo.Repository, treeish)
+	if data, ok := v.Cache.Get(cache.TreeKind, treeishKey); ok {
+		return string(data), nil
 	}
+
+	tree, resp, err := v.Client.Git.GetTree(ctx, runinfo.Owner, runinfo.Repository, treeish, true)
 	if resp != nil && resp.Response.StatusCode == http.StatusNotFound {
-		return nil, nil
+		return "", nil
 	}
-
 	if err != nil {
-		return nil, err
+		return "", err
+	}
+	if tree.GetTruncated() {
+		return "", fmt.Errorf("there is too many files in the %s directory to list them all", path)
 	}
 
-	return objects, nil
+	treeKey := cache.Key(runinfo.Owner, runinfo.Repository, tree.GetSHA())
+	if data, ok := v.Cache.Get(cache.TreeKind, treeKey); ok {
+		v.Cache.Set(cache.TreeKind, treeishKey, data)
+		return string(data), nil
+	}
+
+	allTemplates, err := v.concatAllYamlFiles(ctx, tree.Entries, runinfo)
+	if err != nil {
+		return "", err
+	}
+	v.Cache.Set(cache.TreeKind, treeKey, []byte(allTemplates))
+	v.Cache.Set(cache.TreeKind, treeishKey, []byte(allTemplates))
+	return allTemplates, nil
 }
 
 // GetFileInsideRepo Get a file via Github API using the runinfo information, we
@@ -347,40 +553,175 @@ func (v GithubVCS) GetFileInsideRepo(ctx context.Context, path string, branch bo
 // GetFileFromDefaultBranch will get a file directly from the Default BaseBranch as
 // configured in runinfo which is directly set in webhook by Github
 func (v GithubVCS) GetFileFromDefaultBranch(ctx context.Context, path string, runinfo *RunInfo) (string, error) {
-	runInfoOnMain := &RunInfo{}
-	runinfo.DeepCopyInto(runInfoOnMain)
-	runInfoOnMain.BaseBranch = runInfoOnMain.DefaultBranch
+	return getFileFromDefaultBranch(ctx, v, path, runinfo)
+}
 
-	tektonyaml, err := v.GetFileInsideRepo(ctx, path, true, runInfoOnMain)
+// GetBranchSHA get the SHA of the head commit of a branch
+func (v GithubVCS) GetBranchSHA(ctx context.Context, branch string, runinfo *RunInfo) (string, error) {
+	ghbranch, _, err := v.Client.Repositories.GetBranch(ctx, runinfo.Owner, runinfo.Repository, branch)
 	if err != nil {
-		return "", fmt.Errorf("cannot find %s inside the %s branch: %w", path, runInfoOnMain.BaseBranch, err)
+		return "", err
 	}
-	return tektonyaml, err
+	return ghbranch.GetCommit().GetSHA(), nil
 }
 
-// ConcatAllYamlFiles concat all yaml files from a directory as one big multi document yaml string
-// TODO: trash the tekton.yaml condition when we don't need it anymore
-func (v GithubVCS) ConcatAllYamlFiles(ctx context.Context, objects []*github.RepositoryContent, runinfo *RunInfo) (string, error) {
-	var allTemplates string
-
-	for _, value := range objects {
-		if strings.HasSuffix(value.GetName(), ".yaml") ||
-			strings.HasSuffix(value.GetName(), ".yml") {
-			data, err := v.GetObject(ctx, value.GetSHA(), runinfo)
+// githubMaxCompareFiles is the number of files GitHub lists at most for a
+// comparison, whatever the page, the files of each of its commits are listed
+// when it is reached
+const githubMaxCompareFiles = 300
+
+// GetFilesChanged get the files changed by the pull request or by the commits
+// of the push, a push creating a branch or a recheck of a push only has the
+// files changed by its head commit
+func (v GithubVCS) GetFilesChanged(ctx context.Context, runinfo *RunInfo) ([]string, error) {
+	files := changedFiles{}
+	if runinfo.PullRequestNumber != 0 {
+		err := paginate(ctx, func(listOpts *github.ListOptions) (*github.Response, error) {
+			prFiles, resp, err := v.Client.PullRequests.ListFiles(ctx, runinfo.Owner, runinfo.Repository,
+				runinfo.PullRequestNumber, listOpts)
 			if err != nil {
-				return "", err
+				return nil, err
 			}
-			if allTemplates != "" && !strings.HasPrefix(string(data), "---") {
-				allTemplates += "---"
+			for _, file := range prFiles {
+				files.add(file.GetFilename(), file.GetPreviousFilename())
 			}
-			allTemplates += "\n" + string(data) + "\n"
+			return resp, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		return files.list(), nil
+	}
+
+	var err error
+	if event, ok := runinfo.Event.(*github.PushEvent); ok && !isZeroSHA(event.GetBefore()) {
+		err = v.listComparisonFiles(ctx, runinfo, event.GetBefore(), files)
+	} else {
+		err = v.listCommitFiles(ctx, runinfo, runinfo.SHA, files)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return files.list(), nil
+}
+
+// listComparisonFiles add the files changed between base and runinfo.SHA,
+// going through the files of each commit when the comparison lists too many
+// of them to have them all
+func (v GithubVCS) listComparisonFiles(ctx context.Context, runinfo *RunInfo, base string, files changedFiles) error {
+	u := fmt.Sprintf("repos/%v/%v/compare/%v...%v", runinfo.Owner, runinfo.Repository,
+		url.QueryEscape(base), url.QueryEscape(runinfo.SHA))
+	comparisonFiles := 0
+	commits := []string{}
+	err := paginate(ctx, func(listOpts *github.ListOptions) (*github.Response, error) {
+		comparison := &github.CommitsComparison{}
+		resp, err := v.getPage(ctx, u, listOpts, comparison)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range comparison.Files {
+			files.add(file.GetFilename(), file.GetPreviousFilename())
+		}
+		comparisonFiles += len(comparison.Files)
+		for _, commit := range comparison.Commits {
+			commits = append(commits, commit.GetSHA())
 		}
+		return resp, nil
+	})
+	if err != nil || comparisonFiles < githubMaxCompareFiles {
+		return err
+	}
+
+	for _, sha := range commits {
+		if err := v.listCommitFiles(ctx, runinfo, sha, files); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listCommitFiles add the files changed by the commit sha, a commit lists its
+// files by pages of 300
+func (v GithubVCS) listCommitFiles(ctx context.Context, runinfo *RunInfo, sha string, files changedFiles) error {
+	u := fmt.Sprintf("repos/%v/%v/commits/%v", runinfo.Owner, runinfo.Repository, sha)
+	return paginate(ctx, func(listOpts *github.ListOptions) (*github.Response, error) {
+		commit := &github.RepositoryCommit{}
+		resp, err := v.getPage(ctx, u, listOpts, commit)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range commit.Files {
+			files.add(file.GetFilename(), file.GetPreviousFilename())
+		}
+		return resp, nil
+	})
+}
+
+// getPage get a page of u in ret, for the endpoints go-github does not
+// paginate
+func (v GithubVCS) getPage(ctx context.Context, u string, listOpts *github.ListOptions, ret interface{}) (*github.Response, error) {
+	query := url.Values{}
+	query.Set("per_page", strconv.Itoa(listOpts.PerPage))
+	if listOpts.Page != 0 {
+		query.Set("page", strconv.Itoa(listOpts.Page))
+	}
+	req, err := v.Client.NewRequest(http.MethodGet, u+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return v.Client.Do(ctx, req, ret)
+}
+
+// concatAllYamlFiles concat all yaml files of a tree as one big multi document
+// yaml string, the files are fetched concurrently and concatenated sorted by
+// their path
+func (v GithubVCS) concatAllYamlFiles(ctx context.Context, entries []*github.TreeEntry, runinfo *RunInfo) (string, error) {
+	var yamlEntries []*github.TreeEntry
+	for _, entry := range entries {
+		if entry.GetType() == "blob" &&
+			(strings.HasSuffix(entry.GetPath(), ".yaml") || strings.HasSuffix(entry.GetPath(), ".yml")) {
+			yamlEntries = append(yamlEntries, entry)
+		}
+	}
+	sort.Slice(yamlEntries, func(i, j int) bool {
+		return yamlEntries[i].GetPath() < yamlEntries[j].GetPath()
+	})
+
+	contents := make([][]byte, len(yamlEntries))
+	errs := make([]error, len(yamlEntries))
+	sem := make(chan struct{}, githubConcurrentFetches)
+	var wg sync.WaitGroup
+	for i, entry := range yamlEntries {
+		wg.Add(1)
+		go func(i int, sha string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			contents[i], errs[i] = v.GetObject(ctx, sha, runinfo)
+		}(i, entry.GetSHA())
+	}
+	wg.Wait()
+
+	var allTemplates string
+	for i, data := range contents {
+		if errs[i] != nil {
+			return "", errs[i]
+		}
+		if allTemplates != "" && !strings.HasPrefix(string(data), "---") {
+			allTemplates += "---"
+		}
+		allTemplates += "\n" + string(data) + "\n"
 	}
 	return allTemplates, nil
 }
 
-// GetObject Get an object from a repository
+// GetObject Get an object from a repository, the objects are cached by their SHA
 func (v GithubVCS) GetObject(ctx context.Context, sha string, runinfo *RunInfo) ([]byte, error) {
+	key := cache.Key(runinfo.Owner, runinfo.Repository, sha)
+	if data, ok := v.Cache.Get(cache.BlobKind, key); ok {
+		return data, nil
+	}
+
 	blob, _, err := v.Client.Git.GetBlob(ctx, runinfo.Owner, runinfo.Repository, sha)
 	if err != nil {
 		return nil, err
@@ -390,10 +731,17 @@ func (v GithubVCS) GetObject(ctx context.Context, sha string, runinfo *RunInfo)
 	if err != nil {
 		return nil, err
 	}
+	v.Cache.Set(cache.BlobKind, key, decoded)
 	return decoded, err
 }
 
-func (v GithubVCS) CreateCheckRun(ctx context.Context, status string, runinfo *RunInfo) (*github.CheckRun, error) {
+// CreateCheckRun create a check run and set its ID in runinfo, it falls
+// back to a commit status if the token cannot use the Checks API
+func (v GithubVCS) CreateCheckRun(ctx context.Context, status string, runinfo *RunInfo) error {
+	if v.CommitStatus || runinfo.CommitStatus {
+		return v.createCommitStatus(ctx, runinfo, status)
+	}
+
 	now := github.Timestamp{Time: time.Now()}
 	checkrunoption := github.CreateCheckRunOptions{
 		Name:       runinfo.ApplicationName,
@@ -404,34 +752,83 @@ func (v GithubVCS) CreateCheckRun(ctx context.Context, status string, runinfo *R
 	}
 
 	checkRun, _, err := v.Client.Checks.CreateCheckRun(ctx, runinfo.Owner, runinfo.Repository, checkrunoption)
-	return checkRun, err
+	if isNotAccessibleByIntegration(err) {
+		// Only the GitHub Apps can create check runs
+		runinfo.CommitStatus = true
+		return v.createCommitStatus(ctx, runinfo, status)
+	}
+	if err != nil {
+		return err
+	}
+	runinfo.CheckRunID = checkRun.ID
+	return nil
 }
 
-func (v GithubVCS) CreateStatus(ctx context.Context, runinfo *RunInfo, status, conclusion, text, detailsURL string) (*github.CheckRun, error) {
-	now := github.Timestamp{Time: time.Now()}
+// createCommitStatus create the pending commit status of runinfo and set its
+// ID in runinfo
+func (v GithubVCS) createCommitStatus(ctx context.Context, runinfo *RunInfo, status string) error {
+	title, _ := statusTitleSummary(runinfo, status, "")
+	repoStatus, err := v.setCommitStatus(ctx, runinfo, githubState(status, ""), title, runinfo.LogURL)
+	if err != nil {
+		return err
+	}
+	runinfo.CheckRunID = repoStatus.ID
+	return nil
+}
 
-	var summary, title string
+// setCommitStatus set the state of the runinfo commit status, its context is
+// the runinfo application name to have one status per PipelineRun
+func (v GithubVCS) setCommitStatus(ctx context.Context, runinfo *RunInfo, state, description, detailsURL string) (*github.RepoStatus, error) {
+	repoStatus := &github.RepoStatus{
+		State:       github.String(state),
+		Context:     github.String(runinfo.ApplicationName),
+		Description: github.String(description),
+	}
+	if detailsURL != "" {
+		repoStatus.TargetURL = github.String(detailsURL)
+	}
+	repoStatus, _, err := v.Client.Repositories.CreateStatus(ctx, runinfo.Owner, runinfo.Repository, runinfo.SHA, repoStatus)
+	return repoStatus, err
+}
 
+// githubState is the commit status state of a check run status and
+// conclusion. A commit status has no state for a run which did not verify the
+// commit: a skipped run or one waiting for an approval stays pending until it
+// runs, a cancelled run or one we don't know the outcome of is an error, like
+// the canceled and stopped states of the other providers.
+func githubState(status, conclusion string) string {
+	if status != "completed" {
+		return "pending"
+	}
 	switch conclusion {
 	case "success":
-		title = "✅ Success"
-		summary = fmt.Sprintf("%s has successfully validated your commit.", runinfo.ApplicationName)
-	case "failure":
-		title = "❌ Failed"
-		summary = fmt.Sprintf("%s has <b>failed</b>.", runinfo.ApplicationName)
-	case "skipped":
-		title = "➖ Skipped"
-		summary = fmt.Sprintf("%s is skipping this commit.", runinfo.ApplicationName)
-	case "neutral":
-		title = "❓ Unknown"
-		summary = fmt.Sprintf("%s doesn't know what happened with this commit.", runinfo.ApplicationName)
+		return "success"
+	case "failure", "timed_out":
+		return "failure"
+	case "skipped", "action_required":
+		return "pending"
 	}
+	return "error"
+}
+
+// isNotAccessibleByIntegration tells if err is the 403 response of the
+// Checks API to a token which is not from a GitHub App
+func isNotAccessibleByIntegration(err error) bool {
+	var errResp *github.ErrorResponse
+	return errors.As(err, &errResp) && errResp.Response != nil &&
+		errResp.Response.StatusCode == http.StatusForbidden && errResp.Message == githubNotAccessibleByIntegration
+}
 
-	if status == "in_progress" {
-		title = "CI has Started"
-		summary = fmt.Sprintf("%s is running.", runinfo.ApplicationName)
+// CreateStatus update the check run of runinfo, or its commit status
+func (v GithubVCS) CreateStatus(ctx context.Context, runinfo *RunInfo, status, conclusion, text, detailsURL string) error {
+	if v.CommitStatus || runinfo.CommitStatus {
+		return v.createStatusCommitStatus(ctx, runinfo, status, conclusion, text, detailsURL)
 	}
 
+	now := github.Timestamp{Time: time.Now()}
+
+	title, summary := statusTitleSummary(runinfo, status, conclusion)
+
 	checkRunOutput := &github.CheckRunOutput{
 		Title:   &title,
 		Summary: &summary,
@@ -452,8 +849,99 @@ func (v GithubVCS) CreateStatus(ctx context.Context, runinfo *RunInfo, status, c
 	if conclusion != "" {
 		opts.CompletedAt = &now
 		opts.Conclusion = &conclusion
+		checkRunOutput.Annotations = checkRunAnnotations(runinfo.Annotations)
+	}
+
+	_, _, err := v.Client.Checks.UpdateCheckRun(ctx, runinfo.Owner, runinfo.Repository, *runinfo.CheckRunID, opts)
+	if err != nil || !runinfo.PullRequestComment || runinfo.PullRequestNumber == 0 {
+		return err
+	}
+	return v.createOrUpdatePullRequestComment(ctx, runinfo, markedStatusComment(runinfo, status, conclusion, text))
+}
+
+// createStatusCommitStatus set the commit status and when completed report
+// the details as a comment on the pull request, a commit status has no room
+// for them
+func (v GithubVCS) createStatusCommitStatus(ctx context.Context, runinfo *RunInfo, status, conclusion, text, detailsURL string) error {
+	title, _ := statusTitleSummary(runinfo, status, conclusion)
+	if _, err := v.setCommitStatus(ctx, runinfo, githubState(status, conclusion), title, detailsURL); err != nil {
+		return err
 	}
 
-	checkRun, _, err := v.Client.Checks.UpdateCheckRun(ctx, runinfo.Owner, runinfo.Repository, *runinfo.CheckRunID, opts)
-	return checkRun, err
+	if runinfo.PullRequestNumber == 0 {
+		return nil
+	}
+	if runinfo.PullRequestComment {
+		return v.createOrUpdatePullRequestComment(ctx, runinfo, markedStatusComment(runinfo, status, conclusion, text))
+	}
+	if status != "completed" {
+		return nil
+	}
+
+	body := statusComment(runinfo, status, conclusion, text)
+	_, _, err := v.Client.Issues.CreateComment(ctx, runinfo.Owner, runinfo.Repository, runinfo.PullRequestNumber,
+		&github.IssueComment{Body: &body})
+	return err
+}
+
+// createOrUpdatePullRequestComment edit the comment of the runinfo pull
+// request marked for the runinfo application, or create it
+func (v GithubVCS) createOrUpdatePullRequestComment(ctx context.Context, runinfo *RunInfo, body string) error {
+	self, err := v.self(ctx)
+	if err != nil {
+		return err
+	}
+	comment, err := findPullRequestComment(ctx, v, runinfo, runinfo.ApplicationName, self)
+	if err != nil {
+		return err
+	}
+	if comment != nil {
+		_, _, err = v.Client.Issues.EditComment(ctx, runinfo.Owner, runinfo.Repository, comment.ID,
+			&github.IssueComment{Body: &body})
+		return err
+	}
+	_, _, err = v.Client.Issues.CreateComment(ctx, runinfo.Owner, runinfo.Repository, runinfo.PullRequestNumber,
+		&github.IssueComment{Body: &body})
+	return err
+}
+
+// self get the login of the account the token is from
+func (v GithubVCS) self(ctx context.Context) (string, error) {
+	if v.Login != "" {
+		return v.Login, nil
+	}
+	user, _, err := v.Client.Users.Get(ctx, "")
+	if err != nil {
+		return "", fmt.Errorf("cannot get the account of the token: %w", err)
+	}
+	return user.GetLogin(), nil
+}
+
+// checkRunAnnotations convert the annotations to the check run ones, the
+// ones over maxCheckRunAnnotations are dropped
+func checkRunAnnotations(annotations []Annotation) []*github.CheckRunAnnotation {
+	if len(annotations) > maxCheckRunAnnotations {
+		annotations = annotations[:maxCheckRunAnnotations]
+	}
+	ghAnnotations := make([]*github.CheckRunAnnotation, 0, len(annotations))
+	for _, annotation := range annotations {
+		startLine, endLine := annotation.StartLine, annotation.EndLine
+		// the start line is mandatory, the first one is where the whole
+		// file is annotated
+		if startLine < 1 {
+			startLine = 1
+		}
+		if endLine < startLine {
+			endLine = startLine
+		}
+		ghAnnotations = append(ghAnnotations, &github.CheckRunAnnotation{
+			Path:            github.String(annotation.Path),
+			StartLine:       github.Int(startLine),
+			EndLine:         github.Int(endLine),
+			AnnotationLevel: github.String(annotation.Level),
+			Title:           github.String(annotation.Title),
+			Message:         github.String(annotation.Message),
+		})
+	}
+	return ghAnnotations
 }
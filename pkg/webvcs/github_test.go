@@ -1,12 +1,11 @@
 package webvcs
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
-	"path/filepath"
-	"reflect"
 	"strings"
 	"testing"
 
@@ -15,6 +14,7 @@ import (
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-github/v35/github"
+	"github.com/openshift-pipelines/pipelines-as-code/pkg/cache"
 	"go.uber.org/zap"
 	zapobserver "go.uber.org/zap/zaptest/observer"
 	"gotest.tools/v3/assert"
@@ -79,31 +79,79 @@ func setupFakesURLS() (client GithubVCS, teardown func()) {
 
 	mux.HandleFunc("/repos/tekton/dir/contents/.tekton", func(w http.ResponseWriter, r *http.Request) {
 		_, _ = fmt.Fprint(w, `[{
-
-				  "name": "pipeline.yaml",
-				  "path": ".tekton/pipeline.yaml",
-				  "sha": "pipelineyaml",
-				  "type": "file"
-				},
-				{
 				  "name": "run.yaml",
 				  "path": ".tekton/run.yaml",
-				  "sha": "runyaml",
 				  "type": "file"
 				}]`)
 	})
+
 	mux.HandleFunc("/repos/throw/error/contents/.tekton", func(w http.ResponseWriter, r *http.Request) {
 		_, _ = fmt.Fprint(w, "ERRROR")
 	})
 
-	mux.HandleFunc("/repos/its/afile/contents/.tekton", func(w http.ResponseWriter, r *http.Request) {
-		_, _ = fmt.Fprint(w, `{
-"name": ".tekton",
-"sha": "decfae2653959f7c6c25f21f026c3819bea41ecf",
-"type": "file",
-"content": "WyFbQ29udGFpbmVyIFJlcG9zaXRvcnkgb24gUXVheV0oaHR0cHM6Ly9xdWF5\nLmlvL3JlcG9zaXRvcnkvY2htb3VlbC90ZWt0b24tYXNhLWNvZGUvc3RhdHVz"}`)
+	// the entries are not sorted by path to make sure we sort them
+	mux.HandleFunc("/repos/tekton/dir/git/trees/:.tekton", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("recursive") != "1" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_, _ = fmt.Fprint(w, `{"tree": [{
+				  "path": "run.yaml",
+				  "sha": "runyaml",
+				  "type": "blob"
+				},
+				{
+				  "path": "pipeline.yaml",
+				  "sha": "pipelineyaml",
+				  "type": "blob"
+				},
+				{
+				  "path": "README.md",
+				  "sha": "readme",
+				  "type": "blob"
+				}]}`)
 	})
-	mux.HandleFunc("/repos/pas/la/contents/.tekton", func(w http.ResponseWriter, r *http.Request) {
+
+	mux.HandleFunc("/repos/tekton/nested/git/trees/:.tekton", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, `{"tree": [{
+				  "path": "tasks",
+				  "sha": "taskstree",
+				  "type": "tree"
+				},
+				{
+				  "path": "tasks/task.yml",
+				  "sha": "tektonyaml",
+				  "type": "blob"
+				},
+				{
+				  "path": "run.yaml",
+				  "sha": "runyaml",
+				  "type": "blob"
+				}]}`)
+	})
+	mux.HandleFunc("/repos/tekton/nested/git/blobs/runyaml", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, `{"content": "aGVsbG8gcnVueWFtbA==", "encoding": "base64"}`)
+	})
+	mux.HandleFunc("/repos/tekton/nested/git/blobs/tektonyaml", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, `{"content": "aGVsbG8gdGVrdG9ueWFtbA==", "encoding": "base64"}`)
+	})
+
+	mux.HandleFunc("/repos/throw/error/git/trees/:.tekton", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, "ERRROR")
+	})
+
+	mux.HandleFunc("/repos/too/many/git/trees/:.tekton", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, `{"tree": [], "truncated": true}`)
+	})
+
+	mux.HandleFunc("/repos/blob/error/git/trees/:.tekton", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, `{"tree": [{"path": "run.yaml", "sha": "runyaml", "type": "blob"}]}`)
+	})
+	mux.HandleFunc("/repos/blob/error/git/blobs/runyaml", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	mux.HandleFunc("/repos/pas/la/git/trees/:.tekton", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusNotFound)
 	})
 
@@ -151,7 +199,8 @@ func TestParsePayloadRerequestFromPullRequest(t *testing.T) {
 	fakeclient, mux, _, teardown := ghtesthelper.SetupGH()
 	defer teardown()
 	mux.HandleFunc("/repos/"+prOwner+"/"+repoName+"/pulls/"+prNumber, func(rw http.ResponseWriter, r *http.Request) {
-		_, _ = fmt.Fprintf(rw, `{"head": {"sha": "%s", "ref": "123"}, "user": {"login": "%s"}}`, sha, prOwner)
+		_, _ = fmt.Fprintf(rw, `{"head": {"sha": "%s", "ref": "123"}, "user": {"login": "%s"},
+			"labels": [{"name": "run-e2e"}]}`, sha, prOwner)
 	})
 	mux.HandleFunc("/repos/owner/repo/commits/"+sha, func(w http.ResponseWriter, r *http.Request) {
 		_, _ = fmt.Fprint(w, `{"commit": {"message": "HELLO"}}`)
@@ -167,6 +216,7 @@ func TestParsePayloadRerequestFromPullRequest(t *testing.T) {
 	// TODO
 	runinfo, err := gvcs.ParsePayload(ctx, logger, "check_run", "issue-recheck", checkrunEvent)
 	assert.NilError(t, err)
+	assert.DeepEqual(t, runinfo.PullRequestLabels, []string{"run-e2e"})
 
 	assert.Equal(t, prOwner, runinfo.Owner)
 	assert.Equal(t, repoName, runinfo.Repository)
@@ -262,8 +312,14 @@ func TestParsePayLoadRetest(t *testing.T) {
 	"pull_request": {
 	  "html_url": "https://github.com/%s/%s/pull/%s"
 	}
+  },
+  "comment": {
+	"body": "Looks like a flake\r\n/test e2e",
+	"user": {
+	  "login": "%s"
+	}
   }
-}`, issueSender, repoName, prOwner, repoName, repoOwner, prNumber)
+}`, issueSender, repoName, prOwner, repoName, repoOwner, prNumber, issueSender)
 
 	ctx, _ := rtesting.SetupFakeContext(t)
 	logger, observer := getLogger()
@@ -280,6 +336,10 @@ func TestParsePayLoadRetest(t *testing.T) {
 	firstObservedMessage := observer.TakeAll()[0].Message
 	assert.Assert(t, strings.Contains(firstObservedMessage, "recheck"))
 	assert.Equal(t, runinfo.EventType, "pull_request")
+	// The ACL applies to the comment author
+	assert.Equal(t, runinfo.Sender, issueSender)
+	assert.Equal(t, runinfo.TargetPipelineRun, "e2e")
+	assert.Equal(t, runinfo.TriggerTarget, TriggerTargetTestComment)
 }
 
 func TestParsePayload(t *testing.T) {
@@ -305,9 +365,117 @@ func TestParsePayload(t *testing.T) {
 	assert.Assert(t, runinfo.Owner == "chmouel")
 	assert.Assert(t, runinfo.Repository == "scratchpad")
 	assert.Equal(t, runinfo.EventType, "pull_request")
+	assert.Equal(t, runinfo.PullRequestAction, "opened")
+	assert.Assert(t, !runinfo.PullRequestDraft)
 	assert.Assert(t, runinfo.URL == "https://github.com/chmouel/scratchpad")
 }
 
+func TestParsePayloadPullRequestClosed(t *testing.T) {
+	tests := []struct {
+		name          string
+		merged        bool
+		wantEventType string
+	}{
+		{
+			name:          "closed",
+			wantEventType: "pull_request_closed",
+		},
+		{
+			name:          "merged",
+			merged:        true,
+			wantEventType: "pull_request_merged",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeclient, mux, _, teardown := ghtesthelper.SetupGH()
+			defer teardown()
+			mux.HandleFunc("/repos/owner/repo/git/commits/prsha", func(w http.ResponseWriter, r *http.Request) {
+				_, _ = fmt.Fprint(w, `{"message": "A change"}`)
+			})
+			ctx, _ := rtesting.SetupFakeContext(t)
+			logger, _ := getLogger()
+
+			payload := fmt.Sprintf(`{"action": "closed", "number": 6, "pull_request": {"number": 6, "merged": %t,
+				"user": {"login": "author"}, "labels": [{"name": "bug"}, {"name": "run-e2e"}], "head": {"sha": "prsha", "ref": "feature"}, "base": {"ref": "main"}},
+				"repository": {"name": "repo", "owner": {"login": "owner"}, "default_branch": "main",
+				"html_url": "https://github.com/owner/repo"}, "sender": {"login": "maintainer"}}`, tt.merged)
+			gvcs := GithubVCS{Client: fakeclient}
+			runinfo, err := gvcs.ParsePayload(ctx, logger, "pull_request", TriggerTargetPullRequestClosed, payload)
+			assert.NilError(t, err)
+			assert.Equal(t, runinfo.EventType, tt.wantEventType)
+			assert.Equal(t, runinfo.PullRequestNumber, 6)
+			assert.Equal(t, runinfo.Sender, "maintainer")
+			assert.Equal(t, runinfo.SHA, "prsha")
+			assert.DeepEqual(t, runinfo.PullRequestLabels, []string{"bug", "run-e2e"})
+			assert.NilError(t, runinfo.Check())
+		})
+	}
+}
+
+func TestParsePayloadTags(t *testing.T) {
+	repository := `"repository": {"name": "repo", "owner": {"login": "owner"}, "default_branch": "main",
+		"html_url": "https://github.com/owner/repo"}, "sender": {"login": "sender"}`
+	tests := []struct {
+		name          string
+		eventType     string
+		payload       string
+		wantEventType string
+		wantErr       string
+	}{
+		{
+			name:      "tag push",
+			eventType: "push",
+			payload: fmt.Sprintf(`{"ref": "refs/tags/v1.2.3", "head_commit": {"id": "tagsha"}, %s}`,
+				repository),
+			wantEventType: "tag_push",
+		},
+		{
+			name:          "release",
+			eventType:     "release",
+			payload:       fmt.Sprintf(`{"action": "published", "release": {"tag_name": "v1.2.3"}, %s}`, repository),
+			wantEventType: "release",
+		},
+		{
+			name:      "release of an unknown tag",
+			eventType: "release",
+			payload:   fmt.Sprintf(`{"action": "published", "release": {"tag_name": "v0.0.0"}, %s}`, repository),
+			wantErr:   "cannot get the commit of the release tag v0.0.0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeclient, mux, _, teardown := ghtesthelper.SetupGH()
+			defer teardown()
+			mux.HandleFunc("/repos/owner/repo/commits/refs/tags/v1.2.3", func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, r.Header.Get("Accept"), "application/vnd.github.v3.sha")
+				_, _ = fmt.Fprint(w, "tagsha")
+			})
+			mux.HandleFunc("/repos/owner/repo/git/commits/tagsha", func(w http.ResponseWriter, r *http.Request) {
+				_, _ = fmt.Fprint(w, `{"message": "Release v1.2.3"}`)
+			})
+			ctx, _ := rtesting.SetupFakeContext(t)
+			logger, _ := getLogger()
+
+			gvcs := GithubVCS{Client: fakeclient}
+			runinfo, err := gvcs.ParsePayload(ctx, logger, tt.eventType, "target", tt.payload)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, runinfo.EventType, tt.wantEventType)
+			assert.Equal(t, runinfo.Tag, "v1.2.3")
+			assert.Equal(t, runinfo.SHA, "tagsha")
+			assert.Equal(t, runinfo.SHATitle, "Release v1.2.3")
+			assert.Equal(t, runinfo.BaseBranch, "refs/tags/v1.2.3")
+			assert.Equal(t, runinfo.HeadBranch, "refs/tags/v1.2.3")
+			assert.Equal(t, runinfo.Sender, "sender")
+			assert.NilError(t, runinfo.Check())
+		})
+	}
+}
+
 func TestParsePayloadInvalid(t *testing.T) {
 	ctx, _ := rtesting.SetupFakeContext(t)
 	gvcs := NewGithubVCS("none", "")
@@ -415,7 +583,7 @@ func TestGetTektonDir(t *testing.T) {
 	type args struct {
 		path      string
 		runinfo   *RunInfo
-		assertion func(t *testing.T, got []*github.RepositoryContent, err error)
+		assertion func(t *testing.T, got string, err error)
 	}
 
 	testGetTektonDir := []struct {
@@ -425,9 +593,9 @@ func TestGetTektonDir(t *testing.T) {
 		{
 			name: "testgood",
 			args: args{
-				assertion: func(t *testing.T, got []*github.RepositoryContent, err error) {
+				assertion: func(t *testing.T, got string, err error) {
 					assert.NilError(t, err)
-					assert.Assert(t, got != nil)
+					assert.Assert(t, got != "")
 				},
 				path: ".tekton",
 				runinfo: &RunInfo{
@@ -439,9 +607,9 @@ func TestGetTektonDir(t *testing.T) {
 		{
 			name: "notfound",
 			args: args{
-				assertion: func(t *testing.T, got []*github.RepositoryContent, err error) {
+				assertion: func(t *testing.T, got string, err error) {
 					assert.NilError(t, err)
-					assert.Assert(t, got == nil)
+					assert.Assert(t, got == "")
 				},
 				path: ".tekton",
 				runinfo: &RunInfo{
@@ -451,25 +619,39 @@ func TestGetTektonDir(t *testing.T) {
 			},
 		},
 		{
-			name: "tektondirisafile",
+			name: "toomanyfiles",
+			args: args{
+				assertion: func(t *testing.T, got string, err error) {
+					assert.Error(t, err, "there is too many files in the .tekton directory to list them all")
+					assert.Assert(t, got == "")
+				},
+				path: ".tekton",
+				runinfo: &RunInfo{
+					Owner:      "too",
+					Repository: "many",
+				},
+			},
+		},
+		{
+			name: "bloberror",
 			args: args{
-				assertion: func(t *testing.T, got []*github.RepositoryContent, err error) {
-					assert.Error(t, err, "the object .tekton is a file instead of a directory")
-					assert.Assert(t, got == nil)
+				assertion: func(t *testing.T, got string, err error) {
+					assert.ErrorContains(t, err, "500")
+					assert.Assert(t, got == "")
 				},
 				path: ".tekton",
 				runinfo: &RunInfo{
-					Owner:      "its",
-					Repository: "afile",
+					Owner:      "blob",
+					Repository: "error",
 				},
 			},
 		},
 		{
 			name: "throwerror",
 			args: args{
-				assertion: func(t *testing.T, got []*github.RepositoryContent, err error) {
+				assertion: func(t *testing.T, got string, err error) {
 					assert.ErrorContains(t, err, "invalid character")
-					assert.Assert(t, got == nil)
+					assert.Assert(t, got == "")
 				},
 				path: ".tekton",
 				runinfo: &RunInfo{
@@ -501,16 +683,67 @@ hello runyaml
 		Repository: "dir",
 	}
 
-	ghr, err := gcvs.GetTektonDir(ctx, ".tekton", runinfo)
+	got, err := gcvs.GetTektonDir(ctx, ".tekton", runinfo)
 	assert.NilError(t, err)
+	if d := cmp.Diff(got, expected); d != "" {
+		t.Fatalf("-got, +want: %v", d)
+	}
+}
+
+func TestGetTektonDirNested(t *testing.T) {
+	const expected = `
+hello runyaml
+---
+hello tektonyaml
+`
+	ctx, _ := rtesting.SetupFakeContext(t)
+	gcvs, teardown := setupFakesURLS()
+	defer teardown()
+	runinfo := &RunInfo{
+		Owner:      "tekton",
+		Repository: "nested",
+	}
 
-	got, err := gcvs.ConcatAllYamlFiles(ctx, ghr, runinfo)
+	got, err := gcvs.GetTektonDir(ctx, ".tekton", runinfo)
 	assert.NilError(t, err)
 	if d := cmp.Diff(got, expected); d != "" {
 		t.Fatalf("-got, +want: %v", d)
 	}
 }
 
+func TestGetTektonDirCache(t *testing.T) {
+	ctx, _ := rtesting.SetupFakeContext(t)
+	fakeclient, mux, _, teardown := ghtesthelper.SetupGH()
+	defer teardown()
+	calls := map[string]int{}
+	mux.HandleFunc("/repos/owner/repo/git/trees/", func(w http.ResponseWriter, r *http.Request) {
+		calls[r.URL.Path]++
+		_, _ = fmt.Fprint(w, `{"sha": "treesha", "tree": [{"path": "run.yaml", "sha": "runyaml", "type": "blob"}]}`)
+	})
+	mux.HandleFunc("/repos/owner/repo/git/blobs/runyaml", func(w http.ResponseWriter, r *http.Request) {
+		calls[r.URL.Path]++
+		_, _ = fmt.Fprint(w, `{"content": "aGVsbG8gcnVueWFtbA==", "encoding": "base64"}`)
+	})
+
+	gcvs := GithubVCS{Client: fakeclient, Cache: cache.New(cache.DefaultMaxSize, "")}
+	for _, sha := range []string{"sha1", "sha1", "sha2"} {
+		got, err := gcvs.GetTektonDir(ctx, ".tekton", &RunInfo{Owner: "owner", Repository: "repo", SHA: sha})
+		assert.NilError(t, err)
+		assert.Equal(t, got, "\nhello runyaml\n")
+	}
+
+	// sha1 is cached by its commit and sha2 has the same tree as sha1
+	assert.DeepEqual(t, calls, map[string]int{
+		"/repos/owner/repo/git/trees/sha1:.tekton": 1,
+		"/repos/owner/repo/git/trees/sha2:.tekton": 1,
+		"/repos/owner/repo/git/blobs/runyaml":      1,
+	})
+	assert.DeepEqual(t, gcvs.Cache.Stats(), map[string]cache.Stats{
+		cache.TreeKind: {Hits: 2, Misses: 3},
+		cache.BlobKind: {Hits: 0, Misses: 1},
+	})
+}
+
 func TestGithubVCS_CreateCheckRun(t *testing.T) {
 	ctx, _ := rtesting.SetupFakeContext(t)
 	gcvs, teardown := setupFakesURLS()
@@ -519,14 +752,16 @@ func TestGithubVCS_CreateCheckRun(t *testing.T) {
 		Owner:      "check",
 		Repository: "run",
 	}
-	cr, err := gcvs.CreateCheckRun(ctx, "hello moto", runinfo)
+	err := gcvs.CreateCheckRun(ctx, "hello moto", runinfo)
 	assert.NilError(t, err)
-	assert.Equal(t, cr.GetID(), int64(555))
+	assert.Equal(t, *runinfo.CheckRunID, int64(555))
 }
 
 func TestCheckSenderOrgMembership(t *testing.T) {
 	tests := []struct {
-		name, apiReturn  string
+		name             string
+		memberStatus     int
+		permission       string
 		allowed, wantErr bool
 		runinfo          *RunInfo
 	}{
@@ -536,9 +771,8 @@ func TestCheckSenderOrgMembership(t *testing.T) {
 				Owner:  "organization",
 				Sender: "me",
 			},
-			apiReturn: `[{"login": "me"}]`,
-			allowed:   true,
-			wantErr:   false,
+			memberStatus: http.StatusNoContent,
+			allowed:      true,
 		},
 		{
 			name: "Check Sender not in Org Membership",
@@ -546,16 +780,80 @@ func TestCheckSenderOrgMembership(t *testing.T) {
 				Owner:  "organization",
 				Sender: "me",
 			},
-			apiReturn: `[{"login": "not"}]`,
-			allowed:   false,
+			memberStatus: http.StatusNotFound,
+			allowed:      false,
+		},
+		{
+			name: "Check Sender Org Membership error",
+			runinfo: &RunInfo{
+				Owner:  "organization",
+				Sender: "me",
+			},
+			memberStatus: http.StatusInternalServerError,
+			wantErr:      true,
+		},
+		{
+			name: "Check Sender collaborator with admin",
+			runinfo: &RunInfo{
+				Owner:  "organization",
+				Sender: "me",
+			},
+			memberStatus: http.StatusNotFound,
+			permission:   `{"permission": "admin", "role_name": "admin"}`,
+			allowed:      true,
+		},
+		{
+			name: "Check Sender collaborator with read",
+			runinfo: &RunInfo{
+				Owner:  "organization",
+				Sender: "me",
+			},
+			memberStatus: http.StatusNotFound,
+			permission:   `{"permission": "read", "role_name": "read"}`,
+			allowed:      false,
 		},
 		{
-			name: "Not found on organization",
+			name: "Check Sender collaborator with triage",
 			runinfo: &RunInfo{
-				Owner:  "notfound",
+				Owner:  "organization",
 				Sender: "me",
 			},
-			allowed: false,
+			memberStatus: http.StatusNotFound,
+			permission:   `{"permission": "read", "role_name": "triage"}`,
+			allowed:      false,
+		},
+		{
+			name: "Check Sender collaborator asking for read",
+			runinfo: &RunInfo{
+				Owner:                  "organization",
+				Sender:                 "me",
+				CollaboratorPermission: "read",
+			},
+			memberStatus: http.StatusNotFound,
+			permission:   `{"permission": "read", "role_name": "read"}`,
+			wantErr:      true,
+		},
+		{
+			name: "Check Sender collaborator with maintain and no role name",
+			runinfo: &RunInfo{
+				Owner:                  "organization",
+				Sender:                 "me",
+				CollaboratorPermission: "maintain",
+			},
+			memberStatus: http.StatusNotFound,
+			permission:   `{"permission": "write"}`,
+			allowed:      false,
+		},
+		{
+			name: "Check Sender collaborator with maintain",
+			runinfo: &RunInfo{
+				Owner:                  "organization",
+				Sender:                 "me",
+				CollaboratorPermission: "maintain",
+			},
+			memberStatus: http.StatusNotFound,
+			permission:   `{"permission": "write", "role_name": "maintain"}`,
+			allowed:      true,
 		},
 	}
 	for _, tt := range tests {
@@ -566,9 +864,15 @@ func TestCheckSenderOrgMembership(t *testing.T) {
 			gvcs := GithubVCS{
 				Client: fakeclient,
 			}
-			mux.HandleFunc(fmt.Sprintf("/orgs/%s/public_members", tt.runinfo.Owner), func(rw http.ResponseWriter, r *http.Request) {
-				fmt.Fprint(rw, tt.apiReturn)
+			tt.runinfo.Repository = "repo"
+			mux.HandleFunc(fmt.Sprintf("/orgs/%s/members/%s", tt.runinfo.Owner, tt.runinfo.Sender), func(rw http.ResponseWriter, r *http.Request) {
+				rw.WriteHeader(tt.memberStatus)
 			})
+			if tt.permission != "" {
+				mux.HandleFunc(fmt.Sprintf("/repos/%s/repo/collaborators/%s/permission", tt.runinfo.Owner, tt.runinfo.Sender), func(rw http.ResponseWriter, r *http.Request) {
+					fmt.Fprint(rw, tt.permission)
+				})
+			}
 
 			allowed, err := gvcs.CheckSenderOrgMembership(ctx, tt.runinfo)
 			if tt.wantErr && err == nil {
@@ -584,24 +888,47 @@ func TestCheckSenderOrgMembership(t *testing.T) {
 
 func TestGetStringPullRequestComment(t *testing.T) {
 	regexp := `(^|\n)/retest(\r\n|$)`
+	manyPages := make([]string, githubMaxPages+1)
+	for i := range manyPages {
+		manyPages[i] = `[{"body": "hello"}]`
+	}
 	tests := []struct {
 		name, apiReturn string
+		pages           []string
 		wantErr         bool
 		runinfo         *RunInfo
 		wantRet         bool
+		wantComments    int
 	}{
 		{
 			name:      "Get String from comments",
-			runinfo:   &RunInfo{URL: "http://1"},
+			runinfo:   &RunInfo{PullRequestNumber: 1},
 			apiReturn: `[{"body": "/retest"}]`,
 			wantRet:   true,
 		},
 		{
 			name:      "Not matching string in comments",
-			runinfo:   &RunInfo{URL: "http://1"},
+			runinfo:   &RunInfo{PullRequestNumber: 1},
 			apiReturn: `[{"body": ""}]`,
 			wantRet:   false,
 		},
+		{
+			name:    "Get String from comments on several pages",
+			runinfo: &RunInfo{PullRequestNumber: 1},
+			pages: []string{
+				`[{"body": "/retest"}, {"body": "hello"}]`,
+				`[{"body": "moto"}]`,
+				`[{"body": "/retest"}]`,
+			},
+			wantRet:      true,
+			wantComments: 2,
+		},
+		{
+			name:    "Too many pages of comments",
+			runinfo: &RunInfo{PullRequestNumber: 1},
+			pages:   manyPages,
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -611,9 +938,13 @@ func TestGetStringPullRequestComment(t *testing.T) {
 			gvcs := GithubVCS{
 				Client: fakeclient,
 			}
-			mux.HandleFunc(fmt.Sprintf("/repos/issues/%s/comments", filepath.Base(tt.runinfo.URL)), func(rw http.ResponseWriter, r *http.Request) {
-				fmt.Fprint(rw, tt.apiReturn)
-			})
+			if tt.pages != nil {
+				mux.HandleFunc(fmt.Sprintf("/repos/issues/%d/comments", tt.runinfo.PullRequestNumber), ghtesthelper.PaginatedHandler(tt.pages...))
+			} else {
+				mux.HandleFunc(fmt.Sprintf("/repos/issues/%d/comments", tt.runinfo.PullRequestNumber), func(rw http.ResponseWriter, r *http.Request) {
+					fmt.Fprint(rw, tt.apiReturn)
+				})
+			}
 
 			ret, err := gvcs.GetStringPullRequestComment(ctx, tt.runinfo, regexp)
 			if tt.wantErr && err == nil {
@@ -626,10 +957,34 @@ func TestGetStringPullRequestComment(t *testing.T) {
 			if tt.wantRet {
 				assert.Assert(t, ret != nil)
 			}
+			if tt.wantComments != 0 {
+				assert.Equal(t, len(ret), tt.wantComments)
+			}
 		})
 	}
 }
 
+func TestPaginateCancelled(t *testing.T) {
+	fakeclient, mux, _, teardown := ghtesthelper.SetupGH()
+	defer teardown()
+	ctx, cancel := context.WithCancel(context.Background())
+	gvcs := GithubVCS{
+		Client: fakeclient,
+	}
+	pages := 0
+	paginated := ghtesthelper.PaginatedHandler(`[{"body": "hello"}]`, `[{"body": "hello"}]`, `[{"body": "/retest"}]`)
+	mux.HandleFunc("/repos/issues/1/comments", func(rw http.ResponseWriter, r *http.Request) {
+		pages++
+		// cancel while we are going through the pages
+		cancel()
+		paginated(rw, r)
+	})
+
+	_, err := gvcs.GetStringPullRequestComment(ctx, &RunInfo{PullRequestNumber: 1}, `/retest`)
+	assert.ErrorContains(t, err, "context canceled")
+	assert.Equal(t, pages, 1)
+}
+
 func TestRunInfoCheck(t *testing.T) {
 	type fields struct {
 		Owner         string
@@ -693,6 +1048,15 @@ func TestGithubVCS_CreateStatus(t *testing.T) {
 	checkrunid := int64(2026)
 	resultid := int64(666)
 	runinfo := &RunInfo{Owner: "check", Repository: "run", CheckRunID: &checkrunid}
+	annotatedRuninfo := &RunInfo{Owner: "check", Repository: "run", CheckRunID: &checkrunid}
+	for i := 0; i < maxCheckRunAnnotations+1; i++ {
+		annotatedRuninfo.Annotations = append(annotatedRuninfo.Annotations, Annotation{
+			Path:    "pkg/foo/foo_test.go",
+			Level:   AnnotationLevelFailure,
+			Title:   fmt.Sprintf("TestFoo%d", i),
+			Message: "expected 1, got 2",
+		})
+	}
 
 	type args struct {
 		runinfo            *RunInfo
@@ -702,11 +1066,11 @@ func TestGithubVCS_CreateStatus(t *testing.T) {
 		detailsURL         string
 		titleSubstr        string
 		nilCompletedAtDate bool
+		wantAnnotations    int
 	}
 	tests := []struct {
 		name    string
 		args    args
-		want    *github.CheckRun
 		wantErr bool
 	}{
 		{
@@ -719,7 +1083,6 @@ func TestGithubVCS_CreateStatus(t *testing.T) {
 				detailsURL:  "https://cireport.com",
 				titleSubstr: "Success",
 			},
-			want:    &github.CheckRun{ID: &resultid},
 			wantErr: false,
 		},
 		{
@@ -732,7 +1095,6 @@ func TestGithubVCS_CreateStatus(t *testing.T) {
 				detailsURL:         "https://cireport.com",
 				nilCompletedAtDate: true,
 			},
-			want:    &github.CheckRun{ID: &resultid},
 			wantErr: false,
 		},
 		{
@@ -745,7 +1107,31 @@ func TestGithubVCS_CreateStatus(t *testing.T) {
 				detailsURL:  "https://cireport.com",
 				titleSubstr: "Failed",
 			},
-			want:    &github.CheckRun{ID: &resultid},
+			wantErr: false,
+		},
+		{
+			name: "failure with annotations",
+			args: args{
+				runinfo:         annotatedRuninfo,
+				status:          "completed",
+				conclusion:      "failure",
+				text:            "Nay",
+				detailsURL:      "https://cireport.com",
+				titleSubstr:     "Failed",
+				wantAnnotations: maxCheckRunAnnotations,
+			},
+			wantErr: false,
+		},
+		{
+			name: "in_progress without annotations",
+			args: args{
+				runinfo:            annotatedRuninfo,
+				status:             "in_progress",
+				conclusion:         "",
+				text:               "Yay",
+				detailsURL:         "https://cireport.com",
+				nilCompletedAtDate: true,
+			},
 			wantErr: false,
 		},
 		{
@@ -758,7 +1144,6 @@ func TestGithubVCS_CreateStatus(t *testing.T) {
 				detailsURL:  "https://cireport.com",
 				titleSubstr: "Skipped",
 			},
-			want:    &github.CheckRun{ID: &resultid},
 			wantErr: false,
 		},
 		{
@@ -771,7 +1156,6 @@ func TestGithubVCS_CreateStatus(t *testing.T) {
 				detailsURL:  "https://cireport.com",
 				titleSubstr: "Unknown",
 			},
-			want:    &github.CheckRun{ID: &resultid},
 			wantErr: false,
 		},
 	}
@@ -800,18 +1184,300 @@ func TestGithubVCS_CreateStatus(t *testing.T) {
 				assert.Equal(t, checkRun.Output.GetText(), tt.args.text)
 				assert.Equal(t, checkRun.GetDetailsURL(), tt.args.detailsURL)
 				assert.Assert(t, strings.Contains(checkRun.Output.GetTitle(), tt.args.titleSubstr))
+				assert.Equal(t, len(checkRun.Output.Annotations), tt.args.wantAnnotations)
+				for _, annotation := range checkRun.Output.Annotations {
+					assert.Equal(t, annotation.GetStartLine(), 1)
+					assert.Equal(t, annotation.GetEndLine(), 1)
+					assert.Equal(t, annotation.GetAnnotationLevel(), AnnotationLevelFailure)
+				}
 				_, err = fmt.Fprintf(rw, `{"id": %d}`, resultid)
 				assert.NilError(t, err)
 			})
 
-			got, err := gcvs.CreateStatus(ctx, tt.args.runinfo, tt.args.status, tt.args.conclusion, tt.args.text, tt.args.detailsURL)
+			err := gcvs.CreateStatus(ctx, tt.args.runinfo, tt.args.status, tt.args.conclusion, tt.args.text, tt.args.detailsURL)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("GithubVCS.CreateStatus() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
-			if !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("GithubVCS.CreateStatus() = %v, want %v", got, tt.want)
+		})
+	}
+}
+
+func TestGithubCreateStatusPullRequestComment(t *testing.T) {
+	tests := []struct {
+		name         string
+		comments     string
+		wantRequests []string
+	}{
+		{
+			name:         "create the comment",
+			comments:     `[{"id": 6, "body": "LGTM", "user": {"login": "reviewer"}}]`,
+			wantRequests: []string{"GET /repos/check/run/issues/5/comments", "POST /repos/check/run/issues/5/comments"},
+		},
+		{
+			name: "update the comment",
+			comments: `[{"id": 6, "body": "LGTM", "user": {"login": "reviewer"}},
+				{"id": 7, "body": "<!-- pipelines-as-code: PAC -->\n**CI has Started**", "user": {"login": "pac[bot]"}}]`,
+			wantRequests: []string{"GET /repos/check/run/issues/5/comments", "PATCH /repos/check/run/issues/comments/7"},
+		},
+		{
+			name:         "ignore the comment of another account",
+			comments:     `[{"id": 6, "body": "<!-- pipelines-as-code: PAC -->\n**CI has Started**", "user": {"login": "reviewer"}}]`,
+			wantRequests: []string{"GET /repos/check/run/issues/5/comments", "POST /repos/check/run/issues/5/comments"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeclient, mux, _, teardown := ghtesthelper.SetupGH()
+			defer teardown()
+			mux.HandleFunc("/repos/check/run/check-runs/2026", func(rw http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(rw, `{"id": 2026}`)
+			})
+			mux.HandleFunc("/user", func(rw http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(rw, `{"login": "pac[bot]"}`)
+			})
+			requests := []string{}
+			commentHandler := func(rw http.ResponseWriter, r *http.Request) {
+				requests = append(requests, r.Method+" "+r.URL.Path)
+				if r.Method == http.MethodGet {
+					fmt.Fprint(rw, tt.comments)
+					return
+				}
+				comment := &github.IssueComment{}
+				assert.NilError(t, json.NewDecoder(r.Body).Decode(comment))
+				assert.Assert(t, strings.HasPrefix(comment.GetBody(), "<!-- pipelines-as-code: PAC -->\n**✅ Success**"), comment.GetBody())
+				fmt.Fprint(rw, `{}`)
+			}
+			mux.HandleFunc("/repos/check/run/issues/5/comments", commentHandler)
+			mux.HandleFunc("/repos/check/run/issues/comments/7", commentHandler)
+
+			ctx, _ := rtesting.SetupFakeContext(t)
+			gcvs := GithubVCS{Client: fakeclient}
+			checkrunid := int64(2026)
+			runinfo := &RunInfo{
+				Owner: "check", Repository: "run", CheckRunID: &checkrunid,
+				ApplicationName: "PAC", PullRequestNumber: 5, PullRequestComment: true,
 			}
+			assert.NilError(t, gcvs.CreateStatus(ctx, runinfo, "completed", "success", "text", "https://url"))
+			assert.DeepEqual(t, requests, tt.wantRequests)
 		})
 	}
 }
+
+func TestGithubCommitStatus(t *testing.T) {
+	tests := []struct {
+		name         string
+		commitStatus bool
+		checkRunCode int
+		checkRunBody string
+		wantErr      string
+		wantRequests []string
+		wantStates   []string
+	}{
+		{
+			name:         "configured",
+			commitStatus: true,
+			wantRequests: []string{
+				"POST /repos/owner/repo/statuses/sha", "POST /repos/owner/repo/statuses/sha",
+				"POST /repos/owner/repo/issues/5/comments",
+			},
+			wantStates: []string{"pending", "failure"},
+		},
+		{
+			name:         "fallback of a check run not accessible by integration",
+			checkRunCode: http.StatusForbidden,
+			checkRunBody: `{"message": "Resource not accessible by integration"}`,
+			wantRequests: []string{
+				"POST /repos/owner/repo/check-runs", "POST /repos/owner/repo/statuses/sha",
+				"POST /repos/owner/repo/statuses/sha", "POST /repos/owner/repo/issues/5/comments",
+			},
+			wantStates: []string{"pending", "failure"},
+		},
+		{
+			name:         "no fallback of another forbidden check run",
+			checkRunCode: http.StatusForbidden,
+			checkRunBody: `{"message": "Repository access blocked"}`,
+			wantErr:      "Repository access blocked",
+			wantRequests: []string{"POST /repos/owner/repo/check-runs"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeclient, mux, _, teardown := ghtesthelper.SetupGH()
+			defer teardown()
+			requests := []string{}
+			states := []string{}
+			mux.HandleFunc("/repos/owner/repo/check-runs", func(rw http.ResponseWriter, r *http.Request) {
+				requests = append(requests, r.Method+" "+r.URL.Path)
+				rw.WriteHeader(tt.checkRunCode)
+				fmt.Fprint(rw, tt.checkRunBody)
+			})
+			mux.HandleFunc("/repos/owner/repo/statuses/sha", func(rw http.ResponseWriter, r *http.Request) {
+				requests = append(requests, r.Method+" "+r.URL.Path)
+				repoStatus := &github.RepoStatus{}
+				assert.NilError(t, json.NewDecoder(r.Body).Decode(repoStatus))
+				assert.Equal(t, repoStatus.GetContext(), "PAC / unit")
+				states = append(states, repoStatus.GetState())
+				fmt.Fprint(rw, `{"id": 2026}`)
+			})
+			mux.HandleFunc("/repos/owner/repo/issues/5/comments", func(rw http.ResponseWriter, r *http.Request) {
+				requests = append(requests, r.Method+" "+r.URL.Path)
+				comment := &github.IssueComment{}
+				assert.NilError(t, json.NewDecoder(r.Body).Decode(comment))
+				assert.Assert(t, strings.HasPrefix(comment.GetBody(), "**❌ Failed**"), comment.GetBody())
+				fmt.Fprint(rw, `{}`)
+			})
+
+			ctx, _ := rtesting.SetupFakeContext(t)
+			gcvs := GithubVCS{Client: fakeclient, CommitStatus: tt.commitStatus}
+			runinfo := &RunInfo{
+				Owner: "owner", Repository: "repo", SHA: "sha",
+				ApplicationName: "PAC / unit", PullRequestNumber: 5,
+			}
+			err := gcvs.CreateCheckRun(ctx, "in_progress", runinfo)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				assert.DeepEqual(t, requests, tt.wantRequests)
+				assert.Assert(t, !runinfo.CommitStatus)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, *runinfo.CheckRunID, int64(2026))
+			assert.NilError(t, gcvs.CreateStatus(ctx, runinfo, "completed", "failure", "text", "https://url"))
+			assert.DeepEqual(t, requests, tt.wantRequests)
+			assert.DeepEqual(t, states, tt.wantStates)
+		})
+	}
+}
+
+func TestGithubState(t *testing.T) {
+	tests := []struct {
+		status, conclusion, want string
+	}{
+		{"in_progress", "", "pending"},
+		{"completed", "success", "success"},
+		{"completed", "skipped", "pending"},
+		{"completed", "action_required", "pending"},
+		{"completed", "failure", "failure"},
+		{"completed", "timed_out", "failure"},
+		{"completed", "neutral", "error"},
+		{"completed", "cancelled", "error"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, githubState(tt.status, tt.conclusion), tt.want, tt.status+" "+tt.conclusion)
+	}
+}
+
+func TestGithubGetBranchSHA(t *testing.T) {
+	fakeclient, mux, _, teardown := ghtesthelper.SetupGH()
+	defer teardown()
+	mux.HandleFunc("/repos/owner/repo/branches/main", func(rw http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(rw, `{"name": "main", "commit": {"sha": "headsha"}}`)
+	})
+
+	ctx, _ := rtesting.SetupFakeContext(t)
+	gcvs := GithubVCS{Client: fakeclient}
+	runinfo := &RunInfo{Owner: "owner", Repository: "repo", SHA: "sha"}
+	got, err := gcvs.GetBranchSHA(ctx, "main", runinfo)
+	assert.NilError(t, err)
+	assert.Equal(t, got, "headsha")
+
+	_, err = gcvs.GetBranchSHA(ctx, "gone", runinfo)
+	assert.ErrorContains(t, err, "404")
+}
+
+func TestGithubGetFilesChanged(t *testing.T) {
+	ctx, _ := rtesting.SetupFakeContext(t)
+	fakeclient, mux, serverURL, teardown := ghtesthelper.SetupGH()
+	defer teardown()
+	mux.HandleFunc("/repos/owner/repo/pulls/6/files", func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("page") == "2" {
+			_, _ = fmt.Fprint(rw, `[{"filename": "docs/README.md"}]`)
+			return
+		}
+		rw.Header().Set("Link", fmt.Sprintf(`<%s/api-v3/repos/owner/repo/pulls/6/files?page=2>; rel="next"`, serverURL))
+		_, _ = fmt.Fprint(rw, `[{"filename": "pkg/new.go", "previous_filename": "pkg/old.go"}, {"filename": "Makefile"}]`)
+	})
+	mux.HandleFunc("/repos/owner/repo/compare/before...sha", func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("page") == "2" {
+			_, _ = fmt.Fprint(rw, `{"files": [{"filename": "pkg/paged.go"}]}`)
+			return
+		}
+		rw.Header().Set("Link", fmt.Sprintf(`<%s/api-v3/repos/owner/repo/compare/before...sha?page=2>; rel="next"`, serverURL))
+		_, _ = fmt.Fprint(rw, `{"files": [{"filename": "main.go"}, {"filename": "docs/index.md"}]}`)
+	})
+	// a comparison listing as many files as GitHub does at most, the files of
+	// its commits are listed instead
+	manyFiles := make([]string, githubMaxCompareFiles)
+	manyFilesJSON := make([]string, githubMaxCompareFiles)
+	for i := range manyFiles {
+		manyFiles[i] = fmt.Sprintf("files/%03d.go", i)
+		manyFilesJSON[i] = fmt.Sprintf(`{"filename": %q}`, manyFiles[i])
+	}
+	mux.HandleFunc("/repos/owner/repo/compare/large...sha", func(rw http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintf(rw, `{"files": [%s], "commits": [{"sha": "first"}, {"sha": "sha"}]}`, strings.Join(manyFilesJSON, ","))
+	})
+	mux.HandleFunc("/repos/owner/repo/commits/first", func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("page") == "2" {
+			_, _ = fmt.Fprint(rw, `{"files": [{"filename": "beyond.go"}]}`)
+			return
+		}
+		rw.Header().Set("Link", fmt.Sprintf(`<%s/api-v3/repos/owner/repo/commits/first?page=2>; rel="next"`, serverURL))
+		_, _ = fmt.Fprintf(rw, `{"files": [%s]}`, strings.Join(manyFilesJSON, ","))
+	})
+	mux.HandleFunc("/repos/owner/repo/commits/sha", func(rw http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(rw, `{"files": [{"filename": "main.go"}]}`)
+	})
+	gvcs := GithubVCS{Client: fakeclient}
+
+	tests := []struct {
+		name    string
+		runinfo *RunInfo
+		want    []string
+	}{
+		{
+			name:    "pull request",
+			runinfo: &RunInfo{Owner: "owner", Repository: "repo", SHA: "sha", PullRequestNumber: 6},
+			want:    []string{"Makefile", "docs/README.md", "pkg/new.go", "pkg/old.go"},
+		},
+		{
+			name: "push",
+			runinfo: &RunInfo{
+				Owner: "owner", Repository: "repo", SHA: "sha",
+				Event: &github.PushEvent{Before: github.String("before")},
+			},
+			want: []string{"docs/index.md", "main.go", "pkg/paged.go"},
+		},
+		{
+			name: "push with more files than a comparison lists",
+			runinfo: &RunInfo{
+				Owner: "owner", Repository: "repo", SHA: "sha",
+				Event: &github.PushEvent{Before: github.String("large")},
+			},
+			want: append([]string{"beyond.go"}, append(manyFiles, "main.go")...),
+		},
+		{
+			name: "push creating a branch",
+			runinfo: &RunInfo{
+				Owner: "owner", Repository: "repo", SHA: "sha",
+				Event: &github.PushEvent{Before: github.String("0000000000000000000000000000000000000000")},
+			},
+			want: []string{"main.go"},
+		},
+		{
+			name:    "recheck",
+			runinfo: &RunInfo{Owner: "owner", Repository: "repo", SHA: "sha"},
+			want:    []string{"main.go"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := gvcs.GetFilesChanged(ctx, tt.runinfo)
+			assert.NilError(t, err)
+			assert.DeepEqual(t, got, tt.want)
+		})
+	}
+
+	_, err := gvcs.GetFilesChanged(ctx, &RunInfo{Owner: "owner", Repository: "repo", SHA: "unknown"})
+	assert.ErrorContains(t, err, "404")
+}
@@ -0,0 +1,216 @@
+package webvcs
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v35/github"
+	"golang.org/x/oauth2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8s "k8s.io/client-go/kubernetes"
+)
+
+const (
+	// GithubAppSecretName is the default Secret where the GitHub App private
+	// key and application id are stored
+	GithubAppSecretName = "github-app-secret"
+	githubAppPrivateKey = "private.key"
+	githubAppID         = "application_id"
+	// GitHub refuses JWTs expiring in more than 10 minutes, the issued time is
+	// set in the past to allow for some clock drift
+	githubAppJWTExpiration = 9 * time.Minute
+	githubAppJWTClockDrift = 60 * time.Second
+	// installation tokens are refreshed when they expire in less than that
+	githubAppTokenExpiryDelta = 5 * time.Minute
+)
+
+// GithubApp generate the installation tokens of a GitHub App, the tokens are
+// cached until shortly before they expire
+type GithubApp struct {
+	ID         int64
+	privateKey *rsa.PrivateKey
+
+	mu     sync.Mutex
+	tokens map[string]*github.InstallationToken
+	logins map[string]string
+	now    func() time.Time
+}
+
+// NewGithubApp create a GithubApp from the application id and its PEM
+// encoded private key
+func NewGithubApp(appID int64, privateKey []byte) (*GithubApp, error) {
+	block, _ := pem.Decode(privateKey)
+	if block == nil {
+		return nil, errors.New("cannot decode the GitHub App private key, it is not PEM encoded")
+	}
+
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		pkcs8, err8 := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err8 != nil {
+			return nil, fmt.Errorf("cannot parse the GitHub App private key: %w", err)
+		}
+		var ok bool
+		if key, ok = pkcs8.(*rsa.PrivateKey); !ok {
+			return nil, errors.New("the GitHub App private key is not a RSA key")
+		}
+	}
+
+	return &GithubApp{
+		ID:         appID,
+		privateKey: key,
+		tokens:     map[string]*github.InstallationToken{},
+		logins:     map[string]string{},
+		now:        time.Now,
+	}, nil
+}
+
+// NewGithubAppFromSecret create a GithubApp from the private.key and
+// application_id keys of the namespace/name Secret
+func NewGithubAppFromSecret(ctx context.Context, kube k8s.Interface, namespace, name string) (*GithubApp, error) {
+	secret, err := kube.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("cannot get the GitHub App secret %s/%s: %w", namespace, name, err)
+	}
+
+	appID, err := strconv.ParseInt(strings.TrimSpace(string(secret.Data[githubAppID])), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s in the GitHub App secret %s/%s: %w", githubAppID, namespace, name, err)
+	}
+	return NewGithubApp(appID, secret.Data[githubAppPrivateKey])
+}
+
+// GithubInstallationID get the GitHub App installation id of a webhook payload
+func GithubInstallationID(payload []byte) (int64, error) {
+	event := struct {
+		Installation struct {
+			ID int64 `json:"id"`
+		} `json:"installation"`
+	}{}
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return 0, err
+	}
+	if event.Installation.ID == 0 {
+		return 0, errors.New("payload has no GitHub App installation id")
+	}
+	return event.Installation.ID, nil
+}
+
+// JWT generate a RS256 signed JSON Web Token authenticating as the GitHub App
+func (a *GithubApp) JWT() (string, error) {
+	now := a.now()
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]int64{
+		"iat": now.Add(-githubAppJWTClockDrift).Unix(),
+		"exp": now.Add(githubAppJWTExpiration).Unix(),
+		"iss": a.ID,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	hashed := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, a.privateKey, crypto.SHA256, hashed[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// expired tells if an installation token is expired or about to expire
+func (a *GithubApp) expired(token *github.InstallationToken) bool {
+	return !a.now().Add(githubAppTokenExpiryDelta).Before(token.GetExpiresAt())
+}
+
+// cachedToken get the cached installation token of key if it's not about to expire
+func (a *GithubApp) cachedToken(key string) (string, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	token, ok := a.tokens[key]
+	if !ok || a.expired(token) {
+		return "", false
+	}
+	return token.GetToken(), true
+}
+
+// cacheToken cache the installation token of key, evicting the tokens which
+// are about to expire since they would not be reused anyway
+func (a *GithubApp) cacheToken(key string, token *github.InstallationToken) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for k, t := range a.tokens {
+		if a.expired(t) {
+			delete(a.tokens, k)
+		}
+	}
+	a.tokens[key] = token
+}
+
+// Token get an installation token for installationID, apiURL being the
+// configured GitHub Enterprise API URL or empty for github.com. A cached token
+// is reused until it is about to expire.
+func (a *GithubApp) Token(ctx context.Context, apiURL string, installationID int64) (string, error) {
+	key := fmt.Sprintf("%s/%d", apiURL, installationID)
+	if token, ok := a.cachedToken(key); ok {
+		return token, nil
+	}
+
+	// the lock is not held while asking for a token, two events of the same
+	// installation may both ask for one, the last one is cached
+	jwt, err := a.JWT()
+	if err != nil {
+		return "", err
+	}
+	tc := oauth2.NewClient(ctx, oauth2.StaticTokenSource(&oauth2.Token{AccessToken: jwt}))
+	token, _, err := newGithubClient(apiURL, tc).Apps.CreateInstallationToken(ctx, installationID, nil)
+	if err != nil {
+		return "", fmt.Errorf("cannot get a token for the GitHub App installation %d: %w", installationID, err)
+	}
+	a.cacheToken(key, token)
+	return token.GetToken(), nil
+}
+
+// Login get the login the GitHub App comments with on apiURL, its slug with
+// the [bot] suffix. An installation token cannot ask for it, it is asked with
+// the JWT once per apiURL.
+func (a *GithubApp) Login(ctx context.Context, apiURL string) (string, error) {
+	a.mu.Lock()
+	login, ok := a.logins[apiURL]
+	a.mu.Unlock()
+	if ok {
+		return login, nil
+	}
+
+	jwt, err := a.JWT()
+	if err != nil {
+		return "", err
+	}
+	tc := oauth2.NewClient(ctx, oauth2.StaticTokenSource(&oauth2.Token{AccessToken: jwt}))
+	app, _, err := newGithubClient(apiURL, tc).Apps.Get(ctx, "")
+	if err != nil {
+		return "", fmt.Errorf("cannot get the GitHub App %d: %w", a.ID, err)
+	}
+	login = app.GetSlug() + "[bot]"
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.logins[apiURL] = login
+	return login, nil
+}
@@ -0,0 +1,207 @@
+package webvcs
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	testclient "github.com/openshift-pipelines/pipelines-as-code/pkg/test/clients"
+	"gotest.tools/v3/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	rtesting "knative.dev/pkg/reconciler/testing"
+)
+
+func generatePrivateKey(t *testing.T) (*rsa.PrivateKey, []byte) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NilError(t, err)
+	return key, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+}
+
+// verifyJWT check the JWT is signed with key and return its claims
+func verifyJWT(t *testing.T, key *rsa.PrivateKey, jwt string) map[string]int64 {
+	parts := strings.Split(jwt, ".")
+	assert.Equal(t, len(parts), 3)
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	assert.NilError(t, err)
+	hashed := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	assert.NilError(t, rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, hashed[:], signature))
+
+	b, err := base64.RawURLEncoding.DecodeString(parts[1])
+	assert.NilError(t, err)
+	claims := map[string]int64{}
+	assert.NilError(t, json.Unmarshal(b, &claims))
+	return claims
+}
+
+func TestNewGithubApp(t *testing.T) {
+	_, pkcs1 := generatePrivateKey(t)
+	key, _ := generatePrivateKey(t)
+	pkcs8b, err := x509.MarshalPKCS8PrivateKey(key)
+	assert.NilError(t, err)
+	pkcs8 := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8b})
+
+	tests := []struct {
+		name    string
+		key     []byte
+		wantErr string
+	}{
+		{name: "pkcs1", key: pkcs1},
+		{name: "pkcs8", key: pkcs8},
+		{name: "not pem", key: []byte("hello"), wantErr: "not PEM encoded"},
+		{
+			name:    "not a key",
+			key:     pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: []byte("hello")}),
+			wantErr: "cannot parse the GitHub App private key",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, err := NewGithubApp(12, tt.key)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, app.ID, int64(12))
+		})
+	}
+}
+
+func TestNewGithubAppFromSecret(t *testing.T) {
+	_, privateKey := generatePrivateKey(t)
+	ctx, _ := rtesting.SetupFakeContext(t)
+	stdata, _ := testclient.SeedTestData(t, ctx, testclient.Data{
+		Secrets: []*corev1.Secret{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: GithubAppSecretName, Namespace: "pipelines-as-code"},
+				Data: map[string][]byte{
+					"private.key":    privateKey,
+					"application_id": []byte("1234\n"),
+				},
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "badid", Namespace: "pipelines-as-code"},
+				Data: map[string][]byte{
+					"private.key":    privateKey,
+					"application_id": []byte("moto"),
+				},
+			},
+		},
+	})
+
+	app, err := NewGithubAppFromSecret(ctx, stdata.Kube, "pipelines-as-code", GithubAppSecretName)
+	assert.NilError(t, err)
+	assert.Equal(t, app.ID, int64(1234))
+
+	_, err = NewGithubAppFromSecret(ctx, stdata.Kube, "pipelines-as-code", "badid")
+	assert.ErrorContains(t, err, "invalid application_id in the GitHub App secret pipelines-as-code/badid")
+
+	_, err = NewGithubAppFromSecret(ctx, stdata.Kube, "pipelines-as-code", "nothere")
+	assert.ErrorContains(t, err, "cannot get the GitHub App secret pipelines-as-code/nothere")
+}
+
+func TestGithubInstallationID(t *testing.T) {
+	id, err := GithubInstallationID([]byte(`{"action": "opened", "installation": {"id": 5678}}`))
+	assert.NilError(t, err)
+	assert.Equal(t, id, int64(5678))
+
+	_, err = GithubInstallationID([]byte(`{"action": "opened"}`))
+	assert.ErrorContains(t, err, "payload has no GitHub App installation id")
+
+	_, err = GithubInstallationID([]byte(`moto`))
+	assert.ErrorContains(t, err, "invalid character")
+}
+
+func TestGithubAppToken(t *testing.T) {
+	key, privateKey := generatePrivateKey(t)
+	app, err := NewGithubApp(1234, privateKey)
+	assert.NilError(t, err)
+	now := time.Date(2021, 7, 1, 10, 0, 0, 0, time.UTC)
+	app.now = func() time.Time { return now }
+
+	exchanges := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v3/app/installations/5678/access_tokens", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, r.Method, http.MethodPost)
+		claims := verifyJWT(t, key, strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+		assert.Equal(t, claims["iss"], int64(1234))
+		assert.Equal(t, claims["iat"], now.Add(-60*time.Second).Unix())
+		assert.Equal(t, claims["exp"], now.Add(9*time.Minute).Unix())
+
+		exchanges++
+		fmt.Fprintf(w, `{"token": "token%d", "expires_at": "%s"}`, exchanges,
+			now.Add(time.Hour).Format(time.RFC3339))
+	})
+	mux.HandleFunc("/api/v3/app/installations/4321/access_tokens", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"token": "shortlived", "expires_at": "%s"}`, now.Add(10*time.Minute).Format(time.RFC3339))
+	})
+	mux.HandleFunc("/api/v3/app/installations/404/access_tokens", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"message": "Not Found"}`, http.StatusNotFound)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	token, err := app.Token(context.Background(), server.URL, 5678)
+	assert.NilError(t, err)
+	assert.Equal(t, token, "token1")
+	_, err = app.Token(context.Background(), server.URL, 4321)
+	assert.NilError(t, err)
+
+	// cached
+	now = now.Add(50 * time.Minute)
+	token, err = app.Token(context.Background(), server.URL, 5678)
+	assert.NilError(t, err)
+	assert.Equal(t, token, "token1")
+
+	// about to expire
+	now = now.Add(6 * time.Minute)
+	token, err = app.Token(context.Background(), server.URL, 5678)
+	assert.NilError(t, err)
+	assert.Equal(t, token, "token2")
+	assert.Equal(t, exchanges, 2)
+	// the expired token of the other installation has been evicted
+	assert.Equal(t, len(app.tokens), 1)
+
+	_, err = app.Token(context.Background(), server.URL, 404)
+	assert.ErrorContains(t, err, "cannot get a token for the GitHub App installation 404")
+}
+
+func TestGithubAppLogin(t *testing.T) {
+	key, privateKey := generatePrivateKey(t)
+	app, err := NewGithubApp(1234, privateKey)
+	assert.NilError(t, err)
+
+	requests := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v3/app", func(w http.ResponseWriter, r *http.Request) {
+		claims := verifyJWT(t, key, strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+		assert.Equal(t, claims["iss"], int64(1234))
+		requests++
+		fmt.Fprint(w, `{"id": 1234, "slug": "pipelines-as-code"}`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	for i := 0; i < 2; i++ {
+		login, err := app.Login(context.Background(), server.URL)
+		assert.NilError(t, err)
+		assert.Equal(t, login, "pipelines-as-code[bot]")
+	}
+	assert.Equal(t, requests, 1)
+
+	_, err = app.Login(context.Background(), server.URL+"/notfound")
+	assert.ErrorContains(t, err, "cannot get the GitHub App 1234")
+}
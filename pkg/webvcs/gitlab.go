@@ -0,0 +1,611 @@
+package webvcs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+const (
+	gitlabDefaultAPIURL = "https://gitlab.com"
+	gitlabAPIPath       = "/api/v4"
+
+	// gitlabDeveloperAccessLevel is the minimal access level a project member
+	// needs to be allowed to run the CI
+	gitlabDeveloperAccessLevel = 30
+
+	// gitlabPerPage is the maximum number of items the GitLab list APIs
+	// return per page
+	gitlabPerPage = 100
+)
+
+var _ Interface = GitlabVCS{}
+
+type GitlabVCS struct {
+	rest restClient
+}
+
+type GitlabUser struct {
+	ID       int    `json:"id"`
+	Username string `json:"username"`
+}
+
+type GitlabProject struct {
+	ID                int    `json:"id"`
+	Name              string `json:"name"`
+	PathWithNamespace string `json:"path_with_namespace"`
+	WebURL            string `json:"web_url"`
+	DefaultBranch     string `json:"default_branch"`
+}
+
+type GitlabCommit struct {
+	ID      string `json:"id"`
+	Title   string `json:"title"`
+	Message string `json:"message"`
+	URL     string `json:"url"`
+	WebURL  string `json:"web_url"`
+}
+
+type GitlabMergeRequest struct {
+	IID          int          `json:"iid"`
+	Action       string       `json:"action"`
+	OldRev       string       `json:"oldrev"`
+	SourceBranch string       `json:"source_branch"`
+	TargetBranch string       `json:"target_branch"`
+	SHA          string       `json:"sha"`
+	WebURL       string       `json:"web_url"`
+	URL          string       `json:"url"`
+	Author       GitlabUser   `json:"author"`
+	LastCommit   GitlabCommit `json:"last_commit"`
+}
+
+// GitlabMergeRequestEvent a "Merge Request Hook" webhook payload
+type GitlabMergeRequestEvent struct {
+	ObjectKind       string             `json:"object_kind"`
+	User             GitlabUser         `json:"user"`
+	Project          GitlabProject      `json:"project"`
+	ObjectAttributes GitlabMergeRequest `json:"object_attributes"`
+}
+
+// GitlabPushEvent a "Push Hook" or "Tag Push Hook" webhook payload
+type GitlabPushEvent struct {
+	ObjectKind   string         `json:"object_kind"`
+	Ref          string         `json:"ref"`
+	Before       string         `json:"before"`
+	CheckoutSHA  string         `json:"checkout_sha"`
+	UserUsername string         `json:"user_username"`
+	Project      GitlabProject  `json:"project"`
+	Commits      []GitlabCommit `json:"commits"`
+}
+
+// GitlabNoteEvent a "Note Hook" webhook payload
+type GitlabNoteEvent struct {
+	ObjectKind       string        `json:"object_kind"`
+	User             GitlabUser    `json:"user"`
+	Project          GitlabProject `json:"project"`
+	ObjectAttributes struct {
+		Note         string `json:"note"`
+		NoteableType string `json:"noteable_type"`
+	} `json:"object_attributes"`
+	MergeRequest GitlabMergeRequest `json:"merge_request"`
+}
+
+type gitlabTreeEntry struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Path string `json:"path"`
+}
+
+type gitlabDiff struct {
+	OldPath string `json:"old_path"`
+	NewPath string `json:"new_path"`
+}
+
+type gitlabNote struct {
+	ID     int64      `json:"id"`
+	Body   string     `json:"body"`
+	System bool       `json:"system"`
+	Author GitlabUser `json:"author"`
+}
+
+type gitlabMember struct {
+	Username    string `json:"username"`
+	AccessLevel int    `json:"access_level"`
+}
+
+type gitlabCommitStatus struct {
+	ID int64 `json:"id"`
+}
+
+// NewGitlabVCS Create a new GitLab VCS object for token, apiURL is the GitLab
+// instance URL and default to gitlab.com
+func NewGitlabVCS(token string, apiURL string) GitlabVCS {
+	if apiURL == "" {
+		apiURL = gitlabDefaultAPIURL
+	}
+	if !strings.HasPrefix(apiURL, "http") {
+		apiURL = "https://" + apiURL
+	}
+	apiURL = strings.TrimSuffix(apiURL, "/")
+	if !strings.HasSuffix(apiURL, gitlabAPIPath) {
+		apiURL += gitlabAPIPath
+	}
+	return GitlabVCS{
+		rest: restClient{
+			BaseURL: apiURL,
+			Headers: map[string]string{"PRIVATE-TOKEN": token},
+		},
+	}
+}
+
+// gitlabProjectID get the url encoded project path the API use as project id
+func gitlabProjectID(runinfo *RunInfo) string {
+	return url.PathEscape(runinfo.Owner + "/" + runinfo.Repository)
+}
+
+// gitlabOwnerRepository split a project path with namespace as owner and
+// repository, the owner being the (sub)group or user
+func gitlabOwnerRepository(project GitlabProject) (string, string) {
+	return path.Dir(project.PathWithNamespace), path.Base(project.PathWithNamespace)
+}
+
+// WebhookSignature get the X-Gitlab-Token secret token of a raw webhook request
+func (v GitlabVCS) WebhookSignature(header http.Header) string {
+	return header.Get(gitlabTokenHeader)
+}
+
+// ValidateWebhook check the X-Gitlab-Token secret token of the payload against the webhook secret
+func (v GitlabVCS) ValidateWebhook(secret, signature string, payload []byte) error {
+	return validateToken(secret, signature)
+}
+
+// ParseEventType get the event type from the X-Gitlab-Event header and the
+// trigger target of the events we want to run on
+func (v GitlabVCS) ParseEventType(header http.Header, payload []byte) (string, string) {
+	eventType := header.Get("X-Gitlab-Event")
+	switch eventType {
+	case "Merge Request Hook":
+		mrEvent := &GitlabMergeRequestEvent{}
+		if json.Unmarshal(payload, mrEvent) != nil {
+			return eventType, ""
+		}
+		switch mrEvent.ObjectAttributes.Action {
+		case "open", "reopen":
+			return eventType, TriggerTargetPullRequest
+		case "update":
+			// an update without an oldrev is a change of the title, the
+			// labels or the assignees, not a new commit to run on
+			if mrEvent.ObjectAttributes.OldRev != "" {
+				return eventType, TriggerTargetPullRequest
+			}
+		}
+	case "Note Hook":
+		noteEvent := &GitlabNoteEvent{}
+		if json.Unmarshal(payload, noteEvent) != nil || noteEvent.ObjectAttributes.NoteableType != "MergeRequest" {
+			return eventType, ""
+		}
+		return eventType, commentTriggerTarget(noteEvent.ObjectAttributes.Note)
+	case "Push Hook", "Tag Push Hook":
+		pushEvent := &GitlabPushEvent{}
+		// a branch or a tag deletion has no checkout sha
+		if json.Unmarshal(payload, pushEvent) != nil || pushEvent.CheckoutSHA == "" {
+			return eventType, ""
+		}
+		return eventType, TriggerTargetPush
+	}
+	return eventType, ""
+}
+
+// paginate get all the pages of the GitLab list API at path, following the
+// X-Next-Page header and passing each page body to listPage.
+// It gives up after restMaxPages pages or when the context is cancelled.
+func (v GitlabVCS) paginate(ctx context.Context, path string, listPage func(data []byte) error) error {
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	page := "1"
+	for i := 0; i < restMaxPages; i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		data, resp, err := v.rest.raw(ctx, http.MethodGet,
+			fmt.Sprintf("%s%sper_page=%d&page=%s", path, sep, gitlabPerPage, url.QueryEscape(page)), nil)
+		if err != nil {
+			return err
+		}
+		if err := listPage(data); err != nil {
+			return err
+		}
+		page = resp.Header.Get("X-Next-Page")
+		if page == "" {
+			return nil
+		}
+	}
+	return fmt.Errorf("giving up after listing %d pages of results", restMaxPages)
+}
+
+// ParsePayload parse a GitLab webhook payload, eventType being the X-Gitlab-Event header
+func (v GitlabVCS) ParsePayload(ctx context.Context, log *zap.SugaredLogger, eventType, triggerTarget, payload string) (*RunInfo, error) {
+	var runinfo RunInfo
+	var event interface{}
+	var err error
+	payload = payloadFix(payload)
+
+	switch eventType {
+	case "Merge Request Hook":
+		mrEvent := &GitlabMergeRequestEvent{}
+		if err := json.Unmarshal([]byte(payload), mrEvent); err != nil {
+			return &runinfo, err
+		}
+		runinfo, err = v.getMergeRequest(ctx, mrEvent.Project, mrEvent.ObjectAttributes.IID)
+		if err != nil {
+			return &runinfo, err
+		}
+		event = mrEvent
+	case "Note Hook":
+		noteEvent := &GitlabNoteEvent{}
+		if err := json.Unmarshal([]byte(payload), noteEvent); err != nil {
+			return &runinfo, err
+		}
+		if noteEvent.ObjectAttributes.NoteableType != "MergeRequest" {
+			return &runinfo, fmt.Errorf("note is not coming from a merge_request")
+		}
+		log.Infof("MR recheck from note on %s!%d has been requested", noteEvent.Project.PathWithNamespace,
+			noteEvent.MergeRequest.IID)
+		runinfo, err = v.getMergeRequest(ctx, noteEvent.Project, noteEvent.MergeRequest.IID)
+		if err != nil {
+			return &runinfo, err
+		}
+		setCommentCommand(&runinfo, noteEvent.ObjectAttributes.Note, noteEvent.User.Username)
+		event = noteEvent
+	case "Push Hook", "Tag Push Hook":
+		pushEvent := &GitlabPushEvent{}
+		if err := json.Unmarshal([]byte(payload), pushEvent); err != nil {
+			return &runinfo, err
+		}
+		runinfo = RunInfo{
+			DefaultBranch: pushEvent.Project.DefaultBranch,
+			URL:           pushEvent.Project.WebURL,
+			SHA:           pushEvent.CheckoutSHA,
+			Sender:        pushEvent.UserUsername,
+			BaseBranch:    pushEvent.Ref,
+			HeadBranch:    pushEvent.Ref, // in push events Head Branch is the same as Basebranch
+		}
+		setPushEventType(&runinfo)
+		runinfo.Owner, runinfo.Repository = gitlabOwnerRepository(pushEvent.Project)
+		event = pushEvent
+	default:
+		return &runinfo, errors.New("this event is not supported")
+	}
+
+	err = v.populateCommitInfo(ctx, &runinfo)
+	if err != nil {
+		return nil, err
+	}
+
+	runinfo.Event = event
+	// a comment command has its own trigger target
+	if runinfo.TriggerTarget == "" {
+		runinfo.TriggerTarget = triggerTarget
+	}
+	return &runinfo, nil
+}
+
+// getMergeRequest get a merge request details, project is the target project
+// of the merge request as set in the webhook
+func (v GitlabVCS) getMergeRequest(ctx context.Context, project GitlabProject, iid int) (RunInfo, error) {
+	runinfo := RunInfo{}
+	runinfo.Owner, runinfo.Repository = gitlabOwnerRepository(project)
+
+	mr := &GitlabMergeRequest{}
+	if _, err := v.rest.do(ctx, http.MethodGet,
+		fmt.Sprintf("/projects/%s/merge_requests/%d", gitlabProjectID(&runinfo), iid), nil, mr); err != nil {
+		return runinfo, err
+	}
+
+	// Make sure to use the target project for the default branch and the URL
+	// or there would be a potential hijack from a fork
+	runinfo.DefaultBranch = project.DefaultBranch
+	runinfo.URL = project.WebURL
+	runinfo.SHA = mr.SHA
+	runinfo.Sender = mr.Author.Username
+	runinfo.HeadBranch = mr.SourceBranch
+	runinfo.BaseBranch = mr.TargetBranch
+	runinfo.EventType = "pull_request"
+	runinfo.PullRequestNumber = iid
+	return runinfo, nil
+}
+
+// populateCommitInfo get info on a commit in runinfo
+func (v GitlabVCS) populateCommitInfo(ctx context.Context, runinfo *RunInfo) error {
+	commit := &GitlabCommit{}
+	if _, err := v.rest.do(ctx, http.MethodGet,
+		fmt.Sprintf("/projects/%s/repository/commits/%s", gitlabProjectID(runinfo), url.PathEscape(runinfo.SHA)),
+		nil, commit); err != nil {
+		return err
+	}
+
+	runinfo.SHAURL = commit.WebURL
+	runinfo.SHATitle = commit.Title
+	return nil
+}
+
+// CheckSenderOrgMembership check if the sender is a member of the project,
+// directly or inherited from its groups, with at least the developer access level
+func (v GitlabVCS) CheckSenderOrgMembership(ctx context.Context, runinfo *RunInfo) (bool, error) {
+	members := []gitlabMember{}
+	_, err := v.rest.do(ctx, http.MethodGet,
+		fmt.Sprintf("/projects/%s/members/all?query=%s", gitlabProjectID(runinfo), url.QueryEscape(runinfo.Sender)),
+		nil, &members)
+	if isNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	for _, member := range members {
+		if member.Username == runinfo.Sender && member.AccessLevel >= gitlabDeveloperAccessLevel {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// GetStringPullRequestComment return the notes of a merge request matching the regexp
+func (v GitlabVCS) GetStringPullRequestComment(ctx context.Context, runinfo *RunInfo, reg string) ([]*Comment, error) {
+	var ret []*Comment
+	notes := []gitlabNote{}
+	if err := v.paginate(ctx,
+		fmt.Sprintf("/projects/%s/merge_requests/%d/notes", gitlabProjectID(runinfo), runinfo.PullRequestNumber),
+		func(data []byte) error {
+			page := []gitlabNote{}
+			if err := json.Unmarshal(data, &page); err != nil {
+				return err
+			}
+			notes = append(notes, page...)
+			return nil
+		}); err != nil {
+		return nil, err
+	}
+
+	re := regexp.MustCompile(reg)
+	for _, note := range notes {
+		if note.System {
+			continue
+		}
+		if string(re.Find([]byte(note.Body))) != "" {
+			ret = append(ret, &Comment{
+				ID:     note.ID,
+				Sender: note.Author.Username,
+				Body:   note.Body,
+			})
+		}
+	}
+	return ret, nil
+}
+
+// GetTektonDir Get all yaml files from the tekton directory of a repository
+// as one multi document yaml string
+func (v GitlabVCS) GetTektonDir(ctx context.Context, dirPath string, runinfo *RunInfo) (string, error) {
+	entries := []gitlabTreeEntry{}
+	err := v.paginate(ctx,
+		fmt.Sprintf("/projects/%s/repository/tree?path=%s&ref=%s&recursive=true", gitlabProjectID(runinfo),
+			url.QueryEscape(dirPath), url.QueryEscape(runinfo.SHA)),
+		func(data []byte) error {
+			page := []gitlabTreeEntry{}
+			if err := json.Unmarshal(data, &page); err != nil {
+				return err
+			}
+			entries = append(entries, page...)
+			return nil
+		})
+	if isNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	var allTemplates string
+	for _, entry := range entries {
+		if entry.Type != "blob" ||
+			!(strings.HasSuffix(entry.Name, ".yaml") || strings.HasSuffix(entry.Name, ".yml")) {
+			continue
+		}
+		data, _, err := v.rest.raw(ctx, http.MethodGet,
+			fmt.Sprintf("/projects/%s/repository/blobs/%s/raw", gitlabProjectID(runinfo), entry.ID), nil)
+		if err != nil {
+			return "", err
+		}
+		if allTemplates != "" && !strings.HasPrefix(string(data), "---") {
+			allTemplates += "---"
+		}
+		allTemplates += "\n" + string(data) + "\n"
+	}
+	return allTemplates, nil
+}
+
+// GetFileInsideRepo Get a file via the GitLab API using the runinfo
+// information, if branch is true, use the branch as ref instead of the SHA
+func (v GitlabVCS) GetFileInsideRepo(ctx context.Context, filePath string, branch bool, runinfo *RunInfo) (string, error) {
+	ref := runinfo.SHA
+	if branch {
+		ref = runinfo.BaseBranch
+	}
+
+	data, _, err := v.rest.raw(ctx, http.MethodGet,
+		fmt.Sprintf("/projects/%s/repository/files/%s/raw?ref=%s", gitlabProjectID(runinfo),
+			url.PathEscape(filePath), url.QueryEscape(ref)), nil)
+	if isNotFound(err) {
+		return "", fmt.Errorf("cannot find %s in this repository", filePath)
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// GetFileFromDefaultBranch will get a file directly from the default branch of the project
+func (v GitlabVCS) GetFileFromDefaultBranch(ctx context.Context, filePath string, runinfo *RunInfo) (string, error) {
+	return getFileFromDefaultBranch(ctx, v, filePath, runinfo)
+}
+
+// GetBranchSHA get the SHA of the head commit of a branch
+func (v GitlabVCS) GetBranchSHA(ctx context.Context, branch string, runinfo *RunInfo) (string, error) {
+	glbranch := struct {
+		Commit struct {
+			ID string `json:"id"`
+		} `json:"commit"`
+	}{}
+	if _, err := v.rest.do(ctx, http.MethodGet,
+		fmt.Sprintf("/projects/%s/repository/branches/%s", gitlabProjectID(runinfo), url.PathEscape(branch)), nil, &glbranch); err != nil {
+		return "", err
+	}
+	return glbranch.Commit.ID, nil
+}
+
+// GetFilesChanged get the files changed by the merge request or by the
+// commits of the push, a push creating a branch or a recheck of a push only
+// has the files changed by its head commit
+func (v GitlabVCS) GetFilesChanged(ctx context.Context, runinfo *RunInfo) ([]string, error) {
+	diffs := []gitlabDiff{}
+	listDiffs := func(data []byte) error {
+		page := []gitlabDiff{}
+		if err := json.Unmarshal(data, &page); err != nil {
+			return err
+		}
+		diffs = append(diffs, page...)
+		return nil
+	}
+	var err error
+	event, isPush := runinfo.Event.(*GitlabPushEvent)
+	switch {
+	case runinfo.PullRequestNumber != 0:
+		err = v.paginate(ctx,
+			fmt.Sprintf("/projects/%s/merge_requests/%d/diffs", gitlabProjectID(runinfo), runinfo.PullRequestNumber),
+			listDiffs)
+	case isPush && !isZeroSHA(event.Before):
+		comparison := struct {
+			Diffs []gitlabDiff `json:"diffs"`
+		}{}
+		_, err = v.rest.do(ctx, http.MethodGet,
+			fmt.Sprintf("/projects/%s/repository/compare?from=%s&to=%s", gitlabProjectID(runinfo),
+				url.QueryEscape(event.Before), url.QueryEscape(runinfo.SHA)), nil, &comparison)
+		diffs = comparison.Diffs
+	default:
+		err = v.paginate(ctx,
+			fmt.Sprintf("/projects/%s/repository/commits/%s/diff", gitlabProjectID(runinfo), url.PathEscape(runinfo.SHA)),
+			listDiffs)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	files := changedFiles{}
+	for _, diff := range diffs {
+		files.add(diff.OldPath, diff.NewPath)
+	}
+	return files.list(), nil
+}
+
+// gitlabState convert a check run status and conclusion to a GitLab commit status state
+func gitlabState(status, conclusion string) string {
+	if status != "completed" {
+		return "running"
+	}
+	switch conclusion {
+	case "success":
+		return "success"
+	case "failure":
+		return "failed"
+	}
+	return "canceled"
+}
+
+func (v GitlabVCS) setCommitStatus(ctx context.Context, runinfo *RunInfo, state, description, detailsURL string) (*gitlabCommitStatus, error) {
+	opts := map[string]string{
+		"state":       state,
+		"name":        runinfo.ApplicationName,
+		"description": description,
+	}
+	if detailsURL != "" {
+		opts["target_url"] = detailsURL
+	}
+
+	cstatus := &gitlabCommitStatus{}
+	_, err := v.rest.do(ctx, http.MethodPost,
+		fmt.Sprintf("/projects/%s/statuses/%s", gitlabProjectID(runinfo), url.PathEscape(runinfo.SHA)), opts, cstatus)
+	// GitLab refuses to set a status to the state it already has, it's fine for us.
+	var aerr *apiError
+	if errors.As(err, &aerr) && aerr.StatusCode == http.StatusBadRequest &&
+		strings.Contains(aerr.Body, "Cannot transition status") {
+		return cstatus, nil
+	}
+	return cstatus, err
+}
+
+// CreateCheckRun create a running commit status and set its ID in runinfo
+func (v GitlabVCS) CreateCheckRun(ctx context.Context, status string, runinfo *RunInfo) error {
+	title, _ := statusTitleSummary(runinfo, status, "")
+	cstatus, err := v.setCommitStatus(ctx, runinfo, gitlabState(status, ""), title, runinfo.LogURL)
+	if err != nil {
+		return err
+	}
+	runinfo.CheckRunID = &cstatus.ID
+	return nil
+}
+
+// CreateStatus set the commit status and when completed report the details
+// as a note on the merge request
+func (v GitlabVCS) CreateStatus(ctx context.Context, runinfo *RunInfo, status, conclusion, text, detailsURL string) error {
+	title, _ := statusTitleSummary(runinfo, status, conclusion)
+	if _, err := v.setCommitStatus(ctx, runinfo, gitlabState(status, conclusion), title, detailsURL); err != nil {
+		return err
+	}
+
+	if runinfo.PullRequestNumber == 0 {
+		return nil
+	}
+	if runinfo.PullRequestComment {
+		return v.createOrUpdatePullRequestComment(ctx, runinfo, markedStatusComment(runinfo, status, conclusion, text))
+	}
+	if status != "completed" {
+		return nil
+	}
+
+	_, err := v.rest.do(ctx, http.MethodPost,
+		fmt.Sprintf("/projects/%s/merge_requests/%d/notes", gitlabProjectID(runinfo), runinfo.PullRequestNumber),
+		map[string]string{"body": statusComment(runinfo, status, conclusion, text)}, nil)
+	return err
+}
+
+// createOrUpdatePullRequestComment edit the note of the runinfo merge request
+// marked for the runinfo application, or create it
+func (v GitlabVCS) createOrUpdatePullRequestComment(ctx context.Context, runinfo *RunInfo, body string) error {
+	self := GitlabUser{}
+	if _, err := v.rest.do(ctx, http.MethodGet, "/user", nil, &self); err != nil {
+		return fmt.Errorf("cannot get the account of the token: %w", err)
+	}
+	note, err := findPullRequestComment(ctx, v, runinfo, runinfo.ApplicationName, self.Username)
+	if err != nil {
+		return err
+	}
+	notesPath := fmt.Sprintf("/projects/%s/merge_requests/%d/notes", gitlabProjectID(runinfo), runinfo.PullRequestNumber)
+	if note != nil {
+		_, err = v.rest.do(ctx, http.MethodPut, fmt.Sprintf("%s/%d", notesPath, note.ID), map[string]string{"body": body}, nil)
+		return err
+	}
+	_, err = v.rest.do(ctx, http.MethodPost, notesPath, map[string]string{"body": body}, nil)
+	return err
+}
@@ -0,0 +1,472 @@
+package webvcs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	gltesthelper "github.com/openshift-pipelines/pipelines-as-code/pkg/test/gitlab"
+	"gotest.tools/v3/assert"
+	rtesting "knative.dev/pkg/reconciler/testing"
+)
+
+func setupGitlabFakeCommit(mux *http.ServeMux, project, sha string) {
+	mux.HandleFunc(fmt.Sprintf("/projects/%s/repository/commits/%s", project, sha), func(rw http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(rw, `{"id": "%s", "title": "HELLO", "web_url": "https://gitlab/%s/-/commit/%s"}`, sha, project, sha)
+	})
+}
+
+func TestGitlabParsePayload(t *testing.T) {
+	projectJSON := `{"name": "repo", "path_with_namespace": "group/sub/repo",
+		"web_url": "https://gitlab.com/group/sub/repo", "default_branch": "main"}`
+	tests := []struct {
+		name          string
+		eventType     string
+		payload       string
+		wantErr       string
+		eventTypeR    string
+		sender        string
+		baseBranch    string
+		tag           string
+		prNumber      int
+		triggerTarget string
+	}{
+		{
+			name:       "merge request",
+			eventType:  "Merge Request Hook",
+			payload:    fmt.Sprintf(`{"object_kind": "merge_request", "user": {"username": "updater"}, "project": %s, "object_attributes": {"iid": 5, "action": "open"}}`, projectJSON),
+			eventTypeR: "pull_request",
+			sender:     "author",
+			baseBranch: "main",
+			prNumber:   5,
+		},
+		{
+			name:          "note on merge request",
+			eventType:     "Note Hook",
+			payload:       fmt.Sprintf(`{"object_kind": "note", "user": {"username": "commenter"}, "project": %s, "object_attributes": {"note": "/retest", "noteable_type": "MergeRequest"}, "merge_request": {"iid": 5}}`, projectJSON),
+			triggerTarget: TriggerTargetRetestComment,
+			eventTypeR:    "pull_request",
+			sender:        "commenter",
+			baseBranch:    "main",
+			prNumber:      5,
+		},
+		{
+			name:      "note on a commit",
+			eventType: "Note Hook",
+			payload:   fmt.Sprintf(`{"object_kind": "note", "project": %s, "object_attributes": {"noteable_type": "Commit"}}`, projectJSON),
+			wantErr:   "not coming from a merge_request",
+		},
+		{
+			name:       "push",
+			eventType:  "Push Hook",
+			payload:    fmt.Sprintf(`{"object_kind": "push", "ref": "refs/heads/main", "checkout_sha": "mrsha", "user_username": "pusher", "project": %s}`, projectJSON),
+			eventTypeR: "push",
+			sender:     "pusher",
+			baseBranch: "refs/heads/main",
+		},
+		{
+			name:       "tag push",
+			eventType:  "Tag Push Hook",
+			payload:    fmt.Sprintf(`{"object_kind": "tag_push", "ref": "refs/tags/v1.2.3", "checkout_sha": "mrsha", "user_username": "pusher", "project": %s}`, projectJSON),
+			eventTypeR: "tag_push",
+			sender:     "pusher",
+			baseBranch: "refs/tags/v1.2.3",
+			tag:        "v1.2.3",
+		},
+		{
+			name:      "unknown event",
+			eventType: "Pipeline Hook",
+			payload:   `{}`,
+			wantErr:   "this event is not supported",
+		},
+		{
+			name:      "invalid payload",
+			eventType: "Push Hook",
+			payload:   `hello moto`,
+			wantErr:   "invalid character",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux, serverURL, teardown := gltesthelper.SetupGL()
+			defer teardown()
+			mux.HandleFunc("/projects/group/sub/repo/merge_requests/5", func(rw http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(rw, `{"iid": 5, "sha": "mrsha", "source_branch": "feature", "target_branch": "main", "author": {"username": "author"}}`)
+			})
+			setupGitlabFakeCommit(mux, "group/sub/repo", "mrsha")
+
+			ctx, _ := rtesting.SetupFakeContext(t)
+			logger, _ := getLogger()
+			glvcs := NewGitlabVCS("token", serverURL)
+			runinfo, err := glvcs.ParsePayload(ctx, logger, tt.eventType, "target", tt.payload)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, runinfo.Owner, "group/sub")
+			assert.Equal(t, runinfo.Repository, "repo")
+			assert.Equal(t, runinfo.URL, "https://gitlab.com/group/sub/repo")
+			assert.Equal(t, runinfo.DefaultBranch, "main")
+			assert.Equal(t, runinfo.SHA, "mrsha")
+			assert.Equal(t, runinfo.SHATitle, "HELLO")
+			assert.Equal(t, runinfo.EventType, tt.eventTypeR)
+			assert.Equal(t, runinfo.Sender, tt.sender)
+			assert.Equal(t, runinfo.BaseBranch, tt.baseBranch)
+			assert.Equal(t, runinfo.Tag, tt.tag)
+			assert.Equal(t, runinfo.PullRequestNumber, tt.prNumber)
+			wantTriggerTarget := "target"
+			if tt.triggerTarget != "" {
+				wantTriggerTarget = tt.triggerTarget
+			}
+			assert.Equal(t, runinfo.TriggerTarget, wantTriggerTarget)
+		})
+	}
+}
+
+func TestGitlabGetTektonDir(t *testing.T) {
+	mux, serverURL, teardown := gltesthelper.SetupGL()
+	defer teardown()
+	mux.HandleFunc("/projects/tekton/dir/repository/tree", func(rw http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, r.URL.Query().Get("path"), ".tekton")
+		assert.Equal(t, r.URL.Query().Get("ref"), "sha")
+		assert.Equal(t, r.URL.Query().Get("recursive"), "true")
+		if r.URL.Query().Get("page") == "2" {
+			fmt.Fprint(rw, `[
+			{"id": "taskyaml", "name": "task.yaml", "type": "blob", "path": ".tekton/tasks/task.yaml"}]`)
+			return
+		}
+		rw.Header().Set("X-Next-Page", "2")
+		fmt.Fprint(rw, `[
+			{"id": "pipelineyaml", "name": "pipeline.yaml", "type": "blob"},
+			{"id": "readme", "name": "README.md", "type": "blob"},
+			{"id": "tasks", "name": "tasks", "type": "tree"},
+			{"id": "runyaml", "name": "run.yml", "type": "blob"}]`)
+	})
+	mux.HandleFunc("/projects/tekton/dir/repository/blobs/pipelineyaml/raw", func(rw http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(rw, "hello pipelineyaml")
+	})
+	mux.HandleFunc("/projects/tekton/dir/repository/blobs/runyaml/raw", func(rw http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(rw, "hello runyaml")
+	})
+	mux.HandleFunc("/projects/tekton/dir/repository/blobs/taskyaml/raw", func(rw http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(rw, "hello taskyaml")
+	})
+	mux.HandleFunc("/projects/pas/la/repository/tree", func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(rw, `{"message": "404 Tree Not Found"}`)
+	})
+
+	ctx, _ := rtesting.SetupFakeContext(t)
+	glvcs := NewGitlabVCS("token", serverURL)
+
+	got, err := glvcs.GetTektonDir(ctx, ".tekton", &RunInfo{Owner: "tekton", Repository: "dir", SHA: "sha"})
+	assert.NilError(t, err)
+	assert.Equal(t, got, "\nhello pipelineyaml\n---\nhello runyaml\n---\nhello taskyaml\n")
+
+	got, err = glvcs.GetTektonDir(ctx, ".tekton", &RunInfo{Owner: "pas", Repository: "la", SHA: "sha"})
+	assert.NilError(t, err)
+	assert.Equal(t, got, "")
+}
+
+func TestGitlabGetFileInsideRepo(t *testing.T) {
+	mux, serverURL, teardown := gltesthelper.SetupGL()
+	defer teardown()
+	mux.HandleFunc("/projects/foo/bar/repository/files/", func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/projects/foo/bar/repository/files/dir/README.md/raw" {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprintf(rw, "hello %s", r.URL.Query().Get("ref"))
+	})
+
+	ctx, _ := rtesting.SetupFakeContext(t)
+	glvcs := NewGitlabVCS("token", serverURL)
+	runinfo := &RunInfo{Owner: "foo", Repository: "bar", SHA: "sha", BaseBranch: "branch", DefaultBranch: "main"}
+
+	got, err := glvcs.GetFileInsideRepo(ctx, "dir/README.md", false, runinfo)
+	assert.NilError(t, err)
+	assert.Equal(t, got, "hello sha")
+
+	got, err = glvcs.GetFileInsideRepo(ctx, "dir/README.md", true, runinfo)
+	assert.NilError(t, err)
+	assert.Equal(t, got, "hello branch")
+
+	got, err = glvcs.GetFileFromDefaultBranch(ctx, "dir/README.md", runinfo)
+	assert.NilError(t, err)
+	assert.Equal(t, got, "hello main")
+
+	_, err = glvcs.GetFileInsideRepo(ctx, "OWNERS", false, runinfo)
+	assert.ErrorContains(t, err, "cannot find OWNERS in this repository")
+}
+
+func TestGitlabGetBranchSHA(t *testing.T) {
+	mux, serverURL, teardown := gltesthelper.SetupGL()
+	defer teardown()
+	mux.HandleFunc("/projects/foo/bar/repository/branches/feature/one", func(rw http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(rw, `{"name": "feature/one", "commit": {"id": "headsha"}}`)
+	})
+
+	ctx, _ := rtesting.SetupFakeContext(t)
+	glvcs := NewGitlabVCS("token", serverURL)
+	got, err := glvcs.GetBranchSHA(ctx, "feature/one", &RunInfo{Owner: "foo", Repository: "bar"})
+	assert.NilError(t, err)
+	assert.Equal(t, got, "headsha")
+}
+
+func TestGitlabCheckSenderOrgMembership(t *testing.T) {
+	tests := []struct {
+		name, apiReturn string
+		allowed         bool
+	}{
+		{
+			name:      "developer is allowed",
+			apiReturn: `[{"username": "me", "access_level": 30}]`,
+			allowed:   true,
+		},
+		{
+			name:      "reporter is not allowed",
+			apiReturn: `[{"username": "me", "access_level": 20}]`,
+			allowed:   false,
+		},
+		{
+			name:      "not a member",
+			apiReturn: `[{"username": "meme", "access_level": 50}]`,
+			allowed:   false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux, serverURL, teardown := gltesthelper.SetupGL()
+			defer teardown()
+			mux.HandleFunc("/projects/owner/repo/members/all", func(rw http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, r.URL.Query().Get("query"), "me")
+				fmt.Fprint(rw, tt.apiReturn)
+			})
+			ctx, _ := rtesting.SetupFakeContext(t)
+			glvcs := NewGitlabVCS("token", serverURL)
+			allowed, err := glvcs.CheckSenderOrgMembership(ctx, &RunInfo{Owner: "owner", Repository: "repo", Sender: "me"})
+			assert.NilError(t, err)
+			assert.Equal(t, tt.allowed, allowed)
+		})
+	}
+}
+
+func TestGitlabGetStringPullRequestComment(t *testing.T) {
+	mux, serverURL, teardown := gltesthelper.SetupGL()
+	defer teardown()
+	mux.HandleFunc("/projects/owner/repo/merge_requests/5/notes", func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("page") == "2" {
+			fmt.Fprint(rw, `[{"body": "/ok-to-test", "author": {"username": "nextpage"}}]`)
+			return
+		}
+		rw.Header().Set("X-Next-Page", "2")
+		fmt.Fprint(rw, `[
+			{"body": "/ok-to-test", "author": {"username": "owner"}},
+			{"body": "/ok-to-test", "system": true, "author": {"username": "system"}},
+			{"body": "hello", "author": {"username": "other"}}]`)
+	})
+	ctx, _ := rtesting.SetupFakeContext(t)
+	glvcs := NewGitlabVCS("token", serverURL)
+	comments, err := glvcs.GetStringPullRequestComment(ctx,
+		&RunInfo{Owner: "owner", Repository: "repo", PullRequestNumber: 5}, `(^|\n)/ok-to-test(\r\n|$)`)
+	assert.NilError(t, err)
+	assert.Equal(t, len(comments), 2)
+	assert.Equal(t, comments[0].Sender, "owner")
+	assert.Equal(t, comments[1].Sender, "nextpage")
+}
+
+func TestGitlabCreateStatus(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     string
+		conclusion string
+		prNumber   int
+		state      string
+		wantNote   bool
+	}{
+		{
+			name:   "in progress",
+			status: "in_progress",
+			state:  "running",
+		},
+		{
+			name:       "success on merge request",
+			status:     "completed",
+			conclusion: "success",
+			prNumber:   5,
+			state:      "success",
+			wantNote:   true,
+		},
+		{
+			name:       "failure on push",
+			status:     "completed",
+			conclusion: "failure",
+			state:      "failed",
+		},
+		{
+			name:       "skipped",
+			status:     "completed",
+			conclusion: "skipped",
+			prNumber:   5,
+			state:      "canceled",
+			wantNote:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux, serverURL, teardown := gltesthelper.SetupGL()
+			defer teardown()
+			gotNote := false
+			mux.HandleFunc("/projects/owner/repo/statuses/sha", func(rw http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, r.Header.Get("PRIVATE-TOKEN"), "token")
+				body, _ := ioutil.ReadAll(r.Body)
+				opts := map[string]string{}
+				assert.NilError(t, json.Unmarshal(body, &opts))
+				assert.Equal(t, opts["state"], tt.state)
+				assert.Equal(t, opts["name"], "PAC")
+				assert.Equal(t, opts["target_url"], "https://url")
+				if tt.status != "completed" {
+					// GitLab refuses to set the same state twice
+					rw.WriteHeader(http.StatusBadRequest)
+					fmt.Fprint(rw, `{"message": "Cannot transition status via :run from :running"}`)
+					return
+				}
+				fmt.Fprint(rw, `{"id": 42}`)
+			})
+			mux.HandleFunc("/projects/owner/repo/merge_requests/5/notes", func(rw http.ResponseWriter, r *http.Request) {
+				gotNote = true
+				body, _ := ioutil.ReadAll(r.Body)
+				assert.Assert(t, json.Valid(body))
+				fmt.Fprint(rw, `{}`)
+			})
+			ctx, _ := rtesting.SetupFakeContext(t)
+			glvcs := NewGitlabVCS("token", serverURL)
+			runinfo := &RunInfo{
+				Owner: "owner", Repository: "repo", SHA: "sha",
+				ApplicationName: "PAC", PullRequestNumber: tt.prNumber,
+			}
+			err := glvcs.CreateStatus(ctx, runinfo, tt.status, tt.conclusion, "text", "https://url")
+			assert.NilError(t, err)
+			assert.Equal(t, gotNote, tt.wantNote)
+		})
+	}
+}
+
+func TestGitlabCreateStatusPullRequestComment(t *testing.T) {
+	tests := []struct {
+		name         string
+		notes        string
+		wantRequests []string
+	}{
+		{
+			name:         "create the note",
+			notes:        `[{"id": 6, "body": "LGTM", "author": {"username": "reviewer"}}]`,
+			wantRequests: []string{"GET /projects/owner/repo/merge_requests/5/notes", "POST /projects/owner/repo/merge_requests/5/notes"},
+		},
+		{
+			name: "update the note",
+			notes: `[{"id": 6, "body": "LGTM", "author": {"username": "reviewer"}},
+				{"id": 7, "body": "<!-- pipelines-as-code: PAC -->\n**CI has Started**", "author": {"username": "bot"}}]`,
+			wantRequests: []string{"GET /projects/owner/repo/merge_requests/5/notes", "PUT /projects/owner/repo/merge_requests/5/notes/7"},
+		},
+		{
+			name:         "ignore the note of another account",
+			notes:        `[{"id": 6, "body": "<!-- pipelines-as-code: PAC -->\n**CI has Started**", "author": {"username": "reviewer"}}]`,
+			wantRequests: []string{"GET /projects/owner/repo/merge_requests/5/notes", "POST /projects/owner/repo/merge_requests/5/notes"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux, serverURL, teardown := gltesthelper.SetupGL()
+			defer teardown()
+			mux.HandleFunc("/projects/owner/repo/statuses/sha", func(rw http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(rw, `{"id": 42}`)
+			})
+			mux.HandleFunc("/user", func(rw http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(rw, `{"username": "bot"}`)
+			})
+			requests := []string{}
+			noteHandler := func(rw http.ResponseWriter, r *http.Request) {
+				requests = append(requests, r.Method+" "+r.URL.Path)
+				if r.Method == http.MethodGet {
+					fmt.Fprint(rw, tt.notes)
+					return
+				}
+				note := map[string]string{}
+				assert.NilError(t, json.NewDecoder(r.Body).Decode(&note))
+				assert.Assert(t, strings.HasPrefix(note["body"], "<!-- pipelines-as-code: PAC -->\n**✅ Success**"), note["body"])
+				fmt.Fprint(rw, `{}`)
+			}
+			mux.HandleFunc("/projects/owner/repo/merge_requests/5/notes", noteHandler)
+			mux.HandleFunc("/projects/owner/repo/merge_requests/5/notes/7", noteHandler)
+			ctx, _ := rtesting.SetupFakeContext(t)
+			glvcs := NewGitlabVCS("token", serverURL)
+			runinfo := &RunInfo{
+				Owner: "owner", Repository: "repo", SHA: "sha",
+				ApplicationName: "PAC", PullRequestNumber: 5, PullRequestComment: true,
+			}
+			assert.NilError(t, glvcs.CreateStatus(ctx, runinfo, "completed", "success", "text", "https://url"))
+			assert.DeepEqual(t, requests, tt.wantRequests)
+		})
+	}
+}
+
+func TestGitlabCreateCheckRun(t *testing.T) {
+	mux, serverURL, teardown := gltesthelper.SetupGL()
+	defer teardown()
+	mux.HandleFunc("/projects/owner/repo/statuses/sha", func(rw http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(rw, `{"id": 42}`)
+	})
+	ctx, _ := rtesting.SetupFakeContext(t)
+	glvcs := NewGitlabVCS("token", serverURL)
+	runinfo := &RunInfo{Owner: "owner", Repository: "repo", SHA: "sha"}
+	err := glvcs.CreateCheckRun(ctx, "in_progress", runinfo)
+	assert.NilError(t, err)
+	assert.Equal(t, *runinfo.CheckRunID, int64(42))
+}
+
+func TestGitlabGetFilesChanged(t *testing.T) {
+	mux, serverURL, teardown := gltesthelper.SetupGL()
+	defer teardown()
+	mux.HandleFunc("/projects/owner/repo/merge_requests/6/diffs", func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("page") == "2" {
+			fmt.Fprint(rw, `[{"old_path": "Makefile", "new_path": "Makefile"}]`)
+			return
+		}
+		rw.Header().Set("X-Next-Page", "2")
+		fmt.Fprint(rw, `[{"old_path": "pkg/old.go", "new_path": "pkg/new.go"}]`)
+	})
+	mux.HandleFunc("/projects/owner/repo/repository/compare", func(rw http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, r.URL.Query().Get("from"), "before")
+		assert.Equal(t, r.URL.Query().Get("to"), "sha")
+		fmt.Fprint(rw, `{"diffs": [{"old_path": "main.go", "new_path": "main.go"},
+			{"old_path": "docs/index.md", "new_path": "docs/index.md"}]}`)
+	})
+	mux.HandleFunc("/projects/owner/repo/repository/commits/sha/diff", func(rw http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(rw, `[{"old_path": "main.go", "new_path": "main.go"}]`)
+	})
+
+	ctx, _ := rtesting.SetupFakeContext(t)
+	glvcs := NewGitlabVCS("token", serverURL)
+
+	got, err := glvcs.GetFilesChanged(ctx, &RunInfo{Owner: "owner", Repository: "repo", SHA: "sha", PullRequestNumber: 6})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, got, []string{"Makefile", "pkg/new.go", "pkg/old.go"})
+
+	got, err = glvcs.GetFilesChanged(ctx, &RunInfo{
+		Owner: "owner", Repository: "repo", SHA: "sha", Event: &GitlabPushEvent{Before: "before"},
+	})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, got, []string{"docs/index.md", "main.go"})
+
+	got, err = glvcs.GetFilesChanged(ctx, &RunInfo{
+		Owner: "owner", Repository: "repo", SHA: "sha",
+		Event: &GitlabPushEvent{Before: "0000000000000000000000000000000000000000"},
+	})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, got, []string{"main.go"})
+}
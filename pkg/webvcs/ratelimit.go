@@ -0,0 +1,284 @@
+package webvcs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	githubRateLimitHeader     = "X-RateLimit-Limit"
+	githubRateRemainingHeader = "X-RateLimit-Remaining"
+	githubRateResetHeader     = "X-RateLimit-Reset"
+	githubRateResourceHeader  = "X-RateLimit-Resource"
+	retryAfterHeader          = "Retry-After"
+
+	// githubMaxRetries is the number of times a rate limited call is retried
+	githubMaxRetries = 3
+	// githubMaxRetryWait is the longest we wait before a retry, when the rate
+	// limit resets later than that the call fails right away
+	githubMaxRetryWait = time.Minute
+	// githubRetryBackoff is the first wait when GitHub does not tell us how
+	// long to wait, it doubles on each retry
+	githubRetryBackoff = time.Second
+	// the remaining quota is logged when it goes under that ratio of the limit
+	githubRateLowRatio = 10
+)
+
+// GithubRateLimits keep track of the GitHub rate limits quota we have seen on
+// the responses and of the calls retried because of them
+var GithubRateLimits = &githubRateLimits{
+	quotas:  map[githubRateKey]githubQuota{},
+	retries: map[githubRetryKey]int64{},
+}
+
+type githubRateKey struct {
+	host, resource string
+}
+
+type githubRetryKey struct {
+	host, reason string
+}
+
+type githubQuota struct {
+	limit, remaining int
+	reset            time.Time
+}
+
+type githubRateLimits struct {
+	mu      sync.Mutex
+	log     *zap.SugaredLogger
+	quotas  map[githubRateKey]githubQuota
+	retries map[githubRetryKey]int64
+}
+
+// SetLogger set the logger where the retries and the quota getting low are
+// logged, nothing is logged without it
+func (l *githubRateLimits) SetLogger(log *zap.SugaredLogger) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.log = log
+}
+
+func (l *githubRateLimits) logger() *zap.SugaredLogger {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.log == nil {
+		return zap.NewNop().Sugar()
+	}
+	return l.log
+}
+
+// update record the quota of a response, it is logged when it crosses the low
+// ratio of the limit
+func (l *githubRateLimits) update(host string, resp *http.Response) {
+	limit, err := strconv.Atoi(resp.Header.Get(githubRateLimitHeader))
+	if err != nil {
+		return
+	}
+	remaining, err := strconv.Atoi(resp.Header.Get(githubRateRemainingHeader))
+	if err != nil {
+		return
+	}
+	reset, _ := strconv.ParseInt(resp.Header.Get(githubRateResetHeader), 10, 64)
+	resource := resp.Header.Get(githubRateResourceHeader)
+	if resource == "" {
+		resource = "core"
+	}
+
+	key := githubRateKey{host: host, resource: resource}
+	low := limit / githubRateLowRatio
+	l.mu.Lock()
+	previous, seen := l.quotas[key]
+	l.quotas[key] = githubQuota{limit: limit, remaining: remaining, reset: time.Unix(reset, 0)}
+	l.mu.Unlock()
+
+	if remaining < low && (!seen || previous.remaining >= low) {
+		l.logger().Warnf("GitHub %s rate limit on %s is getting low: %d calls remaining out of %d until %s",
+			resource, host, remaining, limit, time.Unix(reset, 0).UTC().Format(time.RFC3339))
+	}
+}
+
+func (l *githubRateLimits) retried(host, reason string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.retries[githubRetryKey{host: host, reason: reason}]++
+}
+
+// WriteMetrics write the quota we have seen and the retries in the
+// prometheus text format
+func (l *githubRateLimits) WriteMetrics(w io.Writer) error {
+	l.mu.Lock()
+	quotas := make([]githubRateKey, 0, len(l.quotas))
+	for key := range l.quotas {
+		quotas = append(quotas, key)
+	}
+	retries := make([]githubRetryKey, 0, len(l.retries))
+	for key := range l.retries {
+		retries = append(retries, key)
+	}
+	var b strings.Builder
+	sort.Slice(quotas, func(i, j int) bool {
+		return quotas[i].host+"/"+quotas[i].resource < quotas[j].host+"/"+quotas[j].resource
+	})
+	sort.Slice(retries, func(i, j int) bool {
+		return retries[i].host+"/"+retries[i].reason < retries[j].host+"/"+retries[j].reason
+	})
+	for _, gauge := range []struct {
+		name, help string
+		value      func(githubQuota) int
+	}{
+		{"pac_github_ratelimit_limit", "The GitHub rate limit quota.", func(q githubQuota) int { return q.limit }},
+		{"pac_github_ratelimit_remaining", "The GitHub rate limit quota remaining.", func(q githubQuota) int { return q.remaining }},
+	} {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n", gauge.name, gauge.help, gauge.name)
+		for _, key := range quotas {
+			fmt.Fprintf(&b, "%s{host=%q,resource=%q} %d\n", gauge.name, key.host, key.resource, gauge.value(l.quotas[key]))
+		}
+	}
+	fmt.Fprintf(&b, "# HELP pac_github_retries_total The GitHub calls retried.\n# TYPE pac_github_retries_total counter\n")
+	for _, key := range retries {
+		fmt.Fprintf(&b, "pac_github_retries_total{host=%q,reason=%q} %d\n", key.host, key.reason, l.retries[key])
+	}
+	l.mu.Unlock()
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// githubRetryTransport retry the idempotent GitHub calls hitting the primary
+// or the secondary rate limit or failing on a transient server error, waiting
+// as long as GitHub tells us to or with an exponential backoff
+type githubRetryTransport struct {
+	base       http.RoundTripper
+	limits     *githubRateLimits
+	maxRetries int
+	maxWait    time.Duration
+	backoff    time.Duration
+	now        func() time.Time
+	wait       func(ctx context.Context, d time.Duration) error
+}
+
+func newGithubRetryTransport(base http.RoundTripper) *githubRetryTransport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &githubRetryTransport{
+		base:       base,
+		limits:     GithubRateLimits,
+		maxRetries: githubMaxRetries,
+		maxWait:    githubMaxRetryWait,
+		backoff:    githubRetryBackoff,
+		now:        time.Now,
+		wait:       waitContext,
+	}
+}
+
+func waitContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func isIdempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+	}
+	return false
+}
+
+func (t *githubRetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	backoff := t.backoff
+	for attempt := 0; ; attempt++ {
+		resp, err := t.base.RoundTrip(req)
+		if err != nil {
+			return resp, err
+		}
+		t.limits.update(req.URL.Host, resp)
+
+		if attempt >= t.maxRetries || !isIdempotent(req) {
+			return resp, nil
+		}
+		reason, wait := t.retryAfter(resp, backoff)
+		if reason == "" || wait > t.maxWait {
+			return resp, nil
+		}
+
+		// The response is thrown away, drain it so the connection is reused
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		t.limits.retried(req.URL.Host, reason)
+		t.limits.logger().Infof("GitHub call %s %s failed with %s, retrying in %s (%d/%d)",
+			req.Method, req.URL.Path, reason, wait, attempt+1, t.maxRetries)
+		if err := t.wait(req.Context(), wait); err != nil {
+			return nil, err
+		}
+
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+		backoff *= 2
+	}
+}
+
+// retryAfter tell if a response is to be retried, why and how long to wait
+// before, it is not retried when the reason is empty
+func (t *githubRetryTransport) retryAfter(resp *http.Response, backoff time.Duration) (string, time.Duration) {
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusForbidden:
+	case resp.StatusCode == http.StatusBadGateway, resp.StatusCode == http.StatusServiceUnavailable,
+		resp.StatusCode == http.StatusGatewayTimeout:
+		return "server error", backoff
+	default:
+		return "", 0
+	}
+
+	if seconds, err := strconv.Atoi(resp.Header.Get(retryAfterHeader)); err == nil {
+		return "secondary rate limit", time.Duration(seconds) * time.Second
+	}
+	if resp.Header.Get(githubRateRemainingHeader) == "0" {
+		reset, err := strconv.ParseInt(resp.Header.Get(githubRateResetHeader), 10, 64)
+		if err != nil {
+			return "primary rate limit", backoff
+		}
+		wait := time.Unix(reset, 0).Sub(t.now())
+		if wait < 0 {
+			wait = 0
+		}
+		return "primary rate limit", wait
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return "secondary rate limit", backoff
+	}
+
+	// A 403 is otherwise a real permission error unless the body tells us we
+	// hit the secondary rate limit, the body is put back for the caller
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err == nil && (bytes.Contains(body, []byte("secondary rate limit")) || bytes.Contains(body, []byte("abuse"))) {
+		return "secondary rate limit", backoff
+	}
+	return "", 0
+}
@@ -0,0 +1,231 @@
+package webvcs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	zapobserver "go.uber.org/zap/zaptest/observer"
+	"gotest.tools/v3/assert"
+)
+
+type fakeReply struct {
+	code    int
+	headers map[string]string
+	body    string
+}
+
+func TestGithubRetryTransport(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	reset := func(d time.Duration) string { return fmt.Sprintf("%d", now.Add(d).Unix()) }
+	ok := fakeReply{code: http.StatusOK, body: "ok"}
+
+	tests := []struct {
+		name     string
+		method   string
+		body     string
+		replies  []fakeReply
+		wantCode int
+		wantBody string
+		wantWait []time.Duration
+	}{
+		{
+			name:     "no retry",
+			replies:  []fakeReply{ok},
+			wantCode: http.StatusOK,
+			wantBody: "ok",
+		},
+		{
+			name: "primary rate limit",
+			replies: []fakeReply{
+				{code: http.StatusForbidden, headers: map[string]string{
+					githubRateRemainingHeader: "0", githubRateResetHeader: reset(10 * time.Second),
+				}},
+				ok,
+			},
+			wantCode: http.StatusOK,
+			wantBody: "ok",
+			wantWait: []time.Duration{10 * time.Second},
+		},
+		{
+			name: "primary rate limit resetting too late",
+			replies: []fakeReply{
+				{code: http.StatusForbidden, headers: map[string]string{
+					githubRateRemainingHeader: "0", githubRateResetHeader: reset(time.Hour),
+				}, body: "rate limited"},
+			},
+			wantCode: http.StatusForbidden,
+			wantBody: "rate limited",
+		},
+		{
+			name: "secondary rate limit with retry after",
+			replies: []fakeReply{
+				{code: http.StatusForbidden, headers: map[string]string{retryAfterHeader: "30"}},
+				ok,
+			},
+			wantCode: http.StatusOK,
+			wantBody: "ok",
+			wantWait: []time.Duration{30 * time.Second},
+		},
+		{
+			name: "secondary rate limit without retry after",
+			replies: []fakeReply{
+				{code: http.StatusForbidden, body: `{"message": "You have exceeded a secondary rate limit."}`},
+				{code: http.StatusTooManyRequests},
+				ok,
+			},
+			wantCode: http.StatusOK,
+			wantBody: "ok",
+			wantWait: []time.Duration{time.Second, 2 * time.Second},
+		},
+		{
+			name: "forbidden",
+			replies: []fakeReply{
+				{code: http.StatusForbidden, body: `{"message": "Resource not accessible by integration"}`},
+			},
+			wantCode: http.StatusForbidden,
+			wantBody: `{"message": "Resource not accessible by integration"}`,
+		},
+		{
+			name: "server errors until giving up",
+			replies: []fakeReply{
+				{code: http.StatusBadGateway},
+				{code: http.StatusServiceUnavailable},
+				{code: http.StatusGatewayTimeout},
+				{code: http.StatusBadGateway, body: "still down"},
+			},
+			wantCode: http.StatusBadGateway,
+			wantBody: "still down",
+			wantWait: []time.Duration{time.Second, 2 * time.Second, 4 * time.Second},
+		},
+		{
+			name:   "post is not retried",
+			method: http.MethodPost,
+			replies: []fakeReply{
+				{code: http.StatusBadGateway},
+			},
+			wantCode: http.StatusBadGateway,
+		},
+		{
+			name:   "put is retried with its body",
+			method: http.MethodPut,
+			body:   "hello",
+			replies: []fakeReply{
+				{code: http.StatusTooManyRequests, headers: map[string]string{retryAfterHeader: "1"}},
+				{code: http.StatusOK, body: "hello"},
+			},
+			wantCode: http.StatusOK,
+			wantBody: "hello",
+			wantWait: []time.Duration{time.Second},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				reply := tt.replies[calls]
+				calls++
+				body, _ := ioutil.ReadAll(r.Body)
+				assert.Equal(t, string(body), tt.body)
+				for k, v := range reply.headers {
+					w.Header().Set(k, v)
+				}
+				w.WriteHeader(reply.code)
+				fmt.Fprint(w, reply.body)
+			}))
+			defer server.Close()
+
+			var waits []time.Duration
+			transport := newGithubRetryTransport(nil)
+			transport.limits = &githubRateLimits{quotas: map[githubRateKey]githubQuota{}, retries: map[githubRetryKey]int64{}}
+			transport.now = func() time.Time { return now }
+			transport.wait = func(ctx context.Context, d time.Duration) error {
+				waits = append(waits, d)
+				return nil
+			}
+
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			req, err := http.NewRequest(method, server.URL, bytes.NewBufferString(tt.body))
+			assert.NilError(t, err)
+			resp, err := (&http.Client{Transport: transport}).Do(req)
+			assert.NilError(t, err)
+			defer resp.Body.Close()
+			body, err := ioutil.ReadAll(resp.Body)
+			assert.NilError(t, err)
+
+			assert.Equal(t, resp.StatusCode, tt.wantCode)
+			assert.Equal(t, string(body), tt.wantBody)
+			assert.DeepEqual(t, waits, tt.wantWait)
+			assert.Equal(t, calls, len(tt.replies))
+		})
+	}
+}
+
+func TestGithubRetryTransportCancelled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(retryAfterHeader, "10")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	assert.NilError(t, err)
+	transport := newGithubRetryTransport(nil)
+	transport.limits = &githubRateLimits{quotas: map[githubRateKey]githubQuota{}, retries: map[githubRetryKey]int64{}}
+	_, err = transport.RoundTrip(req)
+	assert.ErrorContains(t, err, "context canceled")
+}
+
+func TestGithubRateLimits(t *testing.T) {
+	observer, log := zapobserver.New(zap.InfoLevel)
+	limits := &githubRateLimits{quotas: map[githubRateKey]githubQuota{}, retries: map[githubRetryKey]int64{}}
+	limits.SetLogger(zap.New(observer).Sugar())
+
+	response := func(headers ...string) *http.Response {
+		resp := &http.Response{Header: http.Header{}}
+		for i := 0; i < len(headers); i += 2 {
+			resp.Header.Set(headers[i], headers[i+1])
+		}
+		return resp
+	}
+	for _, remaining := range []string{"1000", "499", "498"} {
+		limits.update("api.github.com", response(githubRateLimitHeader, "5000",
+			githubRateRemainingHeader, remaining, githubRateResetHeader, "1600000000"))
+	}
+	limits.update("ghe.example.com", response(githubRateLimitHeader, "30",
+		githubRateRemainingHeader, "20", githubRateResourceHeader, "search"))
+	limits.update("ghe.example.com", response())
+	limits.retried("api.github.com", "primary rate limit")
+	limits.retried("api.github.com", "primary rate limit")
+
+	// logged once when going under 10% of the limit
+	assert.Equal(t, log.Len(), 1)
+	assert.Assert(t, strings.Contains(log.All()[0].Message, "499 calls remaining out of 5000 until 2020-09-13T12:26:40Z"))
+
+	b := &bytes.Buffer{}
+	assert.NilError(t, limits.WriteMetrics(b))
+	assert.Equal(t, b.String(), `# HELP pac_github_ratelimit_limit The GitHub rate limit quota.
+# TYPE pac_github_ratelimit_limit gauge
+pac_github_ratelimit_limit{host="api.github.com",resource="core"} 5000
+pac_github_ratelimit_limit{host="ghe.example.com",resource="search"} 30
+# HELP pac_github_ratelimit_remaining The GitHub rate limit quota remaining.
+# TYPE pac_github_ratelimit_remaining gauge
+pac_github_ratelimit_remaining{host="api.github.com",resource="core"} 498
+pac_github_ratelimit_remaining{host="ghe.example.com",resource="search"} 20
+# HELP pac_github_retries_total The GitHub calls retried.
+# TYPE pac_github_retries_total counter
+pac_github_retries_total{host="api.github.com",reason="primary rate limit"} 2
+`)
+}
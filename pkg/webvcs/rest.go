@@ -0,0 +1,115 @@
+package webvcs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// restMaxPages bound the number of pages we go through on a list API
+const restMaxPages = 50
+
+// errPaginateDone is returned by a listPage function to stop going through
+// the next pages once it has found what it was looking for
+var errPaginateDone = errors.New("done listing the pages")
+
+// restClient a minimal JSON REST client for the Web VCS providers we talk to
+// without a go library
+type restClient struct {
+	Client  *http.Client
+	BaseURL string
+	Headers map[string]string
+}
+
+// apiError is returned when the REST API doesn't reply with a 2xx
+type apiError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Body       string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("%s %s: %d %s", e.Method, e.URL, e.StatusCode, strings.TrimSpace(e.Body))
+}
+
+// isNotFound return true if the err is an api error with a 404
+func isNotFound(err error) bool {
+	var aerr *apiError
+	return errors.As(err, &aerr) && aerr.StatusCode == http.StatusNotFound
+}
+
+func (r restClient) newRequest(ctx context.Context, method, path string, in interface{}) (*http.Request, error) {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(r.BaseURL, "/")+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	for k, v := range r.Headers {
+		req.Header.Set(k, v)
+	}
+	return req, nil
+}
+
+// raw do a request on path and return the body as is
+func (r restClient) raw(ctx context.Context, method, path string, in interface{}) ([]byte, *http.Response, error) {
+	req, err := r.newRequest(ctx, method, path, in)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, resp, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return data, resp, &apiError{
+			Method:     method,
+			URL:        req.URL.String(),
+			StatusCode: resp.StatusCode,
+			Body:       string(data),
+		}
+	}
+	return data, resp, nil
+}
+
+// do a request on path with in encoded as json and decode the json reply in out
+func (r restClient) do(ctx context.Context, method, path string, in, out interface{}) (*http.Response, error) {
+	data, resp, err := r.raw(ctx, method, path, in)
+	if err != nil {
+		return resp, err
+	}
+	if out == nil || len(data) == 0 {
+		return resp, nil
+	}
+	return resp, json.Unmarshal(data, out)
+}
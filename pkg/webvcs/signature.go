@@ -0,0 +1,65 @@
+package webvcs
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+const (
+	// SignatureHeader is the header where GitHub sends the HMAC SHA256
+	// signature of the payload
+	SignatureHeader = "X-Hub-Signature-256"
+	// giteaSignatureHeader is the header where Gitea and Forgejo send the
+	// HMAC SHA256 signature of the payload
+	giteaSignatureHeader = "X-Gitea-Signature"
+	// bitbucketSignatureHeader is the header where Bitbucket Cloud and
+	// Bitbucket Server send the HMAC SHA256 signature of the payload
+	bitbucketSignatureHeader = "X-Hub-Signature"
+	// gitlabTokenHeader is the header where GitLab sends the secret token
+	// of the webhook as is
+	gitlabTokenHeader = "X-Gitlab-Token"
+
+	signaturePrefix = "sha256="
+)
+
+// ValidateSignature check the signature of a webhook payload, the signature
+// is the hex encoded HMAC SHA256 of the payload with the secret optionally
+// prefixed with sha256= as sent in the X-Hub-Signature-256 header
+func ValidateSignature(secret, signature string, payload []byte) error {
+	if secret == "" {
+		return fmt.Errorf("no webhook secret to validate the signature with")
+	}
+	if signature == "" {
+		return fmt.Errorf("payload has no signature")
+	}
+
+	got, err := hex.DecodeString(strings.TrimPrefix(signature, signaturePrefix))
+	if err != nil {
+		return fmt.Errorf("payload signature is not a valid hex encoded sha256: %w", err)
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write(payload)
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return fmt.Errorf("payload signature does not match the webhook secret")
+	}
+	return nil
+}
+
+// validateToken check the secret token sent as is with a webhook payload
+func validateToken(secret, token string) error {
+	if secret == "" {
+		return fmt.Errorf("no webhook secret to validate the token with")
+	}
+	if token == "" {
+		return fmt.Errorf("payload has no token")
+	}
+	if subtle.ConstantTimeCompare([]byte(secret), []byte(token)) != 1 {
+		return fmt.Errorf("payload token does not match the webhook secret")
+	}
+	return nil
+}
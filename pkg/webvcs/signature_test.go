@@ -0,0 +1,140 @@
+package webvcs
+
+import (
+	"net/http"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestValidateSignature(t *testing.T) {
+	payload := []byte(`{"hello": "moto"}`)
+	// echo -n '{"hello": "moto"}' | openssl dgst -sha256 -hmac secret
+	goodSignature := "71aa50e663fa05dfeb3ab30d1033c5e6e39f011aa0bbc7c36bd8330ed380ed7e"
+	tests := []struct {
+		name      string
+		secret    string
+		signature string
+		wantErr   string
+	}{
+		{
+			name:      "good",
+			secret:    "secret",
+			signature: "sha256=" + goodSignature,
+		},
+		{
+			name:      "good without prefix",
+			secret:    "secret",
+			signature: goodSignature,
+		},
+		{
+			name:      "bad secret",
+			secret:    "notsecret",
+			signature: "sha256=" + goodSignature,
+			wantErr:   "does not match",
+		},
+		{
+			name:      "no signature",
+			secret:    "secret",
+			signature: "",
+			wantErr:   "payload has no signature",
+		},
+		{
+			name:      "not hex",
+			secret:    "secret",
+			signature: "sha256=moto",
+			wantErr:   "not a valid hex encoded sha256",
+		},
+		{
+			name:      "no secret",
+			signature: "sha256=" + goodSignature,
+			wantErr:   "no webhook secret",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateSignature(tt.secret, tt.signature, payload)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+		})
+	}
+}
+
+func TestValidateWebhook(t *testing.T) {
+	payload := []byte(`{"hello": "moto"}`)
+	goodSignature := "71aa50e663fa05dfeb3ab30d1033c5e6e39f011aa0bbc7c36bd8330ed380ed7e"
+	tests := []struct {
+		name      string
+		vcs       Interface
+		header    string
+		signature string
+		wantErr   string
+	}{
+		{
+			name:      "github",
+			vcs:       GithubVCS{},
+			header:    "X-Hub-Signature-256",
+			signature: "sha256=" + goodSignature,
+		},
+		{
+			name:      "gitea",
+			vcs:       GiteaVCS{},
+			header:    "X-Gitea-Signature",
+			signature: goodSignature,
+		},
+		{
+			name:      "gitea with the github header only",
+			vcs:       GiteaVCS{},
+			header:    "X-Hub-Signature-256",
+			signature: "sha256=" + goodSignature,
+			wantErr:   "payload has no signature",
+		},
+		{
+			name:      "bitbucket cloud",
+			vcs:       BitbucketCloudVCS{},
+			header:    "X-Hub-Signature",
+			signature: "sha256=" + goodSignature,
+		},
+		{
+			name:      "bitbucket server",
+			vcs:       BitbucketServerVCS{},
+			header:    "X-Hub-Signature",
+			signature: "sha256=" + goodSignature,
+		},
+		{
+			name:      "gitlab",
+			vcs:       GitlabVCS{},
+			header:    "X-Gitlab-Token",
+			signature: "secret",
+		},
+		{
+			name:      "gitlab bad token",
+			vcs:       GitlabVCS{},
+			header:    "X-Gitlab-Token",
+			signature: "notsecret",
+			wantErr:   "payload token does not match the webhook secret",
+		},
+		{
+			name:      "gitlab signature instead of the token",
+			vcs:       GitlabVCS{},
+			header:    "X-Hub-Signature-256",
+			signature: "sha256=" + goodSignature,
+			wantErr:   "payload has no token",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			header.Set(tt.header, tt.signature)
+			err := tt.vcs.ValidateWebhook("secret", tt.vcs.WebhookSignature(header), payload)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+		})
+	}
+}
@@ -0,0 +1,400 @@
+package webvcs
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+const (
+	GithubType          = "github"
+	GithubStatusType    = "github-status"
+	GitlabType          = "gitlab"
+	BitbucketCloudType  = "bitbucket-cloud"
+	BitbucketServerType = "bitbucket-server"
+	GiteaType           = "gitea"
+)
+
+// The trigger targets, they tell where an event comes from
+const (
+	TriggerTargetPullRequest       = "pull-request"
+	TriggerTargetPush              = "push"
+	TriggerTargetRecheck           = "issue-recheck"
+	TriggerTargetRetestComment     = "retest-comment"
+	TriggerTargetTestComment       = "test-comment"
+	TriggerTargetCancelComment     = "cancel-comment"
+	TriggerTargetOkToTestComment   = "ok-to-test-comment"
+	TriggerTargetRelease           = "release"
+	TriggerTargetPullRequestClosed = "pull-request-closed"
+)
+
+// The event types of a run, they are what the on-event annotation matches
+const (
+	EventTypePullRequest       = "pull_request"
+	EventTypePullRequestClosed = "pull_request_closed"
+	EventTypePullRequestMerged = "pull_request_merged"
+	EventTypePush              = "push"
+	EventTypeTagPush           = "tag_push"
+	EventTypeRelease           = "release"
+)
+
+// The pull request actions
+const (
+	PullRequestActionOpened         = "opened"
+	PullRequestActionSynchronize    = "synchronize"
+	PullRequestActionReopened       = "reopened"
+	PullRequestActionReadyForReview = "ready_for_review"
+	PullRequestActionLabeled        = "labeled"
+	PullRequestActionEdited         = "edited"
+)
+
+const tagRefPrefix = "refs/tags/"
+
+// DefaultCollaboratorPermission is the minimum permission level a repository
+// collaborator needs to run the CI when the Repository doesn't set one
+const DefaultCollaboratorPermission = "write"
+
+// commentCommandRegexp match a comment line with a command and its optional
+// PipelineRun name, i.e: /test e2e
+var commentCommandRegexp = regexp.MustCompile(`^/(test|retest|cancel|ok-to-test)(?:[ \t]+([^ \t]+))?[ \t]*$`)
+
+// commitSHARegexp match a commit SHA or an abbreviated one
+var commitSHARegexp = regexp.MustCompile(`^[0-9a-fA-F]{7,40}$`)
+
+// New create a Web VCS provider of vcsType
+func New(vcsType, token, apiURL string) (Interface, error) {
+	switch vcsType {
+	case "", GithubType:
+		return NewGithubVCS(token, apiURL), nil
+	case GithubStatusType:
+		vcs := NewGithubVCS(token, apiURL)
+		vcs.CommitStatus = true
+		return vcs, nil
+	case GitlabType:
+		return NewGitlabVCS(token, apiURL), nil
+	case BitbucketCloudType:
+		return NewBitbucketCloudVCS(token, apiURL), nil
+	case BitbucketServerType:
+		return NewBitbucketServerVCS(token, apiURL), nil
+	case GiteaType:
+		return NewGiteaVCS(token, apiURL), nil
+	}
+	return nil, fmt.Errorf("unknown web vcs type: %s", vcsType)
+}
+
+// Interface is what a Web VCS provider (ie: GitHub) has to implement to be
+// driven by pipelines as code
+type Interface interface {
+	// ParseEventType get the event type and the trigger target of a raw
+	// webhook request, the trigger target is empty if there is nothing to
+	// run for that event
+	ParseEventType(header http.Header, payload []byte) (string, string)
+
+	// WebhookSignature get the signature, or the token, of a raw webhook
+	// request the payload is verified with
+	WebhookSignature(header http.Header) string
+
+	// ValidateWebhook check the payload has been sent by the Web VCS with the
+	// webhook secret, signature is what WebhookSignature got for it
+	ValidateWebhook(secret, signature string, payload []byte) error
+
+	// ParsePayload parse a webhook payload of eventType into a RunInfo
+	ParsePayload(ctx context.Context, log *zap.SugaredLogger, eventType, triggerTarget, payload string) (*RunInfo, error)
+
+	// GetTektonDir get all the yaml files in the path directory of the
+	// repository at the runinfo SHA as one multi document yaml string, it
+	// returns an empty string if there is no such directory
+	GetTektonDir(ctx context.Context, path string, runinfo *RunInfo) (string, error)
+
+	// GetFileInsideRepo get a file from the repository at the runinfo SHA, or
+	// at the runinfo BaseBranch if branch is true
+	GetFileInsideRepo(ctx context.Context, path string, branch bool, runinfo *RunInfo) (string, error)
+
+	// GetFileFromDefaultBranch get a file from the repository default branch
+	GetFileFromDefaultBranch(ctx context.Context, path string, runinfo *RunInfo) (string, error)
+
+	// GetBranchSHA get the SHA of the commit at the head of a branch of the
+	// runinfo repository
+	GetBranchSHA(ctx context.Context, branch string, runinfo *RunInfo) (string, error)
+
+	// GetFilesChanged get the paths of the files changed by the runinfo pull
+	// request or by the pushed commits
+	GetFilesChanged(ctx context.Context, runinfo *RunInfo) ([]string, error)
+
+	// CreateCheckRun create the first status for this run and set its id in
+	// runinfo.CheckRunID
+	CreateCheckRun(ctx context.Context, status string, runinfo *RunInfo) error
+
+	// CreateStatus update the status created by CreateCheckRun
+	CreateStatus(ctx context.Context, runinfo *RunInfo, status, conclusion, text, detailsURL string) error
+
+	// CheckSenderOrgMembership check if the runinfo Sender is member of the
+	// runinfo Owner organization
+	CheckSenderOrgMembership(ctx context.Context, runinfo *RunInfo) (bool, error)
+
+	// GetStringPullRequestComment get the comments of the runinfo pull
+	// request matching the reg regexp
+	GetStringPullRequestComment(ctx context.Context, runinfo *RunInfo, reg string) ([]*Comment, error)
+}
+
+// Comment a comment on a pull request
+type Comment struct {
+	ID     int64
+	Sender string
+	Body   string
+}
+
+// The levels of an annotation
+const (
+	AnnotationLevelNotice  = "notice"
+	AnnotationLevelWarning = "warning"
+	AnnotationLevelFailure = "failure"
+)
+
+// Annotation a message on lines of a file of the repository, i.e: a failed
+// test case
+type Annotation struct {
+	Path      string // relative to the root of the repository
+	StartLine int
+	EndLine   int
+	Level     string
+	Title     string
+	Message   string
+}
+
+// RunInfo Information about current run
+type RunInfo struct {
+	BaseBranch        string // branch against where we are making the PR
+	CheckRunID        *int64
+	DefaultBranch     string
+	Event             interface{}
+	EventType         string
+	HeadBranch        string // branch from where our SHA get tested
+	Owner             string
+	Repository        string
+	SHA               string
+	SHAURL            string
+	Sender            string
+	TriggerTarget     string
+	URL               string
+	LogURL            string
+	SHATitle          string
+	ApplicationName   string // The Application Name for example "Pipelines as Code"
+	PullRequestNumber int    // The pull request number if the run is for a pull request
+	Tag               string // The tag name if the run is for a tag push or a release
+	PullRequestAction string // The action of a pull request event, i.e: opened or labeled
+	PullRequestDraft  bool   // The pull request is a draft
+	// The labels of the pull request when the event happened
+	PullRequestLabels []string
+	// The label just added to the pull request by a labeled action
+	PullRequestLabel string
+	// The minimum permission level a repository collaborator needs to run the CI
+	CollaboratorPermission string
+	// The draft pull requests are not run until they are ready for review
+	SkipDraftPullRequests bool
+	// The PipelineRun a comment command is about, i.e: e2e for "/test e2e"
+	TargetPipelineRun string
+	// The annotations of the final status, only the providers with check
+	// runs report them
+	Annotations []Annotation
+	// The statuses are reported in a comment of the pull request as well,
+	// edited in place for each new status
+	PullRequestComment bool
+	// The statuses are GitHub commit statuses instead of check runs, the
+	// Checks API being only for the GitHub Apps
+	CommitStatus bool
+}
+
+// Check check if the runinfo is properly set
+func (r RunInfo) Check() error {
+	if r.SHA != "" && r.BaseBranch != "" &&
+		r.Repository != "" && r.DefaultBranch != "" &&
+		r.HeadBranch != "" && r.Owner != "" && r.URL != "" &&
+		r.Sender != "" && r.EventType != "" && r.TriggerTarget != "" {
+		return nil
+	}
+	return fmt.Errorf("missing values in runInfo")
+}
+
+// DeepCopyInto deep copy runinfo in another instance
+func (r *RunInfo) DeepCopyInto(out *RunInfo) {
+	*out = *r
+}
+
+// setPushEventType set the event type of a push to runinfo.BaseBranch, the
+// push of a tag has its own event type and sets the tag name
+func setPushEventType(runinfo *RunInfo) {
+	runinfo.EventType = EventTypePush
+	if strings.HasPrefix(runinfo.BaseBranch, tagRefPrefix) {
+		runinfo.EventType = EventTypeTagPush
+		runinfo.Tag = strings.TrimPrefix(runinfo.BaseBranch, tagRefPrefix)
+	}
+}
+
+// isZeroSHA tells if sha is the null commit id the webhooks use as the
+// previous commit of a new branch
+func isZeroSHA(sha string) bool {
+	return strings.Trim(sha, "0") == ""
+}
+
+// changedFiles is the set of the files changed by a run, a file moved counts
+// for both its old and its new path
+type changedFiles map[string]bool
+
+func (c changedFiles) add(paths ...string) {
+	for _, p := range paths {
+		if p != "" {
+			c[p] = true
+		}
+	}
+}
+
+func (c changedFiles) list() []string {
+	files := make([]string, 0, len(c))
+	for p := range c {
+		files = append(files, p)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// commentCommand is a command of a pull request comment
+type commentCommand struct {
+	triggerTarget string
+	// pipelineRun the PipelineRun the command is about, empty for all of them
+	pipelineRun string
+}
+
+// parseCommentCommand get the first command of a pull request comment, a
+// command being alone on its line :
+//
+//	/test <name>       run the PipelineRun name even if it doesn't match
+//	/retest [name]     run again the PipelineRuns matching, or only name
+//	/cancel [name]     cancel the running PipelineRuns, or only name
+//	/ok-to-test [sha]  allow running the CI on the pull request, and the
+//	                   changes to the .tekton directory at the sha commit
+//
+// it returns nil when the comment doesn't have any command
+func parseCommentCommand(comment string) *commentCommand {
+	for _, line := range strings.Split(comment, "\n") {
+		match := commentCommandRegexp.FindStringSubmatch(strings.TrimSuffix(line, "\r"))
+		if match == nil {
+			continue
+		}
+		switch command, name := match[1], match[2]; {
+		case command == "test" && name != "":
+			return &commentCommand{triggerTarget: TriggerTargetTestComment, pipelineRun: name}
+		case command == "test" || command == "retest":
+			return &commentCommand{triggerTarget: TriggerTargetRetestComment, pipelineRun: name}
+		case command == "cancel":
+			return &commentCommand{triggerTarget: TriggerTargetCancelComment, pipelineRun: name}
+		case command == "ok-to-test" && (name == "" || commitSHARegexp.MatchString(name)):
+			return &commentCommand{triggerTarget: TriggerTargetOkToTestComment}
+		}
+	}
+	return nil
+}
+
+// commentTriggerTarget get the trigger target of a comment command, or an
+// empty string for a comment without any
+func commentTriggerTarget(comment string) string {
+	if command := parseCommentCommand(comment); command != nil {
+		return command.triggerTarget
+	}
+	return ""
+}
+
+// setCommentCommand set the runinfo of a pull request comment command, the
+// comment author is the sender since the ACL applies to who asked for the
+// command and not to the pull request author
+func setCommentCommand(runinfo *RunInfo, comment, author string) {
+	if command := parseCommentCommand(comment); command != nil {
+		runinfo.TriggerTarget = command.triggerTarget
+		runinfo.TargetPipelineRun = command.pipelineRun
+	}
+	runinfo.Sender = author
+}
+
+// getFileFromDefaultBranch get a file with the v provider directly from the
+// default branch of the runinfo repository
+func getFileFromDefaultBranch(ctx context.Context, v Interface, path string, runinfo *RunInfo) (string, error) {
+	runInfoOnMain := &RunInfo{}
+	runinfo.DeepCopyInto(runInfoOnMain)
+	runInfoOnMain.BaseBranch = runInfoOnMain.DefaultBranch
+
+	tektonyaml, err := v.GetFileInsideRepo(ctx, path, true, runInfoOnMain)
+	if err != nil {
+		return "", fmt.Errorf("cannot find %s inside the %s branch: %w", path, runInfoOnMain.BaseBranch, err)
+	}
+	return tektonyaml, err
+}
+
+// pullRequestCommentMarker is the hidden mark of the pull request comment
+// edited in place for key
+func pullRequestCommentMarker(key string) string {
+	return fmt.Sprintf("<!-- pipelines-as-code: %s -->", key)
+}
+
+// findPullRequestComment get the comment of the runinfo pull request marked
+// for key and authored by self, the account we comment with, it returns nil
+// if there isn't any. Anyone can copy the mark in a comment, those are never
+// edited in place of ours.
+func findPullRequestComment(ctx context.Context, vcs Interface, runinfo *RunInfo, key, self string) (*Comment, error) {
+	comments, err := vcs.GetStringPullRequestComment(ctx, runinfo, regexp.QuoteMeta(pullRequestCommentMarker(key)))
+	if err != nil {
+		return nil, err
+	}
+	for _, comment := range comments {
+		if comment.Sender == self {
+			return comment, nil
+		}
+	}
+	return nil, nil
+}
+
+// statusComment is the body of the pull request comment of a status
+func statusComment(runinfo *RunInfo, status, conclusion, text string) string {
+	title, summary := statusTitleSummary(runinfo, status, conclusion)
+	return fmt.Sprintf("**%s**\n\n%s\n\n%s", title, summary, text)
+}
+
+// markedStatusComment is the body of the pull request comment of a status
+// edited in place, it's marked with the application name which is unique for
+// each PipelineRun
+func markedStatusComment(runinfo *RunInfo, status, conclusion, text string) string {
+	return pullRequestCommentMarker(runinfo.ApplicationName) + "\n" + statusComment(runinfo, status, conclusion, text)
+}
+
+// statusTitleSummary get a human title and summary for a status and its conclusion
+func statusTitleSummary(runinfo *RunInfo, status, conclusion string) (string, string) {
+	var summary, title string
+
+	switch conclusion {
+	case "success":
+		title = "✅ Success"
+		summary = fmt.Sprintf("%s has successfully validated your commit.", runinfo.ApplicationName)
+	case "failure":
+		title = "❌ Failed"
+		summary = fmt.Sprintf("%s has <b>failed</b>.", runinfo.ApplicationName)
+	case "skipped":
+		title = "➖ Skipped"
+		summary = fmt.Sprintf("%s is skipping this commit.", runinfo.ApplicationName)
+	case "neutral":
+		title = "❓ Unknown"
+		summary = fmt.Sprintf("%s doesn't know what happened with this commit.", runinfo.ApplicationName)
+	case "action_required":
+		title = "✋ Approval required"
+		summary = fmt.Sprintf("%s is waiting for an approval to run this commit.", runinfo.ApplicationName)
+	}
+
+	if status == "in_progress" {
+		title = "CI has Started"
+		summary = fmt.Sprintf("%s is running.", runinfo.ApplicationName)
+	}
+	return title, summary
+}
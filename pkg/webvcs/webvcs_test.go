@@ -0,0 +1,413 @@
+package webvcs
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"gotest.tools/v3/assert"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		vcsType string
+		want    Interface
+		wantErr bool
+	}{
+		{vcsType: "", want: GithubVCS{}},
+		{vcsType: GithubType, want: GithubVCS{}},
+		{vcsType: GithubStatusType, want: GithubVCS{CommitStatus: true}},
+		{vcsType: GitlabType, want: GitlabVCS{}},
+		{vcsType: BitbucketCloudType, want: BitbucketCloudVCS{}},
+		{vcsType: BitbucketServerType, want: BitbucketServerVCS{}},
+		{vcsType: GiteaType, want: GiteaVCS{}},
+		{vcsType: "cvs", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.vcsType, func(t *testing.T) {
+			got, err := New(tt.vcsType, "token", "https://vcs.example.com")
+			if tt.wantErr {
+				assert.ErrorContains(t, err, "unknown web vcs type")
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, fmt.Sprintf("%T", got), fmt.Sprintf("%T", tt.want))
+			if want, ok := tt.want.(GithubVCS); ok {
+				assert.Equal(t, got.(GithubVCS).CommitStatus, want.CommitStatus)
+			}
+		})
+	}
+}
+
+func TestParseEventType(t *testing.T) {
+	tests := []struct {
+		name              string
+		vcs               Interface
+		header            string
+		event             string
+		payload           string
+		wantEventType     string
+		wantTriggerTarget string
+	}{
+		{
+			name:              "github pull request opened",
+			vcs:               GithubVCS{},
+			header:            "X-GitHub-Event",
+			event:             "pull_request",
+			payload:           `{"action": "opened"}`,
+			wantEventType:     "pull_request",
+			wantTriggerTarget: TriggerTargetPullRequest,
+		},
+		{
+			name:              "github pull request closed",
+			vcs:               GithubVCS{},
+			header:            "X-GitHub-Event",
+			event:             "pull_request",
+			payload:           `{"action": "closed"}`,
+			wantEventType:     "pull_request",
+			wantTriggerTarget: TriggerTargetPullRequestClosed,
+		},
+		{
+			name:              "github pull request labeled",
+			vcs:               GithubVCS{},
+			header:            "X-GitHub-Event",
+			event:             "pull_request",
+			payload:           `{"action": "labeled"}`,
+			wantEventType:     "pull_request",
+			wantTriggerTarget: TriggerTargetPullRequest,
+		},
+		{
+			name:          "github pull request without action",
+			vcs:           GithubVCS{},
+			header:        "X-GitHub-Event",
+			event:         "pull_request",
+			payload:       `{}`,
+			wantEventType: "pull_request",
+		},
+		{
+			name:              "github push",
+			vcs:               GithubVCS{},
+			header:            "X-GitHub-Event",
+			event:             "push",
+			payload:           `{"ref": "refs/heads/main"}`,
+			wantEventType:     "push",
+			wantTriggerTarget: TriggerTargetPush,
+		},
+		{
+			name:              "github release published",
+			vcs:               GithubVCS{},
+			header:            "X-GitHub-Event",
+			event:             "release",
+			payload:           `{"action": "published", "release": {"tag_name": "v1.0.0"}}`,
+			wantEventType:     "release",
+			wantTriggerTarget: TriggerTargetRelease,
+		},
+		{
+			name:          "github release created",
+			vcs:           GithubVCS{},
+			header:        "X-GitHub-Event",
+			event:         "release",
+			payload:       `{"action": "created", "release": {"tag_name": "v1.0.0"}}`,
+			wantEventType: "release",
+		},
+		{
+			name:          "github branch deletion",
+			vcs:           GithubVCS{},
+			header:        "X-GitHub-Event",
+			event:         "push",
+			payload:       `{"ref": "refs/heads/main", "deleted": true}`,
+			wantEventType: "push",
+		},
+		{
+			name:              "github check run rerequested",
+			vcs:               GithubVCS{},
+			header:            "X-GitHub-Event",
+			event:             "check_run",
+			payload:           `{"action": "rerequested"}`,
+			wantEventType:     "check_run",
+			wantTriggerTarget: TriggerTargetRecheck,
+		},
+		{
+			name:              "github retest comment",
+			vcs:               GithubVCS{},
+			header:            "X-GitHub-Event",
+			event:             "issue_comment",
+			payload:           `{"action": "created", "issue": {"state": "open", "pull_request": {}}, "comment": {"body": "/retest"}}`,
+			wantEventType:     "issue_comment",
+			wantTriggerTarget: TriggerTargetRetestComment,
+		},
+		{
+			name:              "github test comment",
+			vcs:               GithubVCS{},
+			header:            "X-GitHub-Event",
+			event:             "issue_comment",
+			payload:           `{"action": "created", "issue": {"state": "open", "pull_request": {}}, "comment": {"body": "/test e2e"}}`,
+			wantEventType:     "issue_comment",
+			wantTriggerTarget: TriggerTargetTestComment,
+		},
+		{
+			name:              "gitea cancel comment",
+			vcs:               GiteaVCS{},
+			header:            "X-Gitea-Event",
+			event:             "issue_comment",
+			payload:           `{"action": "created", "is_pull": true, "comment": {"body": "/cancel"}}`,
+			wantEventType:     "issue_comment",
+			wantTriggerTarget: TriggerTargetCancelComment,
+		},
+		{
+			name:          "github comment on an issue",
+			vcs:           GithubVCS{},
+			header:        "X-GitHub-Event",
+			event:         "issue_comment",
+			payload:       `{"action": "created", "issue": {"state": "open"}, "comment": {"body": "/retest"}}`,
+			wantEventType: "issue_comment",
+		},
+		{
+			name:    "github no event header",
+			vcs:     GithubVCS{},
+			header:  "X-GitHub-Event",
+			payload: `{}`,
+		},
+		{
+			name:              "gitlab merge request update",
+			vcs:               GitlabVCS{},
+			header:            "X-Gitlab-Event",
+			event:             "Merge Request Hook",
+			payload:           `{"object_attributes": {"action": "update", "oldrev": "sha"}}`,
+			wantEventType:     "Merge Request Hook",
+			wantTriggerTarget: TriggerTargetPullRequest,
+		},
+		{
+			name:          "gitlab merge request update without new commits",
+			vcs:           GitlabVCS{},
+			header:        "X-Gitlab-Event",
+			event:         "Merge Request Hook",
+			payload:       `{"object_attributes": {"action": "update"}}`,
+			wantEventType: "Merge Request Hook",
+		},
+		{
+			name:              "gitlab ok-to-test note",
+			vcs:               GitlabVCS{},
+			header:            "X-Gitlab-Event",
+			event:             "Note Hook",
+			payload:           `{"object_attributes": {"note": "/ok-to-test", "noteable_type": "MergeRequest"}}`,
+			wantEventType:     "Note Hook",
+			wantTriggerTarget: TriggerTargetOkToTestComment,
+		},
+		{
+			name:              "gitlab tag push",
+			vcs:               GitlabVCS{},
+			header:            "X-Gitlab-Event",
+			event:             "Tag Push Hook",
+			payload:           `{"ref": "refs/tags/v1.0.0", "checkout_sha": "sha"}`,
+			wantEventType:     "Tag Push Hook",
+			wantTriggerTarget: TriggerTargetPush,
+		},
+		{
+			name:          "gitlab branch deletion",
+			vcs:           GitlabVCS{},
+			header:        "X-Gitlab-Event",
+			event:         "Push Hook",
+			payload:       `{"ref": "refs/heads/main"}`,
+			wantEventType: "Push Hook",
+		},
+		{
+			name:              "bitbucket cloud retest comment",
+			vcs:               BitbucketCloudVCS{},
+			header:            "X-Event-Key",
+			event:             "pullrequest:comment_created",
+			payload:           `{"comment": {"content": {"raw": "/retest"}}}`,
+			wantEventType:     "pullrequest:comment_created",
+			wantTriggerTarget: TriggerTargetRetestComment,
+		},
+		{
+			name:   "bitbucket cloud push",
+			vcs:    BitbucketCloudVCS{},
+			header: "X-Event-Key",
+			event:  "repo:push",
+			payload: `{"push": {"changes": [{"new": null},
+				{"new": {"type": "branch", "name": "main"}}]}}`,
+			wantEventType:     "repo:push",
+			wantTriggerTarget: TriggerTargetPush,
+		},
+		{
+			name:          "bitbucket cloud branch deletion",
+			vcs:           BitbucketCloudVCS{},
+			header:        "X-Event-Key",
+			event:         "repo:push",
+			payload:       `{"push": {"changes": [{"new": null}]}}`,
+			wantEventType: "repo:push",
+		},
+		{
+			name:          "bitbucket cloud tag push",
+			vcs:           BitbucketCloudVCS{},
+			header:        "X-Event-Key",
+			event:         "repo:push",
+			payload:       `{"push": {"changes": [{"new": {"type": "tag", "name": "v1"}}]}}`,
+			wantEventType: "repo:push",
+		},
+		{
+			name:          "bitbucket cloud pull request merged",
+			vcs:           BitbucketCloudVCS{},
+			header:        "X-Event-Key",
+			event:         "pullrequest:fulfilled",
+			payload:       `{}`,
+			wantEventType: "pullrequest:fulfilled",
+		},
+		{
+			name:   "bitbucket server push",
+			vcs:    BitbucketServerVCS{},
+			header: "X-Event-Key",
+			event:  "repo:refs_changed",
+			payload: `{"changes": [{"ref": {"id": "refs/tags/v1", "type": "TAG"}, "type": "ADD"},
+				{"ref": {"id": "refs/heads/main", "type": "BRANCH"}, "type": "UPDATE"}]}`,
+			wantEventType:     "repo:refs_changed",
+			wantTriggerTarget: TriggerTargetPush,
+		},
+		{
+			name:          "bitbucket server branch deletion",
+			vcs:           BitbucketServerVCS{},
+			header:        "X-Event-Key",
+			event:         "repo:refs_changed",
+			payload:       `{"changes": [{"ref": {"id": "refs/heads/main", "type": "BRANCH"}, "type": "DELETE"}]}`,
+			wantEventType: "repo:refs_changed",
+		},
+		{
+			name:          "bitbucket server tag push",
+			vcs:           BitbucketServerVCS{},
+			header:        "X-Event-Key",
+			event:         "repo:refs_changed",
+			payload:       `{"changes": [{"ref": {"id": "refs/tags/v1", "type": "TAG"}, "type": "ADD"}]}`,
+			wantEventType: "repo:refs_changed",
+		},
+		{
+			name:          "bitbucket server random comment",
+			vcs:           BitbucketServerVCS{},
+			header:        "X-Event-Key",
+			event:         "pr:comment:added",
+			payload:       `{"comment": {"text": "looks good"}}`,
+			wantEventType: "pr:comment:added",
+		},
+		{
+			name:              "gitea pull request synchronized",
+			vcs:               GiteaVCS{},
+			header:            "X-Gitea-Event",
+			event:             "pull_request",
+			payload:           `{"action": "synchronized"}`,
+			wantEventType:     "pull_request",
+			wantTriggerTarget: TriggerTargetPullRequest,
+		},
+		{
+			name:              "gitea push",
+			vcs:               GiteaVCS{},
+			header:            "X-Gitea-Event",
+			event:             "push",
+			payload:           `{"ref": "refs/heads/main", "after": "sha"}`,
+			wantEventType:     "push",
+			wantTriggerTarget: TriggerTargetPush,
+		},
+		{
+			name:          "gitea branch deletion",
+			vcs:           GiteaVCS{},
+			header:        "X-Gitea-Event",
+			event:         "push",
+			payload:       `{"ref": "refs/heads/main", "after": "0000000000000000000000000000000000000000"}`,
+			wantEventType: "push",
+		},
+		{
+			name:          "gitea comment on an issue",
+			vcs:           GiteaVCS{},
+			header:        "X-Gitea-Event",
+			event:         "issue_comment",
+			payload:       `{"action": "created", "issue": {}, "comment": {"body": "/retest"}}`,
+			wantEventType: "issue_comment",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			if tt.event != "" {
+				header.Set(tt.header, tt.event)
+			}
+			eventType, triggerTarget := tt.vcs.ParseEventType(header, []byte(tt.payload))
+			assert.Equal(t, eventType, tt.wantEventType)
+			assert.Equal(t, triggerTarget, tt.wantTriggerTarget)
+		})
+	}
+}
+
+func TestParseCommentCommand(t *testing.T) {
+	tests := []struct {
+		name    string
+		comment string
+		want    *commentCommand
+	}{
+		{
+			name:    "retest",
+			comment: "/retest",
+			want:    &commentCommand{triggerTarget: TriggerTargetRetestComment},
+		},
+		{
+			name:    "retest a pipelinerun",
+			comment: "the e2e failed on a timeout\r\n/retest e2e  \r\nthanks",
+			want:    &commentCommand{triggerTarget: TriggerTargetRetestComment, pipelineRun: "e2e"},
+		},
+		{
+			name:    "test a pipelinerun",
+			comment: "/test e2e",
+			want:    &commentCommand{triggerTarget: TriggerTargetTestComment, pipelineRun: "e2e"},
+		},
+		{
+			name:    "test without a pipelinerun",
+			comment: "/test",
+			want:    &commentCommand{triggerTarget: TriggerTargetRetestComment},
+		},
+		{
+			name:    "cancel",
+			comment: "/cancel",
+			want:    &commentCommand{triggerTarget: TriggerTargetCancelComment},
+		},
+		{
+			name:    "cancel a pipelinerun",
+			comment: "/cancel\te2e",
+			want:    &commentCommand{triggerTarget: TriggerTargetCancelComment, pipelineRun: "e2e"},
+		},
+		{
+			name:    "ok-to-test",
+			comment: "/ok-to-test",
+			want:    &commentCommand{triggerTarget: TriggerTargetOkToTestComment},
+		},
+		{
+			name:    "ok-to-test a commit",
+			comment: "/ok-to-test 0123abcd",
+			want:    &commentCommand{triggerTarget: TriggerTargetOkToTestComment},
+		},
+		{
+			name:    "first command only",
+			comment: "/cancel lint\n/test e2e",
+			want:    &commentCommand{triggerTarget: TriggerTargetCancelComment, pipelineRun: "lint"},
+		},
+		{
+			name:    "ok-to-test does not take a pipelinerun",
+			comment: "/ok-to-test e2e",
+		},
+		{
+			name:    "too many arguments",
+			comment: "/test e2e lint",
+		},
+		{
+			name:    "not alone on its line",
+			comment: "please /retest",
+		},
+		{
+			name:    "unknown command",
+			comment: "/retry",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.DeepEqual(t, parseCommentCommand(tt.comment), tt.want, cmp.AllowUnexported(commentCommand{}))
+		})
+	}
+}
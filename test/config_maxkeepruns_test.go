@@ -23,11 +23,11 @@ import (
 func TestMaxKeepRuns(t *testing.T) {
 	targetNS := names.SimpleNameGenerator.RestrictLengthWithRandomSuffix("pac-e2e-ns")
 	ctx := context.Background()
-	cs, opts, err := setup()
+	cs, opts, ghcnx, err := setup()
 	assert.NilError(t, err)
 	maxKepRuns := 1
 
-	repoinfo, resp, err := cs.GithubClient.Client.Repositories.Get(ctx, opts.Owner, opts.Repo)
+	repoinfo, resp, err := ghcnx.Client.Repositories.Get(ctx, opts.Owner, opts.Repo)
 	assert.NilError(t, err)
 	if resp != nil && resp.Response.StatusCode == http.StatusNotFound {
 		t.Errorf("Repository %s not found in %s", opts.Owner, opts.Repo)
@@ -75,15 +75,15 @@ spec:
 		targetRefName := fmt.Sprintf("refs/heads/%s",
 			names.SimpleNameGenerator.RestrictLengthWithRandomSuffix("pac-e2e-test"))
 
-		sha, err := tgithub.PushFilesToRef(ctx, cs.GithubClient.Client, "TestMaxKeepRuns - "+targetRefName, repoinfo.GetDefaultBranch(), targetRefName, opts.Owner, opts.Repo, entries)
+		sha, err := tgithub.PushFilesToRef(ctx, ghcnx.Client, "TestMaxKeepRuns - "+targetRefName, repoinfo.GetDefaultBranch(), targetRefName, opts.Owner, opts.Repo, entries)
 		assert.NilError(t, err)
 		cs.Log.Infof("Commit %s has been created and pushed to %s", sha, targetRefName)
 
 		title := "TestMaxKeepRuns - " + targetRefName
-		number, err := tgithub.PRCreate(ctx, cs, opts.Owner, opts.Repo, targetRefName, repoinfo.GetDefaultBranch(), title)
+		number, err := tgithub.PRCreate(ctx, cs, ghcnx, opts.Owner, opts.Repo, targetRefName, repoinfo.GetDefaultBranch(), title)
 		assert.NilError(t, err)
 
-		defer tearDown(ctx, t, cs, number, targetRefName, targetNS, opts)
+		defer tearDown(ctx, t, cs, ghcnx, number, targetRefName, targetNS, opts)
 
 		cs.Log.Infof("Waiting for Repository to be updated")
 		err = twait.UntilRepositoryUpdated(ctx, cs.PipelineAsCode, targetNS, targetNS, 0, defaultTimeout)
@@ -28,11 +28,11 @@ type E2EOptions struct {
 	Repo, Owner string
 }
 
-func tearDown(ctx context.Context, t *testing.T, cs *cli.Clients, prNumber int, ref string, targetNS string, opts E2EOptions) {
+func tearDown(ctx context.Context, t *testing.T, cs *cli.Clients, ghcnx webvcs.GithubVCS, prNumber int, ref string, targetNS string, opts E2EOptions) {
 	cs.Log.Infof("Closing PR %d", prNumber)
 	if prNumber != -1 {
 		state := "closed"
-		_, _, err := cs.GithubClient.Client.PullRequests.Edit(ctx,
+		_, _, err := ghcnx.Client.PullRequests.Edit(ctx,
 			opts.Owner, opts.Repo, prNumber,
 			&github.PullRequest{State: &state})
 		if err != nil {
@@ -47,13 +47,13 @@ func tearDown(ctx context.Context, t *testing.T, cs *cli.Clients, prNumber int,
 	}
 
 	cs.Log.Infof("Deleting Ref %s", ref)
-	_, err = cs.GithubClient.Client.Git.DeleteRef(ctx, opts.Owner, opts.Repo, ref)
+	_, err = ghcnx.Client.Git.DeleteRef(ctx, opts.Owner, opts.Repo, ref)
 	if err != nil {
 		t.Fatal(err)
 	}
 }
 
-func setup() (*cli.Clients, E2EOptions, error) {
+func setup() (*cli.Clients, E2EOptions, webvcs.GithubVCS, error) {
 	githubURL := os.Getenv("TEST_GITHUB_API_URL")
 	githubToken := os.Getenv("TEST_GITHUB_TOKEN")
 	githubRepoOwner := os.Getenv("TEST_GITHUB_REPO_OWNER")
@@ -63,24 +63,24 @@ func setup() (*cli.Clients, E2EOptions, error) {
 		"GITHUB_REPO_OWNER", "EL_WEBHOOK_SECRET",
 	} {
 		if env := os.Getenv("TEST_" + value); env == "" {
-			return nil, E2EOptions{}, fmt.Errorf("\"TEST_%s\" env variable is required, cannot continue", value)
+			return nil, E2EOptions{}, webvcs.GithubVCS{}, fmt.Errorf("\"TEST_%s\" env variable is required, cannot continue", value)
 		}
 	}
 
 	if githubURL == "" || githubToken == "" || githubRepoOwner == "" {
-		return nil, E2EOptions{}, fmt.Errorf("TEST_GITHUB_API_URL TEST_GITHUB_TOKEN TEST_GITHUB_REPO_OWNER need to be set")
+		return nil, E2EOptions{}, webvcs.GithubVCS{}, fmt.Errorf("TEST_GITHUB_API_URL TEST_GITHUB_TOKEN TEST_GITHUB_REPO_OWNER need to be set")
 	}
 
 	splitted := strings.Split(githubRepoOwner, "/")
-	webvcs := webvcs.NewGithubVCS(githubToken, githubURL)
+	ghcnx := webvcs.NewGithubVCS(githubToken, githubURL)
 
 	p := cli.PacParams{}
 	cs, err := p.Clients()
 	if err != nil {
-		return nil, E2EOptions{}, err
+		return nil, E2EOptions{}, webvcs.GithubVCS{}, err
 	}
-	cs.GithubClient = webvcs
-	return cs, E2EOptions{Owner: splitted[0], Repo: splitted[1]}, nil
+	cs.VCSClient = ghcnx
+	return cs, E2EOptions{Owner: splitted[0], Repo: splitted[1]}, ghcnx, nil
 }
 
 func TestMain(m *testing.M) {
@@ -6,6 +6,7 @@ import (
 
 	"github.com/google/go-github/v35/github"
 	"github.com/openshift-pipelines/pipelines-as-code/pkg/cli"
+	"github.com/openshift-pipelines/pipelines-as-code/pkg/webvcs"
 )
 
 func PushFilesToRef(ctx context.Context, client *github.Client, commitMessage, baseBranch, targetRef, owner, repo string, files map[string]string) (string, error) {
@@ -73,8 +74,8 @@ func PushFilesToRef(ctx context.Context, client *github.Client, commitMessage, b
 	return commit.GetSHA(), nil
 }
 
-func PRCreate(ctx context.Context, cs *cli.Clients, owner, repo, targetRef, defaultBranch, title string) (int, error) {
-	pr, _, err := cs.GithubClient.Client.PullRequests.Create(ctx, owner, repo, &github.NewPullRequest{
+func PRCreate(ctx context.Context, cs *cli.Clients, ghcnx webvcs.GithubVCS, owner, repo, targetRef, defaultBranch, title string) (int, error) {
+	pr, _, err := ghcnx.Client.PullRequests.Create(ctx, owner, repo, &github.NewPullRequest{
 		Title: &title,
 		Head:  &targetRef,
 		Base:  &defaultBranch,
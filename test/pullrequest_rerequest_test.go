@@ -27,7 +27,7 @@ import (
 func TestPullRerequest(t *testing.T) {
 	targetNS := names.SimpleNameGenerator.RestrictLengthWithRandomSuffix("pac-e2e-ns")
 	ctx := context.Background()
-	cs, opts, err := setup()
+	cs, opts, ghcnx, err := setup()
 	assert.NilError(t, err)
 
 	entries := map[string]string{
@@ -52,7 +52,7 @@ spec:
 `, targetNS, mainBranch, pullRequestEvent),
 	}
 
-	repoinfo, resp, err := cs.GithubClient.Client.Repositories.Get(ctx, opts.Owner, opts.Repo)
+	repoinfo, resp, err := ghcnx.Client.Repositories.Get(ctx, opts.Owner, opts.Repo)
 	assert.NilError(t, err)
 	if resp != nil && resp.Response.StatusCode == http.StatusNotFound {
 		t.Errorf("Repository %s not found in %s", opts.Owner, opts.Repo)
@@ -76,7 +76,7 @@ spec:
 	targetRefName := fmt.Sprintf("refs/heads/%s",
 		names.SimpleNameGenerator.RestrictLengthWithRandomSuffix("pac-e2e-test"))
 
-	sha, err := tgithub.PushFilesToRef(ctx, cs.GithubClient.Client,
+	sha, err := tgithub.PushFilesToRef(ctx, ghcnx.Client,
 		"TestPullRequest - "+targetRefName, repoinfo.GetDefaultBranch(),
 		targetRefName,
 		opts.Owner,
@@ -85,10 +85,10 @@ spec:
 	assert.NilError(t, err)
 	cs.Log.Infof("Commit %s has been created and pushed to %s", sha, targetRefName)
 	title := "TestPullRerequest on " + targetRefName
-	number, err := tgithub.PRCreate(ctx, cs, opts.Owner, opts.Repo, targetRefName, repoinfo.GetDefaultBranch(), title)
+	number, err := tgithub.PRCreate(ctx, cs, ghcnx, opts.Owner, opts.Repo, targetRefName, repoinfo.GetDefaultBranch(), title)
 	assert.NilError(t, err)
 
-	defer tearDown(ctx, t, cs, number, targetRefName, targetNS, opts)
+	defer tearDown(ctx, t, cs, ghcnx, number, targetRefName, targetNS, opts)
 
 	cs.Log.Infof("Waiting for Repository to be updated")
 	err = twait.UntilRepositoryUpdated(ctx, cs.PipelineAsCode, targetNS, targetNS, 0, defaultTimeout)
@@ -25,10 +25,10 @@ func TestPush(t *testing.T) {
 	targetEvent := "push"
 
 	ctx := context.Background()
-	cs, opts, err := setup()
+	cs, opts, ghcnx, err := setup()
 	assert.NilError(t, err)
 
-	repoinfo, resp, err := cs.GithubClient.Client.Repositories.Get(ctx, opts.Owner, opts.Repo)
+	repoinfo, resp, err := ghcnx.Client.Repositories.Get(ctx, opts.Owner, opts.Repo)
 	assert.NilError(t, err)
 	if resp != nil && resp.Response.StatusCode == http.StatusNotFound {
 		t.Errorf("Repository %s not found in %s", opts.Owner, opts.Repo)
@@ -72,10 +72,10 @@ spec:
 	}
 
 	targetRefName := fmt.Sprintf("refs/heads/%s", targetBranch)
-	sha, err := tgithub.PushFilesToRef(ctx, cs.GithubClient.Client, "TestPush - "+targetBranch, repoinfo.GetDefaultBranch(), targetRefName, opts.Owner, opts.Repo, entries)
+	sha, err := tgithub.PushFilesToRef(ctx, ghcnx.Client, "TestPush - "+targetBranch, repoinfo.GetDefaultBranch(), targetRefName, opts.Owner, opts.Repo, entries)
 	cs.Log.Infof("Commit %s has been created and pushed to %s", sha, targetRefName)
 	assert.NilError(t, err)
-	defer tearDown(ctx, t, cs, -1, targetRefName, targetNS, opts)
+	defer tearDown(ctx, t, cs, ghcnx, -1, targetRefName, targetNS, opts)
 
 	cs.Log.Infof("Waiting for Repository to be updated")
 	err = twait.UntilRepositoryUpdated(ctx, cs.PipelineAsCode, targetNS, targetNS, 0, defaultTimeout)